@@ -0,0 +1,61 @@
+package artifact
+
+import (
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrNotFound = errors.New("artifact not found")
+
+//BlobStore keeps artifact files by key, keys are slash separated paths like <activity id>/<file path>.
+//It is backed by the filesystem and can be implemented by object stores.
+type BlobStore interface {
+	//Put writes the content of the key, returns its size and sha256 checksum
+	Put(key string, r io.Reader) (int64, string, error)
+	Get(key string) (io.ReadCloser, error)
+	//Delete removes the key and all keys under it
+	Delete(key string) error
+	//List gets blobs of keys under the prefix
+	List(prefix string) ([]BlobInfo, error)
+}
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+//Key gets the key of the artifact of the activity
+func Key(activityId string, name string) string {
+	return activityId + "/" + name
+}
+
+//CleanName cleans the workspace relative path of an artifact, empty if the path is outside the workspace
+func CleanName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" || name == "." {
+		return ""
+	}
+	return name
+}
+
+//Match checks whether the file matches any of the glob patterns, a pattern matching a
+//directory matches all files under it
+func Match(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		pattern = CleanName(pattern)
+		if pattern == "" {
+			continue
+		}
+		for p := name; p != "." && p != "/"; p = path.Dir(p) {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
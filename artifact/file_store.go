@@ -0,0 +1,114 @@
+package artifact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type fileStore struct {
+	root string
+}
+
+//NewFileStore creates a blob store keeping files under the root directory
+func NewFileStore(root string) (BlobStore, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, errors.Wrap(err, "fail to create artifact directory")
+	}
+	return &fileStore{root: filepath.Clean(root)}, nil
+}
+
+func (s *fileStore) path(key string) (string, error) {
+	name := CleanName(key)
+	if name == "" {
+		return "", errors.Errorf("invalid artifact key '%s'", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+//Put writes to a temp file first so that readers never get partial content
+func (s *fileStore) Put(key string, r io.Reader) (int64, string, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return 0, "", err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return 0, "", err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *fileStore) Get(key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *fileStore) Delete(key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(p, s.root+string(filepath.Separator)) {
+		return errors.Errorf("invalid artifact key '%s'", key)
+	}
+	return os.RemoveAll(p)
+}
+
+func (s *fileStore) List(prefix string) ([]BlobInfo, error) {
+	p, err := s.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	blobs := []BlobInfo{}
+	err = filepath.Walk(p, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp-") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, file)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{
+			Key:     filepath.ToSlash(rel),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	return blobs, err
+}
@@ -0,0 +1,193 @@
+package condition
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+var varPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+//Context is what a condition is evaluated against
+type Context struct {
+	//Env are the activity env vars referred by identifiers
+	Env map[string]string
+	//Steps are statuses of steps by step name
+	Steps map[string]string
+	//ChangedFiles are paths changed by the triggering push, nil if unknown
+	ChangedFiles []string
+}
+
+//Evaluate parses and evaluates the condition
+func Evaluate(s string, ctx *Context) (bool, error) {
+	expr, err := Parse(s)
+	if err != nil {
+		return false, err
+	}
+	return Eval(expr, ctx)
+}
+
+//Eval evaluates the parsed condition
+func Eval(expr Expr, ctx *Context) (bool, error) {
+	v, err := expr.eval(ctx)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("condition is not a boolean expression")
+	}
+	return b, nil
+}
+
+func (e *identExpr) eval(ctx *Context) (interface{}, error) {
+	if name, ok := stepName(e.name); ok {
+		status, ok := ctx.Steps[name]
+		if !ok {
+			return nil, fmt.Errorf("step '%s' is not found at position %d", name, e.pos)
+		}
+		return status, nil
+	}
+	return ctx.Env[e.name], nil
+}
+
+//eval substitutes ${VAR} in the literal
+func (e *literalExpr) eval(ctx *Context) (interface{}, error) {
+	return varPattern.ReplaceAllStringFunc(e.value, func(s string) string {
+		return ctx.Env[s[2:len(s)-1]]
+	}), nil
+}
+
+func (e *boolExpr) eval(ctx *Context) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e *listExpr) eval(ctx *Context) (interface{}, error) {
+	items := []string{}
+	for _, item := range e.items {
+		v, err := item.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v.(string))
+	}
+	return items, nil
+}
+
+func (e *notExpr) eval(ctx *Context) (interface{}, error) {
+	v, err := e.x.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return !v.(bool), nil
+}
+
+func (e *binaryExpr) eval(ctx *Context) (interface{}, error) {
+	left, err := e.left.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	//short circuit
+	switch e.op {
+	case tokAnd:
+		if !left.(bool) {
+			return false, nil
+		}
+	case tokOr:
+		if left.(bool) {
+			return true, nil
+		}
+	}
+	right, err := e.right.eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch e.op {
+	case tokAnd, tokOr:
+		return right.(bool), nil
+	case tokEq:
+		return left == right, nil
+	case tokNe:
+		return left != right, nil
+	case tokMatch, tokNotMatch:
+		re := e.re
+		if re == nil {
+			re, err = regexp.Compile(right.(string))
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression at position %d: %v", e.right.Pos(), err)
+			}
+		}
+		return re.MatchString(left.(string)) == (e.op == tokMatch), nil
+	case tokIn:
+		for _, item := range right.([]string) {
+			if item == left.(string) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s at position %d", tokenNames[e.op], e.pos)
+}
+
+func (e *callExpr) eval(ctx *Context) (interface{}, error) {
+	args := []string{}
+	for _, arg := range e.args {
+		v, err := arg.eval(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v.(string))
+	}
+	switch e.name {
+	case "glob":
+		return MatchGlob(args[1], args[0]), nil
+	case "changed":
+		if ctx.ChangedFiles == nil {
+			//changed files are unknown, e.g. manual runs
+			return true, nil
+		}
+		for _, file := range ctx.ChangedFiles {
+			for _, pattern := range args {
+				if MatchGlob(pattern, file) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("unknown function '%s' at position %d", e.name, e.pos)
+}
+
+//MatchGlob matches the path with the pattern, in which '*' matches any sequence of non-separator
+//characters, '**' matches any sequence of characters and '?' matches a non-separator character
+func MatchGlob(pattern string, s string) bool {
+	b := &bytes.Buffer{}
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					//'**/' matches zero or more directories
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
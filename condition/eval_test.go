@@ -0,0 +1,116 @@
+package condition
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	ctx := &Context{
+		Env: map[string]string{
+			"CICD_GIT_BRANCH":  "release/1.0",
+			"CICD_EVENT":       "push",
+			"CICD_RUN_NUMBER":  "3",
+			"CICD_GIT_TAG":     "v1.2",
+			"DEPLOY_BRANCH":    "release/1.0",
+			"CICD_GIT_COMMENT": "a=b",
+		},
+		Steps: map[string]string{
+			"build": "Success",
+			"test":  "Fail",
+		},
+		ChangedFiles: []string{"src/app/main.go", "README.md"},
+	}
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"!false", true},
+		{"CICD_EVENT == 'push'", true},
+		{"CICD_EVENT != 'push'", false},
+		{"CICD_RUN_NUMBER == 3", true},
+		{"UNKNOWN == ''", true},
+		{"CICD_GIT_BRANCH == '${DEPLOY_BRANCH}'", true},
+		{"CICD_GIT_TAG =~ '^v[0-9]+'", true},
+		{"CICD_GIT_TAG !~ '^v[0-9]+'", false},
+		{"CICD_GIT_BRANCH =~ DEPLOY_BRANCH", true},
+		{"CICD_EVENT in ['push', 'tag']", true},
+		{"CICD_EVENT in []", false},
+		{"CICD_EVENT == 'tag' || CICD_EVENT == 'push' && CICD_RUN_NUMBER == '3'", true},
+		{"(CICD_EVENT == 'tag' || CICD_EVENT == 'push') && CICD_RUN_NUMBER == '4'", false},
+		{"true != false", true},
+		{"glob(CICD_GIT_BRANCH, 'release/*')", true},
+		{"glob(CICD_GIT_BRANCH, 'release')", false},
+		{"changed('src/**')", true},
+		{"changed('docs/**', '*.md')", true},
+		{"changed('*.go')", false},
+		{"steps.build.status == 'Success' && steps.test.status != 'Success'", true},
+		//legacy format compares the value literally
+		{"CICD_GIT_BRANCH=release/1.0", true},
+		{"CICD_GIT_BRANCH!=release/1.0", false},
+		{"CICD_GIT_COMMENT=a=b", true},
+		{"CICD_EVENT=push && false", false},
+	}
+	for _, test := range tests {
+		got, err := Evaluate(test.input, ctx)
+		if err != nil {
+			t.Errorf("Evaluate(%q) got error: %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("Evaluate(%q) = %v, want %v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestEvaluateUnknownChanges(t *testing.T) {
+	//changed files are unknown for manual runs
+	got, err := Evaluate("changed('*.go')", &Context{})
+	if err != nil || !got {
+		t.Errorf("Evaluate got %v, %v, want true", got, err)
+	}
+}
+
+func TestEvaluateError(t *testing.T) {
+	ctx := &Context{Env: map[string]string{"A": "("}, Steps: map[string]string{}}
+	tests := []struct {
+		input string
+		msg   string
+	}{
+		{"steps.missing.status == 'Success'", "step 'missing' is not found at position 1"},
+		{"'x' =~ A", "invalid regular expression at position 8: error parsing regexp: missing closing ): `(`"},
+		{"A == ", "unexpected end of condition at position 6"},
+	}
+	for _, test := range tests {
+		_, err := Evaluate(test.input, ctx)
+		if err == nil || err.Error() != test.msg {
+			t.Errorf("Evaluate(%q) got error %v, want %q", test.input, err, test.msg)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "src/main.go", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/**", "src/a/b/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "src/a/main.go", true},
+		{"src/**/test", "src/test", true},
+		{"src/**/test", "src/a/b/test", true},
+		{"?.go", "a.go", true},
+		{"?.go", "ab.go", false},
+		{"?", "/", false},
+		{"a.go", "a_go", false},
+		{"a+b(c)", "a+b(c)", true},
+	}
+	for _, test := range tests {
+		if got := MatchGlob(test.pattern, test.path); got != test.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", test.pattern, test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package condition
+
+import (
+	"fmt"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokAnd
+	tokOr
+	tokNot
+	tokEq
+	tokNe
+	tokMatch
+	tokNotMatch
+	tokIn
+	tokTrue
+	tokFalse
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+var tokenNames = map[tokenKind]string{
+	tokEOF:      "end of condition",
+	tokIdent:    "identifier",
+	tokString:   "string",
+	tokAnd:      "'&&'",
+	tokOr:       "'||'",
+	tokNot:      "'!'",
+	tokEq:       "'=='",
+	tokNe:       "'!='",
+	tokMatch:    "'=~'",
+	tokNotMatch: "'!~'",
+	tokIn:       "'in'",
+	tokTrue:     "'true'",
+	tokFalse:    "'false'",
+	tokLParen:   "'('",
+	tokRParen:   "')'",
+	tokLBracket: "'['",
+	tokRBracket: "']'",
+	tokComma:    "','",
+}
+
+type token struct {
+	kind tokenKind
+	//text is the identifier name or the unquoted string
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokIdent:
+		return "'" + t.text + "'"
+	case tokString:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return tokenNames[t.kind]
+}
+
+//SyntaxError is an error of the condition with the position where it happens
+type SyntaxError struct {
+	//Pos is the 1-based character offset in the condition
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+func errorf(pos int, format string, args ...interface{}) error {
+	return &SyntaxError{Pos: pos + 1, Msg: fmt.Sprintf(format, args...)}
+}
+
+//lex splits the condition into tokens
+func lex(s string) ([]token, error) {
+	tokens := []token{}
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, token{kind: tokAnd, pos: i})
+			i += 2
+		case strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, token{kind: tokOr, pos: i})
+			i += 2
+		case strings.HasPrefix(s[i:], "=="):
+			tokens = append(tokens, token{kind: tokEq, pos: i})
+			i += 2
+		case strings.HasPrefix(s[i:], "!="):
+			tokens = append(tokens, token{kind: tokNe, pos: i})
+			i += 2
+		case strings.HasPrefix(s[i:], "=~"):
+			tokens = append(tokens, token{kind: tokMatch, pos: i})
+			i += 2
+		case strings.HasPrefix(s[i:], "!~"):
+			tokens = append(tokens, token{kind: tokNotMatch, pos: i})
+			i += 2
+		case c == '!':
+			tokens = append(tokens, token{kind: tokNot, pos: i})
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokLParen, pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokRParen, pos: i})
+			i++
+		case c == '[':
+			tokens = append(tokens, token{kind: tokLBracket, pos: i})
+			i++
+		case c == ']':
+			tokens = append(tokens, token{kind: tokRBracket, pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokComma, pos: i})
+			i++
+		case c == '"' || c == '\'':
+			text, n, err := lexString(s, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokString, text: text, pos: i})
+			i += n
+		case isDigit(c):
+			//bare numbers are strings, e.g. CICD_RUN_NUMBER == 3
+			j := i
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokString, text: s[i:j], pos: i})
+			i = j
+		case isIdentStart(c):
+			j := i
+			for j < len(s) && isIdentPart(s[j]) {
+				j++
+			}
+			t := token{kind: tokIdent, text: s[i:j], pos: i}
+			switch t.text {
+			case "in":
+				t.kind = tokIn
+			case "true":
+				t.kind = tokTrue
+			case "false":
+				t.kind = tokFalse
+			}
+			tokens = append(tokens, t)
+			i = j
+		case c == '=':
+			return nil, errorf(i, "unexpected '=', use '==' to compare")
+		default:
+			return nil, errorf(i, "unexpected character %q", c)
+		}
+	}
+	tokens = append(tokens, token{kind: tokEOF, pos: len(s)})
+	return tokens, nil
+}
+
+//lexString reads the quoted string at i, returns the unquoted text and the length consumed
+func lexString(s string, i int) (string, int, error) {
+	quote := s[i]
+	b := []byte{}
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case quote:
+			return string(b), j - i + 1, nil
+		case '\\':
+			//only quotes and backslashes are escaped, so that regular expressions are kept
+			if j+1 < len(s) && (s[j+1] == quote || s[j+1] == '\\') {
+				j++
+			}
+			b = append(b, s[j])
+		default:
+			b = append(b, s[j])
+		}
+	}
+	return "", 0, errorf(i, "unterminated string")
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+//identifiers contain dots for step references and dashes for step names
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || isDigit(c) || c == '.' || c == '-'
+}
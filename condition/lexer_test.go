@@ -0,0 +1,121 @@
+package condition
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		input  string
+		tokens []token
+	}{
+		{"", []token{{kind: tokEOF, pos: 0}}},
+		{
+			`A == "b" && !c`,
+			[]token{
+				{kind: tokIdent, text: "A", pos: 0},
+				{kind: tokEq, pos: 2},
+				{kind: tokString, text: "b", pos: 5},
+				{kind: tokAnd, pos: 9},
+				{kind: tokNot, pos: 12},
+				{kind: tokIdent, text: "c", pos: 13},
+				{kind: tokEOF, pos: 14},
+			},
+		},
+		{
+			"x != 'y' || x =~ 'a' || x !~ 'b'",
+			[]token{
+				{kind: tokIdent, text: "x", pos: 0},
+				{kind: tokNe, pos: 2},
+				{kind: tokString, text: "y", pos: 5},
+				{kind: tokOr, pos: 9},
+				{kind: tokIdent, text: "x", pos: 12},
+				{kind: tokMatch, pos: 14},
+				{kind: tokString, text: "a", pos: 17},
+				{kind: tokOr, pos: 21},
+				{kind: tokIdent, text: "x", pos: 24},
+				{kind: tokNotMatch, pos: 26},
+				{kind: tokString, text: "b", pos: 29},
+				{kind: tokEOF, pos: 32},
+			},
+		},
+		{
+			"n in [1, 2.5]",
+			[]token{
+				{kind: tokIdent, text: "n", pos: 0},
+				{kind: tokIn, text: "in", pos: 2},
+				{kind: tokLBracket, pos: 5},
+				{kind: tokString, text: "1", pos: 6},
+				{kind: tokComma, pos: 7},
+				{kind: tokString, text: "2.5", pos: 9},
+				{kind: tokRBracket, pos: 12},
+				{kind: tokEOF, pos: 13},
+			},
+		},
+		{
+			"(true) && false && steps.build-1.status",
+			[]token{
+				{kind: tokLParen, pos: 0},
+				{kind: tokTrue, text: "true", pos: 1},
+				{kind: tokRParen, pos: 5},
+				{kind: tokAnd, pos: 7},
+				{kind: tokFalse, text: "false", pos: 10},
+				{kind: tokAnd, pos: 16},
+				{kind: tokIdent, text: "steps.build-1.status", pos: 19},
+				{kind: tokEOF, pos: 39},
+			},
+		},
+		{
+			`"a\"b\\c\d"`,
+			[]token{
+				{kind: tokString, text: `a"b\c\d`, pos: 0},
+				{kind: tokEOF, pos: 11},
+			},
+		},
+	}
+	for _, test := range tests {
+		tokens, err := lex(test.input)
+		if err != nil {
+			t.Errorf("lex(%q) got error: %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(tokens, test.tokens) {
+			t.Errorf("lex(%q) = %s, want %s", test.input, formatTokens(tokens), formatTokens(test.tokens))
+		}
+	}
+}
+
+//formatTokens formats tokens with their positions
+func formatTokens(tokens []token) string {
+	s := ""
+	for _, t := range tokens {
+		s += fmt.Sprintf("%s@%d ", t, t.pos)
+	}
+	return s
+}
+
+func TestLexError(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{"a = b", 3, "unexpected '=', use '==' to compare"},
+		{"a == 'b", 6, "unterminated string"},
+		{"a == b;", 7, "unexpected character ';'"},
+		{"@", 1, "unexpected character '@'"},
+	}
+	for _, test := range tests {
+		_, err := lex(test.input)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("lex(%q) got error %v, want syntax error", test.input, err)
+			continue
+		}
+		if serr.Pos != test.pos || serr.Msg != test.msg {
+			t.Errorf("lex(%q) got error %q at %d, want %q at %d", test.input, serr.Msg, serr.Pos, test.msg, test.pos)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package condition
+
+import (
+	"regexp"
+	"strings"
+)
+
+//legacyPattern matches conditions in the old 'KEY=value' and 'KEY!=value' format,
+//in which the value is a literal rather than an expression
+var legacyPattern = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)(!?=)([^=~].*)$`)
+
+var funcs = map[string]struct {
+	minArgs int
+	maxArgs int
+}{
+	//glob(value, pattern)
+	"glob": {2, 2},
+	//changed(pattern, ...)
+	"changed": {1, -1},
+}
+
+type valueType int
+
+const (
+	typeString valueType = iota
+	typeBool
+	typeList
+)
+
+var typeNames = map[valueType]string{
+	typeString: "string",
+	typeBool:   "boolean",
+	typeList:   "list",
+}
+
+//Expr is a parsed condition
+type Expr interface {
+	Pos() int
+	typ() valueType
+	eval(ctx *Context) (interface{}, error)
+}
+
+type identExpr struct {
+	pos  int
+	name string
+}
+
+type literalExpr struct {
+	pos   int
+	value string
+}
+
+type boolExpr struct {
+	pos   int
+	value bool
+}
+
+type listExpr struct {
+	pos   int
+	items []Expr
+}
+
+type notExpr struct {
+	pos int
+	x   Expr
+}
+
+type binaryExpr struct {
+	pos   int
+	op    tokenKind
+	left  Expr
+	right Expr
+	//re is the precompiled pattern of a literal regex
+	re *regexp.Regexp
+}
+
+type callExpr struct {
+	pos  int
+	name string
+	args []Expr
+}
+
+func (e *identExpr) Pos() int   { return e.pos }
+func (e *literalExpr) Pos() int { return e.pos }
+func (e *boolExpr) Pos() int    { return e.pos }
+func (e *listExpr) Pos() int    { return e.pos }
+func (e *notExpr) Pos() int     { return e.pos }
+func (e *binaryExpr) Pos() int  { return e.pos }
+func (e *callExpr) Pos() int    { return e.pos }
+
+func (e *identExpr) typ() valueType   { return typeString }
+func (e *literalExpr) typ() valueType { return typeString }
+func (e *boolExpr) typ() valueType    { return typeBool }
+func (e *listExpr) typ() valueType    { return typeList }
+func (e *notExpr) typ() valueType     { return typeBool }
+func (e *binaryExpr) typ() valueType  { return typeBool }
+func (e *callExpr) typ() valueType    { return typeBool }
+
+type parser struct {
+	tokens []token
+	i      int
+}
+
+//Parse parses the condition. Besides expressions, the legacy 'KEY=value' and 'KEY!=value'
+//formats are accepted, where value is compared literally.
+func Parse(s string) (Expr, error) {
+	if m := legacyPattern.FindStringSubmatch(s); m != nil {
+		op := tokEq
+		if m[2] == "!=" {
+			op = tokNe
+		}
+		return &binaryExpr{
+			pos:   1,
+			op:    op,
+			left:  &identExpr{pos: 1, name: m[1]},
+			right: &literalExpr{pos: len(m[1]) + len(m[2]) + 1, value: m[3]},
+		}, nil
+	}
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, errorf(0, "empty condition")
+	}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorf(t.pos, "unexpected %s", t)
+	}
+	if expr.typ() != typeBool {
+		return nil, errorf(expr.Pos()-1, "condition should be a boolean expression, got %s", typeNames[expr.typ()])
+	}
+	return expr, nil
+}
+
+//StepRefs gets names of steps referred by 'steps.<name>.status' in the expression, with their positions
+func StepRefs(expr Expr) map[string]int {
+	refs := map[string]int{}
+	var walk func(e Expr)
+	walk = func(e Expr) {
+		switch e := e.(type) {
+		case *identExpr:
+			if name, ok := stepName(e.name); ok {
+				refs[name] = e.pos
+			}
+		case *listExpr:
+			for _, item := range e.items {
+				walk(item)
+			}
+		case *notExpr:
+			walk(e.x)
+		case *binaryExpr:
+			walk(e.left)
+			walk(e.right)
+		case *callExpr:
+			for _, arg := range e.args {
+				walk(arg)
+			}
+		}
+	}
+	walk(expr)
+	return refs
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, errorf(t.pos, "expected %s, got %s", tokenNames[kind], t)
+	}
+	return t, nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		t := p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkType(left, typeBool, t); err != nil {
+			return nil, err
+		}
+		if err := checkType(right, typeBool, t); err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{pos: t.pos + 1, op: tokOr, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		t := p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkType(left, typeBool, t); err != nil {
+			return nil, err
+		}
+		if err := checkType(right, typeBool, t); err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{pos: t.pos + 1, op: tokAnd, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().kind == tokNot {
+		t := p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if err := checkType(x, typeBool, t); err != nil {
+			return nil, err
+		}
+		return &notExpr{pos: t.pos + 1, x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch t.kind {
+	case tokEq, tokNe, tokMatch, tokNotMatch, tokIn:
+		p.next()
+	default:
+		return left, nil
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	expr := &binaryExpr{pos: t.pos + 1, op: t.kind, left: left, right: right}
+	switch t.kind {
+	case tokEq, tokNe:
+		if left.typ() == typeList || right.typ() == typeList || left.typ() != right.typ() {
+			return nil, errorf(t.pos, "cannot compare %s with %s", typeNames[left.typ()], typeNames[right.typ()])
+		}
+	case tokMatch, tokNotMatch:
+		if err := checkType(left, typeString, t); err != nil {
+			return nil, err
+		}
+		if err := checkType(right, typeString, t); err != nil {
+			return nil, err
+		}
+		if lit, ok := right.(*literalExpr); ok && !strings.Contains(lit.value, "$") {
+			re, err := regexp.Compile(lit.value)
+			if err != nil {
+				return nil, errorf(lit.pos-1, "invalid regular expression: %v", err)
+			}
+			expr.re = re
+		}
+	case tokIn:
+		if err := checkType(left, typeString, t); err != nil {
+			return nil, err
+		}
+		if err := checkType(right, typeList, t); err != nil {
+			return nil, err
+		}
+	}
+	return expr, nil
+}
+
+func (p *parser) parseOperand() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	case tokLBracket:
+		return p.parseList(t)
+	case tokString:
+		return &literalExpr{pos: t.pos + 1, value: t.text}, nil
+	case tokTrue, tokFalse:
+		return &boolExpr{pos: t.pos + 1, value: t.kind == tokTrue}, nil
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			return p.parseCall(t)
+		}
+		if strings.HasPrefix(t.text, "steps.") {
+			if _, ok := stepName(t.text); !ok {
+				return nil, errorf(t.pos, "invalid step reference '%s', expected 'steps.<name>.status'", t.text)
+			}
+		}
+		return &identExpr{pos: t.pos + 1, name: t.text}, nil
+	}
+	return nil, errorf(t.pos, "unexpected %s", t)
+}
+
+func (p *parser) parseList(start token) (Expr, error) {
+	list := &listExpr{pos: start.pos + 1}
+	if p.peek().kind == tokRBracket {
+		p.next()
+		return list, nil
+	}
+	for {
+		item, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if item.typ() != typeString {
+			return nil, errorf(item.Pos()-1, "list item should be a string, got %s", typeNames[item.typ()])
+		}
+		list.items = append(list.items, item)
+		t := p.next()
+		if t.kind == tokRBracket {
+			return list, nil
+		}
+		if t.kind != tokComma {
+			return nil, errorf(t.pos, "expected ',' or ']', got %s", t)
+		}
+	}
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	f, ok := funcs[name.text]
+	if !ok {
+		return nil, errorf(name.pos, "unknown function '%s'", name.text)
+	}
+	p.next()
+	call := &callExpr{pos: name.pos + 1, name: name.text}
+	if p.peek().kind != tokRParen {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if arg.typ() != typeString {
+				return nil, errorf(arg.Pos()-1, "argument of %s() should be a string, got %s", name.text, typeNames[arg.typ()])
+			}
+			call.args = append(call.args, arg)
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	if len(call.args) < f.minArgs || (f.maxArgs >= 0 && len(call.args) > f.maxArgs) {
+		return nil, errorf(name.pos, "wrong number of arguments for %s()", name.text)
+	}
+	return call, nil
+}
+
+func checkType(e Expr, expected valueType, op token) error {
+	if e.typ() != expected {
+		return errorf(e.Pos()-1, "expected %s operand for %s, got %s", typeNames[expected], op, typeNames[e.typ()])
+	}
+	return nil
+}
+
+//stepName gets the step name of 'steps.<name>.status'
+func stepName(ident string) (string, bool) {
+	if !strings.HasPrefix(ident, "steps.") || !strings.HasSuffix(ident, ".status") {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(ident, "steps."), ".status")
+	if name == "" {
+		return "", false
+	}
+	return name, true
+}
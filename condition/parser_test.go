@@ -0,0 +1,110 @@
+package condition
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLegacy(t *testing.T) {
+	tests := []struct {
+		input string
+		op    tokenKind
+		name  string
+		value string
+	}{
+		{"CICD_GIT_BRANCH=master", tokEq, "CICD_GIT_BRANCH", "master"},
+		{"CICD_GIT_BRANCH!=master", tokNe, "CICD_GIT_BRANCH", "master"},
+		//the value is a literal rather than an expression
+		{"A=b && c", tokEq, "A", "b && c"},
+		{"A!=(x)", tokNe, "A", "(x)"},
+	}
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%q) got error: %v", test.input, err)
+			continue
+		}
+		b, ok := expr.(*binaryExpr)
+		if !ok {
+			t.Errorf("Parse(%q) = %#v, want comparison", test.input, expr)
+			continue
+		}
+		left, lok := b.left.(*identExpr)
+		right, rok := b.right.(*literalExpr)
+		if b.op != test.op || !lok || !rok || left.name != test.name || right.value != test.value {
+			t.Errorf("Parse(%q) = %#v, want %s %s %q", test.input, expr, test.name, tokenNames[test.op], test.value)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []string{
+		"true",
+		"CICD_GIT_BRANCH == 'master'",
+		"A == 'x' && B != 'y' || !(C =~ '^v[0-9]+')",
+		"CICD_GIT_BRANCH in ['master', 'release']",
+		"glob(CICD_GIT_BRANCH, 'release/*')",
+		"changed('src/**', '*.go')",
+		"steps.build.status == 'Success'",
+		"A !~ B",
+	}
+	for _, test := range tests {
+		if _, err := Parse(test); err != nil {
+			t.Errorf("Parse(%q) got error: %v", test, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{"", 1, "empty condition"},
+		{"A", 1, "condition should be a boolean expression, got string"},
+		{"['a']", 1, "condition should be a boolean expression, got list"},
+		{"A == 'x' B", 10, "unexpected 'B'"},
+		{"A == ", 6, "unexpected end of condition"},
+		{"(A == 'x'", 10, "expected ')', got end of condition"},
+		{"A == true", 3, "cannot compare string with boolean"},
+		{"A == ['x']", 3, "cannot compare string with list"},
+		{"A && true", 1, "expected boolean operand for '&&', got string"},
+		{"true || B", 9, "expected boolean operand for '||', got string"},
+		{"!A", 2, "expected boolean operand for '!', got string"},
+		{"true =~ 'a'", 1, "expected string operand for '=~', got boolean"},
+		{"A =~ '('", 6, "invalid regular expression: error parsing regexp: missing closing ): `(`"},
+		{"A in 'x'", 6, "expected list operand for 'in', got string"},
+		{"A in ['x' 'y']", 11, "expected ',' or ']', got \"y\""},
+		{"A in [true]", 7, "list item should be a string, got boolean"},
+		{"foo(A)", 1, "unknown function 'foo'"},
+		{"glob(A)", 1, "wrong number of arguments for glob()"},
+		{"changed()", 1, "wrong number of arguments for changed()"},
+		{"changed(true)", 9, "argument of changed() should be a string, got boolean"},
+		{"steps.build == 'x'", 1, "invalid step reference 'steps.build', expected 'steps.<name>.status'"},
+		{"A == B == C", 8, "unexpected '=='"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.input)
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Errorf("Parse(%q) got error %v, want syntax error", test.input, err)
+			continue
+		}
+		if serr.Pos != test.pos || serr.Msg != test.msg {
+			t.Errorf("Parse(%q) got error %q at %d, want %q at %d", test.input, serr.Msg, serr.Pos, test.msg, test.pos)
+		}
+	}
+}
+
+func TestStepRefs(t *testing.T) {
+	expr, err := Parse("steps.build.status == 'Success' && (steps.test-1.status in ['Fail'] || A == 'x')")
+	if err != nil {
+		t.Fatalf("Parse got error: %v", err)
+	}
+	refs := StepRefs(expr)
+	want := map[string]int{"build": 1, "test-1": 37}
+	if !reflect.DeepEqual(refs, want) {
+		t.Errorf("StepRefs = %v, want %v", refs, want)
+	}
+}
@@ -11,6 +11,29 @@ type config struct {
 	JenkinsUser     string
 	JenkinsToken    string
 	JenkinsAddress  string
+	StorageDriver   string
+	StorageDSN      string
+	Provider        string
+	DockerHost      string
+	KubeHost        string
+	KubeToken       string
+	KubeNamespace   string
+	KubeVolumeSize  string
+	KubeVolumeClass string
+	EncryptionKey   string
+	ArtifactPath    string
+	CachePath       string
+	LogPath         string
+	CacheMaxSize    int
+	CacheMaxAge     int
+	//retention of finished activities
+	ActivityKeepLast        int
+	ActivityMaxAge          int
+	ActivityKeepLastSuccess bool
+	//DefaultOwner is set to pipelines and git credentials without owner
+	DefaultOwner string
+	//ServerUrl is the public address of the pipeline server, commit statuses link to activities on it
+	ServerUrl string
 }
 
 var Config config
@@ -22,4 +45,24 @@ func Parse(context *cli.Context) {
 	Config.CattleUrl = context.String("cattle_url")
 	Config.CattleAccessKey = context.String("cattle_access_key")
 	Config.CattleSecretKey = context.String("cattle_secret_key")
+	Config.StorageDriver = context.String("storage_driver")
+	Config.StorageDSN = context.String("storage_dsn")
+	Config.Provider = context.String("provider")
+	Config.DockerHost = context.String("docker_host")
+	Config.KubeHost = context.String("kube_host")
+	Config.KubeToken = context.String("kube_token")
+	Config.KubeNamespace = context.String("kube_namespace")
+	Config.KubeVolumeSize = context.String("kube_volume_size")
+	Config.KubeVolumeClass = context.String("kube_volume_class")
+	Config.EncryptionKey = context.String("encryption_key")
+	Config.ServerUrl = context.String("server_url")
+	Config.ArtifactPath = context.String("artifact_path")
+	Config.CachePath = context.String("cache_path")
+	Config.LogPath = context.String("log_path")
+	Config.DefaultOwner = context.String("default_pipeline_owner")
+	Config.CacheMaxSize = context.Int("cache_max_size")
+	Config.CacheMaxAge = context.Int("cache_max_age")
+	Config.ActivityKeepLast = context.Int("activity_keep_last")
+	Config.ActivityMaxAge = context.Int("activity_max_age")
+	Config.ActivityKeepLastSuccess = context.BoolT("activity_keep_last_success")
 }
@@ -2,6 +2,8 @@ package git
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net/url"
 	"os"
 	"os/exec"
 	"strings"
@@ -19,8 +21,27 @@ func Clone(path, url, branch string) error {
 	return runcmd("git", "clone", "-b", branch, "--single-branch", url, path)
 }
 
-func BranchHeadCommit(url, branch string) (string, error) {
+//BranchHeadCommit gets the head commit of the remote branch, sshKey is the private key for ssh repositories,
+//empty if not needed. The ssh host key is verified with knownHosts if it is given.
+func BranchHeadCommit(url, branch, sshKey, knownHosts string) (string, error) {
 	cmd := exec.Command("git", "ls-remote", url, branch)
+	if sshKey != "" {
+		keyFile, err := writeSSHFile("git-ssh-key", strings.TrimSpace(sshKey))
+		if err != nil {
+			return "", err
+		}
+		defer os.Remove(keyFile)
+		knownHostsFile := ""
+		if knownHosts != "" {
+			if knownHostsFile, err = writeSSHFile("git-known-hosts", knownHosts); err != nil {
+				return "", err
+			}
+			defer os.Remove(knownHostsFile)
+		} else {
+			log.Warningf("host key of '%s' is not verified without known hosts", url)
+		}
+		cmd.Env = append(os.Environ(), "GIT_SSH_COMMAND="+SSHCommand(keyFile, knownHostsFile))
+	}
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return "", errors.Wrap(err, string(output))
@@ -70,33 +91,43 @@ func IsValid(url string) bool {
 	return (err == nil)
 }
 
-func GetAuthRepoUrl(url, user, token string) (string, error) {
-	if user != "" && token != "" {
-		userName, err := getUserName(user)
-		if err != nil {
-			return "", err
-		}
-		url = strings.Replace(url, "://", "://"+userName+":"+token+"@", 1)
+//GetAuthRepoUrl gets the url carrying the credential, userName is the user to clone with the token
+func GetAuthRepoUrl(repoUrl, userName, token string) (string, error) {
+	if userName != "" && token != "" {
+		repoUrl = strings.Replace(repoUrl, "://", "://"+url.UserPassword(userName, token).String()+"@", 1)
 	} else {
 		return "", errors.New("credential for git repo not provided")
 	}
-	return url, nil
+	return repoUrl, nil
 }
 
-func getUserName(gitUser string) (string, error) {
-	splits := strings.Split(gitUser, ":")
-	if len(splits) != 2 {
-		return "", fmt.Errorf("invalid gituser format '%s'", gitUser)
+//SSHCommand gets the ssh command for GIT_SSH_COMMAND to access repositories with the private key file,
+//the host key is pinned to the known hosts file. Without known hosts file the host key is not checked,
+//which is kept for credentials added before known hosts are supported.
+func SSHCommand(keyFile string, knownHostsFile string) string {
+	if knownHostsFile == "" {
+		return "ssh -i " + keyFile + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null"
 	}
-	scmType := splits[0]
-	userName := splits[1]
-	if scmType == "gitlab" {
-		return "oauth2", nil
-	} else if scmType == "github" {
-		return userName, nil
-	} else {
-		return "", fmt.Errorf("unsupported scmType '%s'", scmType)
+	return "ssh -i " + keyFile + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=yes -o UserKnownHostsFile=" + knownHostsFile
+}
+
+//writeSSHFile writes the content to a temp file that only the owner can read
+func writeSSHFile(prefix string, content string) (string, error) {
+	f, err := ioutil.TempFile("", prefix)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := f.Chmod(0600); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	//ssh rejects keys without the trailing newline
+	if _, err := f.WriteString(strings.TrimSuffix(content, "\n") + "\n"); err != nil {
+		os.Remove(f.Name())
+		return "", err
 	}
+	return f.Name(), nil
 }
 
 func runcmd(name string, arg ...string) error {
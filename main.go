@@ -1,13 +1,19 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/Sirupsen/logrus"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/rancher/pipeline/artifact"
 	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/provider/docker"
 	"github.com/rancher/pipeline/provider/jenkins"
+	"github.com/rancher/pipeline/provider/kubernetes"
 	"github.com/rancher/pipeline/server"
+	"github.com/rancher/pipeline/server/service"
+	"github.com/rancher/pipeline/store"
 	"github.com/urfave/cli"
 )
 
@@ -54,12 +60,145 @@ func main() {
 			EnvVar: "CATTLE_SECRET_KEY",
 			Value:  "",
 		},
+		cli.StringFlag{
+			Name:   "storage_driver",
+			Usage:  "storage of pipeline data, genericobject for generic objects of the Cattle API, sqlite3 for a database file embedded in the server, or mysql for an external MySQL database",
+			EnvVar: "STORAGE_DRIVER",
+			Value:  "genericobject",
+		},
+		cli.StringFlag{
+			Name:   "storage_dsn",
+			Usage:  "data source name of the storage, e.g. user:password@tcp(host:3306)/pipeline for mysql, or the database file for sqlite3",
+			EnvVar: "STORAGE_DSN",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "provider",
+			Usage:  "provider to run pipelines, jenkins, docker or kubernetes",
+			EnvVar: "PIPELINE_PROVIDER",
+			Value:  "jenkins",
+		},
+		cli.StringFlag{
+			Name:   "docker_host",
+			Usage:  "docker daemon address used by docker provider",
+			EnvVar: "DOCKER_HOST",
+			Value:  "unix:///var/run/docker.sock",
+		},
+		cli.StringFlag{
+			Name:   "kube_host",
+			Usage:  "kubernetes api address used by kubernetes provider, in-cluster config is used if not set",
+			EnvVar: "KUBE_HOST",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "kube_token",
+			Usage:  "bearer token of kubernetes api",
+			EnvVar: "KUBE_TOKEN",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "kube_namespace",
+			Usage:  "namespace to run pipeline jobs",
+			EnvVar: "KUBE_NAMESPACE",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "kube_volume_size",
+			Usage:  "size of the workspace volume claim of each kubernetes activity run",
+			EnvVar: "KUBE_VOLUME_SIZE",
+			Value:  "1Gi",
+		},
+		cli.StringFlag{
+			Name:   "kube_volume_class",
+			Usage:  "storage class of workspace volume claims, the default storage class is used if not set",
+			EnvVar: "KUBE_VOLUME_CLASS",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "encryption_key",
+			Usage:  "master key to encrypt secrets, credentials and oauth tokens at rest",
+			EnvVar: "PIPELINE_ENCRYPTION_KEY",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "server_url",
+			Usage:  "public address of the pipeline server, e.g. https://pipeline.example.com, commit statuses link to activities on it if set",
+			EnvVar: "PIPELINE_SERVER_URL",
+			Value:  "",
+		},
+		cli.StringFlag{
+			Name:   "artifact_path",
+			Usage:  "directory to keep artifacts archived by steps",
+			EnvVar: "PIPELINE_ARTIFACT_PATH",
+			Value:  "/var/lib/pipeline/artifacts",
+		},
+		cli.StringFlag{
+			Name:   "cache_path",
+			Usage:  "directory to keep caches saved by steps",
+			EnvVar: "PIPELINE_CACHE_PATH",
+			Value:  "/var/lib/pipeline/caches",
+		},
+		cli.StringFlag{
+			Name:   "log_path",
+			Usage:  "directory to keep compressed logs of finished steps",
+			EnvVar: "PIPELINE_LOG_PATH",
+			Value:  "/var/lib/pipeline/logs",
+		},
+		cli.IntFlag{
+			Name:   "cache_max_size",
+			Usage:  "max size in MB of caches of each pipeline, least recently saved caches are evicted first",
+			EnvVar: "PIPELINE_CACHE_MAX_SIZE",
+			Value:  2048,
+		},
+		cli.IntFlag{
+			Name:   "cache_max_age",
+			Usage:  "days to keep caches that are not saved again",
+			EnvVar: "PIPELINE_CACHE_MAX_AGE",
+			Value:  7,
+		},
+		cli.StringFlag{
+			Name:   "default_pipeline_owner",
+			Usage:  "rancher user id to own pipelines and git credentials created without owner, pipelines without owner are read only otherwise",
+			EnvVar: "PIPELINE_DEFAULT_OWNER",
+			Value:  "",
+		},
+		cli.IntFlag{
+			Name:   "activity_keep_last",
+			Usage:  "number of latest activities to keep for each pipeline, older finished ones are removed, 0 to keep all",
+			EnvVar: "PIPELINE_ACTIVITY_KEEP_LAST",
+			Value:  0,
+		},
+		cli.IntFlag{
+			Name:   "activity_max_age",
+			Usage:  "days to keep finished activities, 0 to keep them regardless of age",
+			EnvVar: "PIPELINE_ACTIVITY_MAX_AGE",
+			Value:  0,
+		},
+		cli.BoolTFlag{
+			Name:   "activity_keep_last_success",
+			Usage:  "always keep the last successful activity of each pipeline",
+			EnvVar: "PIPELINE_ACTIVITY_KEEP_LAST_SUCCESS",
+		},
 		cli.BoolFlag{
 			Name:   "debug",
 			Usage:  "enable debug mode",
 			EnvVar: "DEBUG",
 		},
 	}
+	app.Commands = []cli.Command{
+		{
+			Name:   "rotate-key",
+			Usage:  "encrypt stored data by a new encryption key, plaintext data are encrypted too",
+			Action: rotateKey,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "new_encryption_key",
+					Usage:  "the new master key, set it as the encryption key of the server after rotation",
+					EnvVar: "PIPELINE_NEW_ENCRYPTION_KEY",
+				},
+			},
+		},
+	}
 	app.Run(os.Args)
 }
 
@@ -68,8 +207,53 @@ func checkAndRun(c *cli.Context) (rtnerr error) {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 	config.Parse(c)
-	jenkins.InitJenkins()
-	provider := jenkins.JenkinsProvider{}
+	dataStore, err := store.New(config.Config.StorageDriver, config.Config.StorageDSN)
+	if err != nil {
+		logrus.Errorf("fail to init storage: %v", err)
+		return err
+	}
+	service.InitStore(dataStore)
+	if count, err := service.EncryptPlaintextData(); err != nil {
+		logrus.Errorf("fail to encrypt plaintext data: %v", err)
+		return err
+	} else if count > 0 {
+		logrus.Infof("%d plaintext fields are encrypted", count)
+	}
+	if count, err := service.AssignPipelineOwner(config.Config.DefaultOwner); err != nil {
+		logrus.Errorf("fail to assign owner of pipelines: %v", err)
+		return err
+	} else if count > 0 {
+		logrus.Infof("%d pipelines are owned by '%s'", count, config.Config.DefaultOwner)
+	}
+	if count, err := service.AssignCredentialOwner(config.Config.DefaultOwner); err != nil {
+		logrus.Errorf("fail to assign owner of git credentials: %v", err)
+		return err
+	} else if count > 0 {
+		logrus.Infof("%d git credentials are owned by '%s'", count, config.Config.DefaultOwner)
+	}
+	artifactStore, err := artifact.NewFileStore(config.Config.ArtifactPath)
+	if err != nil {
+		logrus.Errorf("fail to init artifact store: %v", err)
+		return err
+	}
+	service.InitArtifactStore(artifactStore)
+	cacheStore, err := artifact.NewFileStore(config.Config.CachePath)
+	if err != nil {
+		logrus.Errorf("fail to init cache store: %v", err)
+		return err
+	}
+	service.InitCacheStore(cacheStore)
+	logStore, err := artifact.NewFileStore(config.Config.LogPath)
+	if err != nil {
+		logrus.Errorf("fail to init log store: %v", err)
+		return err
+	}
+	service.InitLogStore(logStore)
+	provider, err := newProvider()
+	if err != nil {
+		logrus.Errorf("fail to init provider: %v", err)
+		return err
+	}
 	errChan := make(chan bool)
 	go server.ListenAndServe(provider, errChan)
 
@@ -77,3 +261,43 @@ func checkAndRun(c *cli.Context) (rtnerr error) {
 	logrus.Info("Going down")
 	return nil
 }
+
+//rotateKey encrypts stored data by the new encryption key, the current one is the encryption_key flag
+func rotateKey(c *cli.Context) error {
+	config.Parse(c.Parent())
+	dataStore, err := store.New(config.Config.StorageDriver, config.Config.StorageDSN)
+	if err != nil {
+		logrus.Errorf("fail to init storage: %v", err)
+		return err
+	}
+	service.InitStore(dataStore)
+	count, err := service.RotateEncryptionKey(c.String("new_encryption_key"))
+	if err != nil {
+		logrus.Errorf("fail to rotate encryption key: %v", err)
+		return err
+	}
+	logrus.Infof("encryption key is rotated, %d fields are encrypted by the new key", count)
+	return nil
+}
+
+func newProvider() (model.PipelineProvider, error) {
+	switch config.Config.Provider {
+	case "", "jenkins":
+		jenkins.InitJenkins()
+		return jenkins.JenkinsProvider{}, nil
+	case "docker":
+		client, err := docker.NewClient(config.Config.DockerHost)
+		if err != nil {
+			return nil, err
+		}
+		return docker.NewDockerProvider(client), nil
+	case "kubernetes":
+		client, namespace, err := kubernetes.NewClient(config.Config.KubeHost, config.Config.KubeToken, config.Config.KubeNamespace)
+		if err != nil {
+			return nil, err
+		}
+		return kubernetes.NewKubernetesProvider(client, namespace, config.Config.KubeVolumeSize, config.Config.KubeVolumeClass), nil
+	default:
+		return nil, fmt.Errorf("unsupported provider '%s'", config.Config.Provider)
+	}
+}
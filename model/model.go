@@ -18,6 +18,17 @@ const StepTypeUpgradeCatalog = "upgradeCatalog"
 const TriggerTypeCron = "cron"
 const TriggerTypeManual = "manual"
 const TriggerTypeWebhook = "webhook"
+const TriggerTypeGenericWebhook = "genericWebhook"
+const WebhookEventPush = "push"
+const WebhookEventPullRequest = "pullRequest"
+
+const (
+	CommitStatePending  = "pending"
+	CommitStateRunning  = "running"
+	CommitStateSuccess  = "success"
+	CommitStateFailure  = "failure"
+	CommitStateCanceled = "canceled"
+)
 
 const (
 	ActivityStepWaiting  = "Waiting"
@@ -43,16 +54,40 @@ const (
 	ActivityFail     = "Fail"
 	ActivityDenied   = "Denied"
 	ActivityAbort    = "Abort"
+	ActivityQueued   = "Queued"
 )
 
 var ErrPipelineNotFound = errors.New("Pipeline Not found")
+var ErrFileNotFound = errors.New("File not found in repository")
 
 var PreservedEnvs = [...]string{"CICD_GIT_COMMIT", "CICD_GIT_BRANCH",
 	"CICD_GIT_URL", "CICD_PIPELINE_NAME", "CICD_PIPELINE_ID",
 	"CICD_TRIGGER_TYPE", "CICD_NODE_NAME", "CICD_ACTIVITY_ID",
-	"CICD_ACTIVITY_SEQUENCE",
+	"CICD_ACTIVITY_SEQUENCE", "CICD_PR_NUMBER", "CICD_PR_SOURCE_BRANCH",
+	"CICD_PR_TARGET_BRANCH",
 }
 
+//actions on approval timeout of pending stages
+const (
+	ApprovalTimeoutDeny    = "deny"
+	ApprovalTimeoutApprove = "approve"
+)
+
+//policies when a pipeline runs at its max concurrency
+const (
+	ConcurrencyQueue          = "queue"
+	ConcurrencyCancelPrevious = "cancelPrevious"
+	ConcurrencySkip           = "skip"
+)
+
+//roles on a pipeline, each role includes permissions of the previous ones
+const (
+	RoleReader = "reader"
+	RoleRunner = "runner"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
 type PipelineSetting struct {
 	client.Resource
 	Status string `json:"status,omitempty" yaml:"status,omitempty"`
@@ -101,6 +136,59 @@ type PipelineContent struct {
 	CronTrigger   CronTrigger `json:"cronTrigger,omitempty" yaml:"cronTrigger,omitempty"`
 	Stages        []*Stage    `json:"stages,omitempty" yaml:"stages,omitempty"`
 	KeepWorkspace bool        `json:"keepWorkspace,omitempty" yaml:"keepWorkspace,omitempty"`
+	//read stages from the pipeline file in the repository on each run
+	FromRepository bool            `json:"fromRepository,omitempty" yaml:"fromRepository,omitempty"`
+	GenericWebhook *GenericWebhook `json:"genericWebhook,omitempty" yaml:"genericWebhook,omitempty"`
+	//env keys and git credentials that steps of the pipeline file may use, besides those used by stored stages
+	BoundEnvKeys        []string `json:"boundEnvKeys,omitempty" yaml:"boundEnvKeys,omitempty"`
+	BoundGitCredentials []string `json:"boundGitCredentials,omitempty" yaml:"boundGitCredentials,omitempty"`
+	//rancher user id of the creator, who has all permissions on the pipeline
+	Owner   string            `json:"owner,omitempty" yaml:"-"`
+	Members []*PipelineMember `json:"members,omitempty" yaml:"members,omitempty"`
+	//Concurrency limits running activities of the pipeline
+	Concurrency *PipelineConcurrency `json:"concurrency,omitempty" yaml:"concurrency,omitempty"`
+	//AutoCancel stops older activities of the same branch when a newer webhook run starts
+	AutoCancel *AutoCancel `json:"autoCancel,omitempty" yaml:"autoCancel,omitempty"`
+}
+
+//AutoCancel stops activities superseded by newer webhook runs of the same branch, or the same pull request
+type AutoCancel struct {
+	Enabled bool `json:"enabled" yaml:"enabled,omitempty"`
+	//Pending also stops activities waiting for approval, which are kept by default
+	Pending bool `json:"pending,omitempty" yaml:"pending,omitempty"`
+}
+
+//PipelineConcurrency limits running activities of a pipeline
+type PipelineConcurrency struct {
+	//Max is the max number of running activities, unlimited if zero
+	Max int `json:"max,omitempty" yaml:"max,omitempty"`
+	//Policy is one of queue, cancelPrevious and skip, to take on new runs at max concurrency, queue by default
+	Policy string `json:"policy,omitempty" yaml:"policy,omitempty"`
+}
+
+//PipelineMember grants a role on the pipeline to a rancher user
+type PipelineMember struct {
+	UserId string `json:"userId,omitempty" yaml:"userId,omitempty"`
+	//Role is one of reader, runner and editor
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+}
+
+//GenericWebhook triggers the pipeline by any JSON POST to the webhook url of the pipeline
+type GenericWebhook struct {
+	Enabled bool `json:"enabled" yaml:"enabled,omitempty"`
+	//Secret is the key of the hmac-sha256 signature in the X-Pipeline-Signature header,
+	//or the token in the X-Pipeline-Token header
+	Secret string          `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Params []*WebhookParam `json:"params,omitempty" yaml:"params,omitempty"`
+}
+
+//WebhookParam extracts a value from the webhook payload into an env var of the activity
+type WebhookParam struct {
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	//Path is the JSONPath of the value, e.g. $.push_data.tag
+	Path string `json:"path,omitempty" yaml:"path,omitempty"`
+	//Filter is a regular expression the value should match to run the pipeline
+	Filter string `json:"filter,omitempty" yaml:"filter,omitempty"`
 }
 
 type CronTrigger struct {
@@ -113,10 +201,20 @@ type Stage struct {
 	Name        string `json:"name,omitempty" yaml:"name,omitempty"`
 	NeedApprove bool   `json:"needApprove" yaml:"needApprove,omitempty"`
 	Parallel    bool   `json:"parallel" yaml:"parallel,omitempty"`
-	//Condition   string             `json:"condition,omitempty" yaml:"condition,omitempty"`
+	//Condition is an expression, see the condition package
+	Condition  string              `json:"condition,omitempty" yaml:"condition,omitempty"`
 	Conditions *PipelineConditions `json:"conditions,omitempty" yaml:"conditions,omitempty"`
 	Approvers  []string            `json:"approvers,omitempty" yaml:"approvers,omitempty"`
-	Steps      []*Step             `json:"steps,omitempty" yaml:"steps,omitempty"`
+	//ApproverGroups are identity ids of groups whose members can approve, e.g. github_team:123
+	ApproverGroups []string `json:"approverGroups,omitempty" yaml:"approverGroups,omitempty"`
+	//MinApprovals is the number of approvals to run the stage, 1 if not set
+	MinApprovals int `json:"minApprovals,omitempty" yaml:"minApprovals,omitempty"`
+	//ApprovalVeto denies the stage on the first denial, even if MinApprovals can still be reached
+	ApprovalVeto bool `json:"approvalVeto,omitempty" yaml:"approvalVeto,omitempty"`
+	//ApprovalTimeout in minutes, after which the pending stage is handled by ApprovalTimeoutAction
+	ApprovalTimeout       int     `json:"approvalTimeout,omitempty" yaml:"approvalTimeout,omitempty"`
+	ApprovalTimeoutAction string  `json:"approvalTimeoutAction,omitempty" yaml:"approvalTimeoutAction,omitempty"`
+	Steps                 []*Step `json:"steps,omitempty" yaml:"steps,omitempty"`
 }
 
 type Step struct {
@@ -124,13 +222,25 @@ type Step struct {
 	Type string `json:"type,omitempty" yaml:"type,omitempty"`
 	//Step timeout in minutes
 	Timeout int `json:"timeout,omitempty" yaml:"timeout,omitempty"`
-	//Condition  string             `json:"condition,omitempty" yaml:"condition,omitempty"`
+	//Condition is an expression, see the condition package
+	Condition  string              `json:"condition,omitempty" yaml:"condition,omitempty"`
 	Conditions *PipelineConditions `json:"conditions,omitempty" yaml:"conditions,omitempty"`
+	//DependsOn are names of steps to finish before the step runs, steps run by dependencies
+	//instead of stage by stage when any step declares them
+	DependsOn []string `json:"dependsOn,omitempty" yaml:"dependsOn,omitempty"`
+	//Retry reruns the step when it fails
+	Retry *StepRetry `json:"retry,omitempty" yaml:"retry,omitempty"`
 	//---SCM step
 	Repository string `json:"repository,omitempty" yaml:"repository,omitempty"`
 	Branch     string `json:"branch,omitempty" yaml:"branch,omitempty"`
 	GitUser    string `json:"gitUser,omitempty" yaml:"gitUser,omitempty"`
-	Webhook    bool   `json:"webhook" yaml:"webhook,omitempty"`
+	//GitCredential is the id of a ssh key or basic auth credential to clone with instead of the git account
+	GitCredential string `json:"gitCredential,omitempty" yaml:"gitCredential,omitempty"`
+	Webhook       bool   `json:"webhook" yaml:"webhook,omitempty"`
+	//WebhookEvents are events to trigger by webhook, only push if empty
+	WebhookEvents []string `json:"webhookEvents,omitempty" yaml:"webhookEvents,omitempty"`
+	//MergeRef builds the merge result of pull requests instead of the head
+	MergeRef bool `json:"mergeRef,omitempty" yaml:"mergeRef,omitempty"`
 	//---Build step
 	Dockerfile     string `json:"dockerFileContent,omitempty" yaml:"dockerFileContent,omitempty"`
 	BuildPath      string `json:"buildPath,omitempty" yaml:"buildPath,omitempty"`
@@ -147,6 +257,19 @@ type Step struct {
 	Args        string       `json:"args,omitempty" yaml:"args,omitempty"`
 	Env         []string     `json:"env,omitempty" yaml:"env,omitempty"`
 	Services    []*CIService `json:"services,omitempty" yaml:"services,omitempty"`
+	//Matrix expands the task step into one step per combination of the values,
+	//each combination is set to the env of the expanded step
+	Matrix map[string][]string `json:"matrix,omitempty" yaml:"matrix,omitempty"`
+	//MatrixOf is the name of the matrix step that the step is expanded from
+	MatrixOf string `json:"matrixOf,omitempty" yaml:"-"`
+	//Secrets are names of pipeline secrets injected as env vars into the step container
+	Secrets []string `json:"secrets,omitempty" yaml:"secrets,omitempty"`
+	//Artifacts are glob paths relative to the workspace, files matching them are archived when the step succeeds
+	Artifacts []string `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+	//RestoreArtifacts extracts archived artifacts of the activity into the workspace before the step runs
+	RestoreArtifacts bool `json:"restoreArtifacts,omitempty" yaml:"restoreArtifacts,omitempty"`
+	//Cache is restored before and saved after the step
+	Cache *StepCache `json:"cache,omitempty" yaml:"cache,omitempty"`
 
 	//---upgradeService step
 	ImageTag        string            `json:"imageTag,omitempty" yaml:"imageTag,omitempty"`
@@ -194,6 +317,57 @@ type Activity struct {
 	ActivityStages  []*ActivityStage  `json:"activity_stages,omitempty"`
 	EnvVars         map[string]string `json:"envVars,omitempty"`
 	TriggerType     string            `json:"triggerType,omitempty"`
+	//ChangedFiles are paths changed by the triggering push, nil if unknown
+	ChangedFiles []string     `json:"changedFiles,omitempty"`
+	PullRequest  *PullRequest `json:"pullRequest,omitempty"`
+	Artifacts    []*Artifact  `json:"artifacts,omitempty"`
+	//QueuePosition is the 1-based position of the queued activity in the queue of the pipeline
+	QueuePosition int `json:"queuePosition,omitempty"`
+	//CallbackToken authorizes event requests of steps of the run, it is issued when the activity starts
+	CallbackToken string `json:"callbackToken,omitempty"`
+}
+
+//Artifact is a file archived from the workspace by a step of the activity
+type Artifact struct {
+	client.Resource
+	//Name is the path relative to the workspace
+	Name     string `json:"name,omitempty"`
+	StepName string `json:"stepName,omitempty"`
+	Size     int64  `json:"size"`
+	//Checksum is the sha256 of the content in hex
+	Checksum string `json:"checksum,omitempty"`
+	CreateTS int64  `json:"create_ts,omitempty"`
+}
+
+//CommitStatus is the status of an activity reported to the commit in the SCM
+type CommitStatus struct {
+	//State is one of the CommitState constants
+	State       string
+	Context     string
+	Description string
+	TargetURL   string
+}
+
+//PullRequest is the pull request or merge request that triggers an activity
+type PullRequest struct {
+	Number       int    `json:"number"`
+	SourceBranch string `json:"sourceBranch,omitempty"`
+	TargetBranch string `json:"targetBranch,omitempty"`
+	//Ref is fetched to build, e.g. refs/pull/1/head
+	Ref string `json:"ref,omitempty"`
+	//Fork is true if the source branch is of another repository, secrets, env keys and git credentials are not given to it
+	Fork bool `json:"fork,omitempty"`
+}
+
+//TriggerInfo describes what triggers an activity
+type TriggerInfo struct {
+	TriggerType  string
+	ChangedFiles []string
+	//Commit to run, the branch head is used if empty
+	Commit string
+	//EnvVars are added to the activity, e.g. params of generic webhooks
+	EnvVars     map[string]string
+	PullRequest *PullRequest
 }
 
 type ActivityStage struct {
@@ -206,6 +380,17 @@ type ActivityStage struct {
 	Duration      int64           `json:"duration,omitempty"`
 	Status        string          `json:"status,omitempty"`
 	RawOutput     string          `json:"rawOutput,omitempty"`
+	//PendingTS is when the stage starts pending for approval
+	PendingTS int64       `json:"pending_ts,omitempty"`
+	Approvals []*Approval `json:"approvals,omitempty"`
+}
+
+//Approval is an approval or denial of a pending stage, the user id is empty if it is made on timeout
+type Approval struct {
+	UserId   string `json:"userId,omitempty"`
+	Approved bool   `json:"approved"`
+	Comment  string `json:"comment,omitempty"`
+	TS       int64  `json:"ts,omitempty"`
 }
 
 type ActivityStep struct {
@@ -214,6 +399,23 @@ type ActivityStep struct {
 	Status   string `json:"status,omitempty"`
 	StartTS  int64  `json:"start_ts,omitempty"`
 	Duration int64  `json:"duration,omitempty"`
+	//Triggered is set when the step is triggered by dependencies, before it starts
+	Triggered bool `json:"triggered,omitempty"`
+	//Attempts are finished runs of the step with retry, RetryTS is when the next attempt runs
+	Attempts []*StepAttempt `json:"attempts,omitempty"`
+	RetryTS  int64          `json:"retry_ts,omitempty"`
+}
+
+//StepAttempt is a finished run of a step with retry
+type StepAttempt struct {
+	Status   string `json:"status,omitempty"`
+	StartTS  int64  `json:"start_ts,omitempty"`
+	Duration int64  `json:"duration,omitempty"`
+	//ExitCode is -1 if unknown
+	ExitCode int  `json:"exitCode"`
+	TimedOut bool `json:"timedOut,omitempty"`
+	//LogSaved is set when the log of the attempt is kept in the log store
+	LogSaved bool `json:"logSaved,omitempty"`
 }
 
 type CIService struct {
@@ -224,24 +426,40 @@ type CIService struct {
 	Command       string `json:"command,omitempty"`
 }
 
-func (activity *Activity) CanApprove(userId string) bool {
+//CanApprove checks whether the user in the groups can approve or deny the pending stage,
+//each user approves or denies once
+func (activity *Activity) CanApprove(userId string, groups []string) bool {
 	if activity.Status == ActivityPending && len(activity.Pipeline.Stages) > activity.PendingStage {
-		approvers := activity.Pipeline.Stages[activity.PendingStage].Approvers
-		if len(approvers) == 0 {
+		if activity.PendingStage < len(activity.ActivityStages) {
+			for _, approval := range activity.ActivityStages[activity.PendingStage].Approvals {
+				if approval.UserId == userId {
+					return false
+				}
+			}
+		}
+		stage := activity.Pipeline.Stages[activity.PendingStage]
+		if len(stage.Approvers) == 0 && len(stage.ApproverGroups) == 0 {
 			//no approver limit
 			return true
 		}
-		for _, approver := range approvers {
+		for _, approver := range stage.Approvers {
 			if approver == userId {
 				return true
 			}
 		}
+		for _, approverGroup := range stage.ApproverGroups {
+			for _, group := range groups {
+				if approverGroup == group {
+					return true
+				}
+			}
+		}
 	}
 	return false
 }
 
 type PipelineProvider interface {
-	RunPipeline(*Pipeline, string) (*Activity, error)
+	RunPipeline(*Pipeline, *TriggerInfo) (*Activity, error)
 	RerunActivity(*Activity) error
 	RunStage(*Activity, int) error
 	RunStep(*Activity, int, int) error
@@ -249,8 +467,14 @@ type PipelineProvider interface {
 	SyncActivity(*Activity) error
 	GetStepLog(*Activity, int, int, map[string]interface{}) (string, error)
 	OnActivityCompelte(*Activity)
+	//OnDeleteActivity removes jobs, workspace and logs of the deleted activity kept by the provider
+	OnDeleteActivity(*Activity) error
 	OnCreateAccount(*GitAccount) error
 	OnDeleteAccount(*GitAccount) error
+	OnCreateCredential(*Credential) error
+	OnDeleteCredential(*Credential) error
+	//ArchiveArtifacts stores artifacts of the finished step in the artifact store
+	ArchiveArtifacts(*Activity, int, int) ([]*Artifact, error)
 	Reset() error
 }
 
@@ -263,7 +487,14 @@ type SCManager interface {
 	OAuth(redirectURL string, clientID string, clientSecret string, code string) (*GitAccount, error)
 	DeleteWebhook(pipeline *Pipeline, gitToken string) error
 	CreateWebhook(pipeline *Pipeline, gitToken string, ciEndpoint string) error
-	VerifyWebhookPayload(pipeline *Pipeline, req *http.Request) bool
+	//GetCloneUser gets the user to clone repositories with the access token of the login
+	GetCloneUser(login string) string
+	//VerifyWebhookPayload returns what triggers the pipeline, or false if the payload is invalid or not to trigger.
+	//The trigger is nil for valid payloads that trigger nothing, e.g. pings
+	VerifyWebhookPayload(pipeline *Pipeline, req *http.Request) (*TriggerInfo, bool)
+	//SetCommitStatus reports the status of an activity to the commit
+	SetCommitStatus(repoURL string, commit string, status *CommitStatus, gitToken string) error
+	GetFileContent(repoURL string, ref string, path string, gitToken string) ([]byte, error)
 }
 
 type GitAccount struct {
@@ -288,9 +519,63 @@ type GitRepository struct {
 	Permissions map[string]bool `json:"permissions,omitempty"`
 }
 
+const (
+	CredTypeEnvKey = "envKey"
+	//CredTypeSSHKey is a ssh private key, PublicValue is the user name and SecretValue is the private key
+	CredTypeSSHKey = "sshKey"
+	//CredTypeBasicAuth is a https credential, PublicValue is the user name and SecretValue is the password
+	CredTypeBasicAuth = "basicAuth"
+	//CredTypeSecret is a secret of a pipeline, Name is the secret name, PublicValue is the pipeline id and
+	//SecretValue is the value
+	CredTypeSecret = "secret"
+)
+
 type Credential struct {
 	client.Resource
+	Name        string `json:"name"`
 	CredType    string `json:"credType"`
 	PublicValue string `json:"publicValue"`
 	SecretValue string `json:"secretValue"`
+	//KnownHosts is the known_hosts entry of the ssh host to verify for ssh key credential
+	KnownHosts string `json:"knownHosts,omitempty"`
+	//Owner is the user creating the git credential, who manages and uses it in pipelines.
+	//Credentials without owner are shared by all users.
+	Owner string `json:"owner,omitempty"`
+}
+
+//StepRetry declares when and how many times to rerun a failed step
+type StepRetry struct {
+	//Attempts is the max number of runs including the first one
+	Attempts int `json:"attempts" yaml:"attempts"`
+	//Backoff is the seconds to wait before the first retry, doubled for each following retry
+	Backoff int `json:"backoff,omitempty" yaml:"backoff,omitempty"`
+	//ExitCodes and OnTimeout limit retries to failures of the exit codes or timeouts,
+	//any failure is retried if neither is set
+	ExitCodes []int `json:"exitCodes,omitempty" yaml:"exitCodes,omitempty"`
+	OnTimeout bool  `json:"onTimeout,omitempty" yaml:"onTimeout,omitempty"`
+}
+
+//StepCache declares workspace paths cached across activities of the pipeline
+type StepCache struct {
+	//Key is a template like `go-{{ checksum "go.sum" }}`, env vars like ${CICD_GIT_BRANCH} are substituted
+	Key string `json:"key" yaml:"key"`
+	//Paths are relative to the workspace
+	Paths []string `json:"paths" yaml:"paths"`
+}
+
+//Cache is a saved cache of a pipeline
+type Cache struct {
+	client.Resource
+	Key        string `json:"key"`
+	PipelineId string `json:"pipelineId"`
+	Size       int64  `json:"size"`
+	UpdateTS   int64  `json:"update_ts"`
+}
+
+//Secret is a secret of a pipeline in API, the value is never returned
+type Secret struct {
+	client.Resource
+	Name       string `json:"name"`
+	PipelineId string `json:"pipelineId"`
+	Value      string `json:"value,omitempty"`
 }
@@ -2,6 +2,7 @@ package model
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
@@ -18,6 +19,10 @@ func NewSchema() *client.Schemas {
 	scmSettingSchema(schemas.AddType("scmSetting", SCMSetting{}))
 	accountSchema(schemas.AddType("gitaccount", GitAccount{}))
 	repositorySchema(schemas.AddType("gitrepository", GitRepository{}))
+	credentialSchema(schemas.AddType("gitcredential", Credential{}))
+	secretSchema(schemas.AddType("secret", Secret{}))
+	artifactSchema(schemas.AddType("artifact", Artifact{}))
+	cacheSchema(schemas.AddType("cache", Cache{}))
 	return schemas
 }
 
@@ -137,6 +142,33 @@ func repositorySchema(repository *client.Schema) {
 	repository.PluralName = "gitrepositories"
 }
 
+func credentialSchema(credential *client.Schema) {
+	credential.CollectionMethods = []string{http.MethodGet, http.MethodPost}
+	credential.ResourceActions = map[string]client.Action{
+		"update": client.Action{
+			Output: "gitcredential",
+		},
+		"remove": client.Action{
+			Output: "gitcredential",
+		},
+	}
+}
+
+func secretSchema(secret *client.Schema) {
+	secret.CollectionMethods = []string{http.MethodGet, http.MethodPost}
+	secret.ResourceMethods = []string{http.MethodGet, http.MethodDelete}
+}
+
+func artifactSchema(artifact *client.Schema) {
+	artifact.CollectionMethods = []string{http.MethodGet}
+	artifact.ResourceMethods = []string{http.MethodGet}
+}
+
+func cacheSchema(cache *client.Schema) {
+	cache.CollectionMethods = []string{http.MethodGet, http.MethodDelete}
+	cache.ResourceMethods = []string{http.MethodGet, http.MethodDelete}
+}
+
 func ToPipelineCollections(apiContext *api.ApiContext, pipelines []*Pipeline) []interface{} {
 	var r []interface{}
 	for _, p := range pipelines {
@@ -161,6 +193,8 @@ func ToPipelineResource(apiContext *api.ApiContext, pipeline *Pipeline) *Pipelin
 
 	pipeline.Links["activities"] = apiContext.UrlBuilder.Link(pipeline.Resource, "activities")
 	pipeline.Links["exportConfig"] = apiContext.UrlBuilder.Link(pipeline.Resource, "exportConfig")
+	pipeline.Links["secrets"] = apiContext.UrlBuilder.Link(pipeline.Resource, "secrets")
+	pipeline.Links["caches"] = apiContext.UrlBuilder.Link(pipeline.Resource, "caches")
 	FilterPipeline(pipeline)
 	return pipeline
 }
@@ -177,16 +211,31 @@ func ToActivityResource(apiContext *api.ApiContext, a *Activity) *Activity {
 	//TODO if a.Iscomplete()
 	if a.Status != ActivityWaiting &&
 		a.Status != ActivityBuilding &&
-		a.Status != ActivityPending {
+		a.Status != ActivityPending &&
+		a.Status != ActivityQueued {
 		a.Actions["rerun"] = apiContext.UrlBuilder.ReferenceLink(a.Resource) + "?action=rerun"
 	} else {
 		a.Actions["stop"] = apiContext.UrlBuilder.ReferenceLink(a.Resource) + "?action=stop"
 	}
 
+	a.Links["artifacts"] = apiContext.UrlBuilder.Link(a.Resource, "artifacts")
 	FilterActivity(a)
 	return a
 }
 
+//ToArtifactResource sets the download link of the artifact of the activity
+func ToArtifactResource(apiContext *api.ApiContext, activityId string, artifact *Artifact) *Artifact {
+	activity := client.Resource{Id: activityId, Type: "activity"}
+	artifact.Resource = client.Resource{
+		Id:      artifact.Name,
+		Type:    "artifact",
+		Actions: map[string]string{},
+		Links:   map[string]string{},
+	}
+	artifact.Links["download"] = apiContext.UrlBuilder.Link(activity, "artifacts") + "/" + (&url.URL{Path: artifact.Name}).EscapedPath()
+	return artifact
+}
+
 func ToAccountResource(apiContext *api.ApiContext, account *GitAccount) *GitAccount {
 	account.Resource = client.Resource{
 		Id:      account.Id,
@@ -216,6 +265,44 @@ func ToRepositoryResource(apiContext *api.ApiContext, repository *GitRepository)
 	return repository
 }
 
+func ToCredentialResource(apiContext *api.ApiContext, cred *Credential) *Credential {
+	cred.Resource = client.Resource{
+		Id:      cred.Id,
+		Type:    "gitcredential",
+		Actions: map[string]string{},
+		Links:   map[string]string{},
+	}
+	cred.Actions["update"] = apiContext.UrlBuilder.ReferenceLink(cred.Resource) + "?action=update"
+	cred.Actions["remove"] = apiContext.UrlBuilder.ReferenceLink(cred.Resource) + "?action=remove"
+	FilterCredential(cred)
+	return cred
+}
+
+//ToSecretResource gets the secret of the pipeline from the credential, without the value
+func ToSecretResource(apiContext *api.ApiContext, cred *Credential) *Secret {
+	secret := &Secret{
+		Name:       cred.Name,
+		PipelineId: cred.PublicValue,
+	}
+	secret.Resource = client.Resource{
+		Id:      cred.Id,
+		Type:    "secret",
+		Actions: map[string]string{},
+		Links:   map[string]string{},
+	}
+	return secret
+}
+
+func ToCacheResource(apiContext *api.ApiContext, cache *Cache) *Cache {
+	cache.Resource = client.Resource{
+		Id:      cache.Key,
+		Type:    "cache",
+		Actions: map[string]string{},
+		Links:   map[string]string{},
+	}
+	return cache
+}
+
 func ToPipelineSettingResource(apiContext *api.ApiContext, setting *PipelineSetting) *PipelineSetting {
 	setting.Resource = client.Resource{
 		Type:    "setting",
@@ -245,6 +332,9 @@ func ToSCMSettingResource(apiContext *api.ApiContext, setting *SCMSetting) *SCMS
 
 func FilterPipeline(pipeline *Pipeline) {
 	pipeline.WebHookToken = ""
+	if pipeline.GenericWebhook != nil {
+		pipeline.GenericWebhook.Secret = ""
+	}
 	for _, stage := range pipeline.Stages {
 		for _, step := range stage.Steps {
 			step.Secretkey = ""
@@ -256,6 +346,7 @@ func FilterPipeline(pipeline *Pipeline) {
 func FilterActivity(activity *Activity) {
 	//remove pipeline reference
 	activity.Pipeline.Type = ""
+	activity.CallbackToken = ""
 	FilterPipeline(&activity.Pipeline)
 }
 
@@ -263,6 +354,10 @@ func FilterAccount(account *GitAccount) {
 	account.AccessToken = ""
 }
 
+func FilterCredential(cred *Credential) {
+	cred.SecretValue = ""
+}
+
 func FilterSCMSetting(setting *SCMSetting) {
 	setting.ClientSecret = ""
 }
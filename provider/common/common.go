@@ -0,0 +1,72 @@
+package common
+
+import (
+	"bytes"
+
+	"github.com/rancher/pipeline/git"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//files where CloneScript writes the ssh private key and known hosts, outside of the workspace
+const (
+	sshKeyFile     = "/tmp/.cicd-git-ssh-key"
+	knownHostsFile = "/tmp/.cicd-git-known-hosts"
+)
+
+//ActivityEnv gets env vars of the activity in KEY=value format
+func ActivityEnv(activity *model.Activity) []string {
+	env := []string{}
+	for k, v := range activity.EnvVars {
+		env = append(env, k+"="+v)
+	}
+	return env
+}
+
+//CloneEnv gets env vars used by CloneScript, the repository url carries the account token or
+//the basic auth credential, the ssh private key and known hosts are given if the step uses a ssh key credential
+func CloneEnv(activity *model.Activity, step *model.Step) ([]string, error) {
+	repoUrl, sshKey, knownHosts, err := service.GetActivityCloneCredential(activity, step)
+	if err != nil {
+		return nil, err
+	}
+	env := []string{"R_CICD_GIT_URL=" + repoUrl, "R_CICD_GIT_BRANCH=" + step.Branch}
+	if sshKey != "" {
+		env = append(env, "R_CICD_GIT_SSH_KEY="+sshKey)
+	}
+	if knownHosts != "" {
+		env = append(env, "R_CICD_GIT_KNOWN_HOSTS="+knownHosts)
+	}
+	return env, nil
+}
+
+//CloneScript clones the repository into current directory and checks out the ref of the pull request, or the
+//activity commit if any. The head commit is written to commitFile if it is given.
+func CloneScript(activity *model.Activity, commitFile string) string {
+	script := bytes.NewBufferString("set -e\n")
+	script.WriteString("if [ -n \"$R_CICD_GIT_SSH_KEY\" ]; then\n")
+	script.WriteString("printf '%s\\n' \"$R_CICD_GIT_SSH_KEY\" > " + sshKeyFile + "\n")
+	script.WriteString("chmod 600 " + sshKeyFile + "\n")
+	script.WriteString("if [ -n \"$R_CICD_GIT_KNOWN_HOSTS\" ]; then\n")
+	script.WriteString("printf '%s\\n' \"$R_CICD_GIT_KNOWN_HOSTS\" > " + knownHostsFile + "\n")
+	script.WriteString("export GIT_SSH_COMMAND=\"" + git.SSHCommand(sshKeyFile, knownHostsFile) + "\"\n")
+	script.WriteString("else\n")
+	script.WriteString("echo \"Warning: the ssh host key is not verified, add known hosts to the git credential\"\n")
+	script.WriteString("export GIT_SSH_COMMAND=\"" + git.SSHCommand(sshKeyFile, "") + "\"\n")
+	script.WriteString("fi\n")
+	script.WriteString("fi\n")
+	script.WriteString("echo \"Cloning the remote Git repository\"\n")
+	script.WriteString("git clone --branch \"$R_CICD_GIT_BRANCH\" --single-branch \"$R_CICD_GIT_URL\" .\n")
+	if activity.PullRequest != nil {
+		//the pull request ref is always built, the commit of the activity may not be in the repository
+		script.WriteString("git fetch -q origin " + activity.PullRequest.Ref + "\n")
+		script.WriteString("git checkout -q FETCH_HEAD\n")
+	} else if activity.CommitInfo != "" && activity.CommitInfo != "null" {
+		script.WriteString("git checkout -q " + activity.CommitInfo + "\n")
+	}
+	if commitFile != "" {
+		script.WriteString("git rev-parse HEAD > " + commitFile + "\n")
+	}
+	script.WriteString("git log -1 --oneline\n")
+	return script.String()
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+const (
+	eventEndpoint = "http://localhost:60080/v1/events"
+	readyTimeout  = 30 * time.Second
+)
+
+//PostStepEvent notifies pipeline server of step events as jenkins post build scripts do,
+//status in form is sent in query for stepfinish event
+func PostStepEvent(event string, activityId string, stageOrdinal int, stepOrdinal int, form url.Values) error {
+	v := url.Values{}
+	v.Set("id", activityId)
+	v.Set("stageOrdinal", strconv.Itoa(stageOrdinal))
+	v.Set("stepOrdinal", strconv.Itoa(stepOrdinal))
+	if status := form.Get("status"); status != "" {
+		v.Set("status", status)
+	}
+	resp, err := http.PostForm(eventEndpoint+"/"+event+"?"+v.Encode(), form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("post %s event got status %d", event, resp.StatusCode)
+	}
+	return nil
+}
+
+//WaitStepReady waits until the step is saved as waiting, so that step events are not overwritten
+//by the caller who saves the activity after triggering the step
+func WaitStepReady(activityId string, stageOrdinal int, stepOrdinal int) {
+	deadline := time.Now().Add(readyTimeout)
+	for time.Now().Before(deadline) {
+		activity, err := service.GetActivity(activityId)
+		if err == nil && activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Status == model.ActivityStepWaiting {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	logrus.Warnf("wait for step %d of stage %d in activity '%s' timeout", stepOrdinal, stageOrdinal, activityId)
+}
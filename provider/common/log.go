@@ -0,0 +1,59 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//FormatLog converts RFC3339Nano timestamps of log lines to durations since the activity start,
+//which is the format of jenkins timestamper that log handlers expect
+func FormatLog(startTS int64, rawLog string) string {
+	b := bytes.NewBufferString("")
+	for _, line := range strings.Split(rawLog, "\n") {
+		if line == "" {
+			continue
+		}
+		spans := strings.SplitN(line, " ", 2)
+		text := ""
+		if len(spans) == 2 {
+			text = spans[1]
+		}
+		t, err := time.Parse(time.RFC3339Nano, spans[0])
+		if err != nil {
+			b.WriteString(line + "\n")
+			continue
+		}
+		d := t.UnixNano()/int64(time.Millisecond) - startTS
+		if d < 0 {
+			d = 0
+		}
+		b.WriteString(fmtDuration(d) + "  " + text + "\n")
+	}
+	return b.String()
+}
+
+//FinishLog appends the finish line of the status
+func FinishLog(stepLog string, status string) string {
+	if status == "" {
+		return stepLog
+	}
+	if !strings.HasSuffix(stepLog, "\n") {
+		stepLog += "\n"
+	}
+	return stepLog + "  Finished: " + status + "\n"
+}
+
+func fmtDuration(ms int64) string {
+	return fmt.Sprintf("%02dh%02dm%02ds%03dms", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+//ParseTimestamp parses RFC3339Nano time to unix milliseconds, current time is used if fail
+func ParseTimestamp(ts string) int64 {
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"strings"
+
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/pipeline/util"
+)
+
+//RegistryCredential gets username and password of the image registry from rancher registries,
+//empty values are returned if not found
+func RegistryCredential(image string) (string, string, string, error) {
+	serverAddress := "index.docker.io"
+	if splits := strings.SplitN(image, "/", 2); len(splits) == 2 && strings.ContainsAny(splits[0], ".:") {
+		serverAddress = splits[0]
+	}
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return serverAddress, "", "", err
+	}
+	registries, err := apiClient.Registry.List(&client.ListOpts{
+		Filters: map[string]interface{}{"serverAddress": serverAddress},
+	})
+	if err != nil {
+		return serverAddress, "", "", err
+	}
+	for _, registry := range registries.Data {
+		creds, err := apiClient.RegistryCredential.List(&client.ListOpts{
+			Filters: map[string]interface{}{"registryId": registry.Id},
+		})
+		if err != nil {
+			return serverAddress, "", "", err
+		}
+		for _, cred := range creds.Data {
+			if cred.State == "active" {
+				return serverAddress, cred.PublicValue, cred.SecretValue, nil
+			}
+		}
+	}
+	return serverAddress, "", "", nil
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+//rebaseTar streams entries under prefix of the archive with the prefix trimmed,
+//then appends the extra files. It is used to form build context from workspace.
+func rebaseTar(r io.Reader, prefix string, extra map[string][]byte) io.ReadCloser {
+	prefix = strings.Trim(path.Clean(prefix), "/")
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			name := strings.Trim(path.Clean(hdr.Name), "/")
+			if name != prefix && !strings.HasPrefix(name, prefix+"/") {
+				continue
+			}
+			name = strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+			if name == "" {
+				continue
+			}
+			hdr.Name = name
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		for name, content := range extra {
+			hdr := &tar.Header{
+				Name: name,
+				Mode: 0644,
+				Size: int64(len(content)),
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := tw.Write(content); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
+//readTarFile reads content of the first regular file in the archive
+func readTarFile(r io.Reader) ([]byte, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, ErrNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.TrimSpace(b), nil
+		}
+	}
+}
+
+//selectTar streams entries under any of the paths relative to prefix of the archive,
+//with the prefix trimmed. It is used to form caches from workspace.
+func selectTar(r io.Reader, prefix string, paths []string) io.ReadCloser {
+	prefix = strings.Trim(path.Clean(prefix), "/")
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			name := strings.TrimPrefix(strings.Trim(path.Clean(hdr.Name), "/"), prefix+"/")
+			if !underPaths(name, paths) {
+				continue
+			}
+			hdr.Name = name
+			if err := tw.WriteHeader(hdr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
+func underPaths(name string, paths []string) bool {
+	for _, p := range paths {
+		p = path.Clean(p)
+		if name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+//tarFileChecksum gets sha256 of the first regular file in the archive
+func tarFileChecksum(r io.Reader) (string, error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", ErrNotFound
+		} else if err != nil {
+			return "", err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return "", err
+			}
+			return hex.EncodeToString(h.Sum(nil)), nil
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+)
+
+const apiVersion = "v1.24"
+
+var (
+	ErrNotFound       = errors.New("docker object not found")
+	ErrCreateFail     = errors.New("Create container fail")
+	ErrBuildImageFail = errors.New("Build image fail")
+)
+
+//Client is the subset of docker engine API used by the provider
+type Client interface {
+	CreateVolume(name string) error
+	RemoveVolume(name string) error
+	CreateContainer(name string, conf *ContainerConfig) (string, error)
+	StartContainer(id string) error
+	WaitContainer(id string) (int, error)
+	StopContainer(id string, timeout int) error
+	RemoveContainer(id string) error
+	InspectContainer(id string) (*ContainerState, error)
+	ListContainers(labels ...string) ([]string, error)
+	ContainerLogs(id string) (string, error)
+	CopyFromContainer(id string, path string) (io.ReadCloser, error)
+	CopyToContainer(id string, path string, r io.Reader) error
+	BuildImage(tag string, dockerfile string, context io.Reader, output io.Writer) error
+	PushImage(image string, auth string, output io.Writer) error
+}
+
+type ContainerConfig struct {
+	Image      string
+	Entrypoint []string          `json:",omitempty"`
+	Cmd        []string          `json:",omitempty"`
+	Env        []string          `json:",omitempty"`
+	WorkingDir string            `json:",omitempty"`
+	Labels     map[string]string `json:",omitempty"`
+	HostConfig HostConfig
+}
+
+type HostConfig struct {
+	Binds []string `json:",omitempty"`
+	Links []string `json:",omitempty"`
+}
+
+type ContainerState struct {
+	Running    bool
+	ExitCode   int
+	StartedAt  string
+	FinishedAt string
+}
+
+type httpClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+//NewClient creates a docker engine API client, host is like unix:///var/run/docker.sock or tcp://127.0.0.1:2375
+func NewClient(host string) (Client, error) {
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, err
+	}
+	c := &httpClient{client: &http.Client{}}
+	switch u.Scheme {
+	case "unix":
+		sockPath := u.Path
+		c.client.Transport = &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout("unix", sockPath, 30*time.Second)
+			},
+		}
+		c.baseURL = "http://docker/" + apiVersion
+	case "tcp", "http":
+		c.baseURL = "http://" + u.Host + "/" + apiVersion
+	default:
+		return nil, fmt.Errorf("unsupported docker host '%s'", host)
+	}
+	return c, nil
+}
+
+func (c *httpClient) do(method string, uri string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+uri, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return c.client.Do(req)
+}
+
+//doJSON sends the request with json content and decodes the json response into out if given
+func (c *httpClient) doJSON(method string, uri string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	resp, err := c.do(method, uri, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode >= 400 {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("docker api got status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+func (c *httpClient) CreateVolume(name string) error {
+	return c.doJSON(http.MethodPost, "/volumes/create", map[string]string{"Name": name}, nil)
+}
+
+func (c *httpClient) RemoveVolume(name string) error {
+	return c.doJSON(http.MethodDelete, "/volumes/"+url.PathEscape(name), nil, nil)
+}
+
+//CreateContainer creates a container of the name, image is pulled if not exist
+func (c *httpClient) CreateContainer(name string, conf *ContainerConfig) (string, error) {
+	uri := "/containers/create?name=" + url.QueryEscape(name)
+	out := struct {
+		Id string
+	}{}
+	err := c.doJSON(http.MethodPost, uri, conf, &out)
+	if err == ErrNotFound {
+		logrus.Infof("pulling image '%s'", conf.Image)
+		if err := c.pullImage(conf.Image); err != nil {
+			return "", errors.Wrapf(err, "pull image '%s'", conf.Image)
+		}
+		err = c.doJSON(http.MethodPost, uri, conf, &out)
+	}
+	if err != nil {
+		return "", errors.Wrap(ErrCreateFail, err.Error())
+	}
+	return out.Id, nil
+}
+
+func (c *httpClient) pullImage(image string) error {
+	repo, tag := splitImageTag(image)
+	uri := fmt.Sprintf("/images/create?fromImage=%s&tag=%s", url.QueryEscape(repo), url.QueryEscape(tag))
+	resp, err := c.do(http.MethodPost, uri, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return readJSONMessages(resp.Body, ioutil.Discard)
+}
+
+func (c *httpClient) StartContainer(id string) error {
+	return c.doJSON(http.MethodPost, "/containers/"+id+"/start", nil, nil)
+}
+
+//WaitContainer blocks until the container stops and returns its exit code
+func (c *httpClient) WaitContainer(id string) (int, error) {
+	out := struct {
+		StatusCode int
+	}{}
+	if err := c.doJSON(http.MethodPost, "/containers/"+id+"/wait", nil, &out); err != nil {
+		return -1, err
+	}
+	return out.StatusCode, nil
+}
+
+func (c *httpClient) StopContainer(id string, timeout int) error {
+	return c.doJSON(http.MethodPost, fmt.Sprintf("/containers/%s/stop?t=%d", id, timeout), nil, nil)
+}
+
+func (c *httpClient) RemoveContainer(id string) error {
+	return c.doJSON(http.MethodDelete, "/containers/"+id+"?force=1&v=1", nil, nil)
+}
+
+func (c *httpClient) InspectContainer(id string) (*ContainerState, error) {
+	out := struct {
+		State ContainerState
+	}{}
+	if err := c.doJSON(http.MethodGet, "/containers/"+id+"/json", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out.State, nil
+}
+
+//ListContainers gets ids of all containers with the labels, label is in key or key=value format
+func (c *httpClient) ListContainers(labels ...string) ([]string, error) {
+	filters, err := json.Marshal(map[string][]string{"label": labels})
+	if err != nil {
+		return nil, err
+	}
+	out := []struct {
+		Id string
+	}{}
+	if err := c.doJSON(http.MethodGet, "/containers/json?all=1&filters="+url.QueryEscape(string(filters)), nil, &out); err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, container := range out {
+		ids = append(ids, container.Id)
+	}
+	return ids, nil
+}
+
+//ContainerLogs gets stdout and stderr of the container, each line is prefixed with a RFC3339Nano timestamp
+func (c *httpClient) ContainerLogs(id string) (string, error) {
+	resp, err := c.do(http.MethodGet, "/containers/"+id+"/logs?stdout=1&stderr=1&timestamps=1", "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := demuxLogs(resp.Body, buf); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *httpClient) CopyFromContainer(id string, path string) (io.ReadCloser, error) {
+	resp, err := c.do(http.MethodGet, "/containers/"+id+"/archive?path="+url.QueryEscape(path), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+//CopyToContainer extracts the tar archive to the path of the container
+func (c *httpClient) CopyToContainer(id string, path string, r io.Reader) error {
+	resp, err := c.do(http.MethodPut, "/containers/"+id+"/archive?path="+url.QueryEscape(path), "application/x-tar", r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+//BuildImage builds image from the tar context and writes build output
+func (c *httpClient) BuildImage(tag string, dockerfile string, context io.Reader, output io.Writer) error {
+	uri := fmt.Sprintf("/build?t=%s&dockerfile=%s&rm=1", url.QueryEscape(tag), url.QueryEscape(dockerfile))
+	resp, err := c.do(http.MethodPost, uri, "application/x-tar", context)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return errors.Wrap(ErrBuildImageFail, err.Error())
+	}
+	if err := readJSONMessages(resp.Body, output); err != nil {
+		return errors.Wrap(ErrBuildImageFail, err.Error())
+	}
+	return nil
+}
+
+//PushImage pushes image to registry, auth is base64 encoded auth config
+func (c *httpClient) PushImage(image string, auth string, output io.Writer) error {
+	repo, tag := splitImageTag(image)
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/images/%s/push?tag=%s", c.baseURL, repo, url.QueryEscape(tag)), nil)
+	if err != nil {
+		return err
+	}
+	if auth == "" {
+		//base64 encoded '{}'
+		auth = "e30="
+	}
+	req.Header.Set("X-Registry-Auth", auth)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return err
+	}
+	return readJSONMessages(resp.Body, output)
+}
+
+//readJSONMessages reads the json message stream of docker api, writes the readable parts to output
+func readJSONMessages(r io.Reader, output io.Writer) error {
+	decoder := json.NewDecoder(r)
+	for {
+		msg := struct {
+			Stream string `json:"stream"`
+			Status string `json:"status"`
+			Id     string `json:"id"`
+			Error  string `json:"error"`
+		}{}
+		if err := decoder.Decode(&msg); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if msg.Error != "" {
+			fmt.Fprintln(output, msg.Error)
+			return errors.New(msg.Error)
+		}
+		if msg.Stream != "" {
+			fmt.Fprint(output, msg.Stream)
+		} else if msg.Status != "" {
+			if msg.Id != "" {
+				fmt.Fprintf(output, "%s: %s\n", msg.Id, msg.Status)
+			} else {
+				fmt.Fprintln(output, msg.Status)
+			}
+		}
+	}
+}
+
+//demuxLogs strips the stream headers of non-tty container logs
+func demuxLogs(r io.Reader, w io.Writer) error {
+	reader := bufio.NewReader(r)
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(reader, header); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[4:])
+		if _, err := io.CopyN(w, reader, int64(size)); err != nil {
+			return err
+		}
+	}
+}
+
+//splitImageTag splits image into repository and tag, tag defaults to latest
+func splitImageTag(image string) (string, string) {
+	repo := image
+	tag := "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repo = image[:i]
+		tag = image[i+1:]
+	}
+	return repo, tag
+}
@@ -0,0 +1,910 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/pipeline/artifact"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/provider/common"
+	"github.com/rancher/pipeline/server/service"
+	"github.com/sluu99/uuid"
+)
+
+const (
+	gitImage       = "alpine/git"
+	workspacePath  = "/workspace"
+	commitFile     = "/tmp/.r_cicd_commit"
+	activityLabel  = "activityid"
+	serviceLabel   = "io.rancher.pipeline.service"
+	serviceTimeout = 3 * time.Second
+)
+
+//DockerProvider runs pipeline steps as containers on a docker daemon
+type DockerProvider struct {
+	client Client
+	mu     sync.Mutex
+	//results of steps reported to the server, keyed by container name
+	results map[string]string
+	//logs of steps that are not from containers, or from removed containers
+	logs map[string]*stepLog
+	//containers removed with their logs kept
+	removed map[string]bool
+	//notify posts step events, waitReady blocks until the step is ready to run
+	notify    func(event string, activityId string, stageOrdinal int, stepOrdinal int, form url.Values) error
+	waitReady func(activityId string, stageOrdinal int, stepOrdinal int)
+}
+
+type stepLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+//Write records lines with timestamps as container logs do
+func (l *stepLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		l.buf.WriteString(now + " " + line)
+		if !strings.HasSuffix(line, "\n") {
+			l.buf.WriteString("\n")
+		}
+	}
+	return len(p), nil
+}
+
+func (l *stepLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+func NewDockerProvider(client Client) *DockerProvider {
+	return &DockerProvider{
+		client:    client,
+		results:   map[string]string{},
+		logs:      map[string]*stepLog{},
+		removed:   map[string]bool{},
+		notify:    common.PostStepEvent,
+		waitReady: common.WaitStepReady,
+	}
+}
+
+func (d *DockerProvider) RunPipeline(p *model.Pipeline, trigger *model.TriggerInfo) (*model.Activity, error) {
+	activity := ToActivity(p)
+	activity.TriggerType = trigger.TriggerType
+	activity.ChangedFiles = trigger.ChangedFiles
+	activity.CommitInfo = trigger.Commit
+	activity.PullRequest = trigger.PullRequest
+	service.InitActivityEnvvars(activity)
+
+	if len(p.Stages) == 0 {
+		return nil, errors.New("no stage in pipeline definition to run!")
+	}
+	if err := d.client.CreateVolume(workspaceName(activity)); err != nil {
+		return nil, errors.Wrap(err, "fail to create workspace")
+	}
+	logrus.Debugf("running stage:%v", p.Stages[0])
+	if err := d.RunStage(activity, 0); err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("creating activity:%v", activity)
+	if err := service.CreateActivity(activity); err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+//RerunActivity runs an existing activity in a clean workspace
+func (d *DockerProvider) RerunActivity(a *model.Activity) error {
+	d.cleanActivity(a, true, false)
+	d.forget(a)
+	if err := d.client.CreateVolume(workspaceName(a)); err != nil {
+		return errors.Wrap(err, "fail to create workspace")
+	}
+	a.RunSequence = a.Pipeline.RunCount + 1
+	a.StartTS = time.Now().UnixNano() / int64(time.Millisecond)
+	service.InitActivityEnvvars(a)
+	return d.RunStage(a, 0)
+}
+
+func (d *DockerProvider) RunStage(activity *model.Activity, ordinal int) error {
+	if len(activity.ActivityStages) <= ordinal {
+		return fmt.Errorf("error run stage,stage index out of range")
+	}
+	stage := activity.Pipeline.Stages[ordinal]
+	logrus.Infof("run stage:%s", stage.Name)
+	condFlag := true
+	curTime := time.Now().UnixNano() / int64(time.Millisecond)
+	var err error
+	if service.HasStageCondition(stage) {
+		condFlag, err = service.EvaluateStageCondition(activity, stage)
+		if err != nil {
+			logrus.Errorf("Evaluate condition of stage '%s' got error:%v", stage.Name, err)
+			return err
+		}
+	}
+	if !condFlag {
+		activity.ActivityStages[ordinal].Status = model.ActivityStageSkip
+		if ordinal == len(activity.ActivityStages)-1 {
+			//skip last stage and success activity
+			activity.Status = model.ActivitySuccess
+			activity.StopTS = curTime
+			d.OnActivityCompelte(activity)
+		} else {
+			//skip the stage then run next one.
+			err = d.RunStage(activity, ordinal+1)
+		}
+		return err
+	}
+
+	activity.ActivityStages[ordinal].StartTS = curTime
+	if stage.Parallel {
+		for i := 0; i < len(stage.Steps); i++ {
+			if err := d.RunStep(activity, ordinal, i); err != nil {
+				logrus.Errorf("run step error:%v", err)
+				return err
+			}
+		}
+	} else {
+		if err := d.RunStep(activity, ordinal, 0); err != nil {
+			logrus.Errorf("run step error:%v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DockerProvider) RunStep(activity *model.Activity, stageOrdinal int, stepOrdinal int) error {
+	if len(activity.ActivityStages) <= stageOrdinal ||
+		len(activity.ActivityStages[stageOrdinal].ActivitySteps) <= stepOrdinal ||
+		stageOrdinal < 0 || stepOrdinal < 0 {
+		return fmt.Errorf("error run stage,stage index out of range")
+	}
+	stage := activity.Pipeline.Stages[stageOrdinal]
+	step := stage.Steps[stepOrdinal]
+	condFlag := true
+	var err error
+	if service.HasStepCondition(step) {
+		condFlag, err = service.EvaluateStepCondition(activity, step)
+		if err != nil {
+			logrus.Errorf("Evaluate condition of step '%s' got error:%v", step.Name, err)
+			return err
+		}
+	}
+	if !condFlag {
+		activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Status = model.ActivityStepSkip
+		actiStage := activity.ActivityStages[stageOrdinal]
+		curTime := time.Now().UnixNano() / int64(time.Millisecond)
+		if service.IsStageSuccess(actiStage) {
+			//if skipped and stage success
+			actiStage.Status = model.ActivityStageSuccess
+			actiStage.Duration = curTime - actiStage.StartTS
+			if stageOrdinal == len(activity.ActivityStages)-1 {
+				activity.Status = model.ActivitySuccess
+				activity.StopTS = curTime
+				d.OnActivityCompelte(activity)
+			} else {
+				err = d.RunStage(activity, stageOrdinal+1)
+			}
+		} else if !stage.Parallel {
+			err = d.RunStep(activity, stageOrdinal, stepOrdinal+1)
+		}
+		return err
+	}
+	logrus.Debugf("Run step:%s,%d,%d", activity.Pipeline.Name, stageOrdinal, stepOrdinal)
+	s := &stepRun{
+		activityId:   activity.Id,
+		stageOrdinal: stageOrdinal,
+		stepOrdinal:  stepOrdinal,
+		step:         step,
+		name:         containerName(activity, stageOrdinal, stepOrdinal),
+		timeout:      time.Duration(step.Timeout) * time.Minute,
+		retry:        len(activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Attempts) > 0,
+		exitCode:     -1,
+	}
+	if step.Cache != nil {
+		s.activity = activitySnapshot(activity)
+	}
+	if step.RestoreArtifacts && len(activity.Artifacts) > 0 {
+		//snapshot artifacts archived so far, as the activity is changed by later events
+		s.restore = &model.Activity{Artifacts: append([]*model.Artifact{}, activity.Artifacts...)}
+		s.restore.Id = activity.Id
+	}
+	switch step.Type {
+	case model.StepTypeSCM:
+		s.conf, err = scmContainerConfig(activity, step)
+	case model.StepTypeTask:
+		s.conf, err = taskContainerConfig(activity, stageOrdinal, stepOrdinal)
+	case model.StepTypeBuild:
+		s.scmContainer = containerName(activity, 0, 0)
+	default:
+		err = fmt.Errorf("step type '%s' is not supported by docker provider", step.Type)
+	}
+	if err != nil {
+		return err
+	}
+	go d.run(s)
+	return nil
+}
+
+//stepRun holds everything needed to run a step asynchronously
+type stepRun struct {
+	activityId   string
+	stageOrdinal int
+	stepOrdinal  int
+	step         *model.Step
+	name         string
+	conf         *ContainerConfig
+	scmContainer string
+	timeout      time.Duration
+	//restore holds artifacts to restore into the workspace before the step runs
+	restore *model.Activity
+	//activity is used to render the cache key, cacheKey is set when the cache is restored
+	activity *model.Activity
+	cacheKey string
+	//retry is set if the step runs again, exitCode and timedOut are reported for retries
+	retry    bool
+	exitCode int
+	timedOut bool
+}
+
+func (d *DockerProvider) run(s *stepRun) {
+	d.waitReady(s.activityId, s.stageOrdinal, s.stepOrdinal)
+	if s.retry {
+		d.resetStep(s.name)
+	}
+	if err := d.notify("stepstart", s.activityId, s.stageOrdinal, s.stepOrdinal, nil); err != nil {
+		logrus.Errorf("fail to post stepstart event: %v", err)
+	}
+	var status string
+	form := url.Values{}
+	if s.step.Type == model.StepTypeBuild {
+		status = d.runBuild(s)
+	} else {
+		status = d.runContainer(s)
+	}
+	if s.step.Type == model.StepTypeSCM && status == "SUCCESS" {
+		commit, err := d.readFile(s.name, commitFile)
+		if err != nil {
+			logrus.Errorf("fail to get commit of '%s': %v", s.name, err)
+		}
+		form.Set("GIT_COMMIT", string(commit))
+		form.Set("GIT_URL", s.step.Repository)
+		form.Set("GIT_BRANCH", s.step.Branch)
+	}
+	if status == "SUCCESS" && s.cacheKey != "" && !service.IsForkPullRequest(s.activity) {
+		d.saveCache(s)
+	}
+	d.setResult(s.name, status)
+	form.Set("status", status)
+	form.Set("exitCode", strconv.Itoa(s.exitCode))
+	form.Set("timeout", strconv.FormatBool(s.timedOut))
+	if err := d.notify("stepfinish", s.activityId, s.stageOrdinal, s.stepOrdinal, form); err != nil {
+		logrus.Errorf("fail to post stepfinish event: %v", err)
+	}
+}
+
+//runContainer runs the step container and returns the result status
+func (d *DockerProvider) runContainer(s *stepRun) string {
+	id, err := d.client.CreateContainer(s.name, s.conf)
+	if err != nil {
+		d.failStep(s.name, err)
+		return "FAILURE"
+	}
+	if s.restore != nil {
+		if err := d.restoreArtifacts(id, s.restore); err != nil {
+			d.failStep(s.name, errors.Wrap(err, "fail to restore artifacts"))
+			return "FAILURE"
+		}
+	}
+	if s.step.Cache != nil {
+		d.restoreCache(id, s)
+	}
+	if err := d.client.StartContainer(id); err != nil {
+		d.failStep(s.name, err)
+		return "FAILURE"
+	}
+	if s.step.Type == model.StepTypeTask && s.step.IsService {
+		//a running service container is expected
+		time.Sleep(serviceTimeout)
+		state, err := d.client.InspectContainer(id)
+		if err != nil || !state.Running {
+			d.failStep(s.name, fmt.Errorf("service container '%s' is stopped, a running container is expected when using 'as a service' option", s.step.Alias))
+			return "FAILURE"
+		}
+		return "SUCCESS"
+	}
+	var timer *time.Timer
+	timedOut := false
+	if s.timeout > 0 {
+		timer = time.AfterFunc(s.timeout, func() {
+			d.mu.Lock()
+			timedOut = true
+			d.mu.Unlock()
+			if err := d.client.StopContainer(id, 5); err != nil {
+				logrus.Errorf("fail to stop timeout step '%s': %v", s.name, err)
+			}
+		})
+	}
+	exitCode, err := d.client.WaitContainer(id)
+	if timer != nil {
+		timer.Stop()
+	}
+	if d.getResult(s.name) == "ABORTED" {
+		return "ABORTED"
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s.timedOut = timedOut
+	if err == nil {
+		s.exitCode = exitCode
+	}
+	if err != nil || exitCode != 0 || timedOut {
+		return "FAILURE"
+	}
+	return "SUCCESS"
+}
+
+//runBuild builds image from the workspace by docker image build API
+func (d *DockerProvider) runBuild(s *stepRun) string {
+	output := d.stepLog(s.name)
+	step := s.step
+	buildPath := "."
+	if step.BuildPath != "" {
+		buildPath = step.BuildPath
+	}
+	dockerfilePath := "Dockerfile"
+	extra := map[string][]byte{}
+	if step.Dockerfile != "" {
+		dockerfilePath = ".r_cicd_Dockerfile"
+		extra[dockerfilePath] = []byte(step.Dockerfile)
+	} else if step.DockerfilePath != "" {
+		dockerfilePath = step.DockerfilePath
+	}
+	workspace, err := d.client.CopyFromContainer(s.scmContainer, workspacePath)
+	if err != nil {
+		fmt.Fprintf(output, "fail to read workspace: %v\n", err)
+		return "FAILURE"
+	}
+	defer workspace.Close()
+	buildContext := rebaseTar(workspace, path.Join(path.Base(workspacePath), buildPath), extra)
+	defer buildContext.Close()
+	fmt.Fprintf(output, "building image %s\n", step.TargetImage)
+	if err := d.client.BuildImage(step.TargetImage, dockerfilePath, buildContext, output); err != nil {
+		fmt.Fprintf(output, "%v\n", err)
+		return "FAILURE"
+	}
+	if step.PushFlag {
+		fmt.Fprintf(output, "pushing image %s\n", step.TargetImage)
+		auth, err := registryAuth(step.TargetImage)
+		if err != nil {
+			logrus.Errorf("fail to get registry credential: %v", err)
+		}
+		if err := d.client.PushImage(step.TargetImage, auth, output); err != nil {
+			fmt.Fprintf(output, "%v\n", err)
+			return "FAILURE"
+		}
+	}
+	return "SUCCESS"
+}
+
+//restoreArtifacts copies archived artifacts of the activity into the workspace of the container
+func (d *DockerProvider) restoreArtifacts(id string, activity *model.Activity) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(service.WriteArtifactsTar(activity, pw))
+	}()
+	defer pr.Close()
+	return d.client.CopyToContainer(id, workspacePath, pr)
+}
+
+//restoreCache copies the saved cache into the workspace of the container,
+//failures are written to the step log without failing the step
+func (d *DockerProvider) restoreCache(id string, s *stepRun) {
+	output := d.stepLog(s.name)
+	key, err := service.RenderCacheKey(s.activity, s.step, func(file string) string {
+		r, err := d.client.CopyFromContainer(id, path.Join(workspacePath, file))
+		if err != nil {
+			return ""
+		}
+		defer r.Close()
+		checksum, _ := tarFileChecksum(r)
+		return checksum
+	})
+	if err != nil {
+		fmt.Fprintf(output, "fail to get cache key: %v\n", err)
+		return
+	}
+	s.cacheKey = key
+	r, err := service.GetCache(s.activity.Pipeline.Id, key)
+	if err == artifact.ErrNotFound {
+		fmt.Fprintf(output, "cache '%s' not found\n", key)
+		return
+	} else if err != nil {
+		fmt.Fprintf(output, "fail to get cache '%s': %v\n", key, err)
+		return
+	}
+	defer r.Close()
+	if err := d.client.CopyToContainer(id, workspacePath, r); err != nil {
+		fmt.Fprintf(output, "fail to restore cache '%s': %v\n", key, err)
+		return
+	}
+	fmt.Fprintf(output, "cache '%s' restored\n", key)
+}
+
+//saveCache saves cache paths in the workspace of the step container
+func (d *DockerProvider) saveCache(s *stepRun) {
+	output := d.stepLog(s.name)
+	workspace, err := d.client.CopyFromContainer(s.name, workspacePath)
+	if err != nil {
+		fmt.Fprintf(output, "fail to read workspace: %v\n", err)
+		return
+	}
+	defer workspace.Close()
+	r := selectTar(workspace, path.Base(workspacePath), s.step.Cache.Paths)
+	defer r.Close()
+	if err := service.SaveCache(s.activity.Pipeline.Id, s.cacheKey, r); err != nil {
+		fmt.Fprintf(output, "%v\n", err)
+		return
+	}
+	fmt.Fprintf(output, "cache '%s' saved\n", s.cacheKey)
+}
+
+//ArchiveArtifacts stores workspace files matching artifacts of the step, the workspace
+//volume is read through the SCM container
+func (d *DockerProvider) ArchiveArtifacts(activity *model.Activity, stageOrdinal int, stepOrdinal int) ([]*model.Artifact, error) {
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	r, err := d.client.CopyFromContainer(containerName(activity, 0, 0), workspacePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to read workspace")
+	}
+	defer r.Close()
+	prefix := path.Base(workspacePath) + "/"
+	artifacts := []*model.Artifact{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return artifacts, err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := artifact.CleanName(strings.TrimPrefix(hdr.Name, prefix))
+		if name == "" || !artifact.Match(step.Artifacts, name) {
+			continue
+		}
+		a, err := service.PutArtifact(activity.Id, step.Name, name, tr)
+		if err != nil {
+			return artifacts, err
+		}
+		artifacts = append(artifacts, a)
+	}
+	if len(artifacts) == 0 {
+		return nil, fmt.Errorf("no file matches artifacts %v", step.Artifacts)
+	}
+	return artifacts, nil
+}
+
+func (d *DockerProvider) failStep(name string, err error) {
+	logrus.Errorf("run step '%s' got error: %v", name, err)
+	fmt.Fprintf(d.stepLog(name), "%v\n", err)
+}
+
+func (d *DockerProvider) readFile(container string, filePath string) ([]byte, error) {
+	r, err := d.client.CopyFromContainer(container, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readTarFile(r)
+}
+
+func (d *DockerProvider) stepLog(name string) *stepLog {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	l, ok := d.logs[name]
+	if !ok {
+		l = &stepLog{}
+		d.logs[name] = l
+	}
+	return l
+}
+
+func (d *DockerProvider) setResult(name string, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.results[name] == "ABORTED" {
+		return
+	}
+	d.results[name] = status
+}
+
+func (d *DockerProvider) getResult(name string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.results[name]
+}
+
+func (d *DockerProvider) StopActivity(a *model.Activity) error {
+	logrus.Debugf("stopping activity, current status: %s", a.Status)
+	a.Status = model.ActivityAbort
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	a.StopTS = now
+	for stageOrdinal, stage := range a.ActivityStages {
+		if stage.Status == model.ActivityStageSuccess || stage.Status == model.ActivityStageSkip {
+			continue
+		}
+		for stepOrdinal, step := range stage.ActivitySteps {
+			if step.Status != model.ActivityStepBuilding {
+				continue
+			}
+			name := containerName(a, stageOrdinal, stepOrdinal)
+			d.setResult(name, "ABORTED")
+			if err := d.client.StopContainer(name, 5); err != nil && err != ErrNotFound {
+				logrus.Errorf("stop step got: %v", err)
+			}
+			step.Status = model.ActivityStepAbort
+			step.Duration = now - step.StartTS
+		}
+		stage.Status = model.ActivityStageAbort
+		stage.Duration = now - stage.StartTS
+		break
+	}
+	return nil
+}
+
+//SyncActivity updates status of running steps from their containers
+func (d *DockerProvider) SyncActivity(activity *model.Activity) error {
+	for i, actiStage := range activity.ActivityStages {
+		for j, actiStep := range actiStage.ActivitySteps {
+			if actiStep.Status != model.ActivityStepBuilding {
+				continue
+			}
+			step := activity.Pipeline.Stages[i].Steps[j]
+			if step.Type == model.StepTypeBuild || step.IsService {
+				continue
+			}
+			state, err := d.client.InspectContainer(containerName(activity, i, j))
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if state.Running {
+				continue
+			}
+			finishTS := common.ParseTimestamp(state.FinishedAt)
+			actiStep.Duration = finishTS - actiStep.StartTS
+			if state.ExitCode == 0 {
+				actiStep.Status = model.ActivityStepSuccess
+			} else {
+				actiStep.Status = model.ActivityStepFail
+				actiStage.Status = model.ActivityStageFail
+				actiStage.Duration = finishTS - actiStage.StartTS
+				activity.Status = model.ActivityFail
+				activity.StopTS = finishTS
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DockerProvider) GetStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, paras map[string]interface{}) (string, error) {
+	if stageOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) || stepOrdinal < 0 || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
+		return "", errors.New("ordinal out of range")
+	}
+	name := containerName(activity, stageOrdinal, stepOrdinal)
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	rawLog := ""
+	d.mu.Lock()
+	l, ok := d.logs[name]
+	d.mu.Unlock()
+	if ok {
+		rawLog = l.String()
+	}
+	status := d.getResult(name)
+	if step.Type != model.StepTypeBuild && !d.cached(name) {
+		containerLog, err := d.client.ContainerLogs(name)
+		if err == ErrNotFound && ok {
+			//fail to create the container
+			containerLog = ""
+		} else if err == ErrNotFound {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		rawLog = containerLog + rawLog
+		if status == "" {
+			state, err := d.client.InspectContainer(name)
+			if err != nil {
+				return "", err
+			}
+			if !state.Running && state.ExitCode == 0 && !step.IsService {
+				status = "SUCCESS"
+			} else if !state.Running {
+				status = "FAILURE"
+			}
+		}
+	}
+	return common.FinishLog(common.FormatLog(activity.StartTS, rawLog), status), nil
+}
+
+//resetStep removes the container and drops the kept result and logs of the last attempt,
+//which are recorded in attempts of the activity step
+func (d *DockerProvider) resetStep(name string) {
+	if err := d.client.RemoveContainer(name); err != nil && err != ErrNotFound {
+		logrus.Errorf("error removing container '%s': %v", name, err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.results, name)
+	delete(d.logs, name)
+	delete(d.removed, name)
+}
+
+//cached checks if the container logs are kept after the container is removed
+func (d *DockerProvider) cached(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.removed[name]
+}
+
+//forget drops the kept results and logs of the activity
+func (d *DockerProvider) forget(activity *model.Activity) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, stage := range activity.ActivityStages {
+		for j := range stage.ActivitySteps {
+			name := containerName(activity, i, j)
+			delete(d.results, name)
+			delete(d.logs, name)
+			delete(d.removed, name)
+		}
+	}
+}
+
+//OnActivityCompelte removes containers and workspace of the activity, logs are kept in memory
+func (d *DockerProvider) OnActivityCompelte(activity *model.Activity) {
+	d.cleanActivity(activity, !activity.Pipeline.KeepWorkspace, true)
+	logrus.Infof("activity '%s' complete", activity.Id)
+}
+
+//cleanActivity removes service containers, step containers and workspace are removed when removeWorkspace is set
+func (d *DockerProvider) cleanActivity(activity *model.Activity, removeWorkspace bool, keepLogs bool) {
+	ids, err := d.client.ListContainers(activityLabel + "=" + activity.Id)
+	if err != nil {
+		logrus.Errorf("error listing containers of activity '%s': %v", activity.Id, err)
+		return
+	}
+	if removeWorkspace && keepLogs {
+		//keep step logs before the containers are gone
+		for i, stage := range activity.ActivityStages {
+			for j := range stage.ActivitySteps {
+				name := containerName(activity, i, j)
+				containerLog, err := d.client.ContainerLogs(name)
+				if err != nil {
+					continue
+				}
+				l := &stepLog{}
+				l.buf.WriteString(containerLog + d.stepLog(name).String())
+				d.mu.Lock()
+				d.logs[name] = l
+				d.removed[name] = true
+				d.mu.Unlock()
+			}
+		}
+	}
+	serviceIds, err := d.client.ListContainers(activityLabel+"="+activity.Id, serviceLabel)
+	if err != nil {
+		logrus.Errorf("error listing services of activity '%s': %v", activity.Id, err)
+	}
+	for _, id := range ids {
+		if !removeWorkspace && !contains(serviceIds, id) {
+			continue
+		}
+		if err := d.client.RemoveContainer(id); err != nil {
+			logrus.Errorf("error removing container '%s': %v", id, err)
+		}
+	}
+	if removeWorkspace {
+		if err := d.client.RemoveVolume(workspaceName(activity)); err != nil && err != ErrNotFound {
+			logrus.Errorf("error removing workspace of activity '%s': %v", activity.Id, err)
+		}
+	}
+}
+
+//OnDeleteActivity removes containers, workspace and kept logs of the activity
+func (d *DockerProvider) OnDeleteActivity(activity *model.Activity) error {
+	d.cleanActivity(activity, true, false)
+	d.forget(activity)
+	return nil
+}
+
+func (d *DockerProvider) OnCreateAccount(account *model.GitAccount) error {
+	//account token is used at clone time, nothing to sync
+	return nil
+}
+
+func (d *DockerProvider) OnDeleteAccount(account *model.GitAccount) error {
+	if account == nil {
+		return errors.New("nil account")
+	}
+	return nil
+}
+
+func (d *DockerProvider) OnCreateCredential(cred *model.Credential) error {
+	//credential is used at clone time, nothing to sync
+	return nil
+}
+
+func (d *DockerProvider) OnDeleteCredential(cred *model.Credential) error {
+	if cred == nil {
+		return errors.New("nil credential")
+	}
+	return nil
+}
+
+func (d *DockerProvider) Reset() error {
+	ids, err := d.client.ListContainers(activityLabel)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := d.client.RemoveContainer(id); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	d.results = map[string]string{}
+	d.logs = map[string]*stepLog{}
+	d.removed = map[string]bool{}
+	d.mu.Unlock()
+	return nil
+}
+
+//ToActivity init an activity from pipeline def
+func ToActivity(p *model.Pipeline) *model.Activity {
+	activity := &model.Activity{
+		Id:          uuid.Rand().Hex(),
+		Pipeline:    *p,
+		RunSequence: p.RunCount + 1,
+		Status:      model.ActivityWaiting,
+		StartTS:     time.Now().UnixNano() / int64(time.Millisecond),
+		NodeName:    "docker",
+	}
+	//matrix steps are expanded in stages of the activity, the pipeline is not changed
+	activity.Pipeline.Stages = []*model.Stage{}
+	for _, stage := range p.Stages {
+		stage = service.ExpandMatrix(stage)
+		activity.Pipeline.Stages = append(activity.Pipeline.Stages, stage)
+		activity.ActivityStages = append(activity.ActivityStages, service.ToActivityStage(stage))
+	}
+	return activity
+}
+
+func scmContainerConfig(activity *model.Activity, step *model.Step) (*ContainerConfig, error) {
+	cloneEnv, err := common.CloneEnv(activity, step)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerConfig{
+		Image:      gitImage,
+		Entrypoint: []string{"/bin/sh", "-c"},
+		Cmd:        []string{common.CloneScript(activity, commitFile)},
+		Env:        append(common.ActivityEnv(activity), cloneEnv...),
+		WorkingDir: workspacePath,
+		Labels:     map[string]string{activityLabel: activity.Id},
+		HostConfig: HostConfig{
+			Binds: []string{workspaceName(activity) + ":" + workspacePath},
+		},
+	}, nil
+}
+
+func taskContainerConfig(activity *model.Activity, stageOrdinal int, stepOrdinal int) (*ContainerConfig, error) {
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	conf := &ContainerConfig{
+		Image:      step.Image,
+		Env:        common.ActivityEnv(activity),
+		WorkingDir: workspacePath,
+		Labels:     map[string]string{activityLabel: activity.Id},
+		HostConfig: HostConfig{
+			Binds: []string{workspaceName(activity) + ":" + workspacePath},
+		},
+	}
+	for _, env := range step.Env {
+		conf.Env = append(conf.Env, service.SubstituteVar(activity, env))
+	}
+	secretEnv, err := service.GetStepSecretEnv(activity, step)
+	if err != nil {
+		return nil, err
+	}
+	conf.Env = append(conf.Env, secretEnv...)
+	if step.ShellScript != "" {
+		conf.Entrypoint = []string{"/bin/sh", "-c"}
+		conf.Cmd = []string{"set -xe\n" + step.ShellScript}
+	} else {
+		if step.Entrypoint != "" {
+			conf.Entrypoint = strings.Fields(step.Entrypoint)
+		}
+		conf.Cmd = strings.Fields(service.SubstituteVar(activity, step.Args))
+	}
+	if step.IsService {
+		conf.Labels[serviceLabel] = step.Alias
+	}
+	for _, svc := range service.GetServices(activity, stageOrdinal, stepOrdinal) {
+		conf.HostConfig.Links = append(conf.HostConfig.Links, svc.ContainerName+":"+svc.Name)
+	}
+	return conf, nil
+}
+
+//registryAuth gets encoded auth config of the image registry
+func registryAuth(image string) (string, error) {
+	serverAddress, username, password, err := common.RegistryCredential(image)
+	if err != nil || username == "" {
+		return "", err
+	}
+	b, err := json.Marshal(map[string]string{
+		"username":      username,
+		"password":      password,
+		"serveraddress": serverAddress,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+//activitySnapshot copies fields used to render cache keys, as the activity is changed by later events
+func activitySnapshot(activity *model.Activity) *model.Activity {
+	snapshot := &model.Activity{EnvVars: map[string]string{}}
+	snapshot.Id = activity.Id
+	snapshot.Pipeline.Id = activity.Pipeline.Id
+	for k, v := range activity.EnvVars {
+		snapshot.EnvVars[k] = v
+	}
+	return snapshot
+}
+
+func containerName(activity *model.Activity, stageOrdinal int, stepOrdinal int) string {
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	if step.Type == model.StepTypeTask && step.IsService {
+		//services are linked by this name
+		return activity.Id + step.Alias
+	}
+	return fmt.Sprintf("%s_%d_%d", activity.Id, stageOrdinal, stepOrdinal)
+}
+
+func workspaceName(activity *model.Activity) string {
+	return "r_cicd_" + activity.Id
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package docker
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/pipeline/model"
+)
+
+type stepEvent struct {
+	event string
+	form  url.Values
+}
+
+//newTestProvider gets a provider on the fake client, step events are sent to the returned channel
+func newTestProvider() (*DockerProvider, *FakeClient, chan stepEvent) {
+	fake := NewFakeClient()
+	d := NewDockerProvider(fake)
+	events := make(chan stepEvent, 10)
+	d.notify = func(event string, activityId string, stageOrdinal int, stepOrdinal int, form url.Values) error {
+		events <- stepEvent{event, form}
+		return nil
+	}
+	d.waitReady = func(activityId string, stageOrdinal int, stepOrdinal int) {}
+	return d, fake, events
+}
+
+func testActivity() *model.Activity {
+	return ToActivity(&model.Pipeline{
+		Stages: []*model.Stage{
+			{
+				Name: "build",
+				Steps: []*model.Step{
+					{Name: "test", Type: model.StepTypeTask, Image: "busybox", ShellScript: "echo hello"},
+				},
+			},
+		},
+	})
+}
+
+//waitFinish gets the stepfinish event of the step
+func waitFinish(t *testing.T, events chan stepEvent) url.Values {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.event == "stepfinish" {
+				return e.form
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for the step to finish")
+		}
+	}
+}
+
+func TestRunStep(t *testing.T) {
+	d, fake, events := newTestProvider()
+	activity := testActivity()
+	name := containerName(activity, 0, 0)
+	fake.Logs[name] = "hello\n"
+
+	if err := d.RunStep(activity, 0, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	if e := <-events; e.event != "stepstart" {
+		t.Errorf("got event %s, want stepstart", e.event)
+	}
+	form := waitFinish(t, events)
+	if form.Get("status") != "SUCCESS" || form.Get("exitCode") != "0" || form.Get("timeout") != "false" {
+		t.Errorf("got stepfinish %v, want SUCCESS with exit code 0", form)
+	}
+	c, ok := fake.Containers[name]
+	if !ok {
+		t.Fatalf("container '%s' is not created", name)
+	}
+	if c.Config.Image != "busybox" || c.Config.Labels[activityLabel] != activity.Id {
+		t.Errorf("got container config %+v", c.Config)
+	}
+	if len(c.Config.Cmd) != 1 || !strings.Contains(c.Config.Cmd[0], "echo hello") {
+		t.Errorf("got container cmd %q, want the shell script", c.Config.Cmd)
+	}
+}
+
+func TestRunStepFailure(t *testing.T) {
+	d, fake, events := newTestProvider()
+	activity := testActivity()
+	fake.ExitCodes[containerName(activity, 0, 0)] = 2
+
+	if err := d.RunStep(activity, 0, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	form := waitFinish(t, events)
+	if form.Get("status") != "FAILURE" || form.Get("exitCode") != "2" {
+		t.Errorf("got stepfinish %v, want FAILURE with exit code 2", form)
+	}
+}
+
+func TestRunSCMStep(t *testing.T) {
+	d, fake, events := newTestProvider()
+	activity := ToActivity(&model.Pipeline{
+		Stages: []*model.Stage{
+			{
+				Name: "scm",
+				Steps: []*model.Step{
+					{Name: "clone", Type: model.StepTypeSCM, Repository: "https://github.com/a/b.git", Branch: "master"},
+				},
+			},
+		},
+	})
+	fake.Files[containerName(activity, 0, 0)] = map[string][]byte{commitFile: []byte("abc123")}
+
+	if err := d.RunStep(activity, 0, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	form := waitFinish(t, events)
+	if form.Get("status") != "SUCCESS" || form.Get("GIT_COMMIT") != "abc123" || form.Get("GIT_BRANCH") != "master" {
+		t.Errorf("got stepfinish %v, want SUCCESS with the commit", form)
+	}
+}
+
+func TestSyncActivity(t *testing.T) {
+	tests := []struct {
+		exitCode     int
+		stepStatus   string
+		activityFail bool
+	}{
+		{0, model.ActivityStepSuccess, false},
+		{1, model.ActivityStepFail, true},
+	}
+	for _, test := range tests {
+		d, fake, events := newTestProvider()
+		activity := testActivity()
+		fake.ExitCodes[containerName(activity, 0, 0)] = test.exitCode
+		if err := d.RunStep(activity, 0, 0); err != nil {
+			t.Fatalf("RunStep got error: %v", err)
+		}
+		waitFinish(t, events)
+
+		activity.Status = model.ActivityBuilding
+		activity.ActivityStages[0].ActivitySteps[0].Status = model.ActivityStepBuilding
+		if err := d.SyncActivity(activity); err != nil {
+			t.Fatalf("SyncActivity got error: %v", err)
+		}
+		if got := activity.ActivityStages[0].ActivitySteps[0].Status; got != test.stepStatus {
+			t.Errorf("exit code %d: got step status %s, want %s", test.exitCode, got, test.stepStatus)
+		}
+		if got := activity.Status == model.ActivityFail; got != test.activityFail {
+			t.Errorf("exit code %d: got activity status %s", test.exitCode, activity.Status)
+		}
+	}
+}
+
+func TestSyncActivityNotStarted(t *testing.T) {
+	d, _, _ := newTestProvider()
+	activity := testActivity()
+	activity.ActivityStages[0].ActivitySteps[0].Status = model.ActivityStepBuilding
+	if err := d.SyncActivity(activity); err != nil {
+		t.Fatalf("SyncActivity got error: %v", err)
+	}
+	if got := activity.ActivityStages[0].ActivitySteps[0].Status; got != model.ActivityStepBuilding {
+		t.Errorf("got step status %s, want %s", got, model.ActivityStepBuilding)
+	}
+}
+
+func TestGetStepLog(t *testing.T) {
+	d, fake, events := newTestProvider()
+	activity := testActivity()
+	fake.Logs[containerName(activity, 0, 0)] = "hello\nworld\n"
+
+	if log, err := d.GetStepLog(activity, 0, 0, nil); err != nil || log != "" {
+		t.Errorf("got log %q, %v before the step runs, want empty", log, err)
+	}
+	if err := d.RunStep(activity, 0, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	waitFinish(t, events)
+
+	log, err := d.GetStepLog(activity, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("GetStepLog got error: %v", err)
+	}
+	if !strings.Contains(log, "hello\n") || !strings.Contains(log, "world\n") {
+		t.Errorf("got log %q, want the container log", log)
+	}
+	if !strings.HasSuffix(log, "  Finished: SUCCESS\n") {
+		t.Errorf("got log %q, want finished with SUCCESS", log)
+	}
+	if _, err := d.GetStepLog(activity, 1, 0, nil); err == nil {
+		t.Error("got no error for the out of range ordinal")
+	}
+}
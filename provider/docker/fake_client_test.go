@@ -0,0 +1,323 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+)
+
+//FakeClient is an in-memory stand-in of the docker engine API,
+//containers exit with the configured code as soon as they are started.
+type FakeClient struct {
+	mu         sync.Mutex
+	Volumes    map[string]bool
+	Containers map[string]*FakeContainer
+	Images     map[string]bool
+	//ExitCodes by container name, zero if not set
+	ExitCodes map[string]int
+	//Logs by container name
+	Logs map[string]string
+	//Files by container name and path, returned as archive by CopyFromContainer
+	Files map[string]map[string][]byte
+	//Calls records the invoked methods in order
+	Calls []string
+	seq   int
+}
+
+type FakeContainer struct {
+	Id     string
+	Name   string
+	Config *ContainerConfig
+	State  ContainerState
+	done   chan struct{}
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		Volumes:    map[string]bool{},
+		Containers: map[string]*FakeContainer{},
+		Images:     map[string]bool{},
+		ExitCodes:  map[string]int{},
+		Logs:       map[string]string{},
+		Files:      map[string]map[string][]byte{},
+	}
+}
+
+func (f *FakeClient) record(format string, args ...interface{}) {
+	f.Calls = append(f.Calls, fmt.Sprintf(format, args...))
+}
+
+//lookup finds container by id or name, the lock should be held
+func (f *FakeClient) lookup(id string) (*FakeContainer, error) {
+	for _, c := range f.Containers {
+		if c.Id == id || c.Name == id {
+			return c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (f *FakeClient) CreateVolume(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateVolume %s", name)
+	f.Volumes[name] = true
+	return nil
+}
+
+func (f *FakeClient) RemoveVolume(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("RemoveVolume %s", name)
+	if !f.Volumes[name] {
+		return ErrNotFound
+	}
+	delete(f.Volumes, name)
+	return nil
+}
+
+func (f *FakeClient) CreateContainer(name string, conf *ContainerConfig) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CreateContainer %s", name)
+	if _, ok := f.Containers[name]; ok {
+		return "", fmt.Errorf("container name '%s' is in use", name)
+	}
+	f.seq++
+	c := &FakeContainer{
+		Id:     fmt.Sprintf("fake%d", f.seq),
+		Name:   name,
+		Config: conf,
+		done:   make(chan struct{}),
+	}
+	f.Containers[name] = c
+	return c.Id, nil
+}
+
+//StartContainer runs the container, non-service containers exit immediately
+func (f *FakeClient) StartContainer(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("StartContainer %s", id)
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	c.State.Running = true
+	c.State.StartedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	if _, ok := c.Config.Labels[serviceLabel]; !ok {
+		f.exit(c, f.ExitCodes[c.Name])
+	}
+	return nil
+}
+
+//exit stops the container with the code, the lock should be held
+func (f *FakeClient) exit(c *FakeContainer, code int) {
+	if !c.State.Running {
+		return
+	}
+	c.State.Running = false
+	c.State.ExitCode = code
+	c.State.FinishedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	close(c.done)
+}
+
+func (f *FakeClient) WaitContainer(id string) (int, error) {
+	f.mu.Lock()
+	c, err := f.lookup(id)
+	f.mu.Unlock()
+	if err != nil {
+		return -1, err
+	}
+	<-c.done
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return c.State.ExitCode, nil
+}
+
+func (f *FakeClient) StopContainer(id string, timeout int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("StopContainer %s", id)
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	f.exit(c, 137)
+	return nil
+}
+
+func (f *FakeClient) RemoveContainer(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("RemoveContainer %s", id)
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	f.exit(c, 137)
+	delete(f.Containers, c.Name)
+	return nil
+}
+
+func (f *FakeClient) InspectContainer(id string) (*ContainerState, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	state := c.State
+	return &state, nil
+}
+
+func (f *FakeClient) ListContainers(labels ...string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := []string{}
+	for _, c := range f.Containers {
+		matched := true
+		for _, label := range labels {
+			splits := strings.SplitN(label, "=", 2)
+			value, ok := c.Config.Labels[splits[0]]
+			if !ok || (len(splits) == 2 && value != splits[1]) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			ids = append(ids, c.Id)
+		}
+	}
+	return ids, nil
+}
+
+//ContainerLogs returns the configured logs with timestamps of the container start
+func (f *FakeClient) ContainerLogs(id string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.lookup(id)
+	if err != nil {
+		return "", err
+	}
+	b := &bytes.Buffer{}
+	for _, line := range strings.Split(f.Logs[c.Name], "\n") {
+		if line == "" {
+			continue
+		}
+		b.WriteString(c.State.StartedAt + " " + line + "\n")
+	}
+	return b.String(), nil
+}
+
+//CopyFromContainer archives configured files of the container under the path
+func (f *FakeClient) CopyFromContainer(id string, path string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CopyFromContainer %s %s", id, path)
+	c, err := f.lookup(id)
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	base := path[strings.LastIndex(path, "/")+1:]
+	found := false
+	for name, content := range f.Files[c.Name] {
+		if name != path && !strings.HasPrefix(name, path+"/") {
+			continue
+		}
+		found = true
+		hdr := &tar.Header{
+			Name: base + strings.TrimPrefix(name, path),
+			Mode: 0644,
+			Size: int64(len(content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+//CopyToContainer extracts regular files of the archive to configured files of the container
+func (f *FakeClient) CopyToContainer(id string, path string, r io.Reader) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("CopyToContainer %s %s", id, path)
+	c, err := f.lookup(id)
+	if err != nil {
+		return err
+	}
+	if f.Files[c.Name] == nil {
+		f.Files[c.Name] = map[string][]byte{}
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		f.Files[c.Name][strings.TrimSuffix(path, "/")+"/"+hdr.Name] = content
+	}
+}
+
+//BuildImage consumes the context and tags the image
+func (f *FakeClient) BuildImage(tag string, dockerfile string, context io.Reader, output io.Writer) error {
+	tr := tar.NewReader(context)
+	found := false
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if hdr.Name == dockerfile {
+			found = true
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("BuildImage %s", tag)
+	if !found {
+		return fmt.Errorf("Cannot locate specified Dockerfile: %s", dockerfile)
+	}
+	f.Images[tag] = true
+	fmt.Fprintf(output, "Successfully built %s\n", tag)
+	return nil
+}
+
+func (f *FakeClient) PushImage(image string, auth string, output io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.record("PushImage %s", image)
+	if !f.Images[image] {
+		return ErrNotFound
+	}
+	fmt.Fprintf(output, "pushed %s\n", image)
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
@@ -21,9 +22,11 @@ var (
 	ErrUpdateJobFail    = errors.New("Update Job fail")
 	ErrStopJobFail      = errors.New("Stop Job fail")
 	ErrDeleteBuildFail  = errors.New("Delete Build fail")
+	ErrDeleteJobFail    = errors.New("Delete Job fail")
 	ErrBuildJobFail     = errors.New("Build Job fail")
 	ErrGetBuildInfoFail = errors.New("Get Build Info fail")
 	ErrGetJobInfoFail   = errors.New("Get Job Info fail")
+	ErrGetArtifactFail  = errors.New("Get Artifact fail")
 )
 
 func InitJenkins() {
@@ -126,6 +129,42 @@ func DeleteBuild(jobname string) error {
 
 }
 
+//DeleteJob deletes the job with all its builds, a job not found is taken as deleted
+func DeleteJob(jobname string) error {
+	sah, _ := JenkinsConfig.Get(JenkinsServerAddress)
+	deleteJobURI, _ := JenkinsConfig.Get(DeleteJobURI)
+	deleteJobURI = fmt.Sprintf(deleteJobURI, jobname)
+	user, _ := JenkinsConfig.Get(JenkinsUser)
+	token, _ := JenkinsConfig.Get(JenkinsToken)
+	CrumbHeader, _ := JenkinsConfig.Get(JenkinsCrumbHeader)
+	Crumb, _ := JenkinsConfig.Get(JenkinsCrumb)
+
+	targetURL, err := url.Parse(sah + deleteJobURI)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	req, _ := http.NewRequest(http.MethodPost, targetURL.String(), nil)
+
+	req.Header.Add(CrumbHeader, Crumb)
+	req.SetBasicAuth(user, token)
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Error(err)
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		logrus.Infof("delete job fail,response code is :%v", resp.StatusCode)
+		return ErrDeleteJobFail
+	}
+	return nil
+}
+
 func ExecScript(script string) (string, error) {
 	sah, _ := JenkinsConfig.Get(JenkinsServerAddress)
 	scriptURI, _ := JenkinsConfig.Get(ScriptURI)
@@ -418,6 +457,41 @@ func GetBuildRawOutput(jobname string, startLine int) (string, error) {
 
 }
 
+//GetBuildArtifact gets the content of the artifact archived by the last build of the job
+func GetBuildArtifact(jobname string, relativePath string) (io.ReadCloser, error) {
+	sah, _ := JenkinsConfig.Get(JenkinsServerAddress)
+	artifactURI, _ := JenkinsConfig.Get(JenkinsArtifactURI)
+	segments := strings.Split(relativePath, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	artifactURI = fmt.Sprintf(artifactURI, jobname, strings.Join(segments, "/"))
+	user, _ := JenkinsConfig.Get(JenkinsUser)
+	token, _ := JenkinsConfig.Get(JenkinsToken)
+	CrumbHeader, _ := JenkinsConfig.Get(JenkinsCrumbHeader)
+	Crumb, _ := JenkinsConfig.Get(JenkinsCrumb)
+
+	req, err := http.NewRequest(http.MethodGet, sah+artifactURI, nil)
+	if err != nil {
+		logrus.Error(err)
+		return nil, err
+	}
+	req.Header.Add(CrumbHeader, Crumb)
+	req.SetBasicAuth(user, token)
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Error(err)
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		logrus.Error(ErrGetArtifactFail)
+		return nil, ErrGetArtifactFail
+	}
+	return resp.Body, nil
+}
+
 func StopJob(jobname string) error {
 	sah, _ := JenkinsConfig.Get(JenkinsServerAddress)
 	stopJobURI, _ := JenkinsConfig.Get(StopJobURI)
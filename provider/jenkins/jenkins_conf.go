@@ -16,6 +16,7 @@ const StopJobURI = "StopjobURI"
 const CancelQueueItemURI = "CancelQueueItemURI"
 const ScriptURI = "ScriptURI"
 const DeleteBuildURI = "DeleteBuildURI"
+const DeleteJobURI = "DeleteJobURI"
 const GetCrumbURI = "GetCrumbURI"
 const JenkinsCrumbHeader = "JenkinsCrumbHeader"
 const JenkinsCrumb = "JenkinsCrumb"
@@ -25,6 +26,7 @@ const JenkinsSetCredURI = "JenkinsSetCredURI"
 const JenkinsDeleteCredURI = "JenkinsDeleteCredURI"
 const JenkinsBuildInfoURI = "JenkinsBuildInfoURI"
 const JenkinsBuildLogURI = "JenkinsBuildLogURI"
+const JenkinsArtifactURI = "JenkinsArtifactURI"
 const JenkinsJobBuildWithParamsURI = "JenkinsJobBuildWithParamsURI"
 
 var ErrConfigItemNotFound = errors.New("Jenkins configuration not fount")
@@ -51,6 +53,7 @@ var JenkinsConfig = jenkinsConfig{
 	StopJobURI:                   "/job/%s/lastBuild/stop",
 	CancelQueueItemURI:           "/queue/cancelItem?id=%d",
 	DeleteBuildURI:               "/job/%s/lastBuild/doDelete",
+	DeleteJobURI:                 "/job/%s/doDelete",
 	GetCrumbURI:                  "/crumbIssuer/api/xml?xpath=concat(//crumbRequestField,\":\",//crumb)",
 	JenkinsJobBuildURI:           "/job/%s/build",
 	JenkinsJobBuildWithParamsURI: "/job/%s/buildWithParameters",
@@ -59,6 +62,7 @@ var JenkinsConfig = jenkinsConfig{
 	JenkinsDeleteCredURI:         "/credentials/store/system/domain/_/credential/%s/doDelete",
 	JenkinsBuildInfoURI:          "/job/%s/lastBuild/api/json",
 	JenkinsBuildLogURI:           "/job/%s/lastBuild/timestamps/?elapsed=HH'h'mm'm'ss's'S'ms'&appendLog",
+	JenkinsArtifactURI:           "/job/%s/lastBuild/artifact/%s",
 	ScriptURI:                    "/scriptText",
 }
 
@@ -193,7 +197,10 @@ rm -r ../$TEMPDIR
 `
 
 const stepFinishScript = `def result = manager.build.result
-def command =  ["sh","-c","curl -s -d '' 'pipeline-server:60080/v1/events/stepfinish?id=%v&status=${result}&stageOrdinal=%v&stepOrdinal=%v'"]
+def exitMatcher = manager.getLogMatcher('.*R_CICD_EXIT_CODE=(\\d+).*')
+def exitCode = exitMatcher ? exitMatcher.group(1) : '-1'
+def timeout = manager.logContains('.*Build timed out.*')
+def command =  ["sh","-c","curl -s -d '' 'pipeline-server:60080/v1/events/stepfinish?id=%v&status=${result}&stageOrdinal=%v&stepOrdinal=%v&exitCode=${exitCode}&timeout=${timeout}'"]
 manager.listener.logger.println command.execute().text`
 
 const stepSCMFinishScript = `def result = manager.build.result
@@ -201,7 +208,19 @@ def env = manager.build.environment
 def GIT_COMMIT = env.get("GIT_COMMIT")
 def GIT_URL = env.get("GIT_URL")
 def GIT_BRANCH = env.get("GIT_BRANCH")
-def command =  ["sh","-c","curl -s -d 'GIT_URL=${GIT_URL}&GIT_BRANCH=${GIT_BRANCH}&GIT_COMMIT=${GIT_COMMIT}' 'pipeline-server:60080/v1/events/stepfinish?id=%v&status=${result}&stageOrdinal=%v&stepOrdinal=%v'"]
+def timeout = manager.logContains('.*Build timed out.*')
+def command =  ["sh","-c","curl -s -d 'GIT_URL=${GIT_URL}&GIT_BRANCH=${GIT_BRANCH}&GIT_COMMIT=${GIT_COMMIT}' 'pipeline-server:60080/v1/events/stepfinish?id=%v&status=${result}&stageOrdinal=%v&stepOrdinal=%v&timeout=${timeout}'"]
 manager.listener.logger.println command.execute().text`
 
+//exitCodeScript prints the exit code of the step command for the stepfinish script to report
+const exitCodeScript = "set +x\ntrap 'echo \"R_CICD_EXIT_CODE=$?\"' EXIT\n"
+
+const restoreArtifactsScript = "curl -sf 'pipeline-server:60080/v1/events/artifacts?id=%v&token=%v' | tar -x\n"
+
+const restoreCacheScript = `if curl -sf -o .r_cicd_cache.tar "pipeline-server:60080/v1/events/cache?$R_CICD_CACHE_QUERY"; then tar -xf .r_cicd_cache.tar && echo "cache restored"; else echo "cache not found"; fi
+rm -f .r_cicd_cache.tar
+`
+
+const saveCacheScript = `tar -cf - %s 2>/dev/null | curl -sf -X POST -T - "pipeline-server:60080/v1/events/cache?$R_CICD_CACHE_QUERY" && echo "cache saved" || echo "fail to save cache"`
+
 const stepStartScript = "curl -s -d '' 'pipeline-server:60080/v1/events/stepstart?id=%v&stageOrdinal=%v&stepOrdinal=%v'"
@@ -25,14 +25,17 @@ import (
 type JenkinsProvider struct {
 }
 
-func (j JenkinsProvider) RunPipeline(p *model.Pipeline, triggerType string) (*model.Activity, error) {
+func (j JenkinsProvider) RunPipeline(p *model.Pipeline, trigger *model.TriggerInfo) (*model.Activity, error) {
 
 	activity, err := ToActivity(p)
 	if err != nil {
 		return nil, err
 	}
-	activity.TriggerType = triggerType
-	initActivityEnvvars(activity)
+	activity.TriggerType = trigger.TriggerType
+	activity.ChangedFiles = trigger.ChangedFiles
+	activity.CommitInfo = trigger.Commit
+	activity.PullRequest = trigger.PullRequest
+	service.InitActivityEnvvars(activity)
 
 	if len(p.Stages) == 0 {
 		return nil, errors.New("no stage in pipeline definition to run!")
@@ -91,7 +94,7 @@ func (j JenkinsProvider) RerunActivity(a *model.Activity) error {
 	logrus.Infof("rerunpipeline,get nodeName:%v", nodeName)
 	a.RunSequence = a.Pipeline.RunCount + 1
 	a.StartTS = time.Now().UnixNano() / int64(time.Millisecond)
-	initActivityEnvvars(a)
+	service.InitActivityEnvvars(a)
 	err = j.RunStage(a, 0)
 	return err
 }
@@ -211,7 +214,7 @@ func (j JenkinsProvider) UpdateJobConf(activity *model.Activity) error {
 	for stageNum := 0; stageNum < len(activity.ActivityStages); stageNum++ {
 		for stepNum := 0; stepNum < len(activity.ActivityStages[stageNum].ActivitySteps); stepNum++ {
 			conf := j.generateStepJenkinsProject(activity, stageNum, stepNum)
-			if stageNum == 0 && stepNum == 0 && activity.CommitInfo != "" && activity.CommitInfo != "null" {
+			if stageNum == 0 && stepNum == 0 && activity.PullRequest == nil && activity.CommitInfo != "" && activity.CommitInfo != "null" {
 				conf.Scm.GitBranch = activity.CommitInfo
 			}
 			jobName := getJobName(activity, stageNum, stepNum)
@@ -226,61 +229,6 @@ func (j JenkinsProvider) UpdateJobConf(activity *model.Activity) error {
 	return nil
 }
 
-func EvaluateConditions(activity *model.Activity, condition *model.PipelineConditions) (bool, error) {
-	if condition == nil || (len(condition.All) == 0 && len(condition.Any) == 0) {
-		return false, fmt.Errorf("Nil condition")
-	}
-	if len(condition.All) > 0 {
-		for _, c := range condition.All {
-			resCond, err := EvaluateCondition(activity, c)
-			if err != nil {
-				return false, err
-			}
-			if !resCond {
-				return false, nil
-			}
-		}
-		return true, nil
-	}
-
-	for _, c := range condition.Any {
-		resCond, err := EvaluateCondition(activity, c)
-		if err != nil {
-			return false, err
-		}
-		if resCond {
-			return true, nil
-		}
-	}
-	return false, nil
-}
-
-//valid format:     xxx=xxx; xxx!=xxx
-func EvaluateCondition(activity *model.Activity, condition string) (bool, error) {
-	m := util.GetParams(`(?P<Key>.*?)!=(?P<Value>.*)`, condition)
-	if m["Key"] != "" && m["Value"] != "" {
-		key := SubstituteVar(activity, m["Key"])
-		val := SubstituteVar(activity, m["Value"])
-		envVal := activity.EnvVars[key]
-		if envVal != val {
-			return true, nil
-		}
-		return false, nil
-	}
-
-	m = util.GetParams(`(?P<Key>.*?)=(?P<Value>.*)`, condition)
-	if m["Key"] != "" && m["Value"] != "" {
-		key := SubstituteVar(activity, m["Key"])
-		val := SubstituteVar(activity, m["Value"])
-		envVal := activity.EnvVars[key]
-		if envVal == val {
-			return true, nil
-		}
-		return false, nil
-	}
-	return false, fmt.Errorf("cannot parse condition:%s", condition)
-}
-
 func (j JenkinsProvider) RunStage(activity *model.Activity, ordinal int) error {
 	if len(activity.ActivityStages) <= ordinal {
 		return fmt.Errorf("error run stage,stage index out of range")
@@ -292,9 +240,9 @@ func (j JenkinsProvider) RunStage(activity *model.Activity, ordinal int) error {
 	curTime := time.Now().UnixNano() / int64(time.Millisecond)
 	var err error
 	if service.HasStageCondition(stage) {
-		condFlag, err = EvaluateConditions(activity, stage.Conditions)
+		condFlag, err = service.EvaluateStageCondition(activity, stage)
 		if err != nil {
-			logrus.Errorf("Evaluate condition '%v' got error:%v", stage.Conditions, err)
+			logrus.Errorf("Evaluate condition of stage '%s' got error:%v", stage.Name, err)
 			return err
 		}
 	}
@@ -343,9 +291,9 @@ func (j JenkinsProvider) RunStep(activity *model.Activity, stageOrdinal int, ste
 	condFlag := true
 	var err error
 	if service.HasStepCondition(step) {
-		condFlag, err = EvaluateConditions(activity, step.Conditions)
+		condFlag, err = service.EvaluateStepCondition(activity, step)
 		if err != nil {
-			logrus.Errorf("Evaluate condition '%v' got error:%v", step.Conditions, err)
+			logrus.Errorf("Evaluate condition of step '%s' got error:%v", step.Name, err)
 			return err
 		}
 	}
@@ -391,7 +339,15 @@ func (j JenkinsProvider) generateStepJenkinsProject(activity *model.Activity, st
 
 	step.Services = service.GetServices(activity, stageOrdinal, stepOrdinal)
 	taskShells := []JenkinsTaskShell{}
-	taskShells = append(taskShells, JenkinsTaskShell{Command: commandBuilder(activity, step)})
+	command := commandBuilder(activity, step)
+	if step.Type == model.StepTypeTask && step.Cache != nil {
+		restoreCache, saveCache := cacheScripts(activity, step, stageOrdinal, stepOrdinal)
+		command = restoreCache + command + "\n" + saveCache
+	}
+	if step.Retry != nil {
+		command = exitCodeScript + command
+	}
+	taskShells = append(taskShells, JenkinsTaskShell{Command: command})
 	commandBuilders := JenkinsBuilder{TaskShells: taskShells}
 
 	scm := JenkinsSCM{Class: "hudson.scm.NullSCM"}
@@ -406,6 +362,20 @@ func (j JenkinsProvider) generateStepJenkinsProject(activity *model.Activity, st
 			GitCredentialId: step.GitUser,
 			GitBranch:       step.Branch,
 		}
+		if step.GitCredential != "" {
+			//the jenkins credential is created with the same id
+			scm.GitCredentialId = step.GitCredential
+		}
+		if service.IsForkPullRequest(activity) {
+			scm.GitCredentialId = ""
+		}
+		if pr := activity.PullRequest; pr != nil {
+			//fetch the pull request ref besides branches, the ref is always built
+			scm.GitRefspec = "+refs/heads/*:refs/remotes/origin/* +" + pr.Ref + ":refs/remotes/origin/pull-request"
+			scm.GitBranch = "refs/remotes/origin/pull-request"
+		} else if activity.CommitInfo != "" && activity.CommitInfo != "null" {
+			scm.GitBranch = activity.CommitInfo
+		}
 		postBuildSctipt = stepSCMFinishScript
 	}
 	preSCMStep := PreSCMBuildStepsWrapper{
@@ -440,6 +410,16 @@ func (j JenkinsProvider) generateStepJenkinsProject(activity *model.Activity, st
 		TimeoutWrapper:                   timeoutWrapper,
 		PreSCMBuildStepsWrapper:          preSCMStep,
 	}
+	if secrets := service.StepSecrets(activity, step); step.Type == model.StepTypeTask && len(secrets) > 0 {
+		//secrets are synced as jenkins credentials
+		v.SecretWrapper = &SecretBuildWrapper{Plugin: "credentials-binding@1.13"}
+		for _, name := range secrets {
+			v.SecretWrapper.Bindings = append(v.SecretWrapper.Bindings, StringBinding{
+				CredentialsId: service.SecretId(activity.Pipeline.Id, name),
+				Variable:      name,
+			})
+		}
+	}
 	//post task to notify pipelineserver
 	pbt := PostBuildTask{
 		Plugin:             "groovy-postbuild@2.3.1",
@@ -452,6 +432,12 @@ func (j JenkinsProvider) generateStepJenkinsProject(activity *model.Activity, st
 		},
 	}
 	v.Publishers = pbt
+	if len(step.Artifacts) > 0 {
+		v.ArtifactArchiver = &ArtifactArchiver{
+			Artifacts:        strings.Join(step.Artifacts, ","),
+			OnlyIfSuccessful: true,
+		}
+	}
 
 	return v
 
@@ -467,6 +453,10 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 	stringBuilder.WriteString("set +x \n")
 	switch step.Type {
 	case model.StepTypeTask:
+		if step.RestoreArtifacts && len(activity.Artifacts) > 0 {
+			//extract artifacts archived so far into the workspace
+			stringBuilder.WriteString(fmt.Sprintf(restoreArtifactsScript, url.QueryEscape(activity.Id), url.QueryEscape(activity.CallbackToken)))
+		}
 
 		envVars := ""
 		if len(step.Env) > 0 {
@@ -474,6 +464,10 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 				envVars += fmt.Sprintf("-e %s ", QuoteShell(para))
 			}
 		}
+		//values of secrets are bound in the build env
+		for _, name := range service.StepSecrets(activity, step) {
+			envVars += fmt.Sprintf("-e %s ", name)
+		}
 
 		entrypointPara := ""
 		argsPara := ""
@@ -572,16 +566,23 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 		}
 	case model.StepTypeSCM:
 		//write to a env file that provides the environment variables to use throughout the activity.
+		//values are never expanded by the shell, git vars of the build are quoted at runtime by the same rule
 		stringBuilder.WriteString("GIT_BRANCH=$(echo $GIT_BRANCH|cut -d / -f 2)\n")
-		stringBuilder.WriteString("cat>.r_cicd.env<<R_CICD_EOF\n")
-		stringBuilder.WriteString("CICD_GIT_COMMIT=$GIT_COMMIT\n")
-		stringBuilder.WriteString("CICD_GIT_BRANCH=$GIT_BRANCH\n")
-		stringBuilder.WriteString("CICD_GIT_URL=$GIT_URL\n")
-		stringBuilder.WriteString("CICD_PIPELINE_NAME=" + activity.Pipeline.Name + "\n")
-		stringBuilder.WriteString("CICD_PIPELINE_ID=" + activity.Pipeline.Id + "\n")
-		stringBuilder.WriteString("CICD_TRIGGER_TYPE=" + activity.TriggerType + "\n")
-		stringBuilder.WriteString("CICD_NODE_NAME=" + activity.NodeName + "\n")
-		stringBuilder.WriteString("CICD_ACTIVITY_ID=" + activity.Id + "\n")
+		stringBuilder.WriteString(envQuoteFunc)
+		stringBuilder.WriteString("r_cicd_env CICD_GIT_COMMIT \"$GIT_COMMIT\">.r_cicd.env\n")
+		stringBuilder.WriteString("r_cicd_env CICD_GIT_BRANCH \"$GIT_BRANCH\">>.r_cicd.env\n")
+		stringBuilder.WriteString("r_cicd_env CICD_GIT_URL \"$GIT_URL\">>.r_cicd.env\n")
+		stringBuilder.WriteString("cat>>.r_cicd.env<<'R_CICD_EOF'\n")
+		stringBuilder.WriteString("CICD_PIPELINE_NAME=" + QuoteEnvValue(activity.Pipeline.Name) + "\n")
+		stringBuilder.WriteString("CICD_PIPELINE_ID=" + QuoteEnvValue(activity.Pipeline.Id) + "\n")
+		stringBuilder.WriteString("CICD_TRIGGER_TYPE=" + QuoteEnvValue(activity.TriggerType) + "\n")
+		if pr := activity.PullRequest; pr != nil {
+			stringBuilder.WriteString("CICD_PR_NUMBER=" + strconv.Itoa(pr.Number) + "\n")
+			stringBuilder.WriteString("CICD_PR_SOURCE_BRANCH=" + QuoteEnvValue(pr.SourceBranch) + "\n")
+			stringBuilder.WriteString("CICD_PR_TARGET_BRANCH=" + QuoteEnvValue(pr.TargetBranch) + "\n")
+		}
+		stringBuilder.WriteString("CICD_NODE_NAME=" + QuoteEnvValue(activity.NodeName) + "\n")
+		stringBuilder.WriteString("CICD_ACTIVITY_ID=" + QuoteEnvValue(activity.Id) + "\n")
 		stringBuilder.WriteString("CICD_ACTIVITY_SEQUENCE=" + strconv.Itoa(activity.RunSequence) + "\n")
 		//user defined env vars
 		for _, envvar := range activity.Pipeline.Parameters {
@@ -589,9 +590,13 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 			if len(splits) != 2 {
 				continue
 			}
-			stringBuilder.WriteString(fmt.Sprintf("%s=%s\n", splits[0], QuoteShell(splits[1])))
+			if !envNameRegexp.MatchString(splits[0]) {
+				logrus.Warningf("env var '%s' is skipped as its name is invalid", splits[0])
+				continue
+			}
+			stringBuilder.WriteString(fmt.Sprintf("%s=%s\n", splits[0], QuoteEnvValue(splits[1])))
 		}
-		stringBuilder.WriteString("\nR_CICD_EOF\n")
+		stringBuilder.WriteString("R_CICD_EOF\n")
 
 	case model.StepTypeUpgradeService:
 		stringBuilder.WriteString(". ${PWD}/.r_cicd.env\n")
@@ -602,7 +607,7 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 			stringBuilder.WriteString(" --accesskey ")
 			stringBuilder.WriteString(QuoteShell(step.Accesskey))
 			stringBuilder.WriteString(" --secretkey ")
-			envKey, err := service.GetEnvKey(step.Accesskey)
+			envKey, err := service.StepEnvKey(activity, step)
 			if err != nil {
 				logrus.Errorf("error get env credential:%v", err)
 			}
@@ -640,7 +645,7 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 			script := fmt.Sprintf(upgradeStackScript, "$CATTLE_URL", "$CATTLE_ACCESS_KEY", "$CATTLE_SECRET_KEY", step.StackName, EscapeShell(activity, step.DockerCompose), EscapeShell(activity, step.RancherCompose))
 			stringBuilder.WriteString(script)
 		} else {
-			envKey, err := service.GetEnvKey(step.Accesskey)
+			envKey, err := service.StepEnvKey(activity, step)
 			if err != nil {
 				logrus.Errorf("error get env credential:%v", err)
 			}
@@ -686,7 +691,7 @@ func commandBuilder(activity *model.Activity, step *model.Step) string {
 		if step.Endpoint != "" {
 			endpoint = step.Endpoint
 			accessKey = step.Accesskey
-			envKey, err = service.GetEnvKey(step.Accesskey)
+			envKey, err = service.StepEnvKey(activity, step)
 			if err != nil {
 				logrus.Errorf("error get env credential:%v", err)
 			}
@@ -722,8 +727,7 @@ func (j JenkinsProvider) SyncActivity(activity *model.Activity) error {
 			if err != nil {
 				if actiStage.NeedApproval && j == 0 {
 					//Pending
-					actiStage.Status = model.ActivityStagePending
-					activity.Status = model.ActivityPending
+					service.PendActivity(activity, i)
 				}
 				break
 			}
@@ -832,9 +836,7 @@ func (j JenkinsProvider) SyncActivityStale(activity *model.Activity) (bool, erro
 
 				if i < len(p.Stages)-1 && activity.Pipeline.Stages[i+1].NeedApprove {
 					logrus.Infof("set pending")
-					activity.Status = model.ActivityPending
-					activity.ActivityStages[i+1].Status = model.ActivityStagePending
-					activity.PendingStage = i + 1
+					service.PendActivity(activity, i+1)
 				}
 			}
 			updated = updated || stepStatusUpdated
@@ -849,6 +851,59 @@ func (j JenkinsProvider) SyncActivityStale(activity *model.Activity) (bool, erro
 	return updated, nil
 }
 
+//cacheScripts gets scripts to restore the cache of the step before running and save it after success.
+//Checksums of files in the cache key are computed in the workspace and sent to the server to get the key.
+func cacheScripts(activity *model.Activity, step *model.Step, stageOrdinal int, stepOrdinal int) (string, string) {
+	restore := new(bytes.Buffer)
+	restore.WriteString(fmt.Sprintf("R_CICD_CACHE_QUERY='id=%s&token=%s&stageOrdinal=%d&stepOrdinal=%d'\n", url.QueryEscape(activity.Id), url.QueryEscape(activity.CallbackToken), stageOrdinal, stepOrdinal))
+	files, err := service.CacheKeyFiles(activity, step)
+	if err != nil {
+		logrus.Errorf("fail to parse cache key of step '%s': %v", step.Name, err)
+	}
+	for _, file := range files {
+		restore.WriteString(fmt.Sprintf("R_CICD_CACHE_QUERY=\"$R_CICD_CACHE_QUERY&checksum=%s$(sha256sum %s 2>/dev/null | cut -c1-64)\"\n", url.QueryEscape(file+":"), QuoteShell(file)))
+	}
+	restore.WriteString(restoreCacheScript)
+	if service.IsForkPullRequest(activity) {
+		//caches are restored but never saved by pull requests of forks
+		return restore.String(), ""
+	}
+
+	paths := []string{}
+	for _, p := range step.Cache.Paths {
+		paths = append(paths, QuoteShell(p))
+	}
+	save := fmt.Sprintf(saveCacheScript, strings.Join(paths, " "))
+	return restore.String(), save
+}
+
+//ArchiveArtifacts downloads files archived by the ArtifactArchiver of the step job into the artifact store
+func (j JenkinsProvider) ArchiveArtifacts(activity *model.Activity, stageOrdinal int, stepOrdinal int) ([]*model.Artifact, error) {
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	jobName := getJobName(activity, stageOrdinal, stepOrdinal)
+	buildInfo, err := GetBuildInfo(jobName)
+	if err != nil {
+		return nil, err
+	}
+	if len(buildInfo.Artifacts) == 0 {
+		return nil, fmt.Errorf("no file matches artifacts %v", step.Artifacts)
+	}
+	artifacts := []*model.Artifact{}
+	for _, a := range buildInfo.Artifacts {
+		r, err := GetBuildArtifact(jobName, a.RelativePath)
+		if err != nil {
+			return artifacts, err
+		}
+		archived, err := service.PutArtifact(activity.Id, step.Name, a.RelativePath, r)
+		r.Close()
+		if err != nil {
+			return artifacts, err
+		}
+		artifacts = append(artifacts, archived)
+	}
+	return artifacts, nil
+}
+
 //OnActivityCompelte helps clean up
 func (j JenkinsProvider) OnActivityCompelte(activity *model.Activity) {
 	//clean related container by label
@@ -874,20 +929,38 @@ func (j JenkinsProvider) OnActivityCompelte(activity *model.Activity) {
 
 }
 
+//OnDeleteActivity deletes step jobs of the activity with their builds, then its workspace on the worker node
+func (j JenkinsProvider) OnDeleteActivity(activity *model.Activity) error {
+	for stageOrdinal, stage := range activity.ActivityStages {
+		for stepOrdinal := range stage.ActivitySteps {
+			jobName := getJobName(activity, stageOrdinal, stepOrdinal)
+			if err := DeleteJob(jobName); err != nil {
+				return errors.Wrapf(err, "fail to delete job '%s'", jobName)
+			}
+		}
+	}
+	if activity.NodeName == "" {
+		return nil
+	}
+	command := "rm -rf ${System.getenv('JENKINS_HOME')}/workspace/" + activity.Id
+	cleanWorkspaceScript := fmt.Sprintf(ScriptSkel, activity.NodeName, strings.Replace(command, "\"", "\\\"", -1))
+	if res, err := ExecScript(cleanWorkspaceScript); err != nil {
+		return errors.Wrapf(err, "fail to remove workspace, got result '%s'", res)
+	}
+	return nil
+}
+
 func (j JenkinsProvider) OnCreateAccount(account *model.GitAccount) error {
 	jenkinsCred := &JenkinsCredential{}
 	jenkinsCred.Class = "com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl"
 	jenkinsCred.Scope = "GLOBAL"
 	jenkinsCred.Id = account.Id
-	if account.AccountType == "github" {
-		jenkinsCred.Username = account.Login
-		jenkinsCred.Password = account.AccessToken
-	} else if account.AccountType == "gitlab" {
-		jenkinsCred.Username = "oauth2"
-		jenkinsCred.Password = account.AccessToken
-	} else {
-		return errors.New("unknown scmtype")
+	manager, err := service.GetSCManager(account.AccountType)
+	if err != nil {
+		return err
 	}
+	jenkinsCred.Username = manager.GetCloneUser(account.Login)
+	jenkinsCred.Password = account.AccessToken
 	bodyContent := map[string]interface{}{}
 	bodyContent["credentials"] = jenkinsCred
 	b, err := json.Marshal(bodyContent)
@@ -909,6 +982,54 @@ func (j JenkinsProvider) OnDeleteAccount(account *model.GitAccount) error {
 	return DeleteCredential(account.Id)
 }
 
+//OnCreateCredential creates the jenkins credential with the same id for the git step or secrets,
+//which replaces the existing one if the credential is updated
+func (j JenkinsProvider) OnCreateCredential(cred *model.Credential) error {
+	jenkinsCred := &JenkinsCredential{}
+	jenkinsCred.Scope = "GLOBAL"
+	jenkinsCred.Id = cred.Id
+	jenkinsCred.Description = cred.Name
+	jenkinsCred.Username = cred.PublicValue
+	switch cred.CredType {
+	case model.CredTypeSSHKey:
+		jenkinsCred.Class = "com.cloudbees.jenkins.plugins.sshcredentials.impl.BasicSSHUserPrivateKey"
+		if jenkinsCred.Username == "" {
+			jenkinsCred.Username = "git"
+		}
+		jenkinsCred.PrivateKeySource = &JenkinsPrivateKeySource{
+			StaplerClass: "com.cloudbees.jenkins.plugins.sshcredentials.impl.BasicSSHUserPrivateKey$DirectEntryPrivateKeySource",
+			PrivateKey:   cred.SecretValue,
+		}
+	case model.CredTypeBasicAuth:
+		jenkinsCred.Class = "com.cloudbees.plugins.credentials.impl.UsernamePasswordCredentialsImpl"
+		jenkinsCred.Password = cred.SecretValue
+	case model.CredTypeSecret:
+		jenkinsCred.Class = "org.jenkinsci.plugins.plaintextcredentials.impl.StringCredentialsImpl"
+		jenkinsCred.Username = ""
+		jenkinsCred.Secret = cred.SecretValue
+	default:
+		return fmt.Errorf("unsupported credential type '%s'", cred.CredType)
+	}
+	//ignore the error if it does not exist
+	DeleteCredential(cred.Id)
+	bodyContent := map[string]interface{}{}
+	bodyContent["credentials"] = jenkinsCred
+	b, err := json.Marshal(bodyContent)
+	if err != nil {
+		return err
+	}
+	buff := bytes.NewBufferString("json=")
+	buff.Write(b)
+	return CreateCredential(buff.Bytes())
+}
+
+func (j JenkinsProvider) OnDeleteCredential(cred *model.Credential) error {
+	if cred == nil {
+		return errors.New("nil credential")
+	}
+	return DeleteCredential(cred.Id)
+}
+
 func (j JenkinsProvider) GetStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, paras map[string]interface{}) (string, error) {
 	if stageOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) || stepOrdinal < 0 || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
 		return "", errors.New("ordinal out of range")
@@ -987,7 +1108,7 @@ func parseSteps(actiStage *model.ActivityStage, rawOutput string) bool {
 	for i, step := range actiStage.ActivitySteps {
 		finishStepNum := len(outputs) - 1
 		prevStatus := step.Status
-		logrus.Debug("getting step %v", i)
+		logrus.Debugf("getting step %v", i)
 		if i < finishStepNum-1 {
 			//passed steps
 			step.Status = model.ActivityStepSuccess
@@ -1066,63 +1187,48 @@ func ToActivity(p *model.Pipeline) (*model.Activity, error) {
 		StartTS:     time.Now().UnixNano() / int64(time.Millisecond),
 		NodeName:    nodeName,
 	}
+	service.IssueCallbackToken(activity)
+	//matrix steps are expanded in stages of the activity, the pipeline is not changed
+	activity.Pipeline.Stages = []*model.Stage{}
 	for _, stage := range p.Stages {
-		activity.ActivityStages = append(activity.ActivityStages, ToActivityStage(stage))
+		stage = service.ExpandMatrix(stage)
+		activity.Pipeline.Stages = append(activity.Pipeline.Stages, stage)
+		activity.ActivityStages = append(activity.ActivityStages, service.ToActivityStage(stage))
 	}
 
 	return activity, nil
 }
 
-func initActivityEnvvars(activity *model.Activity) {
-	p := activity.Pipeline
-	vars := map[string]string{}
-	vars["CICD_PIPELINE_NAME"] = p.Name
-	vars["CICD_PIPELINE_ID"] = p.Id
-	vars["CICD_NODE_NAME"] = activity.NodeName
-	vars["CICD_ACTIVITY_ID"] = activity.Id
-	vars["CICD_ACTIVITY_SEQUENCE"] = strconv.Itoa(activity.RunSequence)
-	vars["CICD_GIT_URL"] = p.Stages[0].Steps[0].Repository
-	vars["CICD_GIT_BRANCH"] = p.Stages[0].Steps[0].Branch
-	vars["CICD_GIT_COMMIT"] = activity.CommitInfo
-	vars["CICD_TRIGGER_TYPE"] = activity.TriggerType
-	//user defined env vars
-	for _, envvar := range activity.Pipeline.Parameters {
-		splits := strings.SplitN(envvar, "=", 2)
-		if len(splits) != 2 {
-			continue
-		}
-		vars[splits[0]] = splits[1]
-	}
-	activity.EnvVars = vars
-}
-
-func ToActivityStage(stage *model.Stage) *model.ActivityStage {
-	actiStage := model.ActivityStage{
-		Name:          stage.Name,
-		NeedApproval:  stage.NeedApprove,
-		Status:        "Waiting",
-		ActivitySteps: []*model.ActivityStep{},
-	}
-	for _, step := range stage.Steps {
-		actiStep := &model.ActivityStep{
-			Name:   step.Name,
-			Status: model.ActivityStepWaiting,
-		}
-		actiStage.ActivitySteps = append(actiStage.ActivitySteps, actiStep)
-	}
-	return &actiStage
-
-}
-
 func QuoteShell(script string) string {
 	//Use double quotes so variable substitution works
 
 	escaped := strings.Replace(script, "\\", "\\\\", -1)
-	escaped = strings.Replace(script, "\"", "\\\"", -1)
+	escaped = strings.Replace(escaped, "\"", "\\\"", -1)
 	escaped = "\"" + escaped + "\""
 	return escaped
 }
 
+var (
+	envNameRegexp      = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_]*$")
+	envSafeValueRegexp = regexp.MustCompile("^[A-Za-z0-9_./:@+-]*$")
+)
+
+//envQuoteFunc defines the shell function writing the env var in the same format as QuoteEnvValue
+const envQuoteFunc = `r_cicd_env() { case "$2" in *[!A-Za-z0-9_./:@+-]*) printf "%s='%s'\n" "$1" "$(printf '%s' "$2" | sed "s/'/'\\\\''/g")";; *) printf '%s=%s\n' "$1" "$2";; esac; }
+`
+
+//QuoteEnvValue quotes the value of the env file in single quotes, values of safe characters are kept as they are
+//as the env file is also read by docker, which takes values literally. Line breaks are quoted apart, so that no
+//line of the value ends the heredoc writing the env file.
+func QuoteEnvValue(value string) string {
+	if envSafeValueRegexp.MatchString(value) {
+		return value
+	}
+	escaped := strings.Replace(value, "'", "'\\''", -1)
+	escaped = strings.Replace(escaped, "\n", "'\"\n\"'", -1)
+	return "'" + escaped + "'"
+}
+
 func EscapeShell(activity *model.Activity, script string) string {
 	escaped := strings.Replace(script, "\\", "\\\\", -1)
 	escaped = strings.Replace(escaped, "$", "\\$", -1)
@@ -1136,17 +1242,6 @@ func EscapeShell(activity *model.Activity, script string) string {
 	return escaped
 }
 
-//merely substitute envvars without escaping shell
-func SubstituteVar(activity *model.Activity, text string) string {
-	for k, v := range activity.EnvVars {
-		text = strings.Replace(text, "$"+k+" ", v, -1)
-		text = strings.Replace(text, "$"+k+"\n", v, -1)
-		text = strings.Replace(text, "${"+k+"}", v, -1)
-
-	}
-	return text
-}
-
 func templateURLPath(path string) (string, string, string, string, bool) {
 	pathSplit := strings.Split(path, ":")
 	switch len(pathSplit) {
@@ -0,0 +1,90 @@
+package jenkins
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestQuoteShell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{`a b`, `"a b"`},
+		{`a"b`, `"a\"b"`},
+		{`a\b`, `"a\\b"`},
+		{`a\"b`, `"a\\\"b"`},
+	}
+	for _, test := range tests {
+		if got := QuoteShell(test.value); got != test.want {
+			t.Errorf("QuoteShell(%q) got %s, want %s", test.value, got, test.want)
+		}
+	}
+}
+
+//TestSCMEnvFile runs the env file script of the scm step and checks the values read back by the shell
+func TestSCMEnvFile(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not found")
+	}
+	dir, err := ioutil.TempDir("", "r_cicd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	values := map[string]string{
+		"PLAIN":   "v1.0",
+		"SPACE":   "a b",
+		"QUOTE":   "it's",
+		"SUBST":   "$(touch pwned)`touch pwned`$HOME",
+		"NEWLINE": "x\nR_CICD_EOF\ntouch pwned",
+	}
+	activity := &model.Activity{
+		Id: "a1",
+		Pipeline: model.Pipeline{
+			Name: "my pipeline",
+			Id:   "p1",
+			Parameters: []string{
+				"PLAIN=" + values["PLAIN"],
+				"SPACE=" + values["SPACE"],
+				"QUOTE=" + values["QUOTE"],
+				"SUBST=" + values["SUBST"],
+				"NEWLINE=" + values["NEWLINE"],
+				"BAD;NAME=x",
+			},
+		},
+		PullRequest: &model.PullRequest{Number: 1, SourceBranch: "';touch pwned;'", TargetBranch: "master"},
+	}
+	values["CICD_PIPELINE_NAME"] = "my pipeline"
+	values["CICD_PR_SOURCE_BRANCH"] = "';touch pwned;'"
+	values["CICD_GIT_BRANCH"] = "it's"
+	values["CICD_GIT_COMMIT"] = "abc123"
+
+	script := commandBuilder(activity, &model.Step{Type: model.StepTypeSCM})
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_BRANCH=origin/it's", "GIT_COMMIT=abc123", "GIT_URL=https://github.com/a/b.git")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("script got error: %v\n%s", err, out)
+	}
+	for name, want := range values {
+		read := exec.Command("sh", "-c", `. ./.r_cicd.env && printf '%s' "$`+name+`"`)
+		read.Dir = dir
+		out, err := read.Output()
+		if err != nil {
+			t.Fatalf("fail to read env file: %v", err)
+		}
+		if string(out) != want {
+			t.Errorf("got %s=%q, want %q", name, out, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("values of the env file are run by the shell")
+	}
+}
@@ -24,10 +24,12 @@ type JenkinsProject struct {
 	ConcurrentBuild                  bool                    `xml:"concurrentBuild"`
 	CustomWorkspace                  string                  `xml:"customWorkspace"`
 	Builders                         JenkinsBuilder          `xml:"builders,omitempty"`
+	ArtifactArchiver                 *ArtifactArchiver       `xml:"publishers>hudson.tasks.ArtifactArchiver,omitempty"`
 	Publishers                       PostBuildTask           `xml:"publishers>org.jvnet.hudson.plugins.groovypostbuild.GroovyPostbuildRecorder"`
 	TimeStampWrapper                 TimestampWrapperPlugin  `xml:"buildWrappers>hudson.plugins.timestamper.TimestamperBuildWrapper"`
 	TimeoutWrapper                   *TimeoutWrapperPlugin   `xml:"buildWrappers>hudson.plugins.build__timeout.BuildTimeoutWrapper"`
 	PreSCMBuildStepsWrapper          PreSCMBuildStepsWrapper `xml:"buildWrappers>org.jenkinsci.plugins.preSCMbuildstep.PreSCMBuildStepsWrapper"`
+	SecretWrapper                    *SecretBuildWrapper     `xml:"buildWrappers>org.jenkinsci.plugins.credentialsbinding.impl.SecretBuildWrapper,omitempty"`
 }
 
 type JenkinsSCM struct {
@@ -36,6 +38,7 @@ type JenkinsSCM struct {
 	ConfigVersion                     int    `xml:"configVersion"`
 	GitRepo                           string `xml:"userRemoteConfigs>hudson.plugins.git.UserRemoteConfig>url"`
 	GitCredentialId                   string `xml:"userRemoteConfigs>hudson.plugins.git.UserRemoteConfig>credentialsId"`
+	GitRefspec                        string `xml:"userRemoteConfigs>hudson.plugins.git.UserRemoteConfig>refspec,omitempty"`
 	GitBranch                         string `xml:"branches>hudson.plugins.git.BranchSpec>name"`
 	DoGenerateSubmoduleConfigurations bool   `xml:"doGenerateSubmoduleConfigurations"`
 	SubmodelCfg                       string `xml:"submoduleCfg,omitempty"`
@@ -84,6 +87,18 @@ type PreSCMBuildStepsWrapper struct {
 	FailOnError bool   `xml:"failOnError"`
 	Command     string `xml:"buildSteps>hudson.tasks.Shell>command"`
 }
+
+//SecretBuildWrapper binds secret text credentials to env vars of the build, values are masked in the build log
+type SecretBuildWrapper struct {
+	Plugin   string          `xml:"plugin,attr"`
+	Bindings []StringBinding `xml:"bindings>org.jenkinsci.plugins.credentialsbinding.impl.StringBinding"`
+}
+
+type StringBinding struct {
+	CredentialsId string `xml:"credentialsId"`
+	Variable      string `xml:"variable"`
+}
+
 type PostBuildTask struct {
 	Plugin             string       `xml:"plugin,attr"`
 	GroovyScript       GroovyScript `xml:"script"`
@@ -91,6 +106,13 @@ type PostBuildTask struct {
 	RunForMatrixParent bool         `xml:"runForMatrixParent"`
 }
 
+//ArtifactArchiver archives workspace files before the step finish event is posted
+type ArtifactArchiver struct {
+	Artifacts         string `xml:"artifacts"`
+	AllowEmptyArchive bool   `xml:"allowEmptyArchive"`
+	OnlyIfSuccessful  bool   `xml:"onlyIfSuccessful"`
+}
+
 type GroovyScript struct {
 	Plugin  string `xml:"plugin,attr"`
 	Script  string `xml:"script"`
@@ -236,9 +258,12 @@ type JenkinsBuildInfo struct {
 		RemoteUrls []string `json:"remoteUrls"`
 		ScmName    string   `json:"scmName"`
 	} `json:"actions"`
-	Artifacts []interface{} `json:"artifacts"`
-	Building  bool          `json:"building"`
-	BuiltOn   string        `json:"builtOn"`
+	Artifacts []struct {
+		FileName     string `json:"fileName"`
+		RelativePath string `json:"relativePath"`
+	} `json:"artifacts"`
+	Building  bool   `json:"building"`
+	BuiltOn   string `json:"builtOn"`
 	ChangeSet struct {
 		Class string        `json:"_class"`
 		Items []interface{} `json:"items"`
@@ -260,10 +285,18 @@ type JenkinsBuildInfo struct {
 }
 
 type JenkinsCredential struct {
-	Scope       string `json:"scope"`
-	Id          string `json:"id"`
-	Username    string `json:"username"`
-	Password    string `json:"password"`
-	Description string `json:"description"`
-	Class       string `json:"$class"`
+	Scope            string                   `json:"scope"`
+	Id               string                   `json:"id"`
+	Username         string                   `json:"username"`
+	Password         string                   `json:"password,omitempty"`
+	PrivateKeySource *JenkinsPrivateKeySource `json:"privateKeySource,omitempty"`
+	Secret           string                   `json:"secret,omitempty"`
+	Description      string                   `json:"description"`
+	Class            string                   `json:"$class"`
+}
+
+//JenkinsPrivateKeySource is the private key entered directly for ssh credentials
+type JenkinsPrivateKeySource struct {
+	StaplerClass string `json:"stapler-class"`
+	PrivateKey   string `json:"privateKey"`
 }
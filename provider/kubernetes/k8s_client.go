@@ -0,0 +1,50 @@
+package kubernetes
+
+import (
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	serviceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+	defaultNamespace   = "default"
+)
+
+//NewClient creates a kubernetes clientset and gets the namespace to run jobs in,
+//in-cluster service account is used if host is empty, and its namespace if namespace is empty
+func NewClient(host string, token string, namespace string) (kubernetes.Interface, string, error) {
+	var config *rest.Config
+	if host == "" {
+		c, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, "", errors.Wrap(err, "kubernetes host is not set and not running in cluster")
+		}
+		config = c
+	} else {
+		config = &rest.Config{Host: host}
+		if token == "" {
+			if b, err := ioutil.ReadFile(serviceAccountPath + "/token"); err == nil {
+				token = strings.TrimSpace(string(b))
+			}
+		}
+	}
+	if token != "" {
+		config.BearerToken = token
+	}
+	if namespace == "" {
+		if b, err := ioutil.ReadFile(serviceAccountPath + "/namespace"); err == nil {
+			namespace = strings.TrimSpace(string(b))
+		} else {
+			namespace = defaultNamespace
+		}
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+	return client, namespace, nil
+}
@@ -0,0 +1,971 @@
+package kubernetes
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/provider/common"
+	"github.com/rancher/pipeline/server/service"
+	"github.com/sluu99/uuid"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	gitImage       = "alpine/git"
+	dockerImage    = "docker:stable"
+	workspacePath  = "/workspace"
+	workspaceName  = "workspace"
+	dockerSockName = "docker-sock"
+	dockerSockPath = "/var/run/docker.sock"
+	stepContainer  = "step"
+	commitMarker   = "R_CICD_GIT_COMMIT="
+	activityLabel  = "activityid"
+	stageLabel     = "stage"
+	stepLabel      = "step"
+	jobNameLabel   = "job-name"
+	hostnameLabel  = "kubernetes.io/hostname"
+	pollInterval   = 2 * time.Second
+)
+
+var ErrNotFound = errors.New("kubernetes object not found")
+
+//waiting reasons of containers that will not recover
+var fatalWaitingReasons = []string{"ImagePullBackOff", "InvalidImageName", "CreateContainerConfigError", "CreateContainerError"}
+
+//KubernetesProvider runs each pipeline step as a kubernetes job. Steps of an activity share the workspace
+//on a persistent volume claim of the run, which the scm step clones the repository into once.
+type KubernetesProvider struct {
+	client    kubernetes.Interface
+	namespace string
+	//size and storage class of workspace claims, the default storage class is used if volumeClass is empty
+	volumeSize  string
+	volumeClass string
+	mu          sync.Mutex
+	//results of steps reported to the server, keyed by job name
+	results map[string]string
+	//logs of steps that are not from pods, or from deleted jobs
+	logs map[string]string
+	//notify posts step events, waitReady blocks until the step is ready to run
+	notify    func(event string, activityId string, stageOrdinal int, stepOrdinal int, form url.Values) error
+	waitReady func(activityId string, stageOrdinal int, stepOrdinal int)
+	//podLog gets the container log with timestamps
+	podLog func(pod string, container string) (string, error)
+}
+
+func NewKubernetesProvider(client kubernetes.Interface, namespace string, volumeSize string, volumeClass string) *KubernetesProvider {
+	k := &KubernetesProvider{
+		client:      client,
+		namespace:   namespace,
+		volumeSize:  volumeSize,
+		volumeClass: volumeClass,
+		results:     map[string]string{},
+		logs:        map[string]string{},
+		notify:      common.PostStepEvent,
+		waitReady:   common.WaitStepReady,
+	}
+	k.podLog = k.containerLog
+	return k
+}
+
+func (k *KubernetesProvider) RunPipeline(p *model.Pipeline, trigger *model.TriggerInfo) (*model.Activity, error) {
+	activity := ToActivity(p)
+	activity.TriggerType = trigger.TriggerType
+	activity.ChangedFiles = trigger.ChangedFiles
+	activity.CommitInfo = trigger.Commit
+	activity.PullRequest = trigger.PullRequest
+	service.InitActivityEnvvars(activity)
+
+	if len(p.Stages) == 0 {
+		return nil, errors.New("no stage in pipeline definition to run!")
+	}
+	if err := k.createWorkspace(activity); err != nil {
+		return nil, errors.Wrap(err, "fail to create workspace")
+	}
+	logrus.Debugf("running stage:%v", p.Stages[0])
+	if err := k.RunStage(activity, 0); err != nil {
+		return nil, err
+	}
+
+	logrus.Debugf("creating activity:%v", activity)
+	if err := service.CreateActivity(activity); err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+func (k *KubernetesProvider) RerunActivity(a *model.Activity) error {
+	k.cleanActivity(a)
+	k.forget(a)
+	a.RunSequence = a.Pipeline.RunCount + 1
+	a.StartTS = time.Now().UnixNano() / int64(time.Millisecond)
+	service.InitActivityEnvvars(a)
+	if err := k.createWorkspace(a); err != nil {
+		return errors.Wrap(err, "fail to create workspace")
+	}
+	return k.RunStage(a, 0)
+}
+
+//createWorkspace creates the volume claim shared by steps of the activity run
+func (k *KubernetesProvider) createWorkspace(activity *model.Activity) error {
+	claim, err := workspaceClaim(activity, k.volumeSize, k.volumeClass)
+	if err != nil {
+		return err
+	}
+	_, err = k.client.CoreV1().PersistentVolumeClaims(k.namespace).Create(claim)
+	return err
+}
+
+func (k *KubernetesProvider) RunStage(activity *model.Activity, ordinal int) error {
+	if len(activity.ActivityStages) <= ordinal {
+		return fmt.Errorf("error run stage,stage index out of range")
+	}
+	stage := activity.Pipeline.Stages[ordinal]
+	logrus.Infof("run stage:%s", stage.Name)
+	condFlag := true
+	curTime := time.Now().UnixNano() / int64(time.Millisecond)
+	var err error
+	if service.HasStageCondition(stage) {
+		condFlag, err = service.EvaluateStageCondition(activity, stage)
+		if err != nil {
+			logrus.Errorf("Evaluate condition of stage '%s' got error:%v", stage.Name, err)
+			return err
+		}
+	}
+	if !condFlag {
+		activity.ActivityStages[ordinal].Status = model.ActivityStageSkip
+		if ordinal == len(activity.ActivityStages)-1 {
+			//skip last stage and success activity
+			activity.Status = model.ActivitySuccess
+			activity.StopTS = curTime
+			k.OnActivityCompelte(activity)
+		} else {
+			//skip the stage then run next one.
+			err = k.RunStage(activity, ordinal+1)
+		}
+		return err
+	}
+
+	activity.ActivityStages[ordinal].StartTS = curTime
+	if stage.Parallel {
+		for i := 0; i < len(stage.Steps); i++ {
+			if err := k.RunStep(activity, ordinal, i); err != nil {
+				logrus.Errorf("run step error:%v", err)
+				return err
+			}
+		}
+	} else {
+		if err := k.RunStep(activity, ordinal, 0); err != nil {
+			logrus.Errorf("run step error:%v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (k *KubernetesProvider) RunStep(activity *model.Activity, stageOrdinal int, stepOrdinal int) error {
+	if len(activity.ActivityStages) <= stageOrdinal ||
+		len(activity.ActivityStages[stageOrdinal].ActivitySteps) <= stepOrdinal ||
+		stageOrdinal < 0 || stepOrdinal < 0 {
+		return fmt.Errorf("error run stage,stage index out of range")
+	}
+	stage := activity.Pipeline.Stages[stageOrdinal]
+	step := stage.Steps[stepOrdinal]
+	condFlag := true
+	var err error
+	if service.HasStepCondition(step) {
+		condFlag, err = service.EvaluateStepCondition(activity, step)
+		if err != nil {
+			logrus.Errorf("Evaluate condition of step '%s' got error:%v", step.Name, err)
+			return err
+		}
+	}
+	if !condFlag {
+		activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Status = model.ActivityStepSkip
+		actiStage := activity.ActivityStages[stageOrdinal]
+		curTime := time.Now().UnixNano() / int64(time.Millisecond)
+		if service.IsStageSuccess(actiStage) {
+			//if skipped and stage success
+			actiStage.Status = model.ActivityStageSuccess
+			actiStage.Duration = curTime - actiStage.StartTS
+			if stageOrdinal == len(activity.ActivityStages)-1 {
+				activity.Status = model.ActivitySuccess
+				activity.StopTS = curTime
+				k.OnActivityCompelte(activity)
+			} else {
+				err = k.RunStage(activity, stageOrdinal+1)
+			}
+		} else if !stage.Parallel {
+			err = k.RunStep(activity, stageOrdinal, stepOrdinal+1)
+		}
+		return err
+	}
+	logrus.Debugf("Run step:%s,%d,%d", activity.Pipeline.Name, stageOrdinal, stepOrdinal)
+	s := &stepRun{
+		activityId:   activity.Id,
+		stageOrdinal: stageOrdinal,
+		stepOrdinal:  stepOrdinal,
+		step:         step,
+		name:         jobName(activity, stageOrdinal, stepOrdinal),
+		retry:        len(activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Attempts) > 0,
+		exitCode:     -1,
+	}
+	switch step.Type {
+	case model.StepTypeTask:
+		if !step.IsService {
+			s.job, s.secret, err = stepJob(activity, stageOrdinal, stepOrdinal)
+		}
+	case model.StepTypeSCM, model.StepTypeBuild:
+		s.job, s.secret, err = stepJob(activity, stageOrdinal, stepOrdinal)
+	default:
+		err = fmt.Errorf("step type '%s' is not supported by kubernetes provider", step.Type)
+	}
+	if err != nil {
+		return err
+	}
+	go k.run(s)
+	return nil
+}
+
+//stepRun holds everything needed to run a step asynchronously,
+//job is nil for service steps which run as sidecars of following steps,
+//secret holds sensitive env vars of the step and is nil if there is none
+type stepRun struct {
+	activityId   string
+	stageOrdinal int
+	stepOrdinal  int
+	step         *model.Step
+	name         string
+	job          *batchv1.Job
+	secret       *v1.Secret
+	//retry is set if the step runs again, exitCode and timedOut are reported for retries
+	retry    bool
+	exitCode int
+	timedOut bool
+}
+
+func (k *KubernetesProvider) run(s *stepRun) {
+	k.waitReady(s.activityId, s.stageOrdinal, s.stepOrdinal)
+	if s.retry {
+		k.resetStep(s.name)
+	}
+	if err := k.notify("stepstart", s.activityId, s.stageOrdinal, s.stepOrdinal, nil); err != nil {
+		logrus.Errorf("fail to post stepstart event: %v", err)
+	}
+	form := url.Values{}
+	status, commit := k.runJob(s)
+	if s.step.Type == model.StepTypeSCM && status == "SUCCESS" {
+		form.Set("GIT_COMMIT", commit)
+		form.Set("GIT_URL", s.step.Repository)
+		form.Set("GIT_BRANCH", s.step.Branch)
+	}
+	k.setResult(s.name, status)
+	form.Set("status", status)
+	form.Set("exitCode", strconv.Itoa(s.exitCode))
+	form.Set("timeout", strconv.FormatBool(s.timedOut))
+	if err := k.notify("stepfinish", s.activityId, s.stageOrdinal, s.stepOrdinal, form); err != nil {
+		logrus.Errorf("fail to post stepfinish event: %v", err)
+	}
+}
+
+//runJob runs the step job until the step container terminates, returns the result status and the
+//commit printed by scm step. Logs are kept and the job is deleted when it is done.
+func (k *KubernetesProvider) runJob(s *stepRun) (string, string) {
+	if s.job == nil {
+		k.appendLog(s.name, fmt.Sprintf("service '%s' runs as a sidecar of the following task steps\n", s.step.Alias))
+		return "SUCCESS", ""
+	}
+	if err := k.createJob(s.job, s.secret); err != nil {
+		logrus.Errorf("run step '%s' got error: %v", s.name, err)
+		k.appendLog(s.name, err.Error()+"\n")
+		return "FAILURE", ""
+	}
+	status := k.watch(s.name)
+	if status == "ABORTED" {
+		return status, ""
+	}
+	rawLog, pod, err := k.jobLog(s.name)
+	if err != nil {
+		logrus.Errorf("fail to get log of step '%s': %v", s.name, err)
+	} else {
+		s.exitCode, s.timedOut = podExit(pod)
+	}
+	k.mu.Lock()
+	k.logs[s.name] = rawLog + k.logs[s.name]
+	k.mu.Unlock()
+	k.deleteJob(s.name)
+	return status, parseCommit(rawLog)
+}
+
+//createJob creates the secret of the step before its job
+func (k *KubernetesProvider) createJob(job *batchv1.Job, secret *v1.Secret) error {
+	if secret != nil {
+		if _, err := k.client.CoreV1().Secrets(k.namespace).Create(secret); err != nil {
+			return err
+		}
+	}
+	if _, err := k.client.BatchV1().Jobs(k.namespace).Create(job); err != nil {
+		k.deleteJob(job.Name)
+		return err
+	}
+	return nil
+}
+
+//deleteJob deletes the job with its pods in background, and the secret of the step
+func (k *KubernetesProvider) deleteJob(name string) {
+	policy := metav1.DeletePropagationBackground
+	err := k.client.BatchV1().Jobs(k.namespace).Delete(name, &metav1.DeleteOptions{PropagationPolicy: &policy})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logrus.Errorf("fail to delete job '%s': %v", name, err)
+	}
+	err = k.client.CoreV1().Secrets(k.namespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		logrus.Errorf("fail to delete secret '%s': %v", name, err)
+	}
+}
+
+//listPods lists pods of the job
+func (k *KubernetesProvider) listPods(name string) ([]v1.Pod, error) {
+	pods, err := k.client.CoreV1().Pods(k.namespace).List(metav1.ListOptions{LabelSelector: jobNameLabel + "=" + name})
+	if err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+//watch polls the job pod until the step is done
+func (k *KubernetesProvider) watch(name string) string {
+	for {
+		if k.getResult(name) == "ABORTED" {
+			return "ABORTED"
+		}
+		pods, err := k.listPods(name)
+		if err != nil {
+			logrus.Errorf("fail to get pod of job '%s': %v", name, err)
+		} else if len(pods) > 0 {
+			if status, _ := podResult(&pods[0]); status != "" {
+				return status
+			}
+		} else if _, err := k.client.BatchV1().Jobs(k.namespace).Get(name, metav1.GetOptions{}); apierrors.IsNotFound(err) {
+			k.appendLog(name, fmt.Sprintf("job '%s' is gone\n", name))
+			return "FAILURE"
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+//jobLog gets the step container log of the job pod, which includes the clone log for scm step
+func (k *KubernetesProvider) jobLog(name string) (string, *v1.Pod, error) {
+	pods, err := k.listPods(name)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(pods) == 0 {
+		return "", nil, ErrNotFound
+	}
+	pod := &pods[0]
+	rawLog := ""
+	if containerStatus(pod.Status.ContainerStatuses, stepContainer) != nil {
+		if rawLog, err = k.podLog(pod.Name, stepContainer); err != nil {
+			return "", nil, err
+		}
+	}
+	return rawLog, pod, nil
+}
+
+func (k *KubernetesProvider) containerLog(pod string, container string) (string, error) {
+	data, err := k.client.CoreV1().Pods(k.namespace).GetLogs(pod, &v1.PodLogOptions{Container: container, Timestamps: true}).DoRaw()
+	if apierrors.IsNotFound(err) {
+		return "", ErrNotFound
+	}
+	return string(data), err
+}
+
+//resetStep drops the kept result and logs of the last attempt, which are recorded in attempts of the activity step
+func (k *KubernetesProvider) resetStep(name string) {
+	k.deleteJob(name)
+	//pods are deleted in background, wait for them to be gone so that they are not watched as the new attempt
+	for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); time.Sleep(pollInterval) {
+		if pods, err := k.listPods(name); err != nil || len(pods) == 0 {
+			break
+		}
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.results, name)
+	delete(k.logs, name)
+}
+
+func (k *KubernetesProvider) appendLog(name string, text string) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		k.logs[name] += now + " " + line + "\n"
+	}
+}
+
+func (k *KubernetesProvider) setResult(name string, status string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.results[name] == "ABORTED" {
+		return
+	}
+	k.results[name] = status
+}
+
+func (k *KubernetesProvider) getResult(name string) string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.results[name]
+}
+
+func (k *KubernetesProvider) StopActivity(a *model.Activity) error {
+	logrus.Debugf("stopping activity, current status: %s", a.Status)
+	a.Status = model.ActivityAbort
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	a.StopTS = now
+	for stageOrdinal, stage := range a.ActivityStages {
+		if stage.Status == model.ActivityStageSuccess || stage.Status == model.ActivityStageSkip {
+			continue
+		}
+		for stepOrdinal, step := range stage.ActivitySteps {
+			if step.Status != model.ActivityStepBuilding {
+				continue
+			}
+			name := jobName(a, stageOrdinal, stepOrdinal)
+			k.setResult(name, "ABORTED")
+			if rawLog, _, err := k.jobLog(name); err == nil {
+				k.mu.Lock()
+				k.logs[name] = rawLog + k.logs[name]
+				k.mu.Unlock()
+			}
+			k.deleteJob(name)
+			step.Status = model.ActivityStepAbort
+			step.Duration = now - step.StartTS
+		}
+		stage.Status = model.ActivityStageAbort
+		stage.Duration = now - stage.StartTS
+		break
+	}
+	return nil
+}
+
+//SyncActivity updates status of running steps from phases of their pods
+func (k *KubernetesProvider) SyncActivity(activity *model.Activity) error {
+	for i, actiStage := range activity.ActivityStages {
+		for j, actiStep := range actiStage.ActivitySteps {
+			if actiStep.Status != model.ActivityStepBuilding {
+				continue
+			}
+			step := activity.Pipeline.Stages[i].Steps[j]
+			if step.IsService {
+				continue
+			}
+			pods, err := k.listPods(jobName(activity, i, j))
+			if err != nil {
+				return err
+			}
+			if len(pods) == 0 {
+				continue
+			}
+			status, finishTS := podResult(&pods[0])
+			if status == "" {
+				continue
+			}
+			actiStep.Duration = finishTS - actiStep.StartTS
+			if status == "SUCCESS" {
+				actiStep.Status = model.ActivityStepSuccess
+			} else {
+				actiStep.Status = model.ActivityStepFail
+				actiStage.Status = model.ActivityStageFail
+				actiStage.Duration = finishTS - actiStage.StartTS
+				activity.Status = model.ActivityFail
+				activity.StopTS = finishTS
+			}
+		}
+	}
+	return nil
+}
+
+func (k *KubernetesProvider) GetStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, paras map[string]interface{}) (string, error) {
+	if stageOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) || stepOrdinal < 0 || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
+		return "", errors.New("ordinal out of range")
+	}
+	name := jobName(activity, stageOrdinal, stepOrdinal)
+	status := k.getResult(name)
+	k.mu.Lock()
+	rawLog, ok := k.logs[name]
+	k.mu.Unlock()
+	if !ok {
+		jobLog, pod, err := k.jobLog(name)
+		if err == ErrNotFound {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		rawLog = jobLog
+		if status == "" {
+			status, _ = podResult(pod)
+		}
+	}
+	return common.FinishLog(common.FormatLog(activity.StartTS, rawLog), status), nil
+}
+
+//forget drops the kept results and logs of the activity
+func (k *KubernetesProvider) forget(activity *model.Activity) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i, stage := range activity.ActivityStages {
+		for j := range stage.ActivitySteps {
+			name := jobName(activity, i, j)
+			delete(k.results, name)
+			delete(k.logs, name)
+		}
+	}
+}
+
+//OnActivityCompelte deletes remaining jobs and the workspace of the activity, logs are kept in memory
+func (k *KubernetesProvider) OnActivityCompelte(activity *model.Activity) {
+	k.cleanActivity(activity)
+	logrus.Infof("activity '%s' complete", activity.Id)
+}
+
+//ArchiveArtifacts is not supported, pipelines with artifacts are rejected on validation for kubernetes provider
+func (k *KubernetesProvider) ArchiveArtifacts(activity *model.Activity, stageOrdinal int, stepOrdinal int) ([]*model.Artifact, error) {
+	return nil, errors.New("artifacts are not supported by kubernetes provider")
+}
+
+func (k *KubernetesProvider) cleanActivity(activity *model.Activity) {
+	if err := k.deleteAll(activityLabel + "=" + activity.Id); err != nil {
+		logrus.Errorf("error cleaning activity '%s': %v", activity.Id, err)
+	}
+}
+
+//deleteAll deletes jobs, secrets and workspace claims selected by the label selector
+func (k *KubernetesProvider) deleteAll(selector string) error {
+	opts := metav1.ListOptions{LabelSelector: selector}
+	jobs, err := k.client.BatchV1().Jobs(k.namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs.Items {
+		k.deleteJob(job.Name)
+	}
+	secrets, err := k.client.CoreV1().Secrets(k.namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for _, secret := range secrets.Items {
+		if err := k.client.CoreV1().Secrets(k.namespace).Delete(secret.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	claims, err := k.client.CoreV1().PersistentVolumeClaims(k.namespace).List(opts)
+	if err != nil {
+		return err
+	}
+	for _, claim := range claims.Items {
+		if err := k.client.CoreV1().PersistentVolumeClaims(k.namespace).Delete(claim.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+//OnDeleteActivity deletes jobs, the workspace and kept logs of the activity
+func (k *KubernetesProvider) OnDeleteActivity(activity *model.Activity) error {
+	k.cleanActivity(activity)
+	k.forget(activity)
+	return nil
+}
+
+func (k *KubernetesProvider) OnCreateAccount(account *model.GitAccount) error {
+	//account token is used at clone time, nothing to sync
+	return nil
+}
+
+func (k *KubernetesProvider) OnDeleteAccount(account *model.GitAccount) error {
+	if account == nil {
+		return errors.New("nil account")
+	}
+	return nil
+}
+
+func (k *KubernetesProvider) OnCreateCredential(cred *model.Credential) error {
+	//credential is used at clone time, nothing to sync
+	return nil
+}
+
+func (k *KubernetesProvider) OnDeleteCredential(cred *model.Credential) error {
+	if cred == nil {
+		return errors.New("nil credential")
+	}
+	return nil
+}
+
+func (k *KubernetesProvider) Reset() error {
+	if err := k.deleteAll(activityLabel); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	k.results = map[string]string{}
+	k.logs = map[string]string{}
+	k.mu.Unlock()
+	return nil
+}
+
+//ToActivity init an activity from pipeline def
+func ToActivity(p *model.Pipeline) *model.Activity {
+	activity := &model.Activity{
+		Id:          uuid.Rand().Hex(),
+		Pipeline:    *p,
+		RunSequence: p.RunCount + 1,
+		Status:      model.ActivityWaiting,
+		StartTS:     time.Now().UnixNano() / int64(time.Millisecond),
+		NodeName:    "kubernetes",
+	}
+	//matrix steps are expanded in stages of the activity, the pipeline is not changed
+	activity.Pipeline.Stages = []*model.Stage{}
+	for _, stage := range p.Stages {
+		stage = service.ExpandMatrix(stage)
+		activity.Pipeline.Stages = append(activity.Pipeline.Stages, stage)
+		activity.ActivityStages = append(activity.ActivityStages, service.ToActivityStage(stage))
+	}
+	return activity
+}
+
+//workspaceClaim gets the volume claim of the workspace, which is new for each run of the activity
+func workspaceClaim(activity *model.Activity, size string, class string) (*v1.PersistentVolumeClaim, error) {
+	quantity, err := resource.ParseQuantity(size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid volume size '%s'", size)
+	}
+	claim := &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   claimName(activity),
+			Labels: map[string]string{activityLabel: activity.Id},
+		},
+		Spec: v1.PersistentVolumeClaimSpec{
+			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources: v1.ResourceRequirements{
+				Requests: v1.ResourceList{v1.ResourceStorage: quantity},
+			},
+		},
+	}
+	if class != "" {
+		claim.Spec.StorageClassName = &class
+	}
+	return claim, nil
+}
+
+//stepJob renders the step as a job on the workspace claim, and the secret of its sensitive env vars.
+//The scm step clones the repository into the workspace and services before the step run as sidecars.
+func stepJob(activity *model.Activity, stageOrdinal int, stepOrdinal int) (*batchv1.Job, *v1.Secret, error) {
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	name := jobName(activity, stageOrdinal, stepOrdinal)
+	labels := map[string]string{
+		activityLabel: activity.Id,
+		stageLabel:    strconv.Itoa(stageOrdinal),
+		stepLabel:     strconv.Itoa(stepOrdinal),
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Data:       map[string][]byte{},
+	}
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: v1.PodSpec{
+					RestartPolicy: v1.RestartPolicyNever,
+					Volumes: []v1.Volume{{
+						Name: workspaceName,
+						VolumeSource: v1.VolumeSource{
+							PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName(activity)},
+						},
+					}},
+					//pods of the activity run on one node, so that the workspace claim is mounted by all of them
+					Affinity: &v1.Affinity{
+						PodAffinity: &v1.PodAffinity{
+							RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+								LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{activityLabel: activity.Id}},
+								TopologyKey:   hostnameLabel,
+							}},
+						},
+					},
+				},
+			},
+		},
+	}
+	podSpec := &job.Spec.Template.Spec
+	if step.Timeout > 0 {
+		deadline := int64(step.Timeout * 60)
+		podSpec.ActiveDeadlineSeconds = &deadline
+	}
+	env := toEnvVars(common.ActivityEnv(activity))
+	var main v1.Container
+	switch step.Type {
+	case model.StepTypeSCM:
+		cloneEnv, err := common.CloneEnv(activity, step)
+		if err != nil {
+			return nil, nil, err
+		}
+		//the workspace is emptied first as the step may run again
+		script := "find . -mindepth 1 -delete\n" + common.CloneScript(activity, "") + "echo \"" + commitMarker + "$(git rev-parse HEAD)\"\n"
+		main = v1.Container{
+			Image:   gitImage,
+			Command: []string{"/bin/sh", "-c"},
+			Args:    []string{script},
+			Env:     append(append([]v1.EnvVar{}, env...), secretEnvVars(secret, cloneEnv)...),
+		}
+	case model.StepTypeBuild:
+		main = buildContainer(step, env, secret)
+		podSpec.Volumes = append(podSpec.Volumes, v1.Volume{
+			Name:         dockerSockName,
+			VolumeSource: v1.VolumeSource{HostPath: &v1.HostPathVolumeSource{Path: dockerSockPath}},
+		})
+	default:
+		var err error
+		if main, err = taskContainer(activity, step, env, secret); err != nil {
+			return nil, nil, err
+		}
+		for _, svc := range services(activity, stageOrdinal, stepOrdinal) {
+			sidecar, err := taskContainer(activity, svc, env, secret)
+			if err != nil {
+				return nil, nil, err
+			}
+			sidecar.Name = "svc-" + dnsLabel(svc.Alias)
+			podSpec.Containers = append(podSpec.Containers, sidecar)
+			//containers of a pod share the network, services are reachable by alias on localhost
+			podSpec.HostAliases = append(podSpec.HostAliases, v1.HostAlias{IP: "127.0.0.1", Hostnames: []string{svc.Alias}})
+		}
+	}
+	main.Name = stepContainer
+	main.WorkingDir = workspacePath
+	main.VolumeMounts = append(main.VolumeMounts, v1.VolumeMount{Name: workspaceName, MountPath: workspacePath})
+	podSpec.Containers = append([]v1.Container{main}, podSpec.Containers...)
+	if len(secret.Data) == 0 {
+		secret = nil
+	}
+	return job, secret, nil
+}
+
+//taskContainer gets the container of the task step, secrets requested by the step are kept in the secret
+func taskContainer(activity *model.Activity, step *model.Step, env []v1.EnvVar, secret *v1.Secret) (v1.Container, error) {
+	c := v1.Container{
+		Image:        step.Image,
+		WorkingDir:   workspacePath,
+		VolumeMounts: []v1.VolumeMount{{Name: workspaceName, MountPath: workspacePath}},
+	}
+	stepEnv := []string{}
+	for _, e := range step.Env {
+		stepEnv = append(stepEnv, service.SubstituteVar(activity, e))
+	}
+	secretEnv, err := service.GetStepSecretEnv(activity, step)
+	if err != nil {
+		return c, err
+	}
+	c.Env = append(append(append([]v1.EnvVar{}, env...), toEnvVars(stepEnv)...), secretEnvVars(secret, secretEnv)...)
+	if step.ShellScript != "" {
+		c.Command = []string{"/bin/sh", "-c"}
+		c.Args = []string{"set -xe\n" + step.ShellScript}
+	} else {
+		if step.Entrypoint != "" {
+			c.Command = strings.Fields(step.Entrypoint)
+		}
+		c.Args = strings.Fields(service.SubstituteVar(activity, step.Args))
+	}
+	return c, nil
+}
+
+//buildContainer builds image from the workspace with the docker daemon of the node
+func buildContainer(step *model.Step, env []v1.EnvVar, secret *v1.Secret) v1.Container {
+	buildPath := "."
+	if step.BuildPath != "" {
+		buildPath = step.BuildPath
+	}
+	dockerfilePath := "Dockerfile"
+	if step.DockerfilePath != "" {
+		dockerfilePath = step.DockerfilePath
+	}
+	buildEnv := []string{"R_CICD_BUILD_PATH=" + buildPath, "R_CICD_TARGET_IMAGE=" + step.TargetImage}
+	passwordEnv := []string{}
+	script := "set -e\ncd \"$R_CICD_BUILD_PATH\"\n"
+	if step.Dockerfile != "" {
+		dockerfilePath = ".r_cicd_Dockerfile"
+		buildEnv = append(buildEnv, "R_CICD_DOCKERFILE="+step.Dockerfile)
+		script += "printf '%s' \"$R_CICD_DOCKERFILE\" > " + dockerfilePath + "\n"
+	}
+	buildEnv = append(buildEnv, "R_CICD_DOCKERFILE_PATH="+dockerfilePath)
+	script += "docker build -t \"$R_CICD_TARGET_IMAGE\" -f \"$R_CICD_DOCKERFILE_PATH\" .\n"
+	if step.PushFlag {
+		serverAddress, username, password, err := common.RegistryCredential(step.TargetImage)
+		if err != nil {
+			logrus.Errorf("fail to get registry credential: %v", err)
+		}
+		if username != "" {
+			buildEnv = append(buildEnv, "R_CICD_REGISTRY="+serverAddress, "R_CICD_REGISTRY_USER="+username)
+			passwordEnv = append(passwordEnv, "R_CICD_REGISTRY_PASSWORD="+password)
+			script += "echo \"$R_CICD_REGISTRY_PASSWORD\" | docker login -u \"$R_CICD_REGISTRY_USER\" --password-stdin \"$R_CICD_REGISTRY\"\n"
+		}
+		script += "docker push \"$R_CICD_TARGET_IMAGE\"\n"
+	}
+	return v1.Container{
+		Image:        dockerImage,
+		Command:      []string{"/bin/sh", "-c"},
+		Args:         []string{script},
+		Env:          append(append(append([]v1.EnvVar{}, env...), toEnvVars(buildEnv)...), secretEnvVars(secret, passwordEnv)...),
+		VolumeMounts: []v1.VolumeMount{{Name: dockerSockName, MountPath: dockerSockPath}},
+	}
+}
+
+//services gets service steps run before the step
+func services(activity *model.Activity, stageOrdinal int, stepOrdinal int) []*model.Step {
+	steps := []*model.Step{}
+	for _, svc := range service.GetServices(activity, stageOrdinal, stepOrdinal) {
+		for i := 0; i <= stageOrdinal; i++ {
+			for _, step := range activity.Pipeline.Stages[i].Steps {
+				if step.IsService && step.Alias == svc.Name {
+					steps = append(steps, step)
+				}
+			}
+		}
+	}
+	return steps
+}
+
+//podExit gets the exit code of the step container, -1 if it is not terminated,
+//and whether the pod exceeds the active deadline of the step timeout
+func podExit(pod *v1.Pod) (int, bool) {
+	exitCode := -1
+	if s := containerStatus(pod.Status.ContainerStatuses, stepContainer); s != nil && s.State.Terminated != nil {
+		exitCode = int(s.State.Terminated.ExitCode)
+	}
+	return exitCode, pod.Status.Reason == "DeadlineExceeded"
+}
+
+//podResult gets status of the step from the pod and when it finishes in milliseconds,
+//empty status if the step is still running
+func podResult(pod *v1.Pod) (string, int64) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if s := containerStatus(pod.Status.ContainerStatuses, stepContainer); s != nil {
+		if t := s.State.Terminated; t != nil {
+			finishTS := t.FinishedAt.UnixNano() / int64(time.Millisecond)
+			if t.ExitCode == 0 {
+				return "SUCCESS", finishTS
+			}
+			return "FAILURE", finishTS
+		}
+		if s.State.Waiting != nil && containsString(fatalWaitingReasons, s.State.Waiting.Reason) {
+			return "FAILURE", now
+		}
+	}
+	switch pod.Status.Phase {
+	case v1.PodSucceeded:
+		return "SUCCESS", now
+	case v1.PodFailed:
+		//e.g. active deadline exceeded
+		return "FAILURE", now
+	}
+	return "", 0
+}
+
+func containerStatus(statuses []v1.ContainerStatus, name string) *v1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+//parseCommit gets the commit printed by scm step from its log
+func parseCommit(rawLog string) string {
+	for _, line := range strings.Split(rawLog, "\n") {
+		if i := strings.Index(line, commitMarker); i >= 0 {
+			return strings.TrimSpace(line[i+len(commitMarker):])
+		}
+	}
+	return ""
+}
+
+func toEnvVars(env []string) []v1.EnvVar {
+	vars := []v1.EnvVar{}
+	for _, e := range env {
+		splits := strings.SplitN(e, "=", 2)
+		v := v1.EnvVar{Name: splits[0]}
+		if len(splits) == 2 {
+			v.Value = splits[1]
+		}
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+//secretEnvVars keeps values of the env vars in the secret, and gets env vars referring to them
+func secretEnvVars(secret *v1.Secret, env []string) []v1.EnvVar {
+	vars := []v1.EnvVar{}
+	for _, e := range env {
+		splits := strings.SplitN(e, "=", 2)
+		value := ""
+		if len(splits) == 2 {
+			value = splits[1]
+		}
+		secret.Data[splits[0]] = []byte(value)
+		vars = append(vars, v1.EnvVar{
+			Name: splits[0],
+			ValueFrom: &v1.EnvVarSource{
+				SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: secret.Name},
+					Key:                  splits[0],
+				},
+			},
+		})
+	}
+	return vars
+}
+
+func jobName(activity *model.Activity, stageOrdinal int, stepOrdinal int) string {
+	return fmt.Sprintf("cicd-%s-%d-%d", activity.Id, stageOrdinal, stepOrdinal)
+}
+
+//claimName gets the name of the workspace claim of the activity run
+func claimName(activity *model.Activity) string {
+	return fmt.Sprintf("cicd-%s-%d", activity.Id, activity.StartTS)
+}
+
+//dnsLabel converts the alias to a valid container name
+func dnsLabel(s string) string {
+	b := []byte(strings.ToLower(s))
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9') {
+			b[i] = '-'
+		}
+	}
+	return strings.Trim(string(b), "-")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
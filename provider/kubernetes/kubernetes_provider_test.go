@@ -0,0 +1,382 @@
+package kubernetes
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/pipeline/model"
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testNamespace = "cicd"
+
+//fakeCluster runs pods of jobs created on the fake clientset, each job gets one pod which terminates
+//with the configured exit code as soon as it is created
+type fakeCluster struct {
+	mu   sync.Mutex
+	pods map[string]*v1.Pod
+	//exitCodes of the step container by job name, zero if not set
+	exitCodes map[string]int
+	//logs of the step container by job name
+	logs map[string]string
+}
+
+//newFakeCluster gets the fake clientset with reactors of the cluster, reactors run with the clientset
+//locked so they must not call the clientset
+func newFakeCluster() (*fake.Clientset, *fakeCluster) {
+	cs := fake.NewSimpleClientset()
+	f := &fakeCluster{pods: map[string]*v1.Pod{}, exitCodes: map[string]int{}, logs: map[string]string{}}
+	cs.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		f.runJob(action.(k8stesting.CreateAction).GetObject().(*batchv1.Job))
+		return false, nil, nil
+	})
+	cs.PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.pods, action.(k8stesting.DeleteAction).GetName())
+		return false, nil, nil
+	})
+	cs.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		selector := action.(k8stesting.ListAction).GetListRestrictions().Labels
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		list := &v1.PodList{}
+		for _, pod := range f.pods {
+			if selector.Matches(labels.Set(pod.Labels)) {
+				list.Items = append(list.Items, *pod)
+			}
+		}
+		return true, list, nil
+	})
+	return cs, f
+}
+
+func (f *fakeCluster) runJob(job *batchv1.Job) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-fake", Labels: map[string]string{jobNameLabel: job.Name}}}
+	for k, v := range job.Spec.Template.Labels {
+		pod.Labels[k] = v
+	}
+	code := f.exitCodes[job.Name]
+	pod.Status.Phase = v1.PodSucceeded
+	if code != 0 {
+		pod.Status.Phase = v1.PodFailed
+	}
+	for _, c := range job.Spec.Template.Spec.Containers {
+		status := v1.ContainerStatus{Name: c.Name}
+		if c.Name == stepContainer {
+			status.State.Terminated = &v1.ContainerStateTerminated{ExitCode: int32(code), FinishedAt: metav1.Now()}
+		} else {
+			//sidecars keep running
+			pod.Status.Phase = v1.PodRunning
+		}
+		pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, status)
+	}
+	f.pods[job.Name] = pod
+}
+
+func (f *fakeCluster) podLog(pod string, container string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, p := range f.pods {
+		if p.Name == pod && container == stepContainer {
+			return f.logs[name], nil
+		}
+	}
+	return "", ErrNotFound
+}
+
+//changes gets the create and delete actions on the clientset
+func changes(cs *fake.Clientset) []string {
+	got := []string{}
+	for _, action := range cs.Actions() {
+		if action.GetVerb() == "create" || action.GetVerb() == "delete" {
+			got = append(got, action.GetVerb()+" "+action.GetResource().Resource)
+		}
+	}
+	return got
+}
+
+type stepEvent struct {
+	event string
+	form  url.Values
+}
+
+//newTestProvider gets a provider on the fake clientset, step events are sent to the returned channel
+func newTestProvider() (*KubernetesProvider, *fake.Clientset, *fakeCluster, chan stepEvent) {
+	cs, cluster := newFakeCluster()
+	k := NewKubernetesProvider(cs, testNamespace, "1Gi", "")
+	k.podLog = cluster.podLog
+	events := make(chan stepEvent, 10)
+	k.notify = func(event string, activityId string, stageOrdinal int, stepOrdinal int, form url.Values) error {
+		events <- stepEvent{event, form}
+		return nil
+	}
+	k.waitReady = func(activityId string, stageOrdinal int, stepOrdinal int) {}
+	return k, cs, cluster, events
+}
+
+//testActivity gets an activity cloning the repository in the first stage and running a task in the second
+func testActivity() *model.Activity {
+	return ToActivity(&model.Pipeline{
+		Stages: []*model.Stage{
+			{
+				Name: "scm",
+				Steps: []*model.Step{
+					{Name: "clone", Type: model.StepTypeSCM, Repository: "https://github.com/a/b.git", Branch: "master"},
+				},
+			},
+			{
+				Name: "build",
+				Steps: []*model.Step{
+					{Name: "test", Type: model.StepTypeTask, Image: "busybox", ShellScript: "echo hello"},
+				},
+			},
+		},
+	})
+}
+
+//waitFinish gets the stepfinish event of the step
+func waitFinish(t *testing.T, events chan stepEvent) url.Values {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-events:
+			if e.event == "stepfinish" {
+				return e.form
+			}
+		case <-timeout:
+			t.Fatal("timeout waiting for the step to finish")
+		}
+	}
+}
+
+func TestRunStep(t *testing.T) {
+	k, cs, cluster, events := newTestProvider()
+	activity := testActivity()
+	cluster.logs[jobName(activity, 1, 0)] = "hello\n"
+
+	if err := k.RunStep(activity, 1, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	if e := <-events; e.event != "stepstart" {
+		t.Errorf("got event %s, want stepstart", e.event)
+	}
+	form := waitFinish(t, events)
+	if form.Get("status") != "SUCCESS" || form.Get("exitCode") != "0" || form.Get("timeout") != "false" {
+		t.Errorf("got stepfinish %v, want SUCCESS with exit code 0", form)
+	}
+	want := []string{"create jobs", "delete jobs", "delete secrets"}
+	if got := changes(cs); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got actions %q, want %q", got, want)
+	}
+}
+
+func TestStepJob(t *testing.T) {
+	activity := testActivity()
+	job, secret, err := stepJob(activity, 1, 0)
+	if err != nil {
+		t.Fatalf("stepJob got error: %v", err)
+	}
+	if secret != nil {
+		t.Errorf("got secret %+v, want none for the step without secrets", secret)
+	}
+	spec := job.Spec.Template.Spec
+	if len(spec.Volumes) != 1 || spec.Volumes[0].PersistentVolumeClaim == nil || spec.Volumes[0].PersistentVolumeClaim.ClaimName != claimName(activity) {
+		t.Errorf("got volumes %+v, want the workspace claim", spec.Volumes)
+	}
+	if spec.Affinity == nil || spec.Affinity.PodAffinity == nil ||
+		spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution[0].LabelSelector.MatchLabels[activityLabel] != activity.Id {
+		t.Errorf("got affinity %+v, want pods of the activity on one node", spec.Affinity)
+	}
+	if len(spec.Containers) != 1 {
+		t.Fatalf("got containers %+v, want the step container", spec.Containers)
+	}
+	c := spec.Containers[0]
+	if c.Name != stepContainer || c.Image != "busybox" || c.WorkingDir != workspacePath {
+		t.Errorf("got step container %+v", c)
+	}
+	if len(c.Args) != 1 || !strings.Contains(c.Args[0], "echo hello") {
+		t.Errorf("got step container args %q, want the shell script", c.Args)
+	}
+	if job.Labels[activityLabel] != activity.Id || job.Labels[stageLabel] != "1" {
+		t.Errorf("got job labels %v", job.Labels)
+	}
+}
+
+func TestSCMStepJob(t *testing.T) {
+	activity := testActivity()
+	job, secret, err := stepJob(activity, 0, 0)
+	if err != nil {
+		t.Fatalf("stepJob got error: %v", err)
+	}
+	if secret == nil || secret.Name != job.Name || string(secret.Data["R_CICD_GIT_URL"]) != "https://github.com/a/b.git" {
+		t.Fatalf("got secret %+v, want the clone env", secret)
+	}
+	c := job.Spec.Template.Spec.Containers[0]
+	if !strings.Contains(c.Args[0], "git clone") || !strings.Contains(c.Args[0], commitMarker) {
+		t.Errorf("got scm container args %q, want the clone script", c.Args)
+	}
+	for _, env := range c.Env {
+		if env.Name != "R_CICD_GIT_URL" {
+			continue
+		}
+		if env.Value != "" || env.ValueFrom == nil || env.ValueFrom.SecretKeyRef == nil || env.ValueFrom.SecretKeyRef.Name != secret.Name {
+			t.Errorf("got env %+v, want it from the secret", env)
+		}
+		return
+	}
+	t.Errorf("got env %+v, want R_CICD_GIT_URL", c.Env)
+}
+
+func TestRunPipelineWorkspace(t *testing.T) {
+	k, cs, _, _ := newTestProvider()
+	activity := testActivity()
+	if err := k.createWorkspace(activity); err != nil {
+		t.Fatalf("createWorkspace got error: %v", err)
+	}
+	claim, err := cs.CoreV1().PersistentVolumeClaims(testNamespace).Get(claimName(activity), metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("got error getting the claim: %v", err)
+	}
+	if size := claim.Spec.Resources.Requests[v1.ResourceStorage]; size.String() != "1Gi" {
+		t.Errorf("got claim size %s, want 1Gi", size.String())
+	}
+	job, secret, err := stepJob(activity, 0, 0)
+	if err != nil {
+		t.Fatalf("stepJob got error: %v", err)
+	}
+	if err := k.createJob(job, secret); err != nil {
+		t.Fatalf("createJob got error: %v", err)
+	}
+	if _, err := cs.CoreV1().Secrets(testNamespace).Get(secret.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("got error getting the secret: %v", err)
+	}
+
+	//secrets and the claim are deleted with the activity
+	k.cleanActivity(activity)
+	if secrets, _ := cs.CoreV1().Secrets(testNamespace).List(metav1.ListOptions{}); len(secrets.Items) != 0 {
+		t.Errorf("got secrets %+v left", secrets.Items)
+	}
+	if claims, _ := cs.CoreV1().PersistentVolumeClaims(testNamespace).List(metav1.ListOptions{}); len(claims.Items) != 0 {
+		t.Errorf("got claims %+v left", claims.Items)
+	}
+	if jobs, _ := cs.BatchV1().Jobs(testNamespace).List(metav1.ListOptions{}); len(jobs.Items) != 0 {
+		t.Errorf("got jobs %+v left", jobs.Items)
+	}
+}
+
+func TestRunStepFailure(t *testing.T) {
+	k, _, cluster, events := newTestProvider()
+	activity := testActivity()
+	cluster.exitCodes[jobName(activity, 1, 0)] = 2
+
+	if err := k.RunStep(activity, 1, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	form := waitFinish(t, events)
+	if form.Get("status") != "FAILURE" || form.Get("exitCode") != "2" {
+		t.Errorf("got stepfinish %v, want FAILURE with exit code 2", form)
+	}
+}
+
+func TestRunSCMStep(t *testing.T) {
+	k, _, cluster, events := newTestProvider()
+	activity := testActivity()
+	cluster.logs[jobName(activity, 0, 0)] = "Cloning into '.'...\n" + commitMarker + "abc123\n"
+
+	if err := k.RunStep(activity, 0, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	form := waitFinish(t, events)
+	if form.Get("status") != "SUCCESS" || form.Get("GIT_COMMIT") != "abc123" || form.Get("GIT_BRANCH") != "master" {
+		t.Errorf("got stepfinish %v, want SUCCESS with the commit", form)
+	}
+}
+
+func TestSyncActivity(t *testing.T) {
+	tests := []struct {
+		exitCode     int
+		stepStatus   string
+		activityFail bool
+	}{
+		{0, model.ActivityStepSuccess, false},
+		{1, model.ActivityStepFail, true},
+	}
+	for _, test := range tests {
+		k, _, cluster, _ := newTestProvider()
+		activity := testActivity()
+		cluster.exitCodes[jobName(activity, 1, 0)] = test.exitCode
+		//the job is left as created, while steps run by the provider are deleted when done
+		job, secret, err := stepJob(activity, 1, 0)
+		if err != nil {
+			t.Fatalf("stepJob got error: %v", err)
+		}
+		if err := k.createJob(job, secret); err != nil {
+			t.Fatalf("createJob got error: %v", err)
+		}
+
+		activity.Status = model.ActivityBuilding
+		activity.ActivityStages[1].ActivitySteps[0].Status = model.ActivityStepBuilding
+		if err := k.SyncActivity(activity); err != nil {
+			t.Fatalf("SyncActivity got error: %v", err)
+		}
+		if got := activity.ActivityStages[1].ActivitySteps[0].Status; got != test.stepStatus {
+			t.Errorf("exit code %d: got step status %s, want %s", test.exitCode, got, test.stepStatus)
+		}
+		if got := activity.Status == model.ActivityFail; got != test.activityFail {
+			t.Errorf("exit code %d: got activity status %s", test.exitCode, activity.Status)
+		}
+	}
+}
+
+func TestSyncActivityNotStarted(t *testing.T) {
+	k, _, _, _ := newTestProvider()
+	activity := testActivity()
+	activity.ActivityStages[1].ActivitySteps[0].Status = model.ActivityStepBuilding
+	if err := k.SyncActivity(activity); err != nil {
+		t.Fatalf("SyncActivity got error: %v", err)
+	}
+	if got := activity.ActivityStages[1].ActivitySteps[0].Status; got != model.ActivityStepBuilding {
+		t.Errorf("got step status %s, want %s", got, model.ActivityStepBuilding)
+	}
+}
+
+func TestGetStepLog(t *testing.T) {
+	k, _, cluster, events := newTestProvider()
+	activity := testActivity()
+	cluster.logs[jobName(activity, 1, 0)] = "hello\nworld\n"
+
+	if log, err := k.GetStepLog(activity, 1, 0, nil); err != nil || log != "" {
+		t.Errorf("got log %q, %v before the step runs, want empty", log, err)
+	}
+	if err := k.RunStep(activity, 1, 0); err != nil {
+		t.Fatalf("RunStep got error: %v", err)
+	}
+	waitFinish(t, events)
+
+	//logs are kept after the job is deleted
+	log, err := k.GetStepLog(activity, 1, 0, nil)
+	if err != nil {
+		t.Fatalf("GetStepLog got error: %v", err)
+	}
+	if !strings.Contains(log, "hello\n") || !strings.Contains(log, "world\n") {
+		t.Errorf("got log %q, want the step container log", log)
+	}
+	if !strings.HasSuffix(log, "  Finished: SUCCESS\n") {
+		t.Errorf("got log %q, want finished with SUCCESS", log)
+	}
+	if _, err := k.GetStepLog(activity, 2, 0, nil); err == nil {
+		t.Error("got no error for the out of range ordinal")
+	}
+}
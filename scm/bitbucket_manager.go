@@ -0,0 +1,701 @@
+package scm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+)
+
+const (
+	defaultBitbucketHost = "bitbucket.org"
+	defaultBitbucketAPI  = "https://api.bitbucket.org/2.0"
+	bitbucketServerAPI   = "/rest/api/1.0"
+	bitbucketPageLen     = "100"
+)
+
+//BitbucketManager supports both Bitbucket Cloud and Bitbucket Server,
+//it works with Bitbucket Server if a host other than bitbucket.org is configured
+type BitbucketManager struct {
+	scheme   string
+	hostName string
+	isServer bool
+	cloudAPI string
+}
+
+type bitbucketLink struct {
+	Href string `json:"href"`
+	Name string `json:"name"`
+}
+
+func (b BitbucketManager) Config(setting *model.SCMSetting) model.SCManager {
+	if setting.HostName != "" && setting.HostName != defaultBitbucketHost {
+		b.scheme = setting.Scheme
+		if b.scheme == "" {
+			b.scheme = "https://"
+		}
+		b.hostName = setting.HostName
+		b.isServer = true
+	} else {
+		b.scheme = "https://"
+		b.hostName = defaultBitbucketHost
+		b.isServer = false
+		b.cloudAPI = defaultBitbucketAPI
+	}
+	return b
+}
+
+func (b BitbucketManager) GetType() string {
+	return "bitbucket"
+}
+
+//GetCloneUser gets the user to clone with oauth access tokens
+func (b BitbucketManager) GetCloneUser(login string) string {
+	if b.isServer {
+		return login
+	}
+	return "x-token-auth"
+}
+
+func (b BitbucketManager) apiEndpoint() string {
+	if b.isServer {
+		return b.scheme + b.hostName + bitbucketServerAPI
+	}
+	return b.cloudAPI
+}
+
+//repoPath gets the api path of the repository, the project key and the slug in Bitbucket Server
+//are the last two parts of the clone url, as the owner and the slug in Bitbucket Cloud
+func (b BitbucketManager) repoPath(repoURL string) (string, error) {
+	owner, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	if b.isServer {
+		return fmt.Sprintf("/projects/%s/repos/%s", owner, repo), nil
+	}
+	return fmt.Sprintf("/repositories/%s/%s", owner, repo), nil
+}
+
+func (b BitbucketManager) OAuth(redirectURL string, clientID string, clientSecret string, code string) (*model.GitAccount, error) {
+	bitbucketOauthConfig := &oauth2.Config{
+		RedirectURL:  redirectURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://bitbucket.org/site/oauth2/authorize",
+			TokenURL: "https://bitbucket.org/site/oauth2/access_token",
+		},
+	}
+	if b.isServer {
+		bitbucketOauthConfig.Scopes = []string{"REPO_ADMIN"}
+		bitbucketOauthConfig.Endpoint = oauth2.Endpoint{
+			AuthURL:  fmt.Sprintf("%s%s/rest/oauth2/latest/authorize", b.scheme, b.hostName),
+			TokenURL: fmt.Sprintf("%s%s/rest/oauth2/latest/token", b.scheme, b.hostName),
+		}
+	}
+
+	token, err := bitbucketOauthConfig.Exchange(oauth2.NoContext, code)
+	if err != nil {
+		logrus.Errorf("Code exchange failed with '%s'\n", err)
+		return nil, err
+	} else if !strings.EqualFold(token.TokenType, "bearer") || token.AccessToken == "" {
+		return nil, fmt.Errorf("Fail to get accesstoken with oauth config")
+	}
+	return b.GetAccount(token.AccessToken)
+}
+
+func (b BitbucketManager) GetAccount(accessToken string) (*model.GitAccount, error) {
+	var account *model.GitAccount
+	var err error
+	if b.isServer {
+		account, err = b.getServerUser(accessToken)
+	} else {
+		account, err = b.getCloudUser(accessToken)
+	}
+	if err != nil {
+		return nil, err
+	}
+	account.AccountType = b.GetType()
+	account.Id = b.GetType() + ":" + account.Login
+	account.AccessToken = accessToken
+	account.Private = false
+	return account, nil
+}
+
+func (b BitbucketManager) getCloudUser(accessToken string) (*model.GitAccount, error) {
+	user := struct {
+		Username    string `json:"username"`
+		DisplayName string `json:"display_name"`
+		Links       struct {
+			Avatar bitbucketLink `json:"avatar"`
+			HTML   bitbucketLink `json:"html"`
+		} `json:"links"`
+	}{}
+	if err := b.getJSON(accessToken, b.apiEndpoint()+"/user", &user); err != nil {
+		logrus.Errorf("Bitbucket getCloudUser: received error from bitbucket, err: %v", err)
+		return nil, err
+	}
+	return &model.GitAccount{
+		Login:     user.Username,
+		Name:      user.DisplayName,
+		AvatarURL: user.Links.Avatar.Href,
+		HTMLURL:   user.Links.HTML.Href,
+	}, nil
+}
+
+func (b BitbucketManager) getServerUser(accessToken string) (*model.GitAccount, error) {
+	resp, err := doBitbucket("GET", b.scheme+b.hostName+"/plugins/servlet/applinks/whoami", accessToken, nil)
+	if err != nil {
+		logrus.Errorf("Bitbucket getServerUser: received error from bitbucket, err: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	name, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	users := []struct {
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+		Slug        string `json:"slug"`
+		Links       struct {
+			Self []bitbucketLink `json:"self"`
+		} `json:"links"`
+	}{}
+	if err := b.listValues(accessToken, b.apiEndpoint()+"/users?filter="+url.QueryEscape(string(name)), &users); err != nil {
+		logrus.Errorf("Bitbucket getServerUser: received error from bitbucket, err: %v", err)
+		return nil, err
+	}
+	for _, user := range users {
+		if user.Name != string(name) {
+			continue
+		}
+		account := &model.GitAccount{
+			Login:     user.Name,
+			Name:      user.DisplayName,
+			AvatarURL: fmt.Sprintf("%s%s/users/%s/avatar.png", b.scheme, b.hostName, user.Slug),
+		}
+		if len(user.Links.Self) > 0 {
+			account.HTMLURL = user.Links.Self[0].Href
+		}
+		return account, nil
+	}
+	return nil, fmt.Errorf("bitbucket user '%s' is not found", string(name))
+}
+
+func (b BitbucketManager) GetRepos(account *model.GitAccount) ([]*model.GitRepository, error) {
+	if account == nil {
+		return nil, fmt.Errorf("empty account")
+	}
+	//list with each permission to know permissions of repos
+	permissions := []struct {
+		name  string
+		query string
+	}{
+		{"pull", "/repositories?role=member"},
+		{"push", "/repositories?role=contributor"},
+		{"admin", "/repositories?role=admin"},
+	}
+	if b.isServer {
+		permissions[0].query = "/repos?permission=REPO_READ"
+		permissions[1].query = "/repos?permission=REPO_WRITE"
+		permissions[2].query = "/repos?permission=REPO_ADMIN"
+	}
+	repoMap := map[string]*model.GitRepository{}
+	result := []*model.GitRepository{}
+	for _, permission := range permissions {
+		repos := []struct {
+			Links struct {
+				Clone []bitbucketLink `json:"clone"`
+			} `json:"links"`
+		}{}
+		if err := b.listValues(account.AccessToken, b.apiEndpoint()+permission.query, &repos); err != nil {
+			logrus.Errorf("Bitbucket GetRepos: received error from bitbucket, err: %v", err)
+			return nil, err
+		}
+		for _, repo := range repos {
+			cloneURL := ""
+			for _, link := range repo.Links.Clone {
+				if link.Name == "https" || link.Name == "http" {
+					cloneURL = removeURLUser(link.Href)
+				}
+			}
+			if cloneURL == "" {
+				continue
+			}
+			r, ok := repoMap[cloneURL]
+			if !ok {
+				r = &model.GitRepository{CloneURL: cloneURL, Permissions: map[string]bool{}}
+				repoMap[cloneURL] = r
+				result = append(result, r)
+			}
+			r.Permissions[permission.name] = true
+		}
+	}
+	return result, nil
+}
+
+//removeURLUser removes the user in clone urls like https://user@bitbucket.org/owner/repo.git
+func removeURLUser(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.User = nil
+	return u.String()
+}
+
+func (b BitbucketManager) CreateWebhook(p *model.Pipeline, token string, ciWebhookEndpoint string) error {
+	logrus.Debugf("createwebhook for pipeline:%v", p.Id)
+	if p == nil {
+		return errors.New("empty pipeline to create webhook")
+	}
+	if len(p.Stages) == 0 || len(p.Stages[0].Steps) == 0 || !p.Stages[0].Steps[0].Webhook {
+		return nil
+	}
+	repoPath, err := b.repoPath(p.Stages[0].Steps[0].Repository)
+	if err != nil {
+		return nil
+	}
+	webhookUrl := fmt.Sprintf("%s&pipelineId=%s", ciWebhookEndpoint, p.Id)
+	var hook interface{}
+	var hookURL string
+	if b.isServer {
+		hookURL = b.apiEndpoint() + repoPath + "/webhooks"
+		hook = map[string]interface{}{
+			"name":          "Rancher Pipeline " + p.Name,
+			"url":           webhookUrl,
+			"active":        true,
+			"events":        []string{"repo:refs_changed", "pr:opened", "pr:from_ref_updated"},
+			"configuration": map[string]string{"secret": p.WebHookToken},
+		}
+	} else {
+		hookURL = b.apiEndpoint() + repoPath + "/hooks"
+		hook = map[string]interface{}{
+			"description": "Rancher Pipeline " + p.Name,
+			"url":         webhookUrl,
+			"active":      true,
+			"secret":      p.WebHookToken,
+			"events":      []string{"repo:push", "pullrequest:created", "pullrequest:updated"},
+		}
+	}
+	created := struct {
+		Id int `json:"id"`
+	}{}
+	if err := b.sendJSON("POST", token, hookURL, hook, &created); err != nil {
+		logrus.Errorf("error create webhook,%v", err)
+		return err
+	}
+	//webhooks in Bitbucket Cloud have uuids, they are found by the url to delete
+	p.WebHookId = created.Id
+	return nil
+}
+
+func (b BitbucketManager) DeleteWebhook(p *model.Pipeline, token string) error {
+	logrus.Infof("deletewebhook for pipeline:%v", p.Id)
+	if p == nil {
+		return errors.New("empty pipeline to delete webhook")
+	}
+	if len(p.Stages) == 0 || len(p.Stages[0].Steps) == 0 {
+		return nil
+	}
+	repoPath, err := b.repoPath(p.Stages[0].Steps[0].Repository)
+	if err != nil {
+		return nil
+	}
+	if b.isServer {
+		if p.WebHookId > 0 {
+			resp, err := doBitbucket("DELETE", fmt.Sprintf("%s%s/webhooks/%d", b.apiEndpoint(), repoPath, p.WebHookId), token, nil)
+			if err != nil {
+				logrus.Errorf("error delete webhook,%v", err)
+				return err
+			}
+			resp.Body.Close()
+			p.WebHookId = 0
+		}
+		return nil
+	}
+	hooks := []struct {
+		Uuid string `json:"uuid"`
+		Url  string `json:"url"`
+	}{}
+	if err := b.listValues(token, b.apiEndpoint()+repoPath+"/hooks", &hooks); err != nil {
+		return err
+	}
+	for _, hook := range hooks {
+		if !strings.HasSuffix(hook.Url, "pipelineId="+p.Id) {
+			continue
+		}
+		resp, err := doBitbucket("DELETE", b.apiEndpoint()+repoPath+"/hooks/"+url.PathEscape(hook.Uuid), token, nil)
+		if err != nil {
+			logrus.Errorf("error delete webhook,%v", err)
+			return err
+		}
+		resp.Body.Close()
+	}
+	p.WebHookId = 0
+	return nil
+}
+
+func (b BitbucketManager) VerifyWebhookPayload(p *model.Pipeline, req *http.Request) (*model.TriggerInfo, bool) {
+	var signature string
+	var eventType string
+	if signature = req.Header.Get("X-Hub-Signature"); len(signature) == 0 {
+		logrus.Errorf("receive bitbucket webhook,no signature")
+		return nil, false
+	}
+	if eventType = req.Header.Get("X-Event-Key"); len(eventType) == 0 {
+		logrus.Errorf("receive bitbucket webhook,no event")
+		return nil, false
+	}
+	if p == nil {
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logrus.Errorf("receive bitbucket webhook, got error:%v", err)
+		return nil, false
+	}
+	if match := VerifyBitbucketWebhookSignature([]byte(p.WebHookToken), signature, body); !match {
+		logrus.Errorf("receive bitbucket webhook, invalid signature")
+		return nil, false
+	}
+	switch eventType {
+	case "diagnostics:ping":
+		return nil, true
+	case "repo:push", "repo:refs_changed":
+		if !triggerOnEvent(p, model.WebhookEventPush) {
+			logrus.Debugf("receive bitbucket webhook, push event is not enabled")
+			return nil, false
+		}
+		commit := bitbucketPushedCommit(body, p.Stages[0].Steps[0].Branch)
+		if commit == "" {
+			logrus.Warningf("receive bitbucket webhook, branch not match:%v", p.Stages[0].Steps[0].Branch)
+			return nil, false
+		}
+		return &model.TriggerInfo{TriggerType: model.TriggerTypeWebhook, Commit: commit}, true
+	case "pullrequest:created", "pullrequest:updated", "pr:opened", "pr:from_ref_updated":
+		if !triggerOnEvent(p, model.WebhookEventPullRequest) {
+			logrus.Debugf("receive bitbucket webhook, pull request event is not enabled")
+			return nil, false
+		}
+		return bitbucketPullRequestTrigger(p, body)
+	}
+	logrus.Errorf("receive bitbucket webhook '%s' event, expected push or pull request event", eventType)
+	return nil, false
+}
+
+//bitbucketPushedCommit gets the commit pushed to the branch from Bitbucket Cloud and Server push payloads
+func bitbucketPushedCommit(body []byte, branch string) string {
+	payload := struct {
+		//Bitbucket Cloud
+		Push struct {
+			Changes []struct {
+				New *struct {
+					Type   string `json:"type"`
+					Name   string `json:"name"`
+					Target struct {
+						Hash string `json:"hash"`
+					} `json:"target"`
+				} `json:"new"`
+			} `json:"changes"`
+		} `json:"push"`
+		//Bitbucket Server
+		Changes []struct {
+			Ref struct {
+				Id string `json:"id"`
+			} `json:"ref"`
+			ToHash string `json:"toHash"`
+			Type   string `json:"type"`
+		} `json:"changes"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		logrus.Errorf("fail to parse bitbucket webhook payload,err:%v", err)
+		return ""
+	}
+	for _, change := range payload.Push.Changes {
+		if change.New != nil && change.New.Type == "branch" && change.New.Name == branch {
+			return change.New.Target.Hash
+		}
+	}
+	for _, change := range payload.Changes {
+		if change.Ref.Id == "refs/heads/"+branch && change.Type != "DELETE" {
+			return change.ToHash
+		}
+	}
+	return ""
+}
+
+func bitbucketPullRequestTrigger(p *model.Pipeline, body []byte) (*model.TriggerInfo, bool) {
+	payload := struct {
+		//Bitbucket Cloud
+		PullRequest *struct {
+			Id     int `json:"id"`
+			Source struct {
+				Branch struct {
+					Name string `json:"name"`
+				} `json:"branch"`
+				Commit struct {
+					Hash string `json:"hash"`
+				} `json:"commit"`
+				Repository struct {
+					FullName string `json:"full_name"`
+				} `json:"repository"`
+			} `json:"source"`
+			Destination struct {
+				Branch struct {
+					Name string `json:"name"`
+				} `json:"branch"`
+				Repository struct {
+					FullName string `json:"full_name"`
+				} `json:"repository"`
+			} `json:"destination"`
+		} `json:"pullrequest"`
+		//Bitbucket Server
+		ServerPullRequest *struct {
+			Id      int `json:"id"`
+			FromRef struct {
+				DisplayId    string `json:"displayId"`
+				LatestCommit string `json:"latestCommit"`
+				Repository   struct {
+					Id int `json:"id"`
+				} `json:"repository"`
+			} `json:"fromRef"`
+			ToRef struct {
+				DisplayId  string `json:"displayId"`
+				Repository struct {
+					Id int `json:"id"`
+				} `json:"repository"`
+			} `json:"toRef"`
+		} `json:"pullRequest"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		logrus.Errorf("fail to parse bitbucket webhook payload,err:%v", err)
+		return nil, false
+	}
+	var pr *model.PullRequest
+	var headRef, mergeRef, headCommit string
+	if cloud := payload.PullRequest; cloud != nil {
+		pr = &model.PullRequest{
+			Number:       cloud.Id,
+			SourceBranch: cloud.Source.Branch.Name,
+			TargetBranch: cloud.Destination.Branch.Name,
+			Fork:         cloud.Source.Repository.FullName != cloud.Destination.Repository.FullName,
+		}
+		//Bitbucket Cloud has no refs of pull requests, the source branch in the repository is built
+		headRef = "refs/heads/" + pr.SourceBranch
+		mergeRef = headRef
+		headCommit = cloud.Source.Commit.Hash
+	} else if server := payload.ServerPullRequest; server != nil {
+		pr = &model.PullRequest{
+			Number:       server.Id,
+			SourceBranch: server.FromRef.DisplayId,
+			TargetBranch: server.ToRef.DisplayId,
+			Fork:         server.FromRef.Repository.Id != server.ToRef.Repository.Id,
+		}
+		headRef = fmt.Sprintf("refs/pull-requests/%d/from", server.Id)
+		mergeRef = fmt.Sprintf("refs/pull-requests/%d/merge", server.Id)
+		headCommit = server.FromRef.LatestCommit
+	} else {
+		logrus.Error("fail to parse bitbucket pull request payload")
+		return nil, false
+	}
+	//pull requests to the branch of the pipeline
+	if pr.TargetBranch != p.Stages[0].Steps[0].Branch {
+		logrus.Warningf("receive bitbucket webhook, target branch not match:%v,%v", pr.TargetBranch, p.Stages[0].Steps[0].Branch)
+		return nil, false
+	}
+	return pullRequestTrigger(p, pr, headRef, mergeRef, headCommit), true
+}
+
+//VerifyBitbucketWebhookSignature checks the 'sha256=<hex hmac>' signature of the body
+func VerifyBitbucketWebhookSignature(secret []byte, signature string, body []byte) bool {
+	const signaturePrefix = "sha256="
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	actual, err := hex.DecodeString(signature[len(signaturePrefix):])
+	if err != nil {
+		return false
+	}
+	computed := hmac.New(sha256.New, secret)
+	computed.Write(body)
+	return hmac.Equal(computed.Sum(nil), actual)
+}
+
+//SetCommitStatus sets the build status of the commit
+func (b BitbucketManager) SetCommitStatus(repoURL string, commit string, status *model.CommitStatus, gitToken string) error {
+	repoPath, err := b.repoPath(repoURL)
+	if err != nil {
+		return err
+	}
+	state := "INPROGRESS"
+	switch status.State {
+	case model.CommitStateSuccess:
+		state = "SUCCESSFUL"
+	case model.CommitStateFailure:
+		state = "FAILED"
+	case model.CommitStateCanceled:
+		state = "STOPPED"
+		if b.isServer {
+			state = "FAILED"
+		}
+	}
+	//url is required, link to the repository if not set
+	targetURL := status.TargetURL
+	if targetURL == "" {
+		if b.isServer {
+			targetURL = b.scheme + b.hostName + repoPath
+		} else {
+			targetURL = "https://" + defaultBitbucketHost + strings.TrimPrefix(repoPath, "/repositories")
+		}
+	}
+	buildStatus := map[string]string{
+		//key is limited to 40 characters
+		"key":         fmt.Sprintf("%x", sha1.Sum([]byte(status.Context))),
+		"name":        status.Context,
+		"state":       state,
+		"url":         targetURL,
+		"description": status.Description,
+	}
+	statusURL := b.apiEndpoint() + repoPath + "/commit/" + commit + "/statuses/build"
+	if b.isServer {
+		statusURL = fmt.Sprintf("%s%s/rest/build-status/1.0/commits/%s", b.scheme, b.hostName, commit)
+	}
+	return b.sendJSON("POST", gitToken, statusURL, buildStatus, nil)
+}
+
+//GetFileContent gets content of the file at the ref in the repository
+func (b BitbucketManager) GetFileContent(repoURL string, ref string, path string, gitToken string) ([]byte, error) {
+	repoPath, err := b.repoPath(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	fileURL := fmt.Sprintf("%s%s/src/%s/%s", b.apiEndpoint(), repoPath, ref, path)
+	if b.isServer {
+		fileURL = fmt.Sprintf("%s%s/raw/%s?at=%s", b.apiEndpoint(), repoPath, path, url.QueryEscape(ref))
+	}
+	resp, err := doBitbucket("GET", fileURL, gitToken, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, model.ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+//listValues gets values of all pages, with the pagination of Bitbucket Cloud or Bitbucket Server
+func (b BitbucketManager) listValues(accessToken string, rawURL string, values interface{}) error {
+	all := []json.RawMessage{}
+	start := 0
+	nextURL := rawURL
+	for nextURL != "" {
+		reqURL := nextURL
+		if nextURL == rawURL || b.isServer {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				return err
+			}
+			q := u.Query()
+			if b.isServer {
+				q.Set("limit", bitbucketPageLen)
+				q.Set("start", strconv.Itoa(start))
+			} else {
+				q.Set("pagelen", bitbucketPageLen)
+			}
+			u.RawQuery = q.Encode()
+			reqURL = u.String()
+		}
+		page := struct {
+			Values        []json.RawMessage `json:"values"`
+			Next          string            `json:"next"`
+			IsLastPage    bool              `json:"isLastPage"`
+			NextPageStart int               `json:"nextPageStart"`
+		}{}
+		if err := b.getJSON(accessToken, reqURL, &page); err != nil {
+			return err
+		}
+		all = append(all, page.Values...)
+		nextURL = page.Next
+		if b.isServer {
+			nextURL = ""
+			if !page.IsLastPage {
+				start = page.NextPageStart
+				nextURL = rawURL
+			}
+		}
+	}
+	data, err := json.Marshal(all)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, values)
+}
+
+func (b BitbucketManager) getJSON(accessToken string, url string, v interface{}) error {
+	resp, err := doBitbucket("GET", url, accessToken, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (b BitbucketManager) sendJSON(method string, accessToken string, url string, body interface{}, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := doBitbucket(method, url, accessToken, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func doBitbucket(method string, url string, accessToken string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Errorf("Received error from bitbucket: %v", err)
+		return resp, err
+	}
+	if resp.StatusCode >= 300 {
+		var respBody bytes.Buffer
+		io.Copy(&respBody, resp.Body)
+		resp.Body.Close()
+		return resp, fmt.Errorf("Request failed, got status code: %d. Response: %s",
+			resp.StatusCode, respBody.Bytes())
+	}
+	return resp, nil
+}
@@ -0,0 +1,321 @@
+package scm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func hmacSHA256(secret string, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return fmt.Sprintf("%x", mac.Sum(nil))
+}
+
+func TestVerifyBitbucketWebhookSignature(t *testing.T) {
+	body := `{"push":{}}`
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{"valid", "sha256=" + hmacSHA256("token1", body), true},
+		{"wrong secret", "sha256=" + hmacSHA256("token2", body), false},
+		{"no prefix", hmacSHA256("token1", body), false},
+		{"sha1 prefix", "sha1=" + hmacSHA256("token1", body), false},
+		{"not hex", "sha256=xyz", false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		if got := VerifyBitbucketWebhookSignature([]byte("token1"), test.signature, []byte(body)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestBitbucketVerifyWebhookPayload(t *testing.T) {
+	cloudPush := `{"push":{"changes":[{"new":{"type":"branch","name":"dev","target":{"hash":"c0"}}},{"new":{"type":"branch","name":"master","target":{"hash":"c1"}}}]}}`
+	serverPush := `{"changes":[{"ref":{"id":"refs/heads/master"},"toHash":"c2","type":"UPDATE"}]}`
+	serverDelete := `{"changes":[{"ref":{"id":"refs/heads/master"},"toHash":"0000","type":"DELETE"}]}`
+	cloudPR := `{"pullrequest":{"id":3,"source":{"branch":{"name":"feature"},"commit":{"hash":"c3"},"repository":{"full_name":"user/repo"}},"destination":{"branch":{"name":"master"},"repository":{"full_name":"user/repo"}}}}`
+	cloudForkPR := `{"pullrequest":{"id":4,"source":{"branch":{"name":"feature"},"commit":{"hash":"c4"},"repository":{"full_name":"other/repo"}},"destination":{"branch":{"name":"master"},"repository":{"full_name":"user/repo"}}}}`
+	serverPR := `{"pullRequest":{"id":5,"fromRef":{"displayId":"feature","latestCommit":"c5","repository":{"id":1}},"toRef":{"displayId":"master","repository":{"id":1}}}}`
+	serverForkPR := `{"pullRequest":{"id":6,"fromRef":{"displayId":"feature","latestCommit":"c6","repository":{"id":2}},"toRef":{"displayId":"master","repository":{"id":1}}}}`
+	otherBranchPR := `{"pullrequest":{"id":7,"source":{"branch":{"name":"feature"},"commit":{"hash":"c7"},"repository":{"full_name":"user/repo"}},"destination":{"branch":{"name":"dev"},"repository":{"full_name":"user/repo"}}}}`
+	tests := []struct {
+		name       string
+		event      string
+		body       string
+		signature  string
+		events     []string
+		wantOK     bool
+		wantCommit string
+		wantPR     *model.PullRequest
+	}{
+		{"ping", "diagnostics:ping", `{}`, "", nil, true, "", nil},
+		{"cloud push", "repo:push", cloudPush, "", nil, true, "c1", nil},
+		{"server push", "repo:refs_changed", serverPush, "", nil, true, "c2", nil},
+		{"server branch deleted", "repo:refs_changed", serverDelete, "", nil, false, "", nil},
+		{"push of other branch", "repo:push", strings.Replace(cloudPush, `"master"`, `"release"`, 1), "", nil, false, "", nil},
+		{"push not enabled", "repo:push", cloudPush, "", []string{model.WebhookEventPullRequest}, false, "", nil},
+		{"cloud pull request", "pullrequest:created", cloudPR, "", nil, true, "c3",
+			&model.PullRequest{Number: 3, SourceBranch: "feature", TargetBranch: "master", Ref: "refs/heads/feature"}},
+		{"cloud fork pull request", "pullrequest:updated", cloudForkPR, "", nil, true, "c4",
+			&model.PullRequest{Number: 4, SourceBranch: "feature", TargetBranch: "master", Fork: true, Ref: "refs/heads/feature"}},
+		{"server pull request", "pr:opened", serverPR, "", nil, true, "c5",
+			&model.PullRequest{Number: 5, SourceBranch: "feature", TargetBranch: "master", Ref: "refs/pull-requests/5/from"}},
+		{"server fork pull request", "pr:from_ref_updated", serverForkPR, "", nil, true, "c6",
+			&model.PullRequest{Number: 6, SourceBranch: "feature", TargetBranch: "master", Fork: true, Ref: "refs/pull-requests/6/from"}},
+		{"pull request to other branch", "pullrequest:created", otherBranchPR, "", nil, false, "", nil},
+		{"pull request not enabled", "pr:opened", serverPR, "", []string{model.WebhookEventPush}, false, "", nil},
+		{"unknown event", "repo:fork", `{}`, "", nil, false, "", nil},
+		{"invalid signature", "repo:push", cloudPush, "sha256=" + hmacSHA256("token2", cloudPush), nil, false, "", nil},
+		{"no event", "", cloudPush, "", nil, false, "", nil},
+	}
+	for _, test := range tests {
+		events := test.events
+		if events == nil {
+			events = []string{model.WebhookEventPush, model.WebhookEventPullRequest}
+		}
+		p := &model.Pipeline{
+			Id:           "p1",
+			WebHookToken: "token1",
+			Stages: []*model.Stage{{Steps: []*model.Step{{
+				Type:          model.StepTypeSCM,
+				Repository:    "https://bitbucket.org/user/repo.git",
+				Branch:        "master",
+				WebhookEvents: events,
+			}}}},
+		}
+		signature := test.signature
+		if signature == "" {
+			signature = "sha256=" + hmacSHA256("token1", test.body)
+		}
+		req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(test.body))
+		req.Header.Set("X-Hub-Signature", signature)
+		if test.event != "" {
+			req.Header.Set("X-Event-Key", test.event)
+		}
+		trigger, ok := BitbucketManager{}.VerifyWebhookPayload(p, req)
+		if ok != test.wantOK {
+			t.Errorf("%s: got ok %v, want %v", test.name, ok, test.wantOK)
+			continue
+		}
+		if !ok || trigger == nil {
+			continue
+		}
+		if trigger.Commit != test.wantCommit {
+			t.Errorf("%s: got commit %s, want %s", test.name, trigger.Commit, test.wantCommit)
+		}
+		if (trigger.PullRequest == nil) != (test.wantPR == nil) || (test.wantPR != nil && *trigger.PullRequest != *test.wantPR) {
+			t.Errorf("%s: got pull request %+v, want %+v", test.name, trigger.PullRequest, test.wantPR)
+		}
+	}
+}
+
+func TestBitbucketSetCommitStatus(t *testing.T) {
+	tests := []struct {
+		state    string
+		isServer bool
+		want     string
+	}{
+		{model.CommitStatePending, false, "INPROGRESS"},
+		{model.CommitStateRunning, false, "INPROGRESS"},
+		{model.CommitStateSuccess, false, "SUCCESSFUL"},
+		{model.CommitStateFailure, false, "FAILED"},
+		{model.CommitStateCanceled, false, "STOPPED"},
+		{model.CommitStateRunning, true, "INPROGRESS"},
+		{model.CommitStateSuccess, true, "SUCCESSFUL"},
+		{model.CommitStateFailure, true, "FAILED"},
+		{model.CommitStateCanceled, true, "FAILED"},
+	}
+	for _, test := range tests {
+		var path, auth string
+		body := map[string]string{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.Method + " " + r.URL.Path
+			auth = r.Header.Get("Authorization")
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("fail to decode request: %v", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		}))
+		b := BitbucketManager{cloudAPI: srv.URL}
+		wantPath := "POST /repositories/user/repo/commit/abc123/statuses/build"
+		if test.isServer {
+			b = BitbucketManager{scheme: "http://", hostName: strings.TrimPrefix(srv.URL, "http://"), isServer: true}
+			wantPath = "POST /rest/build-status/1.0/commits/abc123"
+		}
+		status := &model.CommitStatus{
+			State:       test.state,
+			Context:     "continuous-integration/rancher-pipeline/p",
+			Description: "Pipeline is running",
+			TargetURL:   "https://pipeline.example.com/v1/activities/a1",
+		}
+		err := b.SetCommitStatus("https://bitbucket.org/user/repo.git", "abc123", status, "token1")
+		srv.Close()
+		if err != nil {
+			t.Errorf("state %s, server %v: got error: %v", test.state, test.isServer, err)
+			continue
+		}
+		if path != wantPath || auth != "Bearer token1" {
+			t.Errorf("state %s, server %v: got request %s with authorization %q", test.state, test.isServer, path, auth)
+		}
+		wantKey := fmt.Sprintf("%x", sha1.Sum([]byte(status.Context)))
+		if body["state"] != test.want || body["key"] != wantKey || body["name"] != status.Context || body["description"] != status.Description || body["url"] != status.TargetURL {
+			t.Errorf("state %s, server %v: got status %v, want state %s", test.state, test.isServer, body, test.want)
+		}
+	}
+}
+
+func TestBitbucketSetCommitStatusNoTargetURL(t *testing.T) {
+	body := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+	tests := []struct {
+		b    BitbucketManager
+		want string
+	}{
+		{BitbucketManager{cloudAPI: srv.URL}, "https://bitbucket.org/user/repo"},
+		{BitbucketManager{scheme: "http://", hostName: host, isServer: true}, "http://" + host + "/projects/user/repos/repo"},
+	}
+	for _, test := range tests {
+		status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+		if err := test.b.SetCommitStatus("https://bitbucket.org/user/repo.git", "abc123", status, "token1"); err != nil {
+			t.Errorf("server %v: got error: %v", test.b.isServer, err)
+			continue
+		}
+		if body["url"] != test.want {
+			t.Errorf("server %v: got url %s, want %s", test.b.isServer, body["url"], test.want)
+		}
+	}
+}
+
+func TestBitbucketSetCommitStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"type":"error"}`))
+	}))
+	defer srv.Close()
+	b := BitbucketManager{cloudAPI: srv.URL}
+	status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+	err := b.SetCommitStatus("https://bitbucket.org/user/repo.git", "abc123", status, "token1")
+	if err == nil || !strings.Contains(err.Error(), `{"type":"error"}`) {
+		t.Errorf("got error %v, want the response", err)
+	}
+}
+
+//TestBitbucketServerGetRepos lists repos of two pages by the start and limit of Bitbucket Server
+func TestBitbucketServerGetRepos(t *testing.T) {
+	repo := func(name string) string {
+		return `{"links":{"clone":[{"name":"ssh","href":"ssh://git@git.example.com/p/` + name + `.git"},{"name":"http","href":"http://user@git.example.com/scm/p/` + name + `.git"}]}}`
+	}
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		requests = append(requests, q.Get("permission")+":"+q.Get("start"))
+		if q.Get("limit") != bitbucketPageLen {
+			t.Errorf("got limit %s, want %s", q.Get("limit"), bitbucketPageLen)
+		}
+		switch q.Get("permission") + ":" + q.Get("start") {
+		case "REPO_READ:0":
+			w.Write([]byte(`{"values":[` + repo("r1") + `],"isLastPage":false,"nextPageStart":1}`))
+		case "REPO_READ:1":
+			w.Write([]byte(`{"values":[` + repo("r2") + `],"isLastPage":true}`))
+		case "REPO_WRITE:0":
+			w.Write([]byte(`{"values":[` + repo("r2") + `],"isLastPage":true}`))
+		default:
+			w.Write([]byte(`{"values":[],"isLastPage":true}`))
+		}
+	}))
+	defer srv.Close()
+	b := BitbucketManager{scheme: "http://", hostName: strings.TrimPrefix(srv.URL, "http://"), isServer: true}
+	repos, err := b.GetRepos(&model.GitAccount{AccessToken: "token1"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	wantRequests := []string{"REPO_READ:0", "REPO_READ:1", "REPO_WRITE:0", "REPO_ADMIN:0"}
+	if strings.Join(requests, ",") != strings.Join(wantRequests, ",") {
+		t.Errorf("got requests %v, want %v", requests, wantRequests)
+	}
+	got := []string{}
+	for _, r := range repos {
+		perms := []string{}
+		for p := range r.Permissions {
+			perms = append(perms, p)
+		}
+		sort.Strings(perms)
+		got = append(got, r.CloneURL+" "+strings.Join(perms, ","))
+	}
+	want := []string{
+		"http://git.example.com/scm/p/r1.git pull",
+		"http://git.example.com/scm/p/r2.git pull,push",
+	}
+	if strings.Join(got, ";") != strings.Join(want, ";") {
+		t.Errorf("got repos %v, want %v", got, want)
+	}
+}
+
+//TestBitbucketCloudDeleteWebhook follows the next pages of Bitbucket Cloud to find hooks by url and delete them by uuid
+func TestBitbucketCloudDeleteWebhook(t *testing.T) {
+	var requests []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath())
+		if r.Method == "DELETE" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.URL.Query().Get("page") == "2" {
+			w.Write([]byte(`{"values":[{"uuid":"{hook-2}","url":"https://ci.example.com/v1/webhook?scm=bitbucket&pipelineId=p1"}]}`))
+			return
+		}
+		var page bytes.Buffer
+		json.NewEncoder(&page).Encode(map[string]interface{}{
+			"values": []map[string]string{
+				{"uuid": "{hook-1}", "url": "https://ci.example.com/v1/webhook?scm=bitbucket&pipelineId=p10"},
+			},
+			"next": srv.URL + "/repositories/user/repo/hooks?page=2",
+		})
+		w.Write(page.Bytes())
+	}))
+	defer srv.Close()
+	p := &model.Pipeline{
+		Id:        "p1",
+		WebHookId: 1,
+		Stages: []*model.Stage{{Steps: []*model.Step{{
+			Type:       model.StepTypeSCM,
+			Repository: "https://bitbucket.org/user/repo.git",
+		}}}},
+	}
+	b := BitbucketManager{cloudAPI: srv.URL}
+	if err := b.DeleteWebhook(p, "token1"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	want := []string{
+		"GET /repositories/user/repo/hooks",
+		"GET /repositories/user/repo/hooks",
+		"DELETE /repositories/user/repo/hooks/%7Bhook-2%7D",
+	}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("got requests %v, want %v", requests, want)
+	}
+	if p.WebHookId != 0 {
+		t.Errorf("got webhook id %d, want 0", p.WebHookId)
+	}
+}
@@ -0,0 +1,377 @@
+package scm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/oauth2"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+)
+
+const (
+	giteaAPI      = "%s%s/api/v1"
+	giteaPageSize = 50
+)
+
+type GiteaManager struct {
+	scheme string
+	host   string
+}
+
+type giteaUser struct {
+	Login     string `json:"login"`
+	FullName  string `json:"full_name"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type giteaRepo struct {
+	CloneURL    string          `json:"clone_url"`
+	Permissions map[string]bool `json:"permissions"`
+}
+
+func (g GiteaManager) Config(setting *model.SCMSetting) model.SCManager {
+	if setting.Scheme != "" {
+		g.scheme = setting.Scheme
+	} else {
+		g.scheme = "https://"
+	}
+	if setting.HostName != "" {
+		g.host = setting.HostName
+	} else {
+		g.host = "gitea.com"
+	}
+	return g
+}
+
+func (g GiteaManager) GetType() string {
+	return "gitea"
+}
+
+func (g GiteaManager) GetCloneUser(login string) string {
+	return login
+}
+
+func (g GiteaManager) apiEndpoint() string {
+	return fmt.Sprintf(giteaAPI, g.scheme, g.host)
+}
+
+func (g GiteaManager) OAuth(redirectURL string, clientID string, clientSecret string, code string) (*model.GitAccount, error) {
+	giteaOauthConfig := &oauth2.Config{
+		RedirectURL:  redirectURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  fmt.Sprintf("%s%s/login/oauth/authorize", g.scheme, g.host),
+			TokenURL: fmt.Sprintf("%s%s/login/oauth/access_token", g.scheme, g.host),
+		},
+	}
+
+	token, err := giteaOauthConfig.Exchange(oauth2.NoContext, code)
+	if err != nil {
+		logrus.Errorf("Code exchange failed with '%s'\n", err)
+		return nil, err
+	} else if !strings.EqualFold(token.TokenType, "bearer") || token.AccessToken == "" {
+		return nil, fmt.Errorf("Fail to get accesstoken with oauth config")
+	}
+	return g.GetAccount(token.AccessToken)
+}
+
+func (g GiteaManager) GetAccount(accessToken string) (*model.GitAccount, error) {
+	user := &giteaUser{}
+	resp, err := doGitea("GET", g.apiEndpoint()+"/user", accessToken, nil)
+	if err != nil {
+		logrus.Errorf("Gitea GetAccount: received error from gitea, err: %v", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(user); err != nil {
+		return nil, err
+	}
+	account := &model.GitAccount{}
+	account.AccountType = g.GetType()
+	account.AccessToken = accessToken
+	account.AvatarURL = user.AvatarURL
+	account.HTMLURL = fmt.Sprintf("%s%s/%s", g.scheme, g.host, user.Login)
+	account.Id = g.GetType() + ":" + user.Login
+	account.Login = user.Login
+	account.Name = user.FullName
+	account.Private = false
+	return account, nil
+}
+
+func (g GiteaManager) GetRepos(account *model.GitAccount) ([]*model.GitRepository, error) {
+	if account == nil {
+		return nil, fmt.Errorf("empty account")
+	}
+	result := []*model.GitRepository{}
+	for page := 1; ; page++ {
+		reposURL := fmt.Sprintf("%s/user/repos?page=%d&limit=%d", g.apiEndpoint(), page, giteaPageSize)
+		resp, err := doGitea("GET", reposURL, account.AccessToken, nil)
+		if err != nil {
+			logrus.Errorf("Gitea GetRepos: GET url %v received error from gitea, err: %v", reposURL, err)
+			return nil, err
+		}
+		repos := []giteaRepo{}
+		err = json.NewDecoder(resp.Body).Decode(&repos)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range repos {
+			result = append(result, &model.GitRepository{
+				CloneURL:    repo.CloneURL,
+				Permissions: repo.Permissions,
+			})
+		}
+		if len(repos) < giteaPageSize {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (g GiteaManager) CreateWebhook(p *model.Pipeline, token string, ciWebhookEndpoint string) error {
+	logrus.Debugf("createwebhook for pipeline:%v", p.Id)
+	if p == nil {
+		return errors.New("empty pipeline to create webhook")
+	}
+	if len(p.Stages) == 0 || len(p.Stages[0].Steps) == 0 || !p.Stages[0].Steps[0].Webhook {
+		return nil
+	}
+	user, repo, err := getUserRepoFromURL(p.Stages[0].Steps[0].Repository)
+	if err != nil {
+		return nil
+	}
+	hook := map[string]interface{}{
+		"type":   "gitea",
+		"active": true,
+		"events": []string{"push", "pull_request"},
+		"config": map[string]string{
+			"url":          fmt.Sprintf("%s&pipelineId=%s", ciWebhookEndpoint, p.Id),
+			"content_type": "json",
+			"secret":       p.WebHookToken,
+		},
+	}
+	b, err := json.Marshal(hook)
+	if err != nil {
+		return err
+	}
+	resp, err := doGitea("POST", fmt.Sprintf("%s/repos/%s/%s/hooks", g.apiEndpoint(), user, repo), token, bytes.NewReader(b))
+	if err != nil {
+		logrus.Errorf("error create webhook,%v", err)
+		return err
+	}
+	defer resp.Body.Close()
+	created := struct {
+		Id int `json:"id"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return err
+	}
+	p.WebHookId = created.Id
+	return nil
+}
+
+func (g GiteaManager) DeleteWebhook(p *model.Pipeline, token string) error {
+	logrus.Infof("deletewebhook for pipeline:%v", p.Id)
+	if p == nil {
+		return errors.New("empty pipeline to delete webhook")
+	}
+	if len(p.Stages) == 0 || len(p.Stages[0].Steps) == 0 || p.WebHookId <= 0 {
+		return nil
+	}
+	user, repo, err := getUserRepoFromURL(p.Stages[0].Steps[0].Repository)
+	if err != nil {
+		return nil
+	}
+	resp, err := doGitea("DELETE", fmt.Sprintf("%s/repos/%s/%s/hooks/%d", g.apiEndpoint(), user, repo, p.WebHookId), token, nil)
+	if err != nil {
+		logrus.Errorf("error delete webhook,%v", err)
+		return err
+	}
+	resp.Body.Close()
+	p.WebHookId = 0
+	return nil
+}
+
+//VerifyWebhookPayload verifies webhooks of Gitea, and of Gogs which Gitea is compatible with
+func (g GiteaManager) VerifyWebhookPayload(p *model.Pipeline, req *http.Request) (*model.TriggerInfo, bool) {
+	signature := req.Header.Get("X-Gitea-Signature")
+	if signature == "" {
+		signature = req.Header.Get("X-Gogs-Signature")
+	}
+	eventType := req.Header.Get("X-Gitea-Event")
+	if eventType == "" {
+		eventType = req.Header.Get("X-Gogs-Event")
+	}
+	if signature == "" {
+		logrus.Errorf("receive gitea webhook,no signature")
+		return nil, false
+	}
+	if eventType != "push" && eventType != "pull_request" {
+		logrus.Errorf("receive gitea webhook,not push or pull_request event")
+		return nil, false
+	}
+	if p == nil {
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logrus.Errorf("receive gitea webhook, got error:%v", err)
+		return nil, false
+	}
+	if match := VerifyGiteaWebhookSignature([]byte(p.WebHookToken), signature, body); !match {
+		logrus.Errorf("receive gitea webhook, invalid signature")
+		return nil, false
+	}
+	if eventType == "push" {
+		if !triggerOnEvent(p, model.WebhookEventPush) {
+			logrus.Debugf("receive gitea webhook, push event is not enabled")
+			return nil, false
+		}
+		trigger, ok := pushTrigger(p, body)
+		if !ok {
+			logrus.Warningf("receive gitea webhook, branch not match:%v", p.Stages[0].Steps[0].Branch)
+		}
+		return trigger, ok
+	}
+	if !triggerOnEvent(p, model.WebhookEventPullRequest) {
+		logrus.Debugf("receive gitea webhook, pull request event is not enabled")
+		return nil, false
+	}
+	payload := struct {
+		Action      string `json:"action"`
+		Number      int    `json:"number"`
+		PullRequest *struct {
+			Head struct {
+				Ref    string `json:"ref"`
+				Sha    string `json:"sha"`
+				RepoId int64  `json:"repo_id"`
+			} `json:"head"`
+			Base struct {
+				Ref    string `json:"ref"`
+				RepoId int64  `json:"repo_id"`
+			} `json:"base"`
+		} `json:"pull_request"`
+	}{}
+	if err := json.Unmarshal(body, &payload); err != nil || payload.PullRequest == nil {
+		logrus.Error("fail to parse gitea pull request payload")
+		return nil, false
+	}
+	if payload.Action != "opened" && payload.Action != "synchronized" && payload.Action != "reopened" {
+		logrus.Debugf("receive gitea webhook, skip pull request action '%s'", payload.Action)
+		return nil, false
+	}
+	//pull requests to the branch of the pipeline
+	if payload.PullRequest.Base.Ref != p.Stages[0].Steps[0].Branch {
+		logrus.Warningf("target branch not match:%v,%v", payload.PullRequest.Base.Ref, p.Stages[0].Steps[0].Branch)
+		return nil, false
+	}
+	pr := &model.PullRequest{
+		Number:       payload.Number,
+		SourceBranch: payload.PullRequest.Head.Ref,
+		TargetBranch: payload.PullRequest.Base.Ref,
+		Fork:         payload.PullRequest.Head.RepoId != payload.PullRequest.Base.RepoId,
+	}
+	//gitea has no merge refs of pull requests, the head is built
+	headRef := "refs/pull/" + strconv.Itoa(payload.Number) + "/head"
+	return pullRequestTrigger(p, pr, headRef, headRef, payload.PullRequest.Head.Sha), true
+}
+
+//VerifyGiteaWebhookSignature checks the hex encoded hmac-sha256 signature of the body
+func VerifyGiteaWebhookSignature(secret []byte, signature string, body []byte) bool {
+	actual, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	computed := hmac.New(sha256.New, secret)
+	computed.Write(body)
+	return hmac.Equal(computed.Sum(nil), actual)
+}
+
+//SetCommitStatus creates a status of the commit by the statuses API
+func (g GiteaManager) SetCommitStatus(repoURL string, commit string, status *model.CommitStatus, gitToken string) error {
+	user, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return err
+	}
+	//gitea has no running or canceled state
+	state := status.State
+	switch state {
+	case model.CommitStateRunning:
+		state = "pending"
+	case model.CommitStateCanceled:
+		state = "error"
+	}
+	b, err := json.Marshal(map[string]string{
+		"state":       state,
+		"target_url":  status.TargetURL,
+		"description": status.Description,
+		"context":     status.Context,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := doGitea("POST", fmt.Sprintf("%s/repos/%s/%s/statuses/%s", g.apiEndpoint(), user, repo, commit), gitToken, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+//GetFileContent gets content of the file at the ref in the repository
+func (g GiteaManager) GetFileContent(repoURL string, ref string, path string, gitToken string) ([]byte, error) {
+	user, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	fileURL := fmt.Sprintf("%s/repos/%s/%s/raw/%s?ref=%s", g.apiEndpoint(), user, repo, path, url.QueryEscape(ref))
+	resp, err := doGitea("GET", fileURL, gitToken, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, model.ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func doGitea(method string, url string, accessToken string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+	req.Header.Add("Accept", "application/json")
+	if body != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		logrus.Errorf("Received error from gitea: %v", err)
+		return resp, err
+	}
+	if resp.StatusCode >= 300 {
+		var respBody bytes.Buffer
+		io.Copy(&respBody, resp.Body)
+		resp.Body.Close()
+		return resp, fmt.Errorf("Request failed, got status code: %d. Response: %s",
+			resp.StatusCode, respBody.Bytes())
+	}
+	return resp, nil
+}
@@ -0,0 +1,235 @@
+package scm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestVerifyGiteaWebhookSignature(t *testing.T) {
+	body := `{"ref":"refs/heads/master"}`
+	tests := []struct {
+		name      string
+		signature string
+		want      bool
+	}{
+		{"valid", hmacSHA256("token1", body), true},
+		{"wrong secret", hmacSHA256("token2", body), false},
+		{"prefixed", "sha256=" + hmacSHA256("token1", body), false},
+		{"not hex", "xyz", false},
+		{"empty", "", false},
+	}
+	for _, test := range tests {
+		if got := VerifyGiteaWebhookSignature([]byte("token1"), test.signature, []byte(body)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGiteaVerifyWebhookPayload(t *testing.T) {
+	push := `{"ref":"refs/heads/master","after":"c1","commits":[{"added":["a.go"],"modified":["b.go"],"removed":[]}]}`
+	pr := `{"action":"%s","number":3,"pull_request":{"head":{"ref":"feature","sha":"c3","repo_id":1},"base":{"ref":"%s","repo_id":%d}}}`
+	tests := []struct {
+		name       string
+		gogs       bool
+		event      string
+		body       string
+		signature  string
+		events     []string
+		wantOK     bool
+		wantCommit string
+		wantPR     *model.PullRequest
+	}{
+		{"push", false, "push", push, "", nil, true, "c1", nil},
+		{"gogs push", true, "push", push, "", nil, true, "c1", nil},
+		{"push of other branch", false, "push", strings.Replace(push, "master", "dev", 1), "", nil, false, "", nil},
+		{"push not enabled", false, "push", push, "", []string{model.WebhookEventPullRequest}, false, "", nil},
+		{"pull request opened", false, "pull_request", fmt.Sprintf(pr, "opened", "master", 1), "", nil, true, "c3",
+			&model.PullRequest{Number: 3, SourceBranch: "feature", TargetBranch: "master", Ref: "refs/pull/3/head"}},
+		{"pull request synchronized", false, "pull_request", fmt.Sprintf(pr, "synchronized", "master", 1), "", nil, true, "c3",
+			&model.PullRequest{Number: 3, SourceBranch: "feature", TargetBranch: "master", Ref: "refs/pull/3/head"}},
+		{"fork pull request", false, "pull_request", fmt.Sprintf(pr, "reopened", "master", 2), "", nil, true, "c3",
+			&model.PullRequest{Number: 3, SourceBranch: "feature", TargetBranch: "master", Fork: true, Ref: "refs/pull/3/head"}},
+		{"pull request closed", false, "pull_request", fmt.Sprintf(pr, "closed", "master", 1), "", nil, false, "", nil},
+		{"pull request to other branch", false, "pull_request", fmt.Sprintf(pr, "opened", "dev", 1), "", nil, false, "", nil},
+		{"pull request not enabled", false, "pull_request", fmt.Sprintf(pr, "opened", "master", 1), "", []string{model.WebhookEventPush}, false, "", nil},
+		{"not a pull request", false, "pull_request", `{"action":"opened"}`, "", nil, false, "", nil},
+		{"unknown event", false, "issues", `{}`, "", nil, false, "", nil},
+		{"invalid signature", false, "push", push, hmacSHA256("token2", push), nil, false, "", nil},
+	}
+	for _, test := range tests {
+		events := test.events
+		if events == nil {
+			events = []string{model.WebhookEventPush, model.WebhookEventPullRequest}
+		}
+		p := &model.Pipeline{
+			Id:           "p1",
+			WebHookToken: "token1",
+			Stages: []*model.Stage{{Steps: []*model.Step{{
+				Type:          model.StepTypeSCM,
+				Repository:    "https://gitea.com/user/repo.git",
+				Branch:        "master",
+				WebhookEvents: events,
+			}}}},
+		}
+		signature := test.signature
+		if signature == "" {
+			signature = hmacSHA256("token1", test.body)
+		}
+		req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(test.body))
+		if test.gogs {
+			req.Header.Set("X-Gogs-Signature", signature)
+			req.Header.Set("X-Gogs-Event", test.event)
+		} else {
+			req.Header.Set("X-Gitea-Signature", signature)
+			req.Header.Set("X-Gitea-Event", test.event)
+		}
+		trigger, ok := GiteaManager{}.VerifyWebhookPayload(p, req)
+		if ok != test.wantOK {
+			t.Errorf("%s: got ok %v, want %v", test.name, ok, test.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if trigger.Commit != test.wantCommit {
+			t.Errorf("%s: got commit %s, want %s", test.name, trigger.Commit, test.wantCommit)
+		}
+		if (trigger.PullRequest == nil) != (test.wantPR == nil) || (test.wantPR != nil && *trigger.PullRequest != *test.wantPR) {
+			t.Errorf("%s: got pull request %+v, want %+v", test.name, trigger.PullRequest, test.wantPR)
+		}
+	}
+}
+
+func TestGiteaVerifyWebhookPayloadNoSignature(t *testing.T) {
+	p := &model.Pipeline{
+		WebHookToken: "token1",
+		Stages:       []*model.Stage{{Steps: []*model.Step{{Type: model.StepTypeSCM, Branch: "master"}}}},
+	}
+	req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(`{"ref":"refs/heads/master","after":"c1"}`))
+	req.Header.Set("X-Gitea-Event", "push")
+	if _, ok := (GiteaManager{}).VerifyWebhookPayload(p, req); ok {
+		t.Error("got ok for a webhook without signature")
+	}
+}
+
+func TestGiteaPushChangedFiles(t *testing.T) {
+	body := `{"ref":"refs/heads/master","after":"c1","commits":[{"added":["a.go"],"modified":["b.go"]},{"removed":["c.go"]}]}`
+	p := &model.Pipeline{
+		WebHookToken: "token1",
+		Stages:       []*model.Stage{{Steps: []*model.Step{{Type: model.StepTypeSCM, Branch: "master"}}}},
+	}
+	req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(body))
+	req.Header.Set("X-Gitea-Signature", hmacSHA256("token1", body))
+	req.Header.Set("X-Gitea-Event", "push")
+	trigger, ok := GiteaManager{}.VerifyWebhookPayload(p, req)
+	if !ok {
+		t.Fatal("got not ok")
+	}
+	if got := strings.Join(trigger.ChangedFiles, ","); got != "a.go,b.go,c.go" {
+		t.Errorf("got changed files %s, want a.go,b.go,c.go", got)
+	}
+}
+
+func TestGiteaSetCommitStatus(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{model.CommitStatePending, "pending"},
+		{model.CommitStateRunning, "pending"},
+		{model.CommitStateSuccess, "success"},
+		{model.CommitStateFailure, "failure"},
+		{model.CommitStateCanceled, "error"},
+	}
+	for _, test := range tests {
+		var path, auth string
+		body := map[string]string{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.Method + " " + r.URL.Path
+			auth = r.Header.Get("Authorization")
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("fail to decode request: %v", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+		g := GiteaManager{scheme: "http://", host: strings.TrimPrefix(srv.URL, "http://")}
+		status := &model.CommitStatus{
+			State:       test.state,
+			Context:     "continuous-integration/rancher-pipeline/p",
+			Description: "Pipeline is running",
+			TargetURL:   "https://pipeline.example.com/v1/activities/a1",
+		}
+		err := g.SetCommitStatus("https://gitea.com/user/repo.git", "abc123", status, "token1")
+		srv.Close()
+		if err != nil {
+			t.Errorf("state %s: got error: %v", test.state, err)
+			continue
+		}
+		if path != "POST /api/v1/repos/user/repo/statuses/abc123" || auth != "Bearer token1" {
+			t.Errorf("state %s: got request %s with authorization %q", test.state, path, auth)
+		}
+		if body["state"] != test.want || body["context"] != status.Context || body["description"] != status.Description || body["target_url"] != status.TargetURL {
+			t.Errorf("state %s: got status %v, want state %s", test.state, body, test.want)
+		}
+	}
+}
+
+func TestGiteaSetCommitStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Not Found"}`))
+	}))
+	defer srv.Close()
+	g := GiteaManager{scheme: "http://", host: strings.TrimPrefix(srv.URL, "http://")}
+	status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+	err := g.SetCommitStatus("https://gitea.com/user/repo.git", "abc123", status, "token1")
+	if err == nil || !strings.Contains(err.Error(), `{"message":"Not Found"}`) {
+		t.Errorf("got error %v, want the response", err)
+	}
+}
+
+//TestGiteaGetRepos lists pages until a page is not full
+func TestGiteaGetRepos(t *testing.T) {
+	var pages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		pages = append(pages, page)
+		if r.URL.Query().Get("limit") != fmt.Sprint(giteaPageSize) {
+			t.Errorf("got limit %s, want %d", r.URL.Query().Get("limit"), giteaPageSize)
+		}
+		n := giteaPageSize
+		if page == "2" {
+			n = 1
+		}
+		repos := []map[string]interface{}{}
+		for i := 0; i < n; i++ {
+			repos = append(repos, map[string]interface{}{
+				"clone_url":   fmt.Sprintf("https://gitea.com/user/r%s-%d.git", page, i),
+				"permissions": map[string]bool{"pull": true, "push": page == "1"},
+			})
+		}
+		json.NewEncoder(w).Encode(repos)
+	}))
+	defer srv.Close()
+	g := GiteaManager{scheme: "http://", host: strings.TrimPrefix(srv.URL, "http://")}
+	repos, err := g.GetRepos(&model.GitAccount{AccessToken: "token1"})
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if strings.Join(pages, ",") != "1,2" {
+		t.Errorf("got pages %v, want 1,2", pages)
+	}
+	if len(repos) != giteaPageSize+1 {
+		t.Fatalf("got %d repos, want %d", len(repos), giteaPageSize+1)
+	}
+	last := repos[len(repos)-1]
+	if last.CloneURL != "https://gitea.com/user/r2-0.git" || !last.Permissions["pull"] || last.Permissions["push"] {
+		t.Errorf("got repo %+v", last)
+	}
+}
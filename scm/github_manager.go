@@ -61,6 +61,10 @@ func (g GithubManager) GetType() string {
 	return "github"
 }
 
+func (g GithubManager) GetCloneUser(login string) string {
+	return login
+}
+
 func (g GithubManager) GetAccount(accessToken string) (*model.GitAccount, error) {
 	account, err := g.getGithubUser(accessToken)
 	if err != nil {
@@ -296,44 +300,149 @@ func (g GithubManager) CreateWebhook(p *model.Pipeline, token string, ciWebhookE
 	return nil
 }
 
-func (g GithubManager) VerifyWebhookPayload(p *model.Pipeline, req *http.Request) bool {
+func (g GithubManager) VerifyWebhookPayload(p *model.Pipeline, req *http.Request) (*model.TriggerInfo, bool) {
 	var signature string
 	var event_type string
 	if signature = req.Header.Get("X-Hub-Signature"); len(signature) == 0 {
 		logrus.Errorf("receive github webhook,no signature")
-		return false
+		return nil, false
 	}
 	if event_type = req.Header.Get("X-GitHub-Event"); len(event_type) == 0 {
 		logrus.Errorf("receive github webhook,no event")
-		return false
+		return nil, false
 	}
-	if event_type != "push" {
-		logrus.Errorf("receive github webhook,not push event")
-		return false
+	if event_type != "push" && event_type != "pull_request" && event_type != "ping" {
+		logrus.Errorf("receive github webhook,not push or pull_request event")
+		return nil, false
 	}
 	if p == nil {
-		return false
+		return nil, false
 	}
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		logrus.Errorf("receive github webhook, got error:%v", err)
-		return false
+		return nil, false
 	}
 	if match := VerifyGithubWebhookSignature([]byte(p.WebHookToken), signature, body); !match {
 		logrus.Errorf("receive github webhook, invalid signature")
-		return false
+		return nil, false
 	}
-	//check branch
-	payload := &github.WebHookPayload{}
-	if err := json.Unmarshal(body, payload); err != nil {
-		logrus.Error("fail to parse github webhook payload")
-		return false
+	if event_type == "ping" {
+		return nil, true
 	}
-	if *payload.Ref != "refs/heads/"+p.Stages[0].Steps[0].Branch {
-		logrus.Warningf("branch not match:%v,%v", *payload.Ref, p.Stages[0].Steps[0].Branch)
-		return false
+	if event_type == "push" {
+		if !triggerOnEvent(p, model.WebhookEventPush) {
+			logrus.Debugf("receive github webhook, push event is not enabled")
+			return nil, false
+		}
+		trigger, ok := pushTrigger(p, body)
+		if !ok {
+			logrus.Warningf("receive github webhook, branch not match:%v", p.Stages[0].Steps[0].Branch)
+		}
+		return trigger, ok
+	}
+	if !triggerOnEvent(p, model.WebhookEventPullRequest) {
+		logrus.Debugf("receive github webhook, pull request event is not enabled")
+		return nil, false
+	}
+	payload := &github.PullRequestEvent{}
+	if err := json.Unmarshal(body, payload); err != nil || payload.PullRequest == nil {
+		logrus.Error("fail to parse github pull request payload")
+		return nil, false
+	}
+	if action := payload.GetAction(); action != "opened" && action != "synchronize" && action != "reopened" {
+		logrus.Debugf("receive github webhook, skip pull request action '%s'", action)
+		return nil, false
+	}
+	//pull requests to the branch of the pipeline
+	targetBranch := payload.PullRequest.Base.GetRef()
+	if targetBranch != p.Stages[0].Steps[0].Branch {
+		logrus.Warningf("target branch not match:%v,%v", targetBranch, p.Stages[0].Steps[0].Branch)
+		return nil, false
+	}
+	number := payload.GetNumber()
+	pr := &model.PullRequest{
+		Number:       number,
+		SourceBranch: payload.PullRequest.Head.GetRef(),
+		TargetBranch: targetBranch,
+		//the head repository is nil if the fork is deleted
+		Fork: payload.PullRequest.Head.Repo.GetFullName() != payload.PullRequest.Base.Repo.GetFullName(),
+	}
+	return pullRequestTrigger(p, pr,
+		fmt.Sprintf("refs/pull/%d/head", number),
+		fmt.Sprintf("refs/pull/%d/merge", number),
+		payload.PullRequest.Head.GetSHA()), true
+}
+
+//GetFileContent gets content of the file at the ref in the repository
+func (g GithubManager) GetFileContent(repoURL string, ref string, path string, gitToken string) ([]byte, error) {
+	user, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf("%s/repos/%s/%s/contents/%s?ref=%s", g.apiEndpoint, user, repo, path, ref)
+	resp, err := getFromGithub(gitToken, url)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, model.ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	file := &github.RepositoryContent{}
+	if err := json.NewDecoder(resp.Body).Decode(file); err != nil {
+		return nil, err
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(content), nil
+}
+
+//SetCommitStatus creates a status of the commit by the statuses API
+func (g GithubManager) SetCommitStatus(repoURL string, commit string, status *model.CommitStatus, gitToken string) error {
+	user, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return err
+	}
+	//github has no running or canceled state
+	state := status.State
+	switch state {
+	case model.CommitStateRunning:
+		state = "pending"
+	case model.CommitStateCanceled:
+		state = "error"
+	}
+	repoStatus := github.RepoStatus{
+		State:       &state,
+		Context:     &status.Context,
+		Description: &status.Description,
+	}
+	if status.TargetURL != "" {
+		repoStatus.TargetURL = &status.TargetURL
 	}
-	return true
+	b := new(bytes.Buffer)
+	if err := json.NewEncoder(b).Encode(repoStatus); err != nil {
+		return err
+	}
+	APIURL := fmt.Sprintf("%s/repos/%s/%s/statuses/%s", g.apiEndpoint, user, repo, commit)
+	req, err := http.NewRequest("POST", APIURL, b)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "token "+gitToken)
+	req.Header.Add("Content-Type", "application/json")
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respData, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode > 399 {
+		return errors.New(string(respData))
+	}
+	return err
 }
 
 func VerifyGithubWebhookSignature(secret []byte, signature string, body []byte) bool {
@@ -363,7 +472,7 @@ func createGithubWebhook(apiEndpoint string, user string, repo string, accesstok
 		Name:   &name,
 		Active: &active,
 		Config: make(map[string]interface{}),
-		Events: []string{"push"},
+		Events: []string{"push", "pull_request"},
 	}
 
 	hook.Config["url"] = webhookUrl
@@ -417,7 +526,7 @@ func deleteGithubWebhook(apiEndpoint string, user string, repo string, accesstok
 	if resp.StatusCode > 399 {
 		return errors.New(string(respData))
 	}
-	logrus.Debugf("after delete,%v,%v", string(respData))
+	logrus.Debugf("after delete,%v", string(respData))
 	return err
 }
 
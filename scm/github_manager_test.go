@@ -0,0 +1,85 @@
+package scm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestGithubSetCommitStatus(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{model.CommitStatePending, "pending"},
+		{model.CommitStateRunning, "pending"},
+		{model.CommitStateSuccess, "success"},
+		{model.CommitStateFailure, "failure"},
+		{model.CommitStateCanceled, "error"},
+	}
+	for _, test := range tests {
+		var path, auth string
+		body := map[string]string{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.Method + " " + r.URL.Path
+			auth = r.Header.Get("Authorization")
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("fail to decode request: %v", err)
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+		g := GithubManager{apiEndpoint: srv.URL}
+		status := &model.CommitStatus{
+			State:       test.state,
+			Context:     "continuous-integration/rancher-pipeline/p",
+			Description: "Pipeline is running",
+			TargetURL:   "https://pipeline.example.com/v1/activities/a1",
+		}
+		err := g.SetCommitStatus("https://github.com/user/repo.git", "abc123", status, "token1")
+		srv.Close()
+		if err != nil {
+			t.Errorf("state %s: got error: %v", test.state, err)
+			continue
+		}
+		if path != "POST /repos/user/repo/statuses/abc123" || auth != "token token1" {
+			t.Errorf("state %s: got request %s with authorization %q", test.state, path, auth)
+		}
+		if body["state"] != test.want || body["context"] != status.Context || body["description"] != status.Description || body["target_url"] != status.TargetURL {
+			t.Errorf("state %s: got status %v, want state %s", test.state, body, test.want)
+		}
+	}
+}
+
+func TestGithubSetCommitStatusNoTargetURL(t *testing.T) {
+	body := map[string]interface{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	g := GithubManager{apiEndpoint: srv.URL}
+	status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+	if err := g.SetCommitStatus("https://github.com/user/repo.git", "abc123", status, "token1"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if _, ok := body["target_url"]; ok {
+		t.Errorf("got status %v, want no target url", body)
+	}
+}
+
+func TestGithubSetCommitStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"Not Found"}`))
+	}))
+	defer srv.Close()
+	g := GithubManager{apiEndpoint: srv.URL}
+	status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+	err := g.SetCommitStatus("https://github.com/user/repo.git", "abc123", status, "token1")
+	if err == nil || err.Error() != `{"message":"Not Found"}` {
+		t.Errorf("got error %v, want the response", err)
+	}
+}
@@ -45,6 +45,10 @@ func (g GitlabManager) GetType() string {
 	return "gitlab"
 }
 
+func (g GitlabManager) GetCloneUser(login string) string {
+	return "oauth2"
+}
+
 func (g GitlabManager) GetAccount(accessToken string) (*model.GitAccount, error) {
 	account, err := g.getGitlabUser(accessToken)
 	if err != nil {
@@ -308,46 +312,143 @@ func (g GitlabManager) CreateWebhook(p *model.Pipeline, token string, ciWebhookE
 	return nil
 }
 
-func (g GitlabManager) VerifyWebhookPayload(p *model.Pipeline, req *http.Request) bool {
+func (g GitlabManager) VerifyWebhookPayload(p *model.Pipeline, req *http.Request) (*model.TriggerInfo, bool) {
 	var signature string
 	var event_type string
 	if signature = req.Header.Get("X-Gitlab-Token"); len(signature) == 0 {
 		logrus.Warningf("receive gitlab webhook, but got no token")
-		return false
+		return nil, false
 	}
 	if event_type = req.Header.Get("X-Gitlab-Event"); len(event_type) == 0 {
 		logrus.Warningf("receive gitlab webhook, but got no event")
-		return false
+		return nil, false
 	}
 
-	if event_type != "Push Hook" {
-		logrus.Warningf("receive gitlab webhook '%s' event, expected push hook event", event_type)
-		return false
+	if event_type != "Push Hook" && event_type != "Merge Request Hook" {
+		logrus.Warningf("receive gitlab webhook '%s' event, expected push hook or merge request hook event", event_type)
+		return nil, false
 	}
 	if p == nil {
-		return false
+		return nil, false
 	}
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		logrus.Warningf("receive gitlab webhook, got error:%v", err)
-		return false
+		return nil, false
 	}
 	if p.WebHookToken != signature {
 		logrus.Warning("receive gitlab webhook, invalid token")
-		return false
+		return nil, false
 	}
-	//check branch
-	payload := map[string]interface{}{}
 	logrus.Debugf("gitlab webhook got payload:\n%v", string(body))
+	if event_type == "Push Hook" {
+		if !triggerOnEvent(p, model.WebhookEventPush) {
+			logrus.Debugf("receive gitlab webhook, push event is not enabled")
+			return nil, false
+		}
+		trigger, ok := pushTrigger(p, body)
+		if !ok {
+			logrus.Warningf("receive gitlab webhook, branch not match:%v", p.Stages[0].Steps[0].Branch)
+		}
+		return trigger, ok
+	}
+	if !triggerOnEvent(p, model.WebhookEventPullRequest) {
+		logrus.Debugf("receive gitlab webhook, merge request event is not enabled")
+		return nil, false
+	}
+	payload := struct {
+		ObjectAttributes struct {
+			Iid             int    `json:"iid"`
+			Action          string `json:"action"`
+			SourceBranch    string `json:"source_branch"`
+			TargetBranch    string `json:"target_branch"`
+			SourceProjectId int    `json:"source_project_id"`
+			TargetProjectId int    `json:"target_project_id"`
+			LastCommit      struct {
+				Id string `json:"id"`
+			} `json:"last_commit"`
+		} `json:"object_attributes"`
+	}{}
 	if err := json.Unmarshal(body, &payload); err != nil {
-		logrus.Error("fail to parse github webhook payload,err:%v", err)
-		return false
+		logrus.Errorf("fail to parse gitlab webhook payload,err:%v", err)
+		return nil, false
+	}
+	mr := payload.ObjectAttributes
+	if mr.Action != "open" && mr.Action != "reopen" && mr.Action != "update" {
+		logrus.Debugf("receive gitlab webhook, skip merge request action '%s'", mr.Action)
+		return nil, false
+	}
+	//merge requests to the branch of the pipeline
+	if mr.TargetBranch != p.Stages[0].Steps[0].Branch {
+		logrus.Warningf("receive gitlab webhook, target branch not match:%v,%v", mr.TargetBranch, p.Stages[0].Steps[0].Branch)
+		return nil, false
+	}
+	pr := &model.PullRequest{
+		Number:       mr.Iid,
+		SourceBranch: mr.SourceBranch,
+		TargetBranch: mr.TargetBranch,
+		Fork:         mr.SourceProjectId != mr.TargetProjectId,
+	}
+	return pullRequestTrigger(p, pr,
+		fmt.Sprintf("refs/merge-requests/%d/head", mr.Iid),
+		fmt.Sprintf("refs/merge-requests/%d/merge", mr.Iid),
+		mr.LastCommit.Id), true
+}
+
+//GetFileContent gets content of the file at the ref in the repository
+func (g GitlabManager) GetFileContent(repoURL string, ref string, path string, gitToken string) ([]byte, error) {
+	user, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	project := url.QueryEscape(user + "/" + repo)
+	APIURL := fmt.Sprintf(gitlabAPI+"/projects/%s/repository/files/%s/raw?ref=%s", g.scheme, g.host, project, url.QueryEscape(path), url.QueryEscape(ref))
+	resp, err := getFromGitlab(gitToken, APIURL)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, model.ErrFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+//SetCommitStatus sets the status of the commit by the commit status API
+func (g GitlabManager) SetCommitStatus(repoURL string, commit string, status *model.CommitStatus, gitToken string) error {
+	user, repo, err := getUserRepoFromURL(repoURL)
+	if err != nil {
+		return err
+	}
+	state := status.State
+	if state == model.CommitStateFailure {
+		state = "failed"
 	}
-	if payload["ref"] != "refs/heads/"+p.Stages[0].Steps[0].Branch {
-		logrus.Warningf("receive gitlab webhook, branch not match:%v,%v", payload["ref"], p.Stages[0].Steps[0].Branch)
-		return false
+	project := url.QueryEscape(user + "/" + repo)
+	APIURL := fmt.Sprintf(gitlabAPI+"/projects/%s/statuses/%s", g.scheme, g.host, project, commit)
+	req, err := http.NewRequest("POST", APIURL, nil)
+	if err != nil {
+		return err
+	}
+	q := req.URL.Query()
+	q.Set("state", state)
+	q.Set("name", status.Context)
+	q.Set("description", status.Description)
+	if status.TargetURL != "" {
+		q.Set("target_url", status.TargetURL)
+	}
+	req.URL.RawQuery = q.Encode()
+	req.Header.Add("Authorization", "Bearer "+gitToken)
+	client := http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
 	}
-	return true
+	defer resp.Body.Close()
+	respData, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode > 399 {
+		return errors.New(string(respData))
+	}
+	return err
 }
 
 func VerifyGitlabWebhookSignature(secret []byte, signature string, body []byte) bool {
@@ -363,7 +464,8 @@ func (g GitlabManager) createGitlabWebhook(user string, repo string, accesstoken
 	req, err := http.NewRequest("POST", APIURL, nil)
 
 	opt := &gitlab.AddProjectHookOptions{
-		PushEvents: gitlab.Bool(true),
+		PushEvents:          gitlab.Bool(true),
+		MergeRequestsEvents: gitlab.Bool(true),
 		URL:        gitlab.String(webhookUrl),
 		EnableSSLVerification: gitlab.Bool(false),
 		Token: gitlab.String(secret),
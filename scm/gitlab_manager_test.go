@@ -0,0 +1,84 @@
+package scm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestGitlabSetCommitStatus(t *testing.T) {
+	tests := []struct {
+		state string
+		want  string
+	}{
+		{model.CommitStatePending, "pending"},
+		{model.CommitStateRunning, "running"},
+		{model.CommitStateSuccess, "success"},
+		{model.CommitStateFailure, "failed"},
+		{model.CommitStateCanceled, "canceled"},
+	}
+	for _, test := range tests {
+		var path, auth string
+		var query url.Values
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.Method + " " + r.URL.EscapedPath()
+			auth = r.Header.Get("Authorization")
+			query = r.URL.Query()
+			w.WriteHeader(http.StatusCreated)
+		}))
+		g := GitlabManager{scheme: "http://", host: strings.TrimPrefix(srv.URL, "http://")}
+		status := &model.CommitStatus{
+			State:       test.state,
+			Context:     "continuous-integration/rancher-pipeline/p",
+			Description: "Pipeline is running",
+			TargetURL:   "https://pipeline.example.com/v1/activities/a1",
+		}
+		err := g.SetCommitStatus("https://gitlab.com/user/repo.git", "abc123", status, "token1")
+		srv.Close()
+		if err != nil {
+			t.Errorf("state %s: got error: %v", test.state, err)
+			continue
+		}
+		if path != "POST /api/v4/projects/user%2Frepo/statuses/abc123" || auth != "Bearer token1" {
+			t.Errorf("state %s: got request %s with authorization %q", test.state, path, auth)
+		}
+		if query.Get("state") != test.want || query.Get("name") != status.Context || query.Get("description") != status.Description || query.Get("target_url") != status.TargetURL {
+			t.Errorf("state %s: got status %v, want state %s", test.state, query, test.want)
+		}
+	}
+}
+
+func TestGitlabSetCommitStatusNoTargetURL(t *testing.T) {
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+	g := GitlabManager{scheme: "http://", host: strings.TrimPrefix(srv.URL, "http://")}
+	status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+	if err := g.SetCommitStatus("https://gitlab.com/user/repo.git", "abc123", status, "token1"); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if _, ok := query["target_url"]; ok {
+		t.Errorf("got status %v, want no target url", query)
+	}
+}
+
+func TestGitlabSetCommitStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"message":"403 Forbidden"}`))
+	}))
+	defer srv.Close()
+	g := GitlabManager{scheme: "http://", host: strings.TrimPrefix(srv.URL, "http://")}
+	status := &model.CommitStatus{State: model.CommitStateSuccess, Context: "c"}
+	err := g.SetCommitStatus("https://gitlab.com/user/repo.git", "abc123", status, "token1")
+	if err == nil || err.Error() != `{"message":"403 Forbidden"}` {
+		t.Errorf("got error %v, want the response", err)
+	}
+}
@@ -0,0 +1,30 @@
+package scm
+
+import (
+	"fmt"
+
+	"github.com/rancher/pipeline/model"
+)
+
+//managers are the supported SCManagers by scm type, a new SCM only needs to register its manager here
+var managers = map[string]model.SCManager{}
+
+func init() {
+	register(GithubManager{})
+	register(GitlabManager{})
+	register(BitbucketManager{})
+	register(GiteaManager{})
+}
+
+func register(manager model.SCManager) {
+	managers[manager.GetType()] = manager
+}
+
+//GetManager gets the SCManager of the scm type in the setting, configured with the setting
+func GetManager(setting *model.SCMSetting) (model.SCManager, error) {
+	manager, ok := managers[setting.ScmType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported scm type '%s'", setting.ScmType)
+	}
+	return manager.Config(setting), nil
+}
@@ -0,0 +1,68 @@
+package scm
+
+import (
+	"encoding/json"
+
+	"github.com/rancher/pipeline/model"
+)
+
+//pushPayload is the push event payload of both github and gitlab
+type pushPayload struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Modified []string `json:"modified"`
+		Removed  []string `json:"removed"`
+	} `json:"commits"`
+}
+
+//triggerOnEvent checks if the webhook event is configured to trigger the pipeline
+func triggerOnEvent(p *model.Pipeline, event string) bool {
+	events := p.Stages[0].Steps[0].WebhookEvents
+	if len(events) == 0 {
+		return event == model.WebhookEventPush
+	}
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+//pushTrigger gets the pushed commit and paths changed by commits, returns false if the branch does not match
+func pushTrigger(p *model.Pipeline, body []byte) (*model.TriggerInfo, bool) {
+	payload := &pushPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		return nil, false
+	}
+	if payload.Ref != "refs/heads/"+p.Stages[0].Steps[0].Branch {
+		return nil, false
+	}
+	trigger := &model.TriggerInfo{
+		TriggerType: model.TriggerTypeWebhook,
+		Commit:      payload.After,
+	}
+	for _, commit := range payload.Commits {
+		trigger.ChangedFiles = append(trigger.ChangedFiles, commit.Added...)
+		trigger.ChangedFiles = append(trigger.ChangedFiles, commit.Modified...)
+		trigger.ChangedFiles = append(trigger.ChangedFiles, commit.Removed...)
+	}
+	return trigger, true
+}
+
+//pullRequestTrigger builds the head, or the merge result if configured, of the pull request
+func pullRequestTrigger(p *model.Pipeline, pr *model.PullRequest, headRef string, mergeRef string, headCommit string) *model.TriggerInfo {
+	trigger := &model.TriggerInfo{
+		TriggerType: model.TriggerTypeWebhook,
+		PullRequest: pr,
+	}
+	if p.Stages[0].Steps[0].MergeRef {
+		pr.Ref = mergeRef
+	} else {
+		pr.Ref = headRef
+		trigger.Commit = headCommit
+	}
+	return trigger
+}
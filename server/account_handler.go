@@ -54,7 +54,7 @@ func (s *Server) GetAccount(rw http.ResponseWriter, req *http.Request) error {
 
 func (s *Server) RemoveAccount(rw http.ResponseWriter, req *http.Request) error {
 	id := mux.Vars(req)["id"]
-	if !service.ValidAccountAccess(req, id) {
+	if !service.ValidAccountOwner(req, id) {
 		return fmt.Errorf("cannot access account '%s'", id)
 	}
 	a, err := service.GetAccount(id)
@@ -76,7 +76,7 @@ func (s *Server) RemoveAccount(rw http.ResponseWriter, req *http.Request) error
 func (s *Server) ShareAccount(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["id"]
-	if !service.ValidAccountAccess(req, id) {
+	if !service.ValidAccountOwner(req, id) {
 		return fmt.Errorf("cannot access account '%s'", id)
 	}
 	a, err := service.ShareAccount(id)
@@ -90,7 +90,7 @@ func (s *Server) ShareAccount(rw http.ResponseWriter, req *http.Request) error {
 func (s *Server) UnshareAccount(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["id"]
-	if !service.ValidAccountAccess(req, id) {
+	if !service.ValidAccountOwner(req, id) {
 		return fmt.Errorf("cannot access account '%s'", id)
 	}
 	a, err := service.UnshareAccount(id)
@@ -103,6 +103,9 @@ func (s *Server) UnshareAccount(rw http.ResponseWriter, req *http.Request) error
 func (s *Server) RefreshRepos(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
 	id := mux.Vars(req)["id"]
+	if !service.ValidAccountAccess(req, id) {
+		return fmt.Errorf("cannot access account '%s'", id)
+	}
 	repos, err := service.RefreshRepos(id)
 	if err != nil {
 		return err
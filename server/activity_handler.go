@@ -20,28 +20,25 @@ import (
 func (s *Server) ListActivities(rw http.ResponseWriter, req *http.Request) error {
 
 	apiContext := api.GetApiContext(req)
-	geObjList, err := service.PaginateGenericObjects("activity")
+	activities, err := service.ListActivities()
 	if err != nil {
 		logrus.Errorf("fail to list activity,err:%v", err)
 		return err
 	}
-	var activities []*model.Activity
 	uid, err := util.GetCurrentUser(req.Cookies())
 	if err != nil || uid == "" {
 		logrus.Errorf("cannot get currentUser,%v,%v", uid, err)
 	}
+	activities = service.FilterActivitiesByRole(uid, activities, model.RoleReader)
+	groups := service.CurrentUserGroups(req)
 
-	for _, gobj := range geObjList {
-		b := []byte(gobj.ResourceData["data"].(string))
-		a := &model.Activity{}
-		json.Unmarshal(b, a)
+	for _, a := range activities {
 		model.ToActivityResource(apiContext, a)
-		if a.CanApprove(uid) {
+		if a.CanApprove(uid, groups) {
 			//add approve action
 			a.Actions["approve"] = apiContext.UrlBuilder.ReferenceLink(a.Resource) + "?action=approve"
 			a.Actions["deny"] = apiContext.UrlBuilder.ReferenceLink(a.Resource) + "?action=deny"
 		}
-		activities = append(activities, a)
 	}
 
 	datalist := priorityPendingActivity(activities)
@@ -53,35 +50,46 @@ func (s *Server) ListActivities(rw http.ResponseWriter, req *http.Request) error
 
 }
 
-func (s *Server) CleanActivities(rw http.ResponseWriter, req *http.Request) error {
-	apiClient, err := util.GetRancherClient()
+//ListExpiredActivities lists activities to remove by the retention policy, nothing is removed
+func (s *Server) ListExpiredActivities(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	expired, err := service.ExpiredActivities()
 	if err != nil {
+		logrus.Errorf("fail to get expired activities,err:%v", err)
 		return err
 	}
-	geObjList, err := service.PaginateGenericObjects("activity")
+	uid, err := util.GetCurrentUser(req.Cookies())
+	if err != nil || uid == "" {
+		logrus.Errorf("cannot get currentUser,%v,%v", uid, err)
+	}
+	expired = service.FilterActivitiesByRole(uid, expired, model.RoleReader)
+	var activities []interface{}
+	for _, a := range expired {
+		model.ToActivityResource(apiContext, a)
+		activities = append(activities, a)
+	}
+	apiContext.Write(&v1client.GenericCollection{
+		Data: activities,
+	})
+	return nil
+}
+
+func (s *Server) CleanActivities(rw http.ResponseWriter, req *http.Request) error {
+	activities, err := service.ListActivities()
 	if err != nil {
 		logrus.Errorf("fail to list activity,err:%v", err)
 		return err
 	}
-	for _, gobj := range geObjList {
-		apiClient.GenericObject.Delete(&gobj)
+	for _, a := range activities {
+		service.DeleteActivity(a.Id)
 	}
 	return nil
 
 }
 
 func (s *Server) CleanPipelines(rw http.ResponseWriter, req *http.Request) error {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-	geObjList, err := service.PaginateGenericObjects("pipeline")
-	if err != nil {
-		logrus.Errorf("fail to list pipeline,err:%v", err)
-		return err
-	}
-	for _, gobj := range geObjList {
-		apiClient.GenericObject.Delete(&gobj)
+	for _, p := range service.ListPipelines() {
+		service.DeletePipeline(p.Id)
 	}
 	return nil
 }
@@ -95,6 +103,12 @@ func (s *Server) CreateActivity(rw http.ResponseWriter, req *http.Request) error
 	if err := json.Unmarshal(requestBytes, activity); err != nil {
 		return err
 	}
+	if !service.ValidStoredPipelineAccess(req, activity.Pipeline.Id, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", activity.Pipeline.Id)
+	}
+	if _, err := service.GetActivity(activity.Id); err == nil {
+		return fmt.Errorf("activity '%s' already exists", activity.Id)
+	}
 	//validate git account access
 	if !service.ValidAccountAccess(req, activity.Pipeline.Stages[0].Steps[0].GitUser) {
 		return fmt.Errorf("no access to '%s' git account", activity.Pipeline.Stages[0].Steps[0].GitUser)
@@ -123,9 +137,8 @@ func (s *Server) RerunActivity(rw http.ResponseWriter, req *http.Request) error
 		return err
 	}
 	//TODO validate activity
-	//validate git account access
-	if !service.ValidAccountAccess(req, r.Pipeline.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Pipeline.Stages[0].Steps[0].GitUser)
+	if !service.ValidActivityAccess(req, r, model.RoleRunner) {
+		return fmt.Errorf("no access to run pipeline '%s'", r.Pipeline.Name)
 	}
 
 	if err = service.RerunActivity(s.Provider, r); err != nil {
@@ -154,18 +167,26 @@ func (s *Server) ApproveActivity(rw http.ResponseWriter, req *http.Request) erro
 		logrus.Errorf("fail getting activity with id:%v", id)
 		return err
 	}
-	//validate git account access
-	if !service.ValidAccountAccess(req, r.Pipeline.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Pipeline.Stages[0].Steps[0].GitUser)
+	if !service.ValidActivityAccess(req, r, model.RoleRunner) {
+		return fmt.Errorf("no access to run pipeline '%s'", r.Pipeline.Name)
 	}
-
-	if err = service.ApproveActivity(s.Provider, r); err != nil {
-		logrus.Errorf("fail approve activity:%v", err)
+	uid := service.CurrentUser(req)
+	if !r.CanApprove(uid, service.CurrentUserGroups(req)) {
+		return fmt.Errorf("user '%s' cannot approve the activity", uid)
+	}
+	comment, err := getApprovalComment(req)
+	if err != nil {
 		return err
 	}
-	r.Status = model.ActivityWaiting
-	r.ActivityStages[r.PendingStage].Status = model.ActivityStageWaiting
-	r.PendingStage = 0
+	if err = service.AddApproval(r, uid, true, comment); err != nil {
+		return err
+	}
+	//wait for more approvals if needed
+	if service.IsStageApproved(r) {
+		if err = s.runApprovedStage(r); err != nil {
+			return err
+		}
+	}
 	if err = service.UpdateActivity(r); err != nil {
 		logrus.Errorf("fail update activity:%v", err)
 		return err
@@ -191,15 +212,28 @@ func (s *Server) DenyActivity(rw http.ResponseWriter, req *http.Request) error {
 		logrus.Errorf("fail getting activity with id:%v", id)
 		return err
 	}
-	//validate git account access
-	if !service.ValidAccountAccess(req, r.Pipeline.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Pipeline.Stages[0].Steps[0].GitUser)
+	if !service.ValidActivityAccess(req, r, model.RoleRunner) {
+		return fmt.Errorf("no access to run pipeline '%s'", r.Pipeline.Name)
 	}
-
-	if err = service.DenyActivity(r); err != nil {
-		logrus.Errorf("fail denyActivity:%v", err)
+	uid := service.CurrentUser(req)
+	if !r.CanApprove(uid, service.CurrentUserGroups(req)) {
+		return fmt.Errorf("user '%s' cannot deny the activity", uid)
+	}
+	comment, err := getApprovalComment(req)
+	if err != nil {
 		return err
 	}
+	if err = service.AddApproval(r, uid, false, comment); err != nil {
+		return err
+	}
+	//keep pending if the stage can still get enough approvals
+	denied := service.IsStageDenied(r)
+	if denied {
+		if err = service.DenyActivity(r); err != nil {
+			logrus.Errorf("fail denyActivity:%v", err)
+			return err
+		}
+	}
 	if err = service.UpdateActivity(r); err != nil {
 		logrus.Errorf("fail update activity:%v", err)
 		return err
@@ -207,6 +241,9 @@ func (s *Server) DenyActivity(rw http.ResponseWriter, req *http.Request) error {
 
 	broadcastResourceChange(*r)
 	s.UpdateLastActivity(r)
+	if denied {
+		go s.drainQueue(r.Pipeline.Id)
+	}
 	model.ToActivityResource(apiContext, r)
 	apiContext.Write(r)
 	return nil
@@ -226,11 +263,12 @@ func (s *Server) StopActivity(rw http.ResponseWriter, req *http.Request) error {
 		logrus.Errorf("fail getting activity with id:%v", id)
 		return err
 	}
-	//validate git account access
-	if !service.ValidAccountAccess(req, r.Pipeline.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Pipeline.Stages[0].Steps[0].GitUser)
+	if !service.ValidActivityAccess(req, r, model.RoleRunner) {
+		return fmt.Errorf("no access to run pipeline '%s'", r.Pipeline.Name)
 	}
 
+	//a queued activity is not run in the provider
+	queued := r.Status == model.ActivityQueued
 	if err = service.StopActivity(s.Provider, r); err != nil {
 		logrus.Errorf("fail stop activity:%v", err)
 		return err
@@ -241,7 +279,10 @@ func (s *Server) StopActivity(rw http.ResponseWriter, req *http.Request) error {
 	}
 	broadcastResourceChange(*r)
 	s.UpdateLastActivity(r)
-	s.Provider.OnActivityCompelte(r)
+	if !queued {
+		s.Provider.OnActivityCompelte(r)
+	}
+	go s.drainQueue(r.Pipeline.Id)
 	model.ToActivityResource(apiContext, r)
 	apiContext.Write(r)
 	return nil
@@ -254,29 +295,57 @@ func (s *Server) DeleteActivity(rw http.ResponseWriter, req *http.Request) error
 	if err != nil {
 		return err
 	}
-	//validate git account access
-	if !service.ValidAccountAccess(req, r.Pipeline.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Pipeline.Stages[0].Steps[0].GitUser)
+	if !service.ValidActivityAccess(req, r, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", r.Pipeline.Name)
 	}
 	err = service.DeleteActivity(id)
 	if err != nil {
 		return err
 	}
+	if !service.IsRunning(r) {
+		if err := s.Provider.OnDeleteActivity(r); err != nil {
+			logrus.Errorf("fail to clean activity '%s': %v", id, err)
+		}
+	}
 	r.Status = "removed"
 	broadcastResourceChange(*r)
+	go s.drainQueue(r.Pipeline.Id)
 	return nil
 }
 
 func (s *Server) UpdateActivity(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	mutex := GlobalAgent.getActivityLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	prev, err := service.GetActivity(id)
+	if err != nil {
+		return err
+	}
+	//authorize by the stored activity, never by the request data
+	if !service.ValidStoredPipelineAccess(req, prev.Pipeline.Id, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", prev.Pipeline.Name)
+	}
 	requestBytes, err := ioutil.ReadAll(req.Body)
 	activity := &model.Activity{}
 
 	if err := json.Unmarshal(requestBytes, activity); err != nil {
 		return err
 	}
-	//validate git account access
-	if !service.ValidAccountAccess(req, activity.Pipeline.Stages[0].Steps[0].GitUser) {
+	if activity.Id != "" && activity.Id != id {
+		return fmt.Errorf("activity id '%s' does not match '%s'", activity.Id, id)
+	}
+	activity.Id = id
+	if activity.Pipeline.Id != prev.Pipeline.Id {
+		return fmt.Errorf("not allowed to change the pipeline of activity '%s'", id)
+	}
+	//the callback token is not returned by the API, keep the issued one
+	activity.CallbackToken = prev.CallbackToken
+	//validate git account access when it is changed
+	if activity.Pipeline.Stages[0].Steps[0].GitUser != prev.Pipeline.Stages[0].Steps[0].GitUser &&
+		!service.ValidAccountAccess(req, activity.Pipeline.Stages[0].Steps[0].GitUser) {
 		return fmt.Errorf("no access to '%s' git account", activity.Pipeline.Stages[0].Steps[0].GitUser)
 	}
 	err = service.UpdateActivity(activity)
@@ -296,9 +365,8 @@ func (s *Server) GetActivity(rw http.ResponseWriter, req *http.Request) error {
 	if err != nil {
 		return err
 	}
-	//validate git account access
-	if !service.ValidAccountAccess(req, a.Pipeline.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", a.Pipeline.Stages[0].Steps[0].GitUser)
+	if !service.ValidActivityAccess(req, a, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", a.Pipeline.Name)
 	}
 
 	model.ToActivityResource(apiContext, a)
@@ -306,7 +374,7 @@ func (s *Server) GetActivity(rw http.ResponseWriter, req *http.Request) error {
 	if err != nil || uid == "" {
 		logrus.Errorf("get currentUser fail,%v,%v", uid, err)
 	}
-	if a.CanApprove(uid) {
+	if a.CanApprove(uid, service.CurrentUserGroups(req)) {
 		//add approve action
 		a.Actions["approve"] = apiContext.UrlBuilder.ReferenceLink(a.Resource) + "?action=approve"
 		a.Actions["deny"] = apiContext.UrlBuilder.ReferenceLink(a.Resource) + "?action=deny"
@@ -314,6 +382,73 @@ func (s *Server) GetActivity(rw http.ResponseWriter, req *http.Request) error {
 	return apiContext.WriteResource(a)
 }
 
+//runApprovedStage runs the pending stage of the activity
+func (s *Server) runApprovedStage(r *model.Activity) error {
+	if err := service.ApproveActivity(s.Provider, r); err != nil {
+		logrus.Errorf("fail approve activity:%v", err)
+		return err
+	}
+	r.Status = model.ActivityWaiting
+	r.ActivityStages[r.PendingStage].Status = model.ActivityStageWaiting
+	r.PendingStage = 0
+	return nil
+}
+
+//getApprovalComment gets the optional comment in the body of approve and deny actions
+func getApprovalComment(req *http.Request) (string, error) {
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil || len(data) == 0 {
+		return "", err
+	}
+	body := struct {
+		Comment string `json:"comment"`
+	}{}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return "", err
+	}
+	return body.Comment, nil
+}
+
+//onApprovalTimeout denies or approves the pending stage of the activity on approval timeout
+func (s *Server) onApprovalTimeout(id string) {
+	mutex := GlobalAgent.getActivityLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, err := service.GetActivity(id)
+	if err != nil {
+		logrus.Errorf("fail getting activity with id:%v", id)
+		return
+	}
+	action := service.GetApprovalTimeoutAction(r)
+	if action == "" {
+		return
+	}
+	approved := action == model.ApprovalTimeoutApprove
+	if err = service.AddApproval(r, "", approved, "approval timed out"); err != nil {
+		logrus.Errorf("fail to add approval:%v", err)
+		return
+	}
+	if approved {
+		err = s.runApprovedStage(r)
+	} else {
+		err = service.DenyActivity(r)
+	}
+	if err != nil {
+		logrus.Errorf("fail to %s activity '%s' on approval timeout:%v", action, id, err)
+		return
+	}
+	if err = service.UpdateActivity(r); err != nil {
+		logrus.Errorf("fail update activity:%v", err)
+		return
+	}
+	s.UpdateLastActivity(r)
+	broadcastResourceChange(*r)
+	if service.IsComplete(r) {
+		go s.drainQueue(r.Pipeline.Id)
+	}
+}
+
 func priorityPendingActivity(activities []*model.Activity) []interface{} {
 	var actilist []interface{}
 	var pendinglist []interface{}
@@ -5,7 +5,6 @@ import (
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/rancher/pipeline/git"
 	"github.com/rancher/pipeline/model"
 	"github.com/rancher/pipeline/scheduler"
 	"github.com/rancher/pipeline/server/service"
@@ -31,10 +30,24 @@ type Agent struct {
 	unregisterCronRunnerC chan string
 
 	activityLocks syncmap.Map
+	pipelineLocks syncmap.Map
+
+	//recent broadcast messages to resume event streams
+	history     []WSMsg
+	historyLock sync.Mutex
 }
 
 var GlobalAgent *Agent
 
+//period to check approval timeout of pending activities
+const approvalCheckPeriod = 1 * time.Minute
+
+//period to remove activities out of the retention policy
+const retentionCheckPeriod = 1 * time.Hour
+
+//number of recent broadcast messages kept to resume event streams
+const maxHistory = 256
+
 func broadcastResourceChange(obj interface{}) {
 	resourceType := ""
 	switch obj.(type) {
@@ -77,6 +90,8 @@ func InitAgent(s *Server) {
 	logrus.Debugf("inited GlobalAgent:%v", GlobalAgent)
 	go GlobalAgent.handleWS()
 	go GlobalAgent.RunScheduler()
+	go GlobalAgent.checkApprovalTimeout()
+	go GlobalAgent.collectActivities()
 
 }
 
@@ -92,6 +107,7 @@ func (a *Agent) handleWS() {
 			}
 
 		case message := <-a.broadcast:
+			a.record(message)
 			//tell all the web socket connholder in this case
 			logrus.Debugf("broadcast %v holders!", len(a.connHolders))
 			for holder := range a.connHolders {
@@ -106,6 +122,27 @@ func (a *Agent) handleWS() {
 	}
 }
 
+func (a *Agent) record(message WSMsg) {
+	a.historyLock.Lock()
+	defer a.historyLock.Unlock()
+	a.history = append(a.history, message)
+	if len(a.history) > maxHistory {
+		a.history = a.history[len(a.history)-maxHistory:]
+	}
+}
+
+//historyAfter gets recent broadcast messages after the message of the id, nil if it is not recent
+func (a *Agent) historyAfter(id string) []WSMsg {
+	a.historyLock.Lock()
+	defer a.historyLock.Unlock()
+	for i, message := range a.history {
+		if message.Id == id {
+			return append([]WSMsg{}, a.history[i+1:]...)
+		}
+	}
+	return nil
+}
+
 func (a *Agent) RunScheduler() {
 
 	pipelines := service.ListPipelines()
@@ -126,6 +163,57 @@ func (a *Agent) RunScheduler() {
 	}
 }
 
+//checkApprovalTimeout periodically handles pending activities whose approval times out,
+//step retries and queued activities which are due but not run, e.g. scheduled before a restart
+func (a *Agent) checkApprovalTimeout() {
+	ticker := time.NewTicker(approvalCheckPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		activities, err := service.ListActivities()
+		if err != nil {
+			logrus.Errorf("fail to list activity,err:%v", err)
+			continue
+		}
+		pipelineIds := map[string]bool{}
+		for _, activity := range activities {
+			if service.GetApprovalTimeoutAction(activity) != "" {
+				a.Server.onApprovalTimeout(activity.Id)
+			}
+			for _, ref := range service.DueRetries(activity) {
+				a.Server.retryStep(activity.Id, ref.Stage, ref.Step)
+			}
+			if activity.Status == model.ActivityQueued {
+				pipelineIds[activity.Pipeline.Id] = true
+			}
+		}
+		for pipelineId := range pipelineIds {
+			a.Server.drainQueue(pipelineId)
+		}
+	}
+}
+
+//collectActivities periodically removes activities out of the retention policy
+func (a *Agent) collectActivities() {
+	if !service.RetentionEnabled() {
+		return
+	}
+	ticker := time.NewTicker(retentionCheckPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		expired, err := service.ExpiredActivities()
+		if err != nil {
+			logrus.Errorf("fail to get expired activities,err:%v", err)
+			continue
+		}
+		for _, activity := range expired {
+			a.Server.removeActivity(activity.Id)
+		}
+		if len(expired) > 0 {
+			logrus.Infof("removed %d activities out of the retention policy", len(expired))
+		}
+	}
+}
+
 func (a *Agent) onPipelineChange(p *model.Pipeline) {
 	logrus.Debugf("on pipeline change")
 	pId := p.Id
@@ -220,18 +308,7 @@ func (a *Agent) registerCronRunner(cr *scheduler.CronRunner) {
 			if ppl.CronTrigger.TriggerOnUpdate {
 				//run only when new changes exist
 
-				gitUser := ppl.Stages[0].Steps[0].GitUser
-				token, err := service.GetUserToken(gitUser)
-				if err != nil {
-					logrus.Errorf("fail to get user credential for %s: %v", gitUser, err)
-					return
-				}
-				repoUrl, err := git.GetAuthRepoUrl(ppl.Stages[0].Steps[0].Repository, gitUser, token)
-				if err != nil {
-					logrus.Errorf("get repo credential got error: %v", err)
-					return
-				}
-				latestCommit, err := git.BranchHeadCommit(repoUrl, ppl.Stages[0].Steps[0].Branch)
+				latestCommit, err := service.BranchHeadCommit(ppl.Stages[0].Steps[0])
 				if err != nil {
 					logrus.Errorf("cron job fail,Error:%v", err)
 					return
@@ -253,14 +330,16 @@ func (a *Agent) registerCronRunner(cr *scheduler.CronRunner) {
 					return
 				}
 			}
-			_, err = service.RunPipeline(a.Server.Provider, pId, model.TriggerTypeCron)
-			if err != nil {
+			_, err = a.Server.runPipeline(pId, &model.TriggerInfo{TriggerType: model.TriggerTypeCron})
+			if err == service.ErrRunSkipped {
+				return
+			} else if err != nil {
 				logrus.Errorf("cron job fail,pid:%v", pId)
 				return
 			}
 		})
 		if err != nil {
-			logrus.Error("cron addfunc error for pipeline %v:%v", pId, err)
+			logrus.Errorf("cron addfunc error for pipeline %v:%v", pId, err)
 			return
 		}
 		cr.Start()
@@ -288,3 +367,9 @@ func (a *Agent) getActivityLock(activityId string) *sync.Mutex {
 	}
 	return lock.(*sync.Mutex)
 }
+
+//getPipelineLock gets the lock to start and queue activities of the pipeline
+func (a *Agent) getPipelineLock(pipelineId string) *sync.Mutex {
+	lock, _ := a.pipelineLocks.LoadOrStore(pipelineId, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/go-rancher/api"
+	v1client "github.com/rancher/go-rancher/client"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//ListArtifacts lists artifacts archived by steps of the activity
+func (s *Server) ListArtifacts(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	activity, err := service.GetActivity(id)
+	if err != nil {
+		return err
+	}
+	if !service.ValidActivityAccess(req, activity, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", activity.Pipeline.Name)
+	}
+	result := []interface{}{}
+	for _, artifact := range activity.Artifacts {
+		result = append(result, model.ToArtifactResource(apiContext, activity.Id, artifact))
+	}
+	apiContext.Write(&v1client.GenericCollection{
+		Data: result,
+	})
+	return nil
+}
+
+//DownloadArtifact writes the content of the artifact of the activity
+func (s *Server) DownloadArtifact(rw http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	activity, err := service.GetActivity(vars["id"])
+	if err != nil {
+		return err
+	}
+	if !service.ValidActivityAccess(req, activity, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", activity.Pipeline.Name)
+	}
+	r, artifact, err := service.GetArtifact(activity, vars["name"])
+	if err != nil {
+		return fmt.Errorf("fail to get artifact '%s': %v", vars["name"], err)
+	}
+	defer r.Close()
+	rw.Header().Set("Content-Type", "application/octet-stream")
+	rw.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(artifact.Name)))
+	_, err = io.Copy(rw, r)
+	return err
+}
+
+//ArtifactsTar writes artifacts of the activity as a tar archive, used by steps to restore artifacts
+func (s *Server) ArtifactsTar(rw http.ResponseWriter, req *http.Request) error {
+	activity, err := callbackActivity(req)
+	if err != nil {
+		return err
+	}
+	rw.Header().Set("Content-Type", "application/x-tar")
+	return service.WriteArtifactsTar(activity, rw)
+}
+
+//callbackActivity gets the running activity of the event request of its step, which carries the callback token
+//of the activity in `token`
+func callbackActivity(req *http.Request) (*model.Activity, error) {
+	activity, err := service.GetActivity(req.FormValue("id"))
+	if err != nil {
+		return nil, err
+	}
+	if !service.ValidCallbackToken(activity, req.FormValue("token")) {
+		return nil, errors.New("invalid callback token of activity")
+	}
+	return activity, nil
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/go-rancher/api"
+	v1client "github.com/rancher/go-rancher/client"
+	"github.com/rancher/pipeline/artifact"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//ListCaches lists saved caches of the pipeline
+func (s *Server) ListCaches(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	ppl, err := service.GetPipelineById(id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	if !service.ValidPipelineAccess(req, ppl, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", ppl.Name)
+	}
+	caches, err := service.ListCaches(id)
+	if err != nil {
+		return err
+	}
+	result := []interface{}{}
+	for _, cache := range caches {
+		result = append(result, model.ToCacheResource(apiContext, cache))
+	}
+	apiContext.Write(&v1client.GenericCollection{
+		Data: result,
+	})
+	return nil
+}
+
+//PurgeCaches removes the cache of the key, or all caches of the pipeline if the key is not given
+func (s *Server) PurgeCaches(rw http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	ppl, err := service.GetPipelineById(vars["id"])
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	if !service.ValidPipelineAccess(req, ppl, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", ppl.Name)
+	}
+	if key := vars["key"]; key != "" {
+		if err := service.RemoveCache(ppl.Id, key); err != nil {
+			return fmt.Errorf("fail to remove cache '%s': %v", key, err)
+		}
+	} else if err := service.RemoveCaches(ppl.Id); err != nil {
+		return fmt.Errorf("fail to remove caches: %v", err)
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+//cacheOfStep gets the activity and the cache key of the step in the event request, checksums of
+//workspace files are given in `checksum=<file>:<sha256>` form. Caches are always of the pipeline of
+//the activity authorized by the callback token.
+func cacheOfStep(req *http.Request) (*model.Activity, string, error) {
+	activity, err := callbackActivity(req)
+	if err != nil {
+		return nil, "", err
+	}
+	stageOrdinal, err := strconv.Atoi(req.FormValue("stageOrdinal"))
+	if err != nil {
+		return nil, "", err
+	}
+	stepOrdinal, err := strconv.Atoi(req.FormValue("stepOrdinal"))
+	if err != nil {
+		return nil, "", err
+	}
+	if stageOrdinal < 0 || stepOrdinal < 0 || stageOrdinal >= len(activity.Pipeline.Stages) || stepOrdinal >= len(activity.Pipeline.Stages[stageOrdinal].Steps) {
+		return nil, "", fmt.Errorf("step index invalid")
+	}
+	checksums := map[string]string{}
+	for _, v := range req.URL.Query()["checksum"] {
+		if i := strings.LastIndex(v, ":"); i > 0 {
+			checksums[v[:i]] = v[i+1:]
+		}
+	}
+	key, err := service.RenderCacheKey(activity, activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal], func(file string) string {
+		return checksums[file]
+	})
+	return activity, key, err
+}
+
+//GetStepCache writes the saved cache of the step as a tar archive, not found if it is not saved
+func (s *Server) GetStepCache(rw http.ResponseWriter, req *http.Request) error {
+	activity, key, err := cacheOfStep(req)
+	if err != nil {
+		return err
+	}
+	r, err := service.GetCache(activity.Pipeline.Id, key)
+	if err == artifact.ErrNotFound {
+		rw.WriteHeader(http.StatusNotFound)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer r.Close()
+	rw.Header().Set("Content-Type", "application/x-tar")
+	_, err = io.Copy(rw, r)
+	return err
+}
+
+//SaveStepCache saves the tar archive in the body as the cache of the step
+func (s *Server) SaveStepCache(rw http.ResponseWriter, req *http.Request) error {
+	activity, key, err := cacheOfStep(req)
+	if err != nil {
+		return err
+	}
+	if service.IsForkPullRequest(activity) {
+		return fmt.Errorf("caches are not saved for pull requests of forks")
+	}
+	return service.SaveCache(activity.Pipeline.Id, key, req.Body)
+}
@@ -3,8 +3,10 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/websocket"
@@ -12,6 +14,7 @@ import (
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/pipeline/model"
 	"github.com/rancher/pipeline/server/service"
+	"github.com/rancher/pipeline/server/webhook"
 	"github.com/rancher/pipeline/util"
 )
 
@@ -19,52 +22,88 @@ func (s *Server) Webhook(rw http.ResponseWriter, req *http.Request) error {
 	logrus.Debugf("get header:%v", req.Header)
 	logrus.Debugf("get url:%v", req.RequestURI)
 
-	var manager model.SCManager
-	var err error
-	var eventType string
-	if eventType = req.Header.Get("X-GitHub-Event"); len(eventType) != 0 {
-		if eventType == "ping" {
-			return nil
-		}
-		logrus.Debug("receive webhook from github")
-		manager, err = service.GetSCManager("github")
-		if err != nil {
-			return err
-		}
-	} else if eventType = req.Header.Get("X-Gitlab-Event"); len(eventType) != 0 {
-		logrus.Debug("receive webhook from gitlab")
-		manager, err = service.GetSCManager("gitlab")
-		if err != nil {
-			return err
-		}
-	} else {
-		//TODO generic webhook
-		return errors.New("Unknown webhook source")
-	}
-
 	id := req.FormValue("pipelineId")
 	pipeline, err := service.GetPipelineById(id)
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	if !pipeline.IsActivate {
-		return errors.New("pipeline is not activated")
+	if req.Header.Get(webhook.GenericSignatureHeader) != "" || req.Header.Get(webhook.GenericTokenHeader) != "" {
+		return s.genericWebhook(rw, req, pipeline)
 	}
-	if !manager.VerifyWebhookPayload(pipeline, req) {
+	//the webhook is from the scm of the pipeline
+	manager, err := service.GetSCManagerFromUserID(pipeline.Stages[0].Steps[0].GitUser)
+	if err != nil {
+		return err
+	}
+	trigger, ok := manager.VerifyWebhookPayload(pipeline, req)
+	if !ok {
 		return errors.New("verify webhook fail")
 	}
+	if trigger == nil {
+		logrus.Debugf("receive %s webhook with nothing to run", manager.GetType())
+		return nil
+	}
+	if !pipeline.IsActivate {
+		return errors.New("pipeline is not activated")
+	}
 
 	logrus.Debugf("token validate pass")
 
-	if _, err = service.RunPipeline(s.Provider, id, model.TriggerTypeWebhook); err != nil {
+	activity, err := s.runPipeline(id, trigger)
+	if err == service.ErrRunSkipped {
+		rw.Write([]byte("skip run at max concurrency"))
+		return nil
+	} else if err != nil {
 		rw.Write([]byte("run pipeline error!"))
 		return err
 	}
+	s.cancelSuperseded(activity)
 	rw.Write([]byte("run pipeline success!"))
 	logrus.Infof("webhook trigger run for '%s' success", pipeline.Name)
 	return nil
 }
 
+//genericWebhook runs the pipeline with generic webhook enabled by any JSON POST,
+//values extracted from the payload are set to env vars of the activity
+func (s *Server) genericWebhook(rw http.ResponseWriter, req *http.Request, pipeline *model.Pipeline) error {
+	if pipeline.GenericWebhook == nil || !pipeline.GenericWebhook.Enabled {
+		return errors.New("generic webhook is not enabled")
+	}
+	if !pipeline.IsActivate {
+		return errors.New("pipeline is not activated")
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	if !webhook.VerifyGenericPayload(pipeline.GenericWebhook, req, body) {
+		return errors.New("verify webhook fail")
+	}
+	params, matched, err := webhook.ExtractParams(pipeline.GenericWebhook, body)
+	if err != nil {
+		return err
+	}
+	if !matched {
+		logrus.Debugf("generic webhook for '%s' is filtered", pipeline.Name)
+		rw.Write([]byte("skip run by filter"))
+		return nil
+	}
+	trigger := &model.TriggerInfo{
+		TriggerType: model.TriggerTypeGenericWebhook,
+		EnvVars:     params,
+	}
+	if _, err = s.runPipeline(pipeline.Id, trigger); err == service.ErrRunSkipped {
+		rw.Write([]byte("skip run at max concurrency"))
+		return nil
+	} else if err != nil {
+		rw.Write([]byte("run pipeline error!"))
+		return err
+	}
+	rw.Write([]byte("run pipeline success!"))
+	logrus.Infof("generic webhook trigger run for '%s' success", pipeline.Name)
+	return nil
+}
+
 func (s *Server) ServeStatusWS(w http.ResponseWriter, r *http.Request) error {
 	apiContext := api.GetApiContext(r)
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -84,7 +123,7 @@ func (s *Server) ServeStatusWS(w http.ResponseWriter, r *http.Request) error {
 	connHolder.agent.register <- connHolder
 
 	//new go routines
-	go connHolder.DoWrite(apiContext, uid)
+	go connHolder.DoWrite(apiContext, uid, service.CurrentUserGroups(r))
 	connHolder.DoRead()
 
 	return nil
@@ -155,19 +194,56 @@ func (s *Server) StepFinish(rw http.ResponseWriter, req *http.Request) error {
 	if stageOrdinal < 0 || stepOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
 		return errors.New("step index invalid")
 	}
+	//update commitinfo for SCM step, before next steps are triggered
+	if stageOrdinal == 0 && stepOrdinal == 0 {
+		activity.CommitInfo = req.FormValue("GIT_COMMIT")
+		activity.EnvVars["CICD_GIT_COMMIT"] = activity.CommitInfo
+	}
+
+	step := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal]
+	if status == "SUCCESS" && len(step.Artifacts) > 0 {
+		//archive before the workspace may be removed on completion
+		artifacts, err := s.Provider.ArchiveArtifacts(activity, stageOrdinal, stepOrdinal)
+		if err != nil {
+			logrus.Errorf("fail to archive artifacts of step '%s': %v", step.Name, err)
+			status = "FAILURE"
+		}
+		service.AddArtifacts(activity, artifacts)
+	}
+	prevLog := ""
+	stepLog, err := s.Provider.GetStepLog(activity, stageOrdinal, stepOrdinal, map[string]interface{}{"prevLog": &prevLog})
+	if err != nil {
+		logrus.Errorf("fail to get log of step '%s': %v", step.Name, err)
+	}
+	retry := false
+	if step.Retry != nil && (status == "SUCCESS" || status == "FAILURE") {
+		exitCode, err := strconv.Atoi(req.FormValue("exitCode"))
+		if err != nil {
+			exitCode = -1
+		}
+		timedOut := req.FormValue("timeout") == "true"
+		service.RecordStepAttempt(activity, stageOrdinal, stepOrdinal, status, exitCode, timedOut, stepLog)
+		retry = status == "FAILURE" && service.IsStepRetryable(activity, stageOrdinal, stepOrdinal, exitCode, timedOut)
+	}
+	if !retry {
+		//keep the log after jobs of the step are cleaned
+		if err := service.SaveStepLog(activity, stageOrdinal, stepOrdinal, stepLog, status); err != nil {
+			logrus.Errorf("fail to save log of step '%s': %v", step.Name, err)
+		}
+	}
 	if status == "SUCCESS" {
 		service.SuccessStep(activity, stageOrdinal, stepOrdinal)
 		service.Triggernext(activity, stageOrdinal, stepOrdinal, s.Provider)
+	} else if retry {
+		backoff := service.WaitStepRetry(activity, stageOrdinal, stepOrdinal)
+		logrus.Infof("retry step '%s' of activity '%s' in %v", step.Name, activityId, backoff)
+		time.AfterFunc(backoff, func() {
+			s.retryStep(activityId, stageOrdinal, stepOrdinal)
+		})
 	} else if status == "FAILURE" {
 		service.FailStep(activity, stageOrdinal, stepOrdinal)
 	}
 
-	//update commitinfo for SCM step
-	if stageOrdinal == 0 && stepOrdinal == 0 {
-		activity.CommitInfo = req.FormValue("GIT_COMMIT")
-		activity.EnvVars["CICD_GIT_COMMIT"] = activity.CommitInfo
-	}
-
 	if err = service.UpdateActivity(activity); err != nil {
 		return err
 	}
@@ -177,11 +253,42 @@ func (s *Server) StepFinish(rw http.ResponseWriter, req *http.Request) error {
 
 	if service.IsComplete(activity) {
 		s.Provider.OnActivityCompelte(activity)
+		go s.drainQueue(activity.Pipeline.Id)
 	}
 
 	return nil
 }
 
+//retryStep runs the next attempt of the step if it still waits for retry
+func (s *Server) retryStep(activityId string, stageOrdinal int, stepOrdinal int) {
+	mutex := GlobalAgent.getActivityLock(activityId)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	activity, err := service.GetActivity(activityId)
+	if err != nil {
+		logrus.Errorf("fail getting activity with id:%v", activityId)
+		return
+	}
+	if !service.IsWaitingRetry(activity, stageOrdinal, stepOrdinal) {
+		return
+	}
+	if err := service.RetryStep(activity, stageOrdinal, stepOrdinal, s.Provider); err != nil {
+		logrus.Errorf("fail to retry step %d of stage %d in activity '%s': %v", stepOrdinal+1, stageOrdinal+1, activityId, err)
+		service.FailStep(activity, stageOrdinal, stepOrdinal)
+	}
+	if err := service.UpdateActivity(activity); err != nil {
+		logrus.Errorf("fail update activity:%v", err)
+		return
+	}
+	broadcastResourceChange(*activity)
+	s.UpdateLastActivity(activity)
+	if service.IsComplete(activity) {
+		s.Provider.OnActivityCompelte(activity)
+		go s.drainQueue(activity.Pipeline.Id)
+	}
+}
+
 func (s *Server) Reset(rw http.ResponseWriter, req *http.Request) error {
 	return service.Reset()
 }
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//runPipeline runs the pipeline under its concurrency setting. At max concurrency the run is queued,
+//or oldest running activities are stopped to make room, or the run is skipped with ErrRunSkipped.
+func (s *Server) runPipeline(id string, trigger *model.TriggerInfo) (*model.Activity, error) {
+	mutex := GlobalAgent.getPipelineLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	pp, err := service.GetPipelineById(id)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	running, queued, err := service.PipelineRuns(id)
+	if err != nil {
+		return nil, err
+	}
+	if !service.IsAtMaxConcurrency(pp, running, queued) {
+		return service.RunPipeline(s.Provider, id, trigger)
+	}
+	switch service.ConcurrencyPolicy(pp) {
+	case model.ConcurrencySkip:
+		logrus.Infof("skip %s run of pipeline '%s' with %d running activities", trigger.TriggerType, pp.Name, len(running))
+		return nil, service.ErrRunSkipped
+	case model.ConcurrencyCancelPrevious:
+		//queued activities are stale as well
+		for _, activity := range queued {
+			s.cancelActivity(activity.Id, "canceled by a newer run")
+		}
+		for i := 0; i <= len(running)-pp.Concurrency.Max; i++ {
+			s.cancelActivity(running[i].Id, "canceled by a newer run")
+		}
+		return service.RunPipeline(s.Provider, id, trigger)
+	}
+	activity, err := service.QueueActivity(id, trigger)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("queue %s run of pipeline '%s' at position %d", trigger.TriggerType, pp.Name, activity.QueuePosition)
+	broadcastResourceChange(*activity)
+	return activity, nil
+}
+
+//cancelActivity stops the running or queued activity, the reason is set to the fail message
+func (s *Server) cancelActivity(id string, reason string) {
+	mutex := GlobalAgent.getActivityLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, err := service.GetActivity(id)
+	if err != nil {
+		logrus.Errorf("fail getting activity with id:%v", id)
+		return
+	}
+	queued := r.Status == model.ActivityQueued
+	if !service.IsRunning(r) && !queued {
+		return
+	}
+	if err = service.StopActivity(s.Provider, r); err != nil {
+		logrus.Errorf("fail stop activity:%v", err)
+		return
+	}
+	r.FailMessage = reason
+	if err = service.UpdateActivity(r); err != nil {
+		logrus.Errorf("fail update activity:%v", err)
+		return
+	}
+	broadcastResourceChange(*r)
+	s.UpdateLastActivity(r)
+	if !queued {
+		s.Provider.OnActivityCompelte(r)
+	}
+}
+
+//cancelSuperseded stops older activities of the same branch as the newer webhook run if auto cancel is enabled
+func (s *Server) cancelSuperseded(newer *model.Activity) {
+	pp, err := service.GetPipelineById(newer.Pipeline.Id)
+	if err != nil {
+		return
+	}
+	superseded, err := service.SupersededActivities(pp, newer)
+	if err != nil {
+		logrus.Errorf("fail to get superseded activities of pipeline '%s': %v", pp.Name, err)
+		return
+	}
+	for _, activity := range superseded {
+		logrus.Infof("activity '%s' is superseded by '%s'", activity.Id, newer.Id)
+		s.cancelActivity(activity.Id, "superseded by "+newer.Id)
+	}
+	if len(superseded) > 0 {
+		//the newer run may be queued
+		go s.drainQueue(pp.Id)
+	}
+}
+
+//drainQueue runs queued activities of the pipeline while it is under its max concurrency,
+//then updates positions of the remaining ones. It is called when activities complete.
+func (s *Server) drainQueue(pipelineId string) {
+	mutex := GlobalAgent.getPipelineLock(pipelineId)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	pp, err := service.GetPipelineById(pipelineId)
+	if err != nil {
+		return
+	}
+	running, queued, err := service.PipelineRuns(pipelineId)
+	if err != nil {
+		logrus.Errorf("fail to get runs of pipeline '%s': %v", pp.Name, err)
+		return
+	}
+	max := 0
+	if pp.Concurrency != nil {
+		max = pp.Concurrency.Max
+	}
+	for len(queued) > 0 && (max <= 0 || len(running) < max) {
+		if s.runQueuedActivity(queued[0].Id) {
+			running = append(running, queued[0])
+		}
+		queued = queued[1:]
+	}
+	for i, activity := range queued {
+		if activity.QueuePosition != i+1 {
+			s.setQueuePosition(activity.Id, i+1)
+		}
+	}
+}
+
+//runQueuedActivity runs the activity if it is still queued, returns whether it is started
+func (s *Server) runQueuedActivity(id string) bool {
+	mutex := GlobalAgent.getActivityLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, err := service.GetActivity(id)
+	if err != nil || r.Status != model.ActivityQueued {
+		return false
+	}
+	started := true
+	if err = service.RunQueuedActivity(s.Provider, r); err != nil {
+		logrus.Errorf("fail to run queued activity '%s': %v", id, err)
+		r.Status = model.ActivityFail
+		r.FailMessage = fmt.Sprintf("fail to run queued activity: %v", err)
+		started = false
+	}
+	if err = service.UpdateActivity(r); err != nil {
+		logrus.Errorf("fail update activity:%v", err)
+		return started
+	}
+	broadcastResourceChange(*r)
+	s.UpdateLastActivity(r)
+	return started
+}
+
+func (s *Server) setQueuePosition(id string, position int) {
+	mutex := GlobalAgent.getActivityLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, err := service.GetActivity(id)
+	if err != nil || r.Status != model.ActivityQueued {
+		return
+	}
+	r.QueuePosition = position
+	if err = service.UpdateActivity(r); err != nil {
+		logrus.Errorf("fail update activity:%v", err)
+		return
+	}
+	broadcastResourceChange(*r)
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+	"github.com/rancher/pipeline/store"
+)
+
+//initTestServer runs a server of the provider on a SQLite store of a temp dir, broadcast messages are dropped
+func initTestServer(t *testing.T, provider model.PipelineProvider) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "pipeline-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.NewSQLiteStore(filepath.Join(dir, "pipeline.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("fail to create store: %v", err)
+	}
+	service.InitStore(s)
+	server := NewServer(provider)
+	broadcast := make(chan WSMsg)
+	GlobalAgent = &Agent{Server: server, broadcast: broadcast}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-broadcast:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return server, func() {
+		close(done)
+		os.RemoveAll(dir)
+	}
+}
+
+//fakeProvider runs activities by updating their status, reruns of queued activities fail with rerunErr
+//and cleaning deleted activities fails with deleteErr
+type fakeProvider struct {
+	model.PipelineProvider
+	mu        sync.Mutex
+	seq       int
+	rerunErr  map[string]error
+	deleteErr error
+	reruns    []string
+	stops     []string
+	deleted   []string
+}
+
+func (f *fakeProvider) RunPipeline(p *model.Pipeline, trigger *model.TriggerInfo) (*model.Activity, error) {
+	f.mu.Lock()
+	f.seq++
+	seq := f.seq
+	f.mu.Unlock()
+	activity := &model.Activity{
+		Id:          fmt.Sprintf("a%d", seq),
+		Pipeline:    *p,
+		Status:      model.ActivityBuilding,
+		StartTS:     int64(seq),
+		RunSequence: seq,
+		TriggerType: trigger.TriggerType,
+		PullRequest: trigger.PullRequest,
+	}
+	for _, stage := range p.Stages {
+		activity.ActivityStages = append(activity.ActivityStages, service.ToActivityStage(stage))
+	}
+	if err := service.CreateActivity(activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+func (f *fakeProvider) RerunActivity(activity *model.Activity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.rerunErr[activity.Id]; err != nil {
+		return err
+	}
+	f.reruns = append(f.reruns, activity.Id)
+	activity.Status = model.ActivityBuilding
+	return nil
+}
+
+func (f *fakeProvider) StopActivity(activity *model.Activity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stops = append(f.stops, activity.Id)
+	activity.Status = model.ActivityAbort
+	return nil
+}
+
+func (f *fakeProvider) OnActivityCompelte(activity *model.Activity) {}
+
+func (f *fakeProvider) OnDeleteActivity(activity *model.Activity) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.deleteErr != nil {
+		return f.deleteErr
+	}
+	f.deleted = append(f.deleted, activity.Id)
+	return nil
+}
+
+func createConcurrencyPipeline(t *testing.T, concurrency *model.PipelineConcurrency, autoCancel *model.AutoCancel) {
+	p := &model.Pipeline{
+		Id:   "p1",
+		Name: "p",
+		Stages: []*model.Stage{
+			{Steps: []*model.Step{{Type: model.StepTypeSCM, Repository: "https://github.com/user/repo.git", Branch: "master"}}},
+			{Steps: []*model.Step{{Name: "build", Type: model.StepTypeTask}}},
+		},
+		Concurrency: concurrency,
+		AutoCancel:  autoCancel,
+	}
+	if err := service.CreatePipeline(p); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTestActivity(t *testing.T, id string) *model.Activity {
+	activity, err := service.GetActivity(id)
+	if err != nil {
+		t.Fatalf("fail to get activity %s: %v", id, err)
+	}
+	return activity
+}
+
+//finishActivity completes the running activity and drains the queue as the server does on completion
+func finishActivity(t *testing.T, s *Server, id string) {
+	activity := getTestActivity(t, id)
+	activity.Status = model.ActivitySuccess
+	if err := service.UpdateActivity(activity); err != nil {
+		t.Fatal(err)
+	}
+	s.drainQueue(activity.Pipeline.Id)
+}
+
+var manualTrigger = &model.TriggerInfo{TriggerType: model.TriggerTypeManual}
+
+func TestRunPipelineQueue(t *testing.T) {
+	provider := &fakeProvider{}
+	s, cleanup := initTestServer(t, provider)
+	defer cleanup()
+	createConcurrencyPipeline(t, &model.PipelineConcurrency{Max: 1}, nil)
+
+	first, err := s.runPipeline("p1", manualTrigger)
+	if err != nil || first.Status != model.ActivityBuilding {
+		t.Fatalf("got first run %v, error %v, want it running", first, err)
+	}
+	queued := []string{}
+	for i := 1; i <= 3; i++ {
+		activity, err := s.runPipeline("p1", manualTrigger)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		if activity.Status != model.ActivityQueued || activity.QueuePosition != i {
+			t.Errorf("got status %s at position %d, want queued at %d", activity.Status, activity.QueuePosition, i)
+		}
+		queued = append(queued, activity.Id)
+		//queued activities are ordered by their start time
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	//each completion runs the oldest queued activity and moves the others up
+	finishActivity(t, s, first.Id)
+	if r := getTestActivity(t, queued[0]); r.Status != model.ActivityBuilding {
+		t.Errorf("got status %s of the oldest queued activity, want running", r.Status)
+	}
+	for i, id := range queued[1:] {
+		if r := getTestActivity(t, id); r.Status != model.ActivityQueued || r.QueuePosition != i+1 {
+			t.Errorf("got status %s at position %d of %s, want queued at %d", r.Status, r.QueuePosition, id, i+1)
+		}
+	}
+	finishActivity(t, s, queued[0])
+	finishActivity(t, s, queued[1])
+	if want := queued; fmt.Sprint(provider.reruns) != fmt.Sprint(want) {
+		t.Errorf("got queued activities run in order %v, want %v", provider.reruns, want)
+	}
+	if pp, _ := service.GetPipelineById("p1"); pp.LastRunId != queued[2] {
+		t.Errorf("got last run %s, want %s", pp.LastRunId, queued[2])
+	}
+}
+
+func TestRunPipelineSkip(t *testing.T) {
+	provider := &fakeProvider{}
+	s, cleanup := initTestServer(t, provider)
+	defer cleanup()
+	createConcurrencyPipeline(t, &model.PipelineConcurrency{Max: 1, Policy: model.ConcurrencySkip}, nil)
+
+	if _, err := s.runPipeline("p1", manualTrigger); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if _, err := s.runPipeline("p1", manualTrigger); err != service.ErrRunSkipped {
+		t.Errorf("got error %v, want %v", err, service.ErrRunSkipped)
+	}
+	if running, queued, _ := service.PipelineRuns("p1"); len(running) != 1 || len(queued) != 0 {
+		t.Errorf("got %d running and %d queued activities, want 1 running", len(running), len(queued))
+	}
+}
+
+func TestRunPipelineCancelPrevious(t *testing.T) {
+	provider := &fakeProvider{}
+	s, cleanup := initTestServer(t, provider)
+	defer cleanup()
+	createConcurrencyPipeline(t, &model.PipelineConcurrency{Max: 2, Policy: model.ConcurrencyCancelPrevious}, nil)
+
+	a1, _ := s.runPipeline("p1", manualTrigger)
+	a2, _ := s.runPipeline("p1", manualTrigger)
+	a3, err := s.runPipeline("p1", manualTrigger)
+	if err != nil || a3.Status != model.ActivityBuilding {
+		t.Fatalf("got newer run %v, error %v, want it running", a3, err)
+	}
+	//only the oldest run is canceled to make room
+	if r := getTestActivity(t, a1.Id); r.Status != model.ActivityAbort || r.FailMessage != "canceled by a newer run" {
+		t.Errorf("got oldest activity %s with message %q, want it canceled", r.Status, r.FailMessage)
+	}
+	if r := getTestActivity(t, a2.Id); r.Status != model.ActivityBuilding {
+		t.Errorf("got status %s of the previous activity, want running", r.Status)
+	}
+	if fmt.Sprint(provider.stops) != fmt.Sprint([]string{a1.Id}) {
+		t.Errorf("got stopped activities %v, want [%s]", provider.stops, a1.Id)
+	}
+}
+
+//TestDrainQueueRunError checks that a queued activity failing to run releases its slot to the next one
+func TestDrainQueueRunError(t *testing.T) {
+	provider := &fakeProvider{}
+	s, cleanup := initTestServer(t, provider)
+	defer cleanup()
+	createConcurrencyPipeline(t, &model.PipelineConcurrency{Max: 1}, nil)
+
+	first, _ := s.runPipeline("p1", manualTrigger)
+	q1, _ := s.runPipeline("p1", manualTrigger)
+	time.Sleep(2 * time.Millisecond)
+	q2, _ := s.runPipeline("p1", manualTrigger)
+	provider.rerunErr = map[string]error{q1.Id: errors.New("jenkins is down")}
+
+	finishActivity(t, s, first.Id)
+	if r := getTestActivity(t, q1.Id); r.Status != model.ActivityFail || r.FailMessage == "" {
+		t.Errorf("got status %s with message %q of the activity failing to run, want failed", r.Status, r.FailMessage)
+	}
+	if r := getTestActivity(t, q2.Id); r.Status != model.ActivityBuilding {
+		t.Errorf("got status %s of the next queued activity, want running", r.Status)
+	}
+}
+
+func TestCancelSuperseded(t *testing.T) {
+	provider := &fakeProvider{}
+	s, cleanup := initTestServer(t, provider)
+	defer cleanup()
+	createConcurrencyPipeline(t, &model.PipelineConcurrency{Max: 1}, &model.AutoCancel{Enabled: true})
+
+	older, _ := s.runPipeline("p1", manualTrigger)
+	time.Sleep(2 * time.Millisecond)
+	newer, err := s.runPipeline("p1", &model.TriggerInfo{TriggerType: model.TriggerTypeWebhook, Commit: "c2"})
+	if err != nil || newer.Status != model.ActivityQueued {
+		t.Fatalf("got newer run %v, error %v, want it queued", newer, err)
+	}
+
+	s.cancelSuperseded(newer)
+	if r := getTestActivity(t, older.Id); r.Status != model.ActivityAbort || r.FailMessage != "superseded by "+newer.Id {
+		t.Errorf("got older activity %s with message %q, want it superseded", r.Status, r.FailMessage)
+	}
+	//the newer run takes the slot of the superseded one
+	deadline := time.Now().Add(5 * time.Second)
+	for getTestActivity(t, newer.Id).Status != model.ActivityBuilding {
+		if time.Now().After(deadline) {
+			t.Fatal("newer activity is not run after the superseded one is canceled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	//wait for the drain to finish
+	lock := GlobalAgent.getPipelineLock("p1")
+	lock.Lock()
+	lock.Unlock()
+}
+
+func TestCancelSupersededKeepsPending(t *testing.T) {
+	provider := &fakeProvider{}
+	s, cleanup := initTestServer(t, provider)
+	defer cleanup()
+	createConcurrencyPipeline(t, nil, &model.AutoCancel{Enabled: true})
+
+	pending, _ := s.runPipeline("p1", manualTrigger)
+	pending.Status = model.ActivityPending
+	service.UpdateActivity(pending)
+	pr, _ := s.runPipeline("p1", &model.TriggerInfo{TriggerType: model.TriggerTypeWebhook, PullRequest: &model.PullRequest{Number: 1}})
+	newer, _ := s.runPipeline("p1", &model.TriggerInfo{TriggerType: model.TriggerTypeWebhook, Commit: "c3"})
+
+	s.cancelSuperseded(newer)
+	if r := getTestActivity(t, pending.Id); r.Status != model.ActivityPending {
+		t.Errorf("got status %s of the activity waiting for approval, want it kept", r.Status)
+	}
+	if r := getTestActivity(t, pr.Id); r.Status != model.ActivityBuilding {
+		t.Errorf("got status %s of the pull request activity, want it kept", r.Status)
+	}
+	if len(provider.stops) != 0 {
+		t.Errorf("got stopped activities %v, want none", provider.stops)
+	}
+}
@@ -43,7 +43,7 @@ func (c *ConnHolder) DoRead() {
 	}
 }
 
-func (c *ConnHolder) DoWrite(apiContext *api.ApiContext, uid string) {
+func (c *ConnHolder) DoWrite(apiContext *api.ApiContext, uid string, groups []string) {
 	pingTicker := time.NewTicker(pingPeriod)
 	pollTicker := time.NewTicker(pollPeriod)
 	defer func() {
@@ -60,38 +60,10 @@ func (c *ConnHolder) DoWrite(apiContext *api.ApiContext, uid string) {
 				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			switch v := message.Data.(type) {
-			case model.Activity:
-				if !service.ValidAccountAccessById(uid, v.Pipeline.Stages[0].Steps[0].GitUser) {
-					continue
-				}
-				model.ToActivityResource(apiContext, &v)
-				if v.CanApprove(uid) {
-					//add approve action
-					v.Actions["approve"] = apiContext.UrlBuilder.ReferenceLink(v.Resource) + "?action=approve"
-					v.Actions["deny"] = apiContext.UrlBuilder.ReferenceLink(v.Resource) + "?action=deny"
-				}
-				message.Data = v
-			case model.Pipeline:
-				if !service.ValidAccountAccessById(uid, v.Stages[0].Steps[0].GitUser) {
-					continue
-				}
-				model.ToPipelineResource(apiContext, &v)
-				message.Data = v
-			case model.GitAccount:
-				if v.RancherUserID != uid && v.Private {
-					continue
-				}
-				model.ToAccountResource(apiContext, &v)
-				message.Data = v
-			case model.PipelineSetting:
-				model.ToPipelineSettingResource(apiContext, &v)
-				message.Data = v
-			case model.SCMSetting:
-				model.ToSCMSettingResource(apiContext, &v)
-				message.Data = v
+			message, ok = filterMessage(apiContext, uid, groups, message)
+			if !ok {
+				continue
 			}
-
 			b, err := json.Marshal(message)
 			if err != nil {
 				return
@@ -114,3 +86,39 @@ func (c *ConnHolder) DoWrite(apiContext *api.ApiContext, uid string) {
 		}
 	}
 }
+
+//filterMessage converts the resource of the message for the user, returns false if the user has no access to it
+func filterMessage(apiContext *api.ApiContext, uid string, groups []string, message WSMsg) (WSMsg, bool) {
+	switch v := message.Data.(type) {
+	case model.Activity:
+		if !service.ValidActivityAccessById(uid, &v, model.RoleReader) {
+			return message, false
+		}
+		model.ToActivityResource(apiContext, &v)
+		if v.CanApprove(uid, groups) {
+			//add approve action
+			v.Actions["approve"] = apiContext.UrlBuilder.ReferenceLink(v.Resource) + "?action=approve"
+			v.Actions["deny"] = apiContext.UrlBuilder.ReferenceLink(v.Resource) + "?action=deny"
+		}
+		message.Data = v
+	case model.Pipeline:
+		if !service.ValidPipelineAccessById(uid, &v, model.RoleReader) {
+			return message, false
+		}
+		model.ToPipelineResource(apiContext, &v)
+		message.Data = v
+	case model.GitAccount:
+		if v.RancherUserID != uid && v.Private {
+			return message, false
+		}
+		model.ToAccountResource(apiContext, &v)
+		message.Data = v
+	case model.PipelineSetting:
+		model.ToPipelineSettingResource(apiContext, &v)
+		message.Data = v
+	case model.SCMSetting:
+		model.ToSCMSettingResource(apiContext, &v)
+		message.Data = v
+	}
+	return message, true
+}
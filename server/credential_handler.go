@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/rancher/go-rancher/api"
+	v1client "github.com/rancher/go-rancher/client"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+	"github.com/sluu99/uuid"
+)
+
+func (s *Server) ListGitCredentials(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	creds, err := service.ListGitCredentials(service.CurrentUser(req))
+	if err != nil {
+		return err
+	}
+	result := []interface{}{}
+	for _, cred := range creds {
+		result = append(result, model.ToCredentialResource(apiContext, cred))
+	}
+	apiContext.Write(&v1client.GenericCollection{
+		Data: result,
+	})
+	return nil
+}
+
+func (s *Server) GetGitCredential(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	cred, err := service.GetGitCredential(id)
+	if err != nil {
+		return err
+	}
+	if !service.ValidCredentialAccess(req, cred) {
+		return fmt.Errorf("no access to git credential '%s'", id)
+	}
+	return apiContext.WriteResource(model.ToCredentialResource(apiContext, cred))
+}
+
+func (s *Server) CreateGitCredential(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	cred := &model.Credential{}
+	if err := json.Unmarshal(data, cred); err != nil {
+		return err
+	}
+	cred.Id = uuid.Rand().Hex()
+	cred.Owner = service.CurrentUser(req)
+	if cred.Owner == "" {
+		return fmt.Errorf("fail to get current user")
+	}
+	if err := service.CreateGitCredential(cred); err != nil {
+		return err
+	}
+	if err := s.Provider.OnCreateCredential(cred); err != nil {
+		return err
+	}
+	return apiContext.WriteResource(model.ToCredentialResource(apiContext, cred))
+}
+
+func (s *Server) UpdateGitCredential(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	cred := &model.Credential{}
+	if err := json.Unmarshal(data, cred); err != nil {
+		return err
+	}
+	cred.Id = id
+	if err := validCredentialOwner(req, id); err != nil {
+		return err
+	}
+	if err := service.UpdateGitCredential(cred); err != nil {
+		return err
+	}
+	if err := s.Provider.OnCreateCredential(cred); err != nil {
+		return err
+	}
+	return apiContext.WriteResource(model.ToCredentialResource(apiContext, cred))
+}
+
+func (s *Server) RemoveGitCredential(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	if err := validCredentialOwner(req, id); err != nil {
+		return err
+	}
+	cred, err := service.RemoveGitCredential(id)
+	if err != nil {
+		return err
+	}
+	if err := s.Provider.OnDeleteCredential(cred); err != nil {
+		return err
+	}
+	return apiContext.WriteResource(model.ToCredentialResource(apiContext, cred))
+}
+
+//validCredentialOwner checks the current user owns the git credential to change it
+func validCredentialOwner(req *http.Request, id string) error {
+	cred, err := service.GetGitCredential(id)
+	if err != nil {
+		return err
+	}
+	if !service.ValidCredentialAccess(req, cred) {
+		return fmt.Errorf("only the owner of git credential '%s' can change it", cred.Name)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//DownloadStepLog writes the log of the step as a plain text file, or gzip file with `format=gzip`.
+//The log of a former attempt of the step with retry is written with `attempt=<n>`.
+func (s *Server) DownloadStepLog(rw http.ResponseWriter, req *http.Request) error {
+	vars := mux.Vars(req)
+	activity, err := service.GetActivity(vars["id"])
+	if err != nil {
+		return err
+	}
+	if !service.ValidActivityAccess(req, activity, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", activity.Pipeline.Name)
+	}
+	stageOrdinal, err := strconv.Atoi(vars["stageOrdinal"])
+	if err != nil {
+		return err
+	}
+	stepOrdinal, err := strconv.Atoi(vars["stepOrdinal"])
+	if err != nil {
+		return err
+	}
+	if stageOrdinal < 0 || stepOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
+		return errors.New("step index invalid")
+	}
+	if a := req.URL.Query().Get("attempt"); a != "" {
+		attempt, err := strconv.Atoi(a)
+		if err != nil {
+			return fmt.Errorf("invalid attempt: %v", err)
+		}
+		stepLog, err := service.GetSavedAttemptLog(activity.Id, stageOrdinal, stepOrdinal, attempt)
+		if err != nil {
+			return fmt.Errorf("fail to get log of attempt %d: %v", attempt, err)
+		}
+		if stepLog, err = service.MaskSecrets(activity, stepLog); err != nil {
+			return err
+		}
+		return writeLogFile(rw, req, fmt.Sprintf("%s-%d-%d.%d.log", activity.Id, stageOrdinal, stepOrdinal, attempt), stepLog)
+	}
+	stepLog, err := s.getStepLog(activity, stageOrdinal, stepOrdinal)
+	if err != nil {
+		return fmt.Errorf("fail to get step log: %v", err)
+	}
+	return writeLogFile(rw, req, fmt.Sprintf("%s-%d-%d.log", activity.Id, stageOrdinal, stepOrdinal), stepLog)
+}
+
+//DownloadActivityLog writes logs of the run steps of the activity as a plain text file, or gzip file with `format=gzip`
+func (s *Server) DownloadActivityLog(rw http.ResponseWriter, req *http.Request) error {
+	activity, err := service.GetActivity(mux.Vars(req)["id"])
+	if err != nil {
+		return err
+	}
+	if !service.ValidActivityAccess(req, activity, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", activity.Pipeline.Name)
+	}
+	b := &bytes.Buffer{}
+	for i, stage := range activity.ActivityStages {
+		for j, step := range stage.ActivitySteps {
+			if step.Status == model.ActivityStepWaiting || step.Status == model.ActivityStepSkip {
+				continue
+			}
+			stepLog, err := s.getStepLog(activity, i, j)
+			if err != nil {
+				logrus.Errorf("fail to get log of step %d of stage %d in activity '%s': %v", j+1, i+1, activity.Id, err)
+				stepLog = fmt.Sprintf("fail to get step log: %v\n", err)
+			}
+			fmt.Fprintf(b, "==> %s / %s <==\n", stage.Name, activity.Pipeline.Stages[i].Steps[j].Name)
+			b.WriteString(stepLog)
+			if !strings.HasSuffix(stepLog, "\n") {
+				b.WriteString("\n")
+			}
+		}
+	}
+	return writeLogFile(rw, req, activity.Id+".log", b.String())
+}
+
+//getStepLog gets the stored log of the finished step, or the log from the provider, with secrets masked
+func (s *Server) getStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int) (string, error) {
+	if service.IsStepFinished(activity, stageOrdinal, stepOrdinal) {
+		if stepLog, err := service.GetSavedStepLog(activity.Id, stageOrdinal, stepOrdinal); err == nil {
+			return service.MaskSecrets(activity, stepLog)
+		}
+	}
+	prevLog := ""
+	stepLog, err := s.Provider.GetStepLog(activity, stageOrdinal, stepOrdinal, map[string]interface{}{"prevLog": &prevLog})
+	if err != nil {
+		return "", err
+	}
+	return service.MaskSecrets(activity, completeLines(stepLog))
+}
+
+func writeLogFile(rw http.ResponseWriter, req *http.Request, name string, content string) error {
+	if req.URL.Query().Get("format") == "gzip" {
+		rw.Header().Set("Content-Type", "application/gzip")
+		rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".gz"))
+		w := gzip.NewWriter(rw)
+		if _, err := io.WriteString(w, content); err != nil {
+			return err
+		}
+		return w.Close()
+	}
+	rw.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	_, err := io.WriteString(rw, content)
+	return err
+}
@@ -12,7 +12,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/rancher/go-rancher/api"
 	"github.com/rancher/go-rancher/client"
-	v2client "github.com/rancher/go-rancher/v2"
 	"github.com/rancher/pipeline/model"
 	"github.com/rancher/pipeline/server/service"
 	"github.com/rancher/pipeline/server/webhook"
@@ -28,7 +27,7 @@ func (s *Server) ListPipelines(rw http.ResponseWriter, req *http.Request) error
 	if err != nil || uid == "" {
 		logrus.Debugf("getAccessibleAccounts unrecognized user")
 	}
-	pipelines := service.ListPipelines()
+	pipelines := service.FilterPipelinesByRole(uid, service.ListPipelines(), model.RoleReader)
 
 	apiContext.Write(&client.GenericCollection{
 		Data: model.ToPipelineCollections(apiContext, pipelines),
@@ -43,6 +42,9 @@ func (s *Server) ListPipeline(rw http.ResponseWriter, req *http.Request) error {
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
+	if !service.ValidPipelineAccess(req, r, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", r.Name)
+	}
 	apiContext.Write(model.ToPipelineResource(apiContext, r))
 	return nil
 }
@@ -72,6 +74,11 @@ func (s *Server) CreatePipeline(rw http.ResponseWriter, req *http.Request) error
 		logrus.Debugf("got imported pipeline:\n%v", ppl)
 	}
 	service.CleanPipeline(ppl)
+	//the owner is validated to use git credentials
+	ppl.Owner = service.CurrentUser(req)
+	if ppl.Owner == "" {
+		return fmt.Errorf("fail to get current user")
+	}
 
 	if err := service.Validate(ppl); err != nil {
 		return err
@@ -80,22 +87,22 @@ func (s *Server) CreatePipeline(rw http.ResponseWriter, req *http.Request) error
 	if !service.ValidAccountAccess(req, ppl.Stages[0].Steps[0].GitUser) {
 		return fmt.Errorf("no access to '%s' git account", ppl.Stages[0].Steps[0].GitUser)
 	}
-
 	ppl.Id = uuid.Rand().Hex()
 	ppl.WebHookToken = uuid.Rand().Hex()
-	gitUser := ppl.Stages[0].Steps[0].GitUser
-	token, err := service.GetUserToken(gitUser)
-	if err != nil {
-		return err
-	}
-	scManager, err := service.GetSCManagerFromUserID(gitUser)
-	if err != nil {
-		return err
-	}
+	if gitUser := ppl.Stages[0].Steps[0].GitUser; gitUser != "" {
+		token, err := service.GetUserToken(gitUser)
+		if err != nil {
+			return err
+		}
+		scManager, err := service.GetSCManagerFromUserID(gitUser)
+		if err != nil {
+			return err
+		}
 
-	if err = scManager.CreateWebhook(ppl, token, webhook.CIWebhookEndpoint); err != nil {
-		logrus.Errorf("fail createWebhook")
-		return err
+		if err = scManager.CreateWebhook(ppl, token, webhook.CIWebhookEndpoint); err != nil {
+			logrus.Errorf("fail createWebhook")
+			return err
+		}
 	}
 
 	if err = service.UpdatePipelineEnvKey(ppl); err != nil {
@@ -119,47 +126,65 @@ func (s *Server) UpdatePipeline(rw http.ResponseWriter, req *http.Request) error
 	if err := json.Unmarshal(data, ppl); err != nil {
 		return err
 	}
-	if err := service.Validate(ppl); err != nil {
-		return err
+	prevPipeline, err := service.GetPipelineById(id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, ppl.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", ppl.Stages[0].Steps[0].GitUser)
+	uid := service.CurrentUser(req)
+	role := service.GetPipelineRole(uid, prevPipeline)
+	if !service.ValidPipelineAccessById(uid, prevPipeline, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", prevPipeline.Name)
 	}
-	gitUser := ppl.Stages[0].Steps[0].GitUser
-	token, err := service.GetUserToken(gitUser)
-	if err != nil {
-		logrus.Error(err)
-		return fmt.Errorf("no access to '%s' git account", ppl.Stages[0].Steps[0].GitUser)
+	//only the owner manages members
+	if role != model.RoleOwner && !service.SameMembers(ppl, prevPipeline) {
+		return fmt.Errorf("only the owner of pipeline '%s' can change its members", prevPipeline.Name)
 	}
-	scManager, err := service.GetSCManagerFromUserID(gitUser)
-	if err != nil {
+	ppl.Owner = prevPipeline.Owner
+	if err := service.Validate(ppl); err != nil {
 		return err
 	}
-	// Update webhook
-	prevPipeline, err := service.GetPipelineById(id)
-	if err != nil {
-		return fmt.Errorf("fail to get pipeline: %v", err)
+	//valid git account access when it is changed
+	if ppl.Stages[0].Steps[0].GitUser != prevPipeline.Stages[0].Steps[0].GitUser &&
+		!service.ValidAccountAccess(req, ppl.Stages[0].Steps[0].GitUser) {
+		return fmt.Errorf("no access to '%s' git account", ppl.Stages[0].Steps[0].GitUser)
 	}
-	if prevPipeline.Stages[0].Steps[0].Webhook && !ppl.Stages[0].Steps[0].Webhook {
-		if err = scManager.DeleteWebhook(prevPipeline, token); err != nil {
-			logrus.Error(err)
-		}
-	} else if !prevPipeline.Stages[0].Steps[0].Webhook && ppl.Stages[0].Steps[0].Webhook {
-		if err = scManager.CreateWebhook(ppl, token, webhook.CIWebhookEndpoint); err != nil {
-			logrus.Error(err)
-			return err
+	// Update webhook
+	gitUser := ppl.Stages[0].Steps[0].GitUser
+	if gitUser == "" {
+		//webhook is not supported without git account
+		if prevPipeline.Stages[0].Steps[0].Webhook {
+			deleteWebhook(prevPipeline)
 		}
-	} else if prevPipeline.Stages[0].Steps[0].Webhook &&
-		ppl.Stages[0].Steps[0].Webhook &&
-		(prevPipeline.Stages[0].Steps[0].Repository != ppl.Stages[0].Steps[0].Repository) {
-		if err = scManager.DeleteWebhook(prevPipeline, token); err != nil {
+	} else {
+		token, err := service.GetUserToken(gitUser)
+		if err != nil {
 			logrus.Error(err)
+			return fmt.Errorf("no access to '%s' git account", ppl.Stages[0].Steps[0].GitUser)
 		}
-		if err = scManager.CreateWebhook(ppl, token, webhook.CIWebhookEndpoint); err != nil {
-			logrus.Error(err)
+		scManager, err := service.GetSCManagerFromUserID(gitUser)
+		if err != nil {
 			return err
 		}
+		if prevPipeline.Stages[0].Steps[0].Webhook && !ppl.Stages[0].Steps[0].Webhook {
+			if err = scManager.DeleteWebhook(prevPipeline, token); err != nil {
+				logrus.Error(err)
+			}
+		} else if !prevPipeline.Stages[0].Steps[0].Webhook && ppl.Stages[0].Steps[0].Webhook {
+			if err = scManager.CreateWebhook(ppl, token, webhook.CIWebhookEndpoint); err != nil {
+				logrus.Error(err)
+				return err
+			}
+		} else if prevPipeline.Stages[0].Steps[0].Webhook &&
+			ppl.Stages[0].Steps[0].Webhook &&
+			(prevPipeline.Stages[0].Steps[0].Repository != ppl.Stages[0].Steps[0].Repository) {
+			if err = scManager.DeleteWebhook(prevPipeline, token); err != nil {
+				logrus.Error(err)
+			}
+			if err = scManager.CreateWebhook(ppl, token, webhook.CIWebhookEndpoint); err != nil {
+				logrus.Error(err)
+				return err
+			}
+		}
 	}
 
 	if err = service.UpdatePipelineEnvKey(ppl); err != nil {
@@ -175,17 +200,8 @@ func (s *Server) UpdatePipeline(rw http.ResponseWriter, req *http.Request) error
 	return nil
 }
 
-func (s *Server) DeletePipeline(rw http.ResponseWriter, req *http.Request) error {
-	id := mux.Vars(req)["id"]
-	ppl, err := service.GetPipelineById(id)
-	if err != nil {
-		return fmt.Errorf("fail to get pipeline: %v", err)
-	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, ppl.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", ppl.Stages[0].Steps[0].GitUser)
-	}
-
+//deleteWebhook deletes the webhook of the pipeline in the scm, failure is logged but not blocking
+func deleteWebhook(ppl *model.Pipeline) {
 	gitUser := ppl.Stages[0].Steps[0].GitUser
 	token, err := service.GetUserToken(gitUser)
 	scManager, err := service.GetSCManagerFromUserID(gitUser)
@@ -195,10 +211,29 @@ func (s *Server) DeletePipeline(rw http.ResponseWriter, req *http.Request) error
 		//log delete webhook failure but not block
 		logrus.Errorf("fail to delete webhook for pipeline \"%v\",for %v", ppl.Name, err)
 	}
+}
+
+func (s *Server) DeletePipeline(rw http.ResponseWriter, req *http.Request) error {
+	id := mux.Vars(req)["id"]
+	ppl, err := service.GetPipelineById(id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	if !service.ValidPipelineAccess(req, ppl, model.RoleOwner) {
+		return fmt.Errorf("no access to delete pipeline '%s'", ppl.Name)
+	}
+
+	if ppl.Stages[0].Steps[0].GitUser != "" {
+		deleteWebhook(ppl)
+	}
 	r, err := service.DeletePipeline(id)
 	if err != nil {
 		return err
 	}
+	s.removePipelineSecrets(id)
+	if err := service.RemoveCaches(id); err != nil {
+		logrus.Errorf("fail to remove caches of pipeline '%s': %v", id, err)
+	}
 	GlobalAgent.onPipelineDelete(r)
 	return nil
 }
@@ -210,9 +245,8 @@ func (s *Server) ActivatePipeline(rw http.ResponseWriter, req *http.Request) err
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, r.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Stages[0].Steps[0].GitUser)
+	if !service.ValidPipelineAccess(req, r, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", r.Name)
 	}
 	r.IsActivate = true
 	err = service.UpdatePipeline(r)
@@ -232,9 +266,8 @@ func (s *Server) DeActivatePipeline(rw http.ResponseWriter, req *http.Request) e
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, r.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Stages[0].Steps[0].GitUser)
+	if !service.ValidPipelineAccess(req, r, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", r.Name)
 	}
 	r.IsActivate = false
 	err = service.UpdatePipeline(r)
@@ -252,9 +285,8 @@ func (s *Server) ExportPipeline(rw http.ResponseWriter, req *http.Request) error
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, r.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Stages[0].Steps[0].GitUser)
+	if !service.ValidPipelineAccess(req, r, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", r.Name)
 	}
 	service.CleanPipeline(r)
 	model.FilterPipeline(r)
@@ -276,11 +308,10 @@ func (s *Server) RunPipeline(rw http.ResponseWriter, req *http.Request) error {
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, r.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Stages[0].Steps[0].GitUser)
+	if !service.ValidPipelineAccess(req, r, model.RoleRunner) {
+		return fmt.Errorf("no access to run pipeline '%s'", r.Name)
 	}
-	activity, err := service.RunPipeline(s.Provider, id, model.TriggerTypeManual)
+	activity, err := s.runPipeline(id, &model.TriggerInfo{TriggerType: model.TriggerTypeManual})
 	if err != nil {
 		return err
 	}
@@ -290,37 +321,20 @@ func (s *Server) RunPipeline(rw http.ResponseWriter, req *http.Request) error {
 
 func (s *Server) ListActivitiesOfPipeline(rw http.ResponseWriter, req *http.Request) error {
 	apiContext := api.GetApiContext(req)
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
 	pId := mux.Vars(req)["id"]
 	r, err := service.GetPipelineById(pId)
 	if err != nil {
 		return fmt.Errorf("fail to get pipeline: %v", err)
 	}
-	//valid git account access
-	if !service.ValidAccountAccess(req, r.Stages[0].Steps[0].GitUser) {
-		return fmt.Errorf("no access to '%s' git account", r.Stages[0].Steps[0].GitUser)
+	if !service.ValidPipelineAccess(req, r, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", r.Name)
 	}
-	filters := make(map[string]interface{})
-	filters["kind"] = "activity"
-	goCollection, err := apiClient.GenericObject.List(&v2client.ListOpts{
-		Filters: filters,
-	})
-
+	list, err := service.ListActivitiesOfPipeline(pId)
 	if err != nil {
 		return err
 	}
 	var activities []interface{}
-	for _, gobj := range goCollection.Data {
-		b := []byte(gobj.ResourceData["data"].(string))
-		a := &model.Activity{}
-		json.Unmarshal(b, a)
-		if a.Pipeline.Id != pId {
-			continue
-		}
-
+	for _, a := range list {
 		model.ToActivityResource(apiContext, a)
 		activities = append(activities, a)
 	}
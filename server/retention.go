@@ -0,0 +1,34 @@
+package server
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//removeActivity removes the finished activity with its jobs, workspace, logs and artifacts.
+//The activity is kept if the provider fails to clean it, so it is removed in the next round.
+func (s *Server) removeActivity(id string) {
+	mutex := GlobalAgent.getActivityLock(id)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	r, err := service.GetActivity(id)
+	if err != nil {
+		return
+	}
+	if service.IsRunning(r) || r.Status == model.ActivityQueued {
+		return
+	}
+	if err := s.Provider.OnDeleteActivity(r); err != nil {
+		logrus.Errorf("fail to clean activity '%s': %v", id, err)
+		return
+	}
+	if err := service.DeleteActivity(id); err != nil {
+		logrus.Errorf("fail to delete activity '%s': %v", id, err)
+		return
+	}
+	logrus.Debugf("activity '%s' of pipeline '%s' is removed by retention", id, r.Pipeline.Name)
+	r.Status = "removed"
+	broadcastResourceChange(*r)
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+func TestRemoveActivity(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		deleteErr   error
+		wantRemoved bool
+	}{
+		{"success", model.ActivitySuccess, nil, true},
+		{"failed", model.ActivityFail, nil, true},
+		{"building", model.ActivityBuilding, nil, false},
+		{"waiting", model.ActivityWaiting, nil, false},
+		{"pending", model.ActivityPending, nil, false},
+		{"queued", model.ActivityQueued, nil, false},
+		{"clean error", model.ActivitySuccess, errors.New("fail to delete job"), false},
+	}
+	for _, test := range tests {
+		provider := &fakeProvider{deleteErr: test.deleteErr}
+		s, cleanup := initTestServer(t, provider)
+		createConcurrencyPipeline(t, nil, nil)
+		activity, err := s.runPipeline("p1", manualTrigger)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		activity.Status = test.status
+		service.UpdateActivity(activity)
+
+		s.removeActivity(activity.Id)
+		_, err = service.GetActivity(activity.Id)
+		if removed := err != nil; removed != test.wantRemoved {
+			t.Errorf("%s: got removed %v, want %v", test.name, removed, test.wantRemoved)
+		}
+		//the provider cleans only activities to remove
+		if cleaned := len(provider.deleted) > 0; cleaned != test.wantRemoved {
+			t.Errorf("%s: got cleaned %v, want %v", test.name, cleaned, test.wantRemoved)
+		}
+		cleanup()
+	}
+}
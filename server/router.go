@@ -72,18 +72,35 @@ func NewRouter(s *Server) *mux.Router {
 	router.Methods(http.MethodGet).Path("/v1/pipelines/{id}/activities").Handler(f(schemas, s.ListActivitiesOfPipeline))
 	router.Methods(http.MethodDelete).Path("/v1/pipelines/{id}").Handler(f(schemas, s.DeletePipeline))
 	router.Methods(http.MethodGet).Path("/v1/pipelines/{id}/exportconfig").Handler(f(schemas, s.ExportPipeline))
+	router.Methods(http.MethodGet).Path("/v1/pipelines/{id}/secrets").Handler(f(schemas, s.ListSecrets))
+	router.Methods(http.MethodPost).Path("/v1/pipelines/{id}/secrets").Handler(f(schemas, s.SetSecret))
+	router.Methods(http.MethodDelete).Path("/v1/pipelines/{id}/secrets/{name}").Handler(f(schemas, s.RemoveSecret))
+	router.Methods(http.MethodGet).Path("/v1/pipelines/{id}/caches").Handler(f(schemas, s.ListCaches))
+	router.Methods(http.MethodDelete).Path("/v1/pipelines/{id}/caches").Handler(f(schemas, s.PurgeCaches))
+	router.Methods(http.MethodDelete).Path("/v1/pipelines/{id}/caches/{key}").Handler(f(schemas, s.PurgeCaches))
 	//router.Methods(http.MethodDelete).Path("/v1/pipeline").Handler(f(schemas, s.CleanPipelines))
 
 	//activities
 	router.Methods(http.MethodGet).Path("/v1/activities").Handler(f(schemas, s.ListActivities))
+	router.Methods(http.MethodGet).Path("/v1/activities/expired").Handler(f(schemas, s.ListExpiredActivities))
 	router.Methods(http.MethodGet).Path("/v1/activities/{id}").Handler(f(schemas, s.GetActivity))
 	router.Methods(http.MethodDelete).Path("/v1/activities/{id}").Handler(f(schemas, s.DeleteActivity))
+	router.Methods(http.MethodGet).Path("/v1/activities/{id}/artifacts").Handler(f(schemas, s.ListArtifacts))
+	router.Methods(http.MethodGet).Path("/v1/activities/{id}/logs").Handler(f(schemas, s.DownloadActivityLog))
+	router.Methods(http.MethodGet).Path("/v1/activities/{id}/logs/{stageOrdinal:[0-9]+}/{stepOrdinal:[0-9]+}").Handler(f(schemas, s.DownloadStepLog))
+	router.Methods(http.MethodGet).Path("/v1/activities/{id}/artifacts/{name:.+}").Handler(f(schemas, s.DownloadArtifact))
 	//router.Methods(http.MethodDelete).Path("/v1/activity").Handler(f(schemas, s.CleanActivities))
 
 	//scm accounts
 	router.Methods(http.MethodGet).Path("/v1/gitaccounts").Handler(f(schemas, s.ListAccounts))
 	router.Methods(http.MethodGet).Path("/v1/gitaccounts/{id}").Handler(f(schemas, s.GetAccount))
 	router.Methods(http.MethodGet).Path("/v1/gitaccounts/{id}/repos").Handler(f(schemas, s.GetCacheRepos))
+
+	//git credentials
+	router.Methods(http.MethodGet).Path("/v1/gitcredentials").Handler(f(schemas, s.ListGitCredentials))
+	router.Methods(http.MethodPost).Path("/v1/gitcredentials").Handler(f(schemas, s.CreateGitCredential))
+	router.Methods(http.MethodGet).Path("/v1/gitcredentials/{id}").Handler(f(schemas, s.GetGitCredential))
+
 	//settings
 	router.Methods(http.MethodGet).Path("/v1/settings").Handler(f(schemas, s.GetPipelineSetting))
 	router.Methods(http.MethodGet).Path("/v1/settings/scmsettings").Handler(f(schemas, s.ListSCMSetting))
@@ -96,9 +113,16 @@ func NewRouter(s *Server) *mux.Router {
 	router.Methods(http.MethodGet).Path("/v1/ws/log").Handler(f(schemas, s.ServeStepLog))
 	router.Methods(http.MethodGet).Path("/v1/ws/status").Handler(f(schemas, s.ServeStatusWS))
 
+	//server-sent events
+	router.Methods(http.MethodGet).Path("/v1/sse/log").Handler(f(schemas, s.ServeStepLogSSE))
+	router.Methods(http.MethodGet).Path("/v1/sse/status").Handler(f(schemas, s.ServeStatusSSE))
+
 	//callback path for jenkins events
 	router.Methods(http.MethodPost).Path("/v1/events/stepfinish").Handler(f(schemas, s.StepFinish))
 	router.Methods(http.MethodPost).Path("/v1/events/stepstart").Handler(f(schemas, s.StepStart))
+	router.Methods(http.MethodGet).Path("/v1/events/artifacts").Handler(f(schemas, s.ArtifactsTar))
+	router.Methods(http.MethodGet).Path("/v1/events/cache").Handler(f(schemas, s.GetStepCache))
+	router.Methods(http.MethodPost).Path("/v1/events/cache").Handler(f(schemas, s.SaveStepCache))
 
 	//webhook endpoint
 	router.Methods(http.MethodPost).Path("/v1/webhook").Handler(f(schemas, s.Webhook))
@@ -152,5 +176,13 @@ func NewRouter(s *Server) *mux.Router {
 	for name, actions := range accountActions {
 		router.Methods(http.MethodPost).Path("/v1/gitaccounts/{id}").Queries("action", name).Handler(actions)
 	}
+
+	credentialActions := map[string]http.Handler{
+		"update": f(schemas, s.UpdateGitCredential),
+		"remove": f(schemas, s.RemoveGitCredential),
+	}
+	for name, actions := range credentialActions {
+		router.Methods(http.MethodPost).Path("/v1/gitcredentials/{id}").Queries("action", name).Handler(actions)
+	}
 	return router
 }
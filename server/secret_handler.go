@@ -0,0 +1,106 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/rancher/go-rancher/api"
+	v1client "github.com/rancher/go-rancher/client"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+func (s *Server) ListSecrets(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	ppl, err := service.GetPipelineById(id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	if !service.ValidPipelineAccess(req, ppl, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", ppl.Name)
+	}
+	secrets, err := service.ListSecrets(id)
+	if err != nil {
+		return err
+	}
+	result := []interface{}{}
+	for _, secret := range secrets {
+		result = append(result, model.ToSecretResource(apiContext, secret))
+	}
+	apiContext.Write(&v1client.GenericCollection{
+		Data: result,
+	})
+	return nil
+}
+
+//SetSecret creates the secret of the pipeline, or updates it if the name exists
+func (s *Server) SetSecret(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	ppl, err := service.GetPipelineById(id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	if !service.ValidPipelineAccess(req, ppl, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", ppl.Name)
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	secret := &model.Secret{}
+	if err := json.Unmarshal(data, secret); err != nil {
+		return err
+	}
+	cred, err := service.SetSecret(id, secret.Name, secret.Value)
+	if err != nil {
+		return err
+	}
+	//providers get the plain value
+	plain := *cred
+	plain.SecretValue = secret.Value
+	if err := s.Provider.OnCreateCredential(&plain); err != nil {
+		return err
+	}
+	return apiContext.WriteResource(model.ToSecretResource(apiContext, cred))
+}
+
+func (s *Server) RemoveSecret(rw http.ResponseWriter, req *http.Request) error {
+	apiContext := api.GetApiContext(req)
+	id := mux.Vars(req)["id"]
+	name := mux.Vars(req)["name"]
+	ppl, err := service.GetPipelineById(id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	if !service.ValidPipelineAccess(req, ppl, model.RoleEditor) {
+		return fmt.Errorf("no access to edit pipeline '%s'", ppl.Name)
+	}
+	cred, err := service.RemoveSecret(id, name)
+	if err != nil {
+		return err
+	}
+	if err := s.Provider.OnDeleteCredential(cred); err != nil {
+		logrus.Errorf("fail to delete secret '%s' in provider: %v", name, err)
+	}
+	return apiContext.WriteResource(model.ToSecretResource(apiContext, cred))
+}
+
+//removePipelineSecrets removes secrets of the deleted pipeline, failure is logged but not blocking
+func (s *Server) removePipelineSecrets(pipelineId string) {
+	secrets, err := service.RemoveSecrets(pipelineId)
+	if err != nil {
+		logrus.Errorf("fail to remove secrets of pipeline '%s': %v", pipelineId, err)
+		return
+	}
+	for _, secret := range secrets {
+		if err := s.Provider.OnDeleteCredential(secret); err != nil {
+			logrus.Errorf("fail to delete secret '%s' in provider: %v", secret.Name, err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/util"
+)
+
+var roleLevels = map[string]int{
+	model.RoleReader: 1,
+	model.RoleRunner: 2,
+	model.RoleEditor: 3,
+	model.RoleOwner:  4,
+}
+
+//CurrentUser gets the rancher user id of the request, empty if the user is unrecognized
+func CurrentUser(req *http.Request) string {
+	uid, err := util.GetCurrentUser(req.Cookies())
+	if err != nil {
+		logrus.Debugf("unrecognized user: %v", err)
+		return ""
+	}
+	return uid
+}
+
+//CurrentUserGroups gets identity ids of groups of the request user
+func CurrentUserGroups(req *http.Request) []string {
+	groups, err := util.GetCurrentIdentities(req.Cookies())
+	if err != nil {
+		logrus.Debugf("fail to get identities of current user: %v", err)
+		return nil
+	}
+	return groups
+}
+
+//GetPipelineRole gets the role of the user on the pipeline, empty if the user has no access.
+//Pipelines created without owner are readable by all users until an owner is assigned.
+func GetPipelineRole(uid string, ppl *model.Pipeline) string {
+	if uid == "" {
+		return ""
+	}
+	if ppl.Owner != "" && uid == ppl.Owner {
+		return model.RoleOwner
+	}
+	role := ""
+	if ppl.Owner == "" {
+		role = model.RoleReader
+	}
+	for _, member := range ppl.Members {
+		if member.UserId == uid && roleLevels[member.Role] > roleLevels[role] {
+			role = member.Role
+		}
+	}
+	return role
+}
+
+//AssignPipelineOwner sets the owner of pipelines which have no owner, which are only readable by users.
+//It migrates pipelines created before permissions, the number of changed pipelines is returned.
+func AssignPipelineOwner(owner string) (int, error) {
+	pipelines, err := dataStore.Pipelines().List()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, ppl := range pipelines {
+		if ppl.Owner != "" {
+			continue
+		}
+		if owner == "" {
+			logrus.Warningf("pipeline '%s' has no owner and is read only", ppl.Name)
+			continue
+		}
+		ppl.Owner = owner
+		if err := dataStore.Pipelines().Update(ppl); err != nil {
+			return count, fmt.Errorf("fail to set owner of pipeline '%s': %v", ppl.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+//ValidPipelineAccess checks the current user has the role on the pipeline
+func ValidPipelineAccess(req *http.Request, ppl *model.Pipeline, role string) bool {
+	return ValidPipelineAccessById(CurrentUser(req), ppl, role)
+}
+
+//ValidPipelineAccessById checks the user has the role on the pipeline
+func ValidPipelineAccessById(uid string, ppl *model.Pipeline, role string) bool {
+	userRole := GetPipelineRole(uid, ppl)
+	return userRole != "" && roleLevels[userRole] >= roleLevels[role]
+}
+
+//ValidStoredPipelineAccess checks the current user has the role on the stored pipeline of the id,
+//it fails if the pipeline is not found. It is used to authorize changes by request data.
+func ValidStoredPipelineAccess(req *http.Request, pipelineId string, role string) bool {
+	ppl, err := GetPipelineById(pipelineId)
+	if err != nil {
+		return false
+	}
+	return ValidPipelineAccess(req, ppl, role)
+}
+
+//ValidActivityAccessById checks the user has the role on the pipeline of the activity,
+//using the current pipeline if it exists
+func ValidActivityAccessById(uid string, activity *model.Activity, role string) bool {
+	ppl, err := GetPipelineById(activity.Pipeline.Id)
+	if err != nil {
+		ppl = &activity.Pipeline
+	}
+	return ValidPipelineAccessById(uid, ppl, role)
+}
+
+//ValidActivityAccess checks the current user has the role on the pipeline of the activity
+func ValidActivityAccess(req *http.Request, activity *model.Activity, role string) bool {
+	return ValidActivityAccessById(CurrentUser(req), activity, role)
+}
+
+//FilterPipelinesByRole gets pipelines on which the user has the role
+func FilterPipelinesByRole(uid string, pipelines []*model.Pipeline, role string) []*model.Pipeline {
+	result := []*model.Pipeline{}
+	for _, ppl := range pipelines {
+		if ValidPipelineAccessById(uid, ppl, role) {
+			result = append(result, ppl)
+		}
+	}
+	return result
+}
+
+//FilterActivitiesByRole gets activities on whose pipelines the user has the role
+func FilterActivitiesByRole(uid string, activities []*model.Activity, role string) []*model.Activity {
+	pipelines := map[string]*model.Pipeline{}
+	for _, ppl := range ListPipelines() {
+		pipelines[ppl.Id] = ppl
+	}
+	result := []*model.Activity{}
+	for _, activity := range activities {
+		ppl, ok := pipelines[activity.Pipeline.Id]
+		if !ok {
+			ppl = &activity.Pipeline
+		}
+		if ValidPipelineAccessById(uid, ppl, role) {
+			result = append(result, activity)
+		}
+	}
+	return result
+}
+
+//SameMembers checks whether the members of two pipelines are the same
+func SameMembers(a *model.Pipeline, b *model.Pipeline) bool {
+	if len(a.Members) != len(b.Members) {
+		return false
+	}
+	roles := map[string]string{}
+	for _, member := range a.Members {
+		roles[member.UserId] = member.Role
+	}
+	for _, member := range b.Members {
+		if role, ok := roles[member.UserId]; !ok || role != member.Role {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestGetPipelineRole(t *testing.T) {
+	members := []*model.PipelineMember{{UserId: "u2", Role: model.RoleRunner}}
+	tests := []struct {
+		name  string
+		uid   string
+		owner string
+		want  string
+	}{
+		{"owner", "u1", "u1", model.RoleOwner},
+		{"member", "u2", "u1", model.RoleRunner},
+		{"other user", "u3", "u1", ""},
+		{"unrecognized user", "", "u1", ""},
+		{"ownerless", "u3", "", model.RoleReader},
+		{"member of ownerless", "u2", "", model.RoleRunner},
+		{"unrecognized user of ownerless", "", "", ""},
+	}
+	for _, test := range tests {
+		ppl := &model.Pipeline{Owner: test.owner, Members: members}
+		if got := GetPipelineRole(test.uid, ppl); got != test.want {
+			t.Errorf("%s: got role '%s', want '%s'", test.name, got, test.want)
+		}
+	}
+}
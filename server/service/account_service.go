@@ -1,19 +1,15 @@
 package service
 
 import (
-	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/pipeline/git"
 	"github.com/rancher/pipeline/model"
-	"github.com/rancher/pipeline/util"
+	"github.com/rancher/pipeline/store"
 )
 
-const GIT_ACCOUNT_TYPE = "gitaccount"
-const REPO_CACHE_TYPE = "repocache"
-
 func RefreshRepos(accountId string) ([]*model.GitRepository, error) {
 
 	account, err := GetAccount(accountId)
@@ -32,49 +28,23 @@ func RefreshRepos(accountId string) ([]*model.GitRepository, error) {
 }
 
 func GetAccount(id string) (*model.GitAccount, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-	filters := make(map[string]interface{})
-	filters["kind"] = GIT_ACCOUNT_TYPE
-	filters["key"] = id
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("Error %v filtering genericObjects by key", err)
-	}
-	if len(goCollection.Data) == 0 {
+	account, err := dataStore.Accounts().Get(id)
+	if err == store.ErrNotFound {
 		return nil, fmt.Errorf("cannot find account with id '%s'", id)
-	}
-	data := goCollection.Data[0]
-	account := &model.GitAccount{}
-	if err = json.Unmarshal([]byte(data.ResourceData["data"].(string)), &account); err != nil {
-		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Error %v getting account", err)
 	}
 	return account, nil
 }
 
 //listAccounts gets scm accounts accessible by the user
 func ListAccounts(uid string) ([]*model.GitAccount, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-	filters := make(map[string]interface{})
-	filters["kind"] = GIT_ACCOUNT_TYPE
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
+	all, err := dataStore.Accounts().List()
 	if err != nil {
-		return nil, fmt.Errorf("Error %v filtering genericObjects by key", err)
+		return nil, fmt.Errorf("Error %v listing accounts", err)
 	}
 	var accounts []*model.GitAccount
-	for _, gobj := range goCollection.Data {
-		b := []byte(gobj.ResourceData["data"].(string))
-		a := &model.GitAccount{}
-		json.Unmarshal(b, a)
+	for _, a := range all {
 		if uid == a.RancherUserID || !a.Private {
 			accounts = append(accounts, a)
 		}
@@ -115,294 +85,102 @@ func UnshareAccount(id string) (*model.GitAccount, error) {
 }
 
 func UpdateAccount(account *model.GitAccount) error {
-	b, err := json.Marshal(account)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = account.Id
-	filters["kind"] = GIT_ACCOUNT_TYPE
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error querying account:%v", err)
-		return err
-	}
-	if len(goCollection.Data) == 0 {
+	err := dataStore.Accounts().Update(account)
+	if err == store.ErrNotFound {
 		return fmt.Errorf("account '%s' not found", account.Id)
 	}
-	existing := goCollection.Data[0]
-	_, err = apiClient.GenericObject.Update(&existing, &client.GenericObject{
-		Name:         account.Id,
-		Key:          account.Id,
-		ResourceData: resourceData,
-		Kind:         GIT_ACCOUNT_TYPE,
-	})
 	return err
 }
 
 func RemoveAccount(id string) (*model.GitAccount, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = id
-	filters["kind"] = GIT_ACCOUNT_TYPE
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error querying account:%v", err)
-		return nil, err
-	}
-	if len(goCollection.Data) == 0 {
+	account, err := dataStore.Accounts().Delete(id)
+	if err == store.ErrNotFound {
 		return nil, fmt.Errorf("account '%s' not found", id)
-	}
-	existing := goCollection.Data[0]
-
-	account := &model.GitAccount{}
-	if err = json.Unmarshal([]byte(existing.ResourceData["data"].(string)), account); err != nil {
+	} else if err != nil {
+		logrus.Errorf("Error removing account:%v", err)
 		return nil, err
 	}
-	if err = apiClient.GenericObject.Delete(&existing); err != nil {
-		return nil, err
-	}
-
 	return account, nil
 }
 
 func CleanAccounts(scmType string) ([]*model.GitAccount, error) {
-
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-	geObjList, err := PaginateGenericObjects(GIT_ACCOUNT_TYPE)
+	accounts, err := dataStore.Accounts().List()
 	if err != nil {
-		logrus.Errorf("fail to list acciybt,err:%v", err)
+		logrus.Errorf("fail to list account,err:%v", err)
 		return nil, err
 	}
 	delAccounts := []*model.GitAccount{}
-	for _, gobj := range geObjList {
-		account := &model.GitAccount{}
-		if err := json.Unmarshal([]byte(gobj.ResourceData["data"].(string)), account); err != nil {
-			logrus.Errorf("parse data got error:%v", err)
-			continue
-		}
+	for _, account := range accounts {
 		if account.AccountType == scmType {
 			delAccounts = append(delAccounts, account)
-			apiClient.GenericObject.Delete(&gobj)
+			if _, err := dataStore.Accounts().Delete(account.Id); err != nil {
+				logrus.Errorf("fail to remove account '%s',err:%v", account.Id, err)
+			}
 		}
 	}
 	return delAccounts, nil
 }
 
 func CreateAccount(account *model.GitAccount) error {
-	b, err := json.Marshal(account)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-	_, err = apiClient.GenericObject.Create(&client.GenericObject{
-		Name:         account.Id,
-		Key:          account.Id,
-		ResourceData: resourceData,
-		Kind:         GIT_ACCOUNT_TYPE,
-	})
-	return err
+	return dataStore.Accounts().Create(account)
 }
 
 func GetCacheRepoList(accountId string) ([]*model.GitRepository, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-	filters := make(map[string]interface{})
-	filters["kind"] = REPO_CACHE_TYPE
-	filters["key"] = accountId
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("Error %v filtering genericObjects by key", err)
-	}
-	if len(goCollection.Data) == 0 {
+	repos, err := dataStore.Accounts().GetRepoCache(accountId)
+	if err == store.ErrNotFound {
 		//no cache,refresh
-		repos, err := RefreshRepos(accountId)
-		if err != nil {
-			return nil, err
-		}
-		return repos, nil
-	}
-	repos := []*model.GitRepository{}
-	data := goCollection.Data[0]
-	if err = json.Unmarshal([]byte(data.ResourceData["data"].(string)), &repos); err != nil {
-		return nil, err
+		return RefreshRepos(accountId)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error %v getting repo cache", err)
 	}
 	return repos, nil
 }
 
 func CreateOrUpdateCacheRepoList(accountId string, repos []*model.GitRepository) error {
-
 	logrus.Debugf("refreshing repos")
-	b, err := json.Marshal(repos)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
+	if err := dataStore.Accounts().SaveRepoCache(accountId, repos); err != nil {
+		return fmt.Errorf("Save repo cache got error: %v", err)
 	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = accountId
-	filters["kind"] = REPO_CACHE_TYPE
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error querying account:%v", err)
-		return err
-	}
-	if len(goCollection.Data) == 0 {
-		//not exist,create a repocache object
-		if _, err := apiClient.GenericObject.Create(&client.GenericObject{
-			Key:          accountId,
-			ResourceData: resourceData,
-			Kind:         REPO_CACHE_TYPE,
-		}); err != nil {
-			return fmt.Errorf("Save repo cache got error: %v", err)
-		}
-
-		logrus.Debugf("done refresh repos")
-		return nil
-	}
-	existing := goCollection.Data[0]
-	_, err = apiClient.GenericObject.Update(&existing, &client.GenericObject{
-		Key:          accountId,
-		ResourceData: resourceData,
-		Kind:         REPO_CACHE_TYPE,
-	})
-	return err
+	logrus.Debugf("done refresh repos")
+	return nil
 }
 
 func CreateCredential(cred *model.Credential) error {
-	b, err := json.Marshal(cred)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-	_, err = apiClient.GenericObject.Create(&client.GenericObject{
-		Name:         cred.Id,
-		Key:          cred.Id,
-		ResourceData: resourceData,
-		Kind:         "pipelineCred",
-	})
-	return err
+	return dataStore.Credentials().Create(cred)
 }
 
 func UpdateCredential(cred *model.Credential) error {
-	b, err := json.Marshal(cred)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = cred.Id
-	filters["kind"] = "pipelineCred"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error querying account:%v", err)
-		return err
-	}
-	if len(goCollection.Data) == 0 {
+	err := dataStore.Credentials().Update(cred)
+	if err == store.ErrNotFound {
 		return fmt.Errorf("credential '%s' not found", cred.Id)
 	}
-	existing := goCollection.Data[0]
-	_, err = apiClient.GenericObject.Update(&existing, &client.GenericObject{
-		Name:         cred.Id,
-		Key:          cred.Id,
-		ResourceData: resourceData,
-		Kind:         "pipelineCred",
-	})
 	return err
 }
 
 func GetEnvKey(clientId string) (string, error) {
-	id := "envKey:" + clientId
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return "", err
-	}
-	filters := make(map[string]interface{})
-	filters["kind"] = "pipelineCred"
-	filters["key"] = id
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		return "", fmt.Errorf("Error %v filtering genericObjects by key", err)
-	}
-	if len(goCollection.Data) == 0 {
+	cred, err := dataStore.Credentials().Get("envKey:" + clientId)
+	if err == store.ErrNotFound {
 		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("Error %v getting credential", err)
 	}
-	a := &model.Credential{}
-	data := goCollection.Data[0]
-	if err = json.Unmarshal([]byte(data.ResourceData["data"].(string)), a); err != nil {
-		return "", err
+	return cred.SecretValue, nil
+}
+
+//StepEnvKey gets the env key of the upgrade step of the activity, env keys are withheld from
+//pull requests of forks
+func StepEnvKey(activity *model.Activity, step *model.Step) (string, error) {
+	if IsForkPullRequest(activity) {
+		return "", fmt.Errorf("env key '%s' is not given to pull requests of forks", step.Accesskey)
 	}
-	return a.SecretValue, nil
+	return GetEnvKey(step.Accesskey)
 }
 
 func CreateOrUpdateEnvKey(clientId string, token string) error {
 	id := "envKey:" + clientId
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-	filters := make(map[string]interface{})
-	filters["kind"] = "pipelineCred"
-	filters["key"] = id
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		return fmt.Errorf("Error %v filtering genericObjects by key", err)
+	_, err := dataStore.Credentials().Get(id)
+	if err != nil && err != store.ErrNotFound {
+		return fmt.Errorf("Error %v getting credential", err)
 	}
 	cred := &model.Credential{
 		CredType:    "envKey",
@@ -410,26 +188,53 @@ func CreateOrUpdateEnvKey(clientId string, token string) error {
 		SecretValue: token,
 	}
 	cred.Id = id
-	if len(goCollection.Data) == 0 {
+	if err == store.ErrNotFound {
 		//not exist, create new
-		if err := CreateCredential(cred); err != nil {
-			return err
-		}
-	} else {
-		//update
-		if err := UpdateCredential(cred); err != nil {
-			return err
-		}
+		return CreateCredential(cred)
 	}
-	return nil
+	//update
+	return UpdateCredential(cred)
 }
 
+//ValidAccountAccess checks the current user can use the git account
 func ValidAccountAccess(req *http.Request, accountId string) bool {
-	return true
+	return ValidAccountAccessById(CurrentUser(req), accountId)
 }
 
+//ValidAccountAccessById checks the user owns the git account or it is shared,
+//no git account is required when the id is empty
 func ValidAccountAccessById(uid string, accountId string) bool {
-	return true
+	if accountId == "" {
+		return true
+	}
+	account, err := GetAccount(accountId)
+	if err != nil {
+		return false
+	}
+	return uid == account.RancherUserID || !account.Private
+}
+
+//ValidAccountOwner checks the current user can share, unshare or remove the git account
+func ValidAccountOwner(req *http.Request, accountId string) bool {
+	account, err := GetAccount(accountId)
+	if err != nil {
+		return false
+	}
+	//accounts added without a known user are managed by anyone
+	return account.RancherUserID == "" || account.RancherUserID == CurrentUser(req)
+}
+
+//GetAuthRepoUrl gets the repository url carrying the credential of the git user
+func GetAuthRepoUrl(repoUrl string, gitUser string) (string, error) {
+	account, err := GetAccount(gitUser)
+	if err != nil {
+		return "", err
+	}
+	manager, err := GetSCManager(account.AccountType)
+	if err != nil {
+		return "", err
+	}
+	return git.GetAuthRepoUrl(repoUrl, manager.GetCloneUser(account.Login), account.AccessToken)
 }
 
 func GetUserToken(gitUser string) (string, error) {
@@ -1,151 +1,90 @@
 package service
 
 import (
-	"encoding/json"
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/pipeline/condition"
 	"github.com/rancher/pipeline/model"
-	"github.com/rancher/pipeline/util"
+	"github.com/rancher/pipeline/store"
+	"github.com/sluu99/uuid"
 )
 
 func ListActivities() ([]*model.Activity, error) {
-	geObjList, err := PaginateGenericObjects("activity")
+	activities, err := dataStore.Activities().List(nil)
 	if err != nil {
 		logrus.Errorf("fail to list activity, err:%v", err)
 		return nil, err
 	}
-	var activities []*model.Activity
-	for _, gobj := range geObjList {
-		b := []byte(gobj.ResourceData["data"].(string))
-		a := &model.Activity{}
-		json.Unmarshal(b, a)
-		activities = append(activities, a)
-	}
-
 	return activities, nil
 }
 
-//Get Activity From GenericObjects By Id
-func GetActivity(id string) (*model.Activity, error) {
-	apiClient, err := util.GetRancherClient()
+//ListActivitiesOfPipeline gets activities of the pipeline
+func ListActivitiesOfPipeline(pipelineId string) ([]*model.Activity, error) {
+	activities, err := dataStore.Activities().List(&store.ActivityFilter{PipelineId: pipelineId})
 	if err != nil {
+		logrus.Errorf("fail to list activity, err:%v", err)
 		return nil, err
 	}
-	filters := make(map[string]interface{})
-	filters["key"] = id
-	filters["kind"] = "activity"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("Error %v filtering genericObjects by key", err)
-	}
-	if len(goCollection.Data) == 0 {
+	return activities, nil
+}
+
+//Get Activity From the store By Id
+func GetActivity(id string) (*model.Activity, error) {
+	activity, err := dataStore.Activities().Get(id)
+	if err == store.ErrNotFound {
 		return nil, fmt.Errorf("Requested activity not found")
+	} else if err != nil {
+		return nil, fmt.Errorf("Error %v getting activity", err)
 	}
-	data := goCollection.Data[0]
-	activity := &model.Activity{}
-	json.Unmarshal([]byte(data.ResourceData["data"].(string)), activity)
-
 	return activity, nil
 }
 
 func CreateActivity(activity *model.Activity) error {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-	//activity.Id = uuid.Rand().Hex()
-	b, err := json.Marshal(activity)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-
-	if _, err := apiClient.GenericObject.Create(&client.GenericObject{
-		Name:         activity.Id,
-		Key:          activity.Id,
-		ResourceData: resourceData,
-		Kind:         "activity",
-	}); err != nil {
+	if err := dataStore.Activities().Create(activity); err != nil {
 		return fmt.Errorf("Failed to save activity: %v", err)
 	}
+	ReportCommitStatus(nil, activity)
 	return nil
 }
 
 func UpdateActivity(activity *model.Activity) error {
 	logrus.Debugf("updating activity %v.", activity.Id)
 	logrus.Debugf("activity stages:%v", activity.ActivityStages)
-	b, err := json.Marshal(activity)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = activity.Id
-	filters["kind"] = "activity"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
+	prev, err := dataStore.Activities().Get(activity.Id)
+	if err == store.ErrNotFound {
+		logrus.Errorf("activity '%s' to update is not found", activity.Id)
 		return nil
+	} else if err != nil {
+		return err
 	}
-	if len(goCollection.Data) == 0 {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
+	err = dataStore.Activities().Update(activity)
+	if err == store.ErrNotFound {
+		logrus.Errorf("activity '%s' to update is not found", activity.Id)
 		return nil
-	}
-	existing := goCollection.Data[0]
-	_, err = apiClient.GenericObject.Update(&existing, &client.GenericObject{
-		Name:         activity.Id,
-		Key:          activity.Id,
-		ResourceData: resourceData,
-		Kind:         "activity",
-	})
-	if err != nil {
+	} else if err != nil {
 		return err
 	}
+	ReportCommitStatus(prev, activity)
 	return nil
 }
 
 func DeleteActivity(id string) error {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = id
-	filters["kind"] = "activity"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
+	err := dataStore.Activities().Delete(id)
+	if err == store.ErrNotFound {
+		logrus.Errorf("activity '%s' to delete is not found", id)
 		return nil
-	}
-	if len(goCollection.Data) == 0 {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
-		return nil
-	}
-	existing := goCollection.Data[0]
-	err = apiClient.GenericObject.Delete(&existing)
-	if err != nil {
+	} else if err != nil {
 		return err
 	}
+	RemoveArtifacts(id)
+	RemoveStepLogs(id)
 	return nil
 }
 
@@ -154,7 +93,12 @@ func RerunActivity(provider model.PipelineProvider, activity *model.Activity) er
 	if activity.Status == model.ActivityBuilding || activity.Status == model.ActivityWaiting {
 		return errors.New("not allow to rerun a running activity")
 	}
+	if activity.Status == model.ActivityQueued {
+		return errors.New("not allow to rerun a queued activity")
+	}
 	ResetActivityStatus(activity)
+	//logs of the former run
+	RemoveStepLogs(activity.Id)
 
 	if err := provider.RerunActivity(activity); err != nil {
 		return err
@@ -163,20 +107,39 @@ func RerunActivity(provider model.PipelineProvider, activity *model.Activity) er
 	return nil
 }
 
-//resetActivityStatus reset status and timestamp
+//IssueCallbackToken sets a new token for event requests of steps of the activity,
+//the token of the former run is no longer valid
+func IssueCallbackToken(activity *model.Activity) {
+	activity.CallbackToken = uuid.Rand().Hex()
+}
+
+//ValidCallbackToken checks the token of the event request of a step of the running activity
+func ValidCallbackToken(activity *model.Activity, token string) bool {
+	return IsRunning(activity) && activity.CallbackToken != "" &&
+		subtle.ConstantTimeCompare([]byte(activity.CallbackToken), []byte(token)) == 1
+}
+
+//resetActivityStatus reset status and timestamp, a new callback token is issued for the run
 func ResetActivityStatus(activity *model.Activity) {
+	IssueCallbackToken(activity)
 	activity.Status = model.ActivityWaiting
 	activity.PendingStage = 0
 	activity.StartTS = 0
 	activity.StopTS = 0
+	activity.QueuePosition = 0
 	for _, stage := range activity.ActivityStages {
 		stage.Duration = 0
 		stage.StartTS = 0
 		stage.Status = model.ActivityStageWaiting
+		stage.PendingTS = 0
+		stage.Approvals = nil
 		for _, step := range stage.ActivitySteps {
 			step.Duration = 0
 			step.StartTS = 0
 			step.Status = model.ActivityStepWaiting
+			step.Triggered = false
+			step.Attempts = nil
+			step.RetryTS = 0
 		}
 	}
 }
@@ -204,6 +167,109 @@ func ApproveActivity(provider model.PipelineProvider, activity *model.Activity)
 	return provider.RunStage(activity, activity.PendingStage)
 }
 
+//PendActivity sets the stage of the activity pending for approval
+func PendActivity(activity *model.Activity, stageOrdinal int) {
+	stage := activity.ActivityStages[stageOrdinal]
+	stage.Status = model.ActivityStagePending
+	if stage.PendingTS == 0 {
+		stage.PendingTS = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+	activity.Status = model.ActivityPending
+	activity.PendingStage = stageOrdinal
+}
+
+//AddApproval records the approval or denial of the user on the pending stage
+func AddApproval(activity *model.Activity, userId string, approved bool, comment string) error {
+	if activity.Status != model.ActivityPending || activity.PendingStage >= len(activity.ActivityStages) {
+		return errors.New("activity not pending for approval")
+	}
+	stage := activity.ActivityStages[activity.PendingStage]
+	stage.Approvals = append(stage.Approvals, &model.Approval{
+		UserId:   userId,
+		Approved: approved,
+		Comment:  comment,
+		TS:       time.Now().UnixNano() / int64(time.Millisecond),
+	})
+	return nil
+}
+
+//IsStageApproved checks whether the pending stage gets enough approvals to run
+func IsStageApproved(activity *model.Activity) bool {
+	if activity.PendingStage >= len(activity.ActivityStages) || activity.PendingStage >= len(activity.Pipeline.Stages) {
+		return false
+	}
+	required := activity.Pipeline.Stages[activity.PendingStage].MinApprovals
+	if required < 1 {
+		required = 1
+	}
+	approvals := 0
+	for _, approval := range activity.ActivityStages[activity.PendingStage].Approvals {
+		if approval.Approved {
+			approvals++
+		}
+	}
+	return approvals >= required
+}
+
+//IsStageDenied checks whether the pending stage is denied. A stage requiring a single approval or with approval veto
+//is denied by any denial, otherwise it is denied once the approvers who have not voted cannot make up the approvals.
+//Members of approver groups are not known, so stages with approver groups or without approvers are kept pending.
+func IsStageDenied(activity *model.Activity) bool {
+	if activity.PendingStage >= len(activity.ActivityStages) || activity.PendingStage >= len(activity.Pipeline.Stages) {
+		return false
+	}
+	stage := activity.Pipeline.Stages[activity.PendingStage]
+	approvals, denials := 0, 0
+	voted := map[string]bool{}
+	for _, approval := range activity.ActivityStages[activity.PendingStage].Approvals {
+		if approval.Approved {
+			approvals++
+		} else {
+			denials++
+		}
+		voted[approval.UserId] = true
+	}
+	if denials == 0 {
+		return false
+	}
+	if stage.ApprovalVeto || stage.MinApprovals <= 1 {
+		return true
+	}
+	if len(stage.ApproverGroups) > 0 || len(stage.Approvers) == 0 {
+		return false
+	}
+	remaining := 0
+	for _, approver := range stage.Approvers {
+		if !voted[approver] {
+			remaining++
+		}
+	}
+	return approvals+remaining < stage.MinApprovals
+}
+
+//GetApprovalTimeoutAction gets the action to take if the pending stage of the activity times out,
+//empty if it does not
+func GetApprovalTimeoutAction(activity *model.Activity) string {
+	if activity.Status != model.ActivityPending ||
+		activity.PendingStage >= len(activity.ActivityStages) ||
+		activity.PendingStage >= len(activity.Pipeline.Stages) {
+		return ""
+	}
+	stage := activity.Pipeline.Stages[activity.PendingStage]
+	pendingTS := activity.ActivityStages[activity.PendingStage].PendingTS
+	if stage.ApprovalTimeout <= 0 || pendingTS == 0 {
+		return ""
+	}
+	deadline := pendingTS + int64(stage.ApprovalTimeout)*int64(time.Minute/time.Millisecond)
+	if time.Now().UnixNano()/int64(time.Millisecond) < deadline {
+		return ""
+	}
+	if stage.ApprovalTimeoutAction == model.ApprovalTimeoutApprove {
+		return model.ApprovalTimeoutApprove
+	}
+	return model.ApprovalTimeoutDeny
+}
+
 func DenyActivity(activity *model.Activity) error {
 	if activity == nil {
 		return errors.New("nil activity")
@@ -223,7 +289,14 @@ func StopActivity(provider model.PipelineProvider, activity *model.Activity) err
 	if activity == nil {
 		return errors.New("nil activity")
 	}
-	if activity.Status != model.ActivityBuilding && activity.Status != model.ActivityWaiting {
+	if activity.Status == model.ActivityQueued {
+		//not run yet, nothing to stop in the provider
+		activity.Status = model.ActivityAbort
+		activity.QueuePosition = 0
+		activity.StopTS = time.Now().UnixNano() / int64(time.Millisecond)
+		return nil
+	}
+	if activity.Status != model.ActivityBuilding && activity.Status != model.ActivityWaiting && activity.Status != model.ActivityPending {
 		return errors.New("Not a running activity for stop")
 	}
 
@@ -235,7 +308,8 @@ func StopActivity(provider model.PipelineProvider, activity *model.Activity) err
 func SyncActivity(provider model.PipelineProvider, activity *model.Activity) error {
 	//its done, no need to sync
 	if activity.Status == model.ActivityFail || activity.Status == model.ActivitySuccess ||
-		activity.Status == model.ActivityDenied || activity.Status == model.ActivityAbort {
+		activity.Status == model.ActivityDenied || activity.Status == model.ActivityAbort ||
+		activity.Status == model.ActivityQueued {
 		return nil
 	}
 	return provider.SyncActivity(activity)
@@ -301,7 +375,7 @@ func StartStep(activity *model.Activity, stageOrdinal int, stepOrdinal int) {
 	step.Status = model.ActivityStepBuilding
 	stage.Status = model.ActivityStageBuilding
 	activity.Status = model.ActivityBuilding
-	if stepOrdinal == 0 {
+	if stepOrdinal == 0 || stage.StartTS == 0 {
 		stage.StartTS = curTime
 	}
 }
@@ -332,15 +406,18 @@ func SuccessStep(activity *model.Activity, stageOrdinal int, stepOrdinal int) {
 	if IsStageSuccess(stage) {
 		stage.Status = model.ActivityStageSuccess
 		stage.Duration = curTime - stage.StartTS
-		if stageOrdinal == len(activity.ActivityStages)-1 {
+		if IsDependencyMode(&activity.Pipeline) {
+			if isStagesDone(activity) {
+				activity.Status = model.ActivitySuccess
+				activity.StopTS = curTime
+			}
+		} else if stageOrdinal == len(activity.ActivityStages)-1 {
 			activity.Status = model.ActivitySuccess
 			activity.StopTS = curTime
 		} else {
 			nextStage := activity.ActivityStages[stageOrdinal+1]
 			if nextStage.NeedApproval {
-				nextStage.Status = model.ActivityStagePending
-				activity.Status = model.ActivityPending
-				activity.PendingStage = stageOrdinal + 1
+				PendActivity(activity, stageOrdinal+1)
 			}
 		}
 	}
@@ -356,6 +433,10 @@ func Triggernext(activity *model.Activity, stageOrdinal int, stepOrdinal int, pr
 		activity.Status == model.ActivityAbort {
 		return
 	}
+	if IsDependencyMode(&activity.Pipeline) {
+		TriggerReadySteps(activity, provider)
+		return
+	}
 	stage := activity.ActivityStages[stageOrdinal]
 	if IsStageSuccess(stage) && stageOrdinal+1 < len(activity.ActivityStages) {
 		nextStage := activity.ActivityStages[stageOrdinal+1]
@@ -375,3 +456,211 @@ func Triggernext(activity *model.Activity, stageOrdinal int, stepOrdinal int, pr
 		}
 	}
 }
+
+//InitActivityEnvvars sets the preserved and user defined env vars of the activity
+func InitActivityEnvvars(activity *model.Activity) {
+	p := activity.Pipeline
+	vars := map[string]string{}
+	vars["CICD_PIPELINE_NAME"] = p.Name
+	vars["CICD_PIPELINE_ID"] = p.Id
+	vars["CICD_NODE_NAME"] = activity.NodeName
+	vars["CICD_ACTIVITY_ID"] = activity.Id
+	vars["CICD_ACTIVITY_SEQUENCE"] = strconv.Itoa(activity.RunSequence)
+	vars["CICD_GIT_URL"] = p.Stages[0].Steps[0].Repository
+	vars["CICD_GIT_BRANCH"] = p.Stages[0].Steps[0].Branch
+	vars["CICD_GIT_COMMIT"] = activity.CommitInfo
+	vars["CICD_TRIGGER_TYPE"] = activity.TriggerType
+	if pr := activity.PullRequest; pr != nil {
+		vars["CICD_PR_NUMBER"] = strconv.Itoa(pr.Number)
+		vars["CICD_PR_SOURCE_BRANCH"] = pr.SourceBranch
+		vars["CICD_PR_TARGET_BRANCH"] = pr.TargetBranch
+	}
+	//user defined env vars
+	for _, envvar := range activity.Pipeline.Parameters {
+		splits := strings.SplitN(envvar, "=", 2)
+		if len(splits) != 2 {
+			continue
+		}
+		vars[splits[0]] = splits[1]
+	}
+	activity.EnvVars = vars
+}
+
+//ToActivityStage inits the activity stage, matrix steps are expanded as ExpandMatrix does
+func ToActivityStage(stage *model.Stage) *model.ActivityStage {
+	stage = ExpandMatrix(stage)
+	actiStage := model.ActivityStage{
+		Name:          stage.Name,
+		NeedApproval:  stage.NeedApprove,
+		Status:        "Waiting",
+		ActivitySteps: []*model.ActivityStep{},
+	}
+	for _, step := range stage.Steps {
+		actiStep := &model.ActivityStep{
+			Name:   step.Name,
+			Status: model.ActivityStepWaiting,
+		}
+		actiStage.ActivitySteps = append(actiStage.ActivitySteps, actiStep)
+	}
+	return &actiStage
+
+}
+
+//EvaluateStageCondition evaluates the condition expression and the all/any conditions of the stage
+func EvaluateStageCondition(activity *model.Activity, stage *model.Stage) (bool, error) {
+	return evaluateConditions(activity, stage.Condition, stage.Conditions)
+}
+
+//EvaluateStepCondition evaluates the condition expression and the all/any conditions of the step
+func EvaluateStepCondition(activity *model.Activity, step *model.Step) (bool, error) {
+	return evaluateConditions(activity, step.Condition, step.Conditions)
+}
+
+func evaluateConditions(activity *model.Activity, expr string, conditions *model.PipelineConditions) (bool, error) {
+	if expr != "" {
+		res, err := EvaluateCondition(activity, expr)
+		if err != nil || !res {
+			return false, err
+		}
+	}
+	if conditions == nil || (len(conditions.All) == 0 && len(conditions.Any) == 0) {
+		return true, nil
+	}
+	return EvaluateConditions(activity, conditions)
+}
+
+func EvaluateConditions(activity *model.Activity, condition *model.PipelineConditions) (bool, error) {
+	if condition == nil || (len(condition.All) == 0 && len(condition.Any) == 0) {
+		return false, fmt.Errorf("Nil condition")
+	}
+	if len(condition.All) > 0 {
+		for _, c := range condition.All {
+			resCond, err := EvaluateCondition(activity, c)
+			if err != nil {
+				return false, err
+			}
+			if !resCond {
+				return false, nil
+			}
+		}
+		return true, nil
+	}
+
+	for _, c := range condition.Any {
+		resCond, err := EvaluateCondition(activity, c)
+		if err != nil {
+			return false, err
+		}
+		if resCond {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//EvaluateCondition evaluates the condition expression against the activity
+func EvaluateCondition(activity *model.Activity, expr string) (bool, error) {
+	res, err := condition.Evaluate(expr, conditionContext(activity))
+	if err != nil {
+		return false, fmt.Errorf("cannot evaluate condition '%s': %v", expr, err)
+	}
+	return res, nil
+}
+
+//conditionContext gets env vars, step statuses and changed files of the activity for condition evaluation
+func conditionContext(activity *model.Activity) *condition.Context {
+	steps := map[string]string{}
+	for i, stage := range activity.Pipeline.Stages {
+		if i >= len(activity.ActivityStages) {
+			break
+		}
+		for j, step := range stage.Steps {
+			if step.Name == "" || j >= len(activity.ActivityStages[i].ActivitySteps) {
+				continue
+			}
+			status := activity.ActivityStages[i].ActivitySteps[j].Status
+			steps[step.Name] = status
+			//a matrix step is successful if all its combinations are
+			if step.MatrixOf != "" {
+				if prev, ok := steps[step.MatrixOf]; !ok || prev == model.ActivityStepSuccess {
+					steps[step.MatrixOf] = status
+				}
+			}
+		}
+	}
+	return &condition.Context{
+		Env:          activity.EnvVars,
+		Steps:        steps,
+		ChangedFiles: activity.ChangedFiles,
+	}
+}
+
+func SubstituteVar(activity *model.Activity, text string) string {
+	for k, v := range activity.EnvVars {
+		text = strings.Replace(text, "$"+k+" ", v, -1)
+		text = strings.Replace(text, "$"+k+"\n", v, -1)
+		text = strings.Replace(text, "${"+k+"}", v, -1)
+
+	}
+	return text
+}
+
+//ExpandMatrix gets the stage whose matrix steps are expanded into one step per combination,
+//the stage itself is returned if there is no matrix step
+func ExpandMatrix(stage *model.Stage) *model.Stage {
+	hasMatrix := false
+	for _, step := range stage.Steps {
+		if len(step.Matrix) > 0 {
+			hasMatrix = true
+			break
+		}
+	}
+	if !hasMatrix {
+		return stage
+	}
+	expanded := *stage
+	expanded.Steps = []*model.Step{}
+	for _, step := range stage.Steps {
+		if len(step.Matrix) == 0 {
+			expanded.Steps = append(expanded.Steps, step)
+			continue
+		}
+		for _, combination := range MatrixCombinations(step.Matrix) {
+			matrixStep := *step
+			matrixStep.Matrix = nil
+			matrixStep.MatrixOf = step.Name
+			matrixStep.Env = append([]string{}, step.Env...)
+			for _, env := range combination {
+				//matrix values are also substituted in the image
+				splits := strings.SplitN(env, "=", 2)
+				matrixStep.Env = append(matrixStep.Env, env)
+				matrixStep.Image = strings.Replace(matrixStep.Image, "${"+splits[0]+"}", splits[1], -1)
+			}
+			matrixStep.Name = fmt.Sprintf("%s (%s)", step.Name, strings.Join(combination, ", "))
+			expanded.Steps = append(expanded.Steps, &matrixStep)
+		}
+	}
+	return &expanded
+}
+
+//MatrixCombinations gets combinations of the matrix in `KEY=value` form, keys are sorted
+//and values are in the given order so that expanded steps are deterministic
+func MatrixCombinations(matrix map[string][]string) [][]string {
+	keys := []string{}
+	for k := range matrix {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	combinations := [][]string{{}}
+	for _, k := range keys {
+		next := [][]string{}
+		for _, combination := range combinations {
+			for _, v := range matrix[k] {
+				c := append(append([]string{}, combination...), k+"="+v)
+				next = append(next, c)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestIsStageDenied(t *testing.T) {
+	approve := &model.Approval{UserId: "u1", Approved: true}
+	deny := &model.Approval{UserId: "u2", Approved: false}
+	deny3 := &model.Approval{UserId: "u3", Approved: false}
+	tests := []struct {
+		name      string
+		stage     model.Stage
+		approvals []*model.Approval
+		want      bool
+	}{
+		{"no denial", model.Stage{MinApprovals: 2, Approvers: []string{"u1", "u2"}}, []*model.Approval{approve}, false},
+		{"single approval", model.Stage{}, []*model.Approval{deny}, true},
+		{"single approval of approvers", model.Stage{Approvers: []string{"u1", "u2", "u3"}}, []*model.Approval{deny}, true},
+		{"quorum reachable", model.Stage{MinApprovals: 2, Approvers: []string{"u1", "u2", "u3"}}, []*model.Approval{deny}, false},
+		{"quorum reachable with approval", model.Stage{MinApprovals: 2, Approvers: []string{"u1", "u2", "u3"}}, []*model.Approval{approve, deny}, false},
+		{"quorum unreachable", model.Stage{MinApprovals: 2, Approvers: []string{"u1", "u2", "u3"}}, []*model.Approval{deny, deny3}, true},
+		{"quorum of all approvers", model.Stage{MinApprovals: 2, Approvers: []string{"u1", "u2"}}, []*model.Approval{deny}, true},
+		{"veto", model.Stage{MinApprovals: 2, ApprovalVeto: true, Approvers: []string{"u1", "u2", "u3"}}, []*model.Approval{deny}, true},
+		{"approver groups", model.Stage{MinApprovals: 2, Approvers: []string{"u1", "u2"}, ApproverGroups: []string{"g"}}, []*model.Approval{deny, deny3}, false},
+		{"no approvers", model.Stage{MinApprovals: 2}, []*model.Approval{deny, deny3}, false},
+	}
+	for _, test := range tests {
+		stage := test.stage
+		activity := &model.Activity{
+			Status:         model.ActivityPending,
+			Pipeline:       model.Pipeline{Stages: []*model.Stage{{}, &stage}},
+			ActivityStages: []*model.ActivityStage{{}, {Approvals: test.approvals}},
+			PendingStage:   1,
+		}
+		if got := IsStageDenied(activity); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
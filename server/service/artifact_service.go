@@ -0,0 +1,112 @@
+package service
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/artifact"
+	"github.com/rancher/pipeline/model"
+)
+
+var artifactStore artifact.BlobStore
+
+//InitArtifactStore sets the store of artifacts archived by steps
+func InitArtifactStore(s artifact.BlobStore) {
+	artifactStore = s
+}
+
+//PutArtifact stores the file archived by the step of the activity
+func PutArtifact(activityId string, stepName string, name string, r io.Reader) (*model.Artifact, error) {
+	if artifactStore == nil {
+		return nil, fmt.Errorf("artifact store is not configured")
+	}
+	name = artifact.CleanName(name)
+	if name == "" {
+		return nil, fmt.Errorf("invalid artifact name")
+	}
+	size, checksum, err := artifactStore.Put(artifact.Key(activityId, name), r)
+	if err != nil {
+		return nil, fmt.Errorf("fail to store artifact '%s': %v", name, err)
+	}
+	return &model.Artifact{
+		Name:     name,
+		StepName: stepName,
+		Size:     size,
+		Checksum: checksum,
+		CreateTS: time.Now().UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+//GetArtifact gets the content of the artifact of the activity
+func GetArtifact(activity *model.Activity, name string) (io.ReadCloser, *model.Artifact, error) {
+	if artifactStore == nil {
+		return nil, nil, artifact.ErrNotFound
+	}
+	for _, a := range activity.Artifacts {
+		if a.Name == name {
+			r, err := artifactStore.Get(artifact.Key(activity.Id, name))
+			return r, a, err
+		}
+	}
+	return nil, nil, artifact.ErrNotFound
+}
+
+//AddArtifacts adds archived artifacts to the activity, replacing ones of the same name
+func AddArtifacts(activity *model.Activity, artifacts []*model.Artifact) {
+	for _, added := range artifacts {
+		replaced := false
+		for i, a := range activity.Artifacts {
+			if a.Name == added.Name {
+				activity.Artifacts[i] = added
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			activity.Artifacts = append(activity.Artifacts, added)
+		}
+	}
+}
+
+//WriteArtifactsTar writes artifacts of the activity as a tar archive, used to restore them into workspaces
+func WriteArtifactsTar(activity *model.Activity, w io.Writer) error {
+	if artifactStore == nil && len(activity.Artifacts) > 0 {
+		return fmt.Errorf("artifact store is not configured")
+	}
+	tw := tar.NewWriter(w)
+	for _, a := range activity.Artifacts {
+		r, err := artifactStore.Get(artifact.Key(activity.Id, a.Name))
+		if err != nil {
+			return fmt.Errorf("fail to get artifact '%s': %v", a.Name, err)
+		}
+		hdr := &tar.Header{
+			Name:    a.Name,
+			Mode:    0644,
+			Size:    a.Size,
+			ModTime: time.Unix(0, a.CreateTS*int64(time.Millisecond)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			r.Close()
+			return err
+		}
+		_, err = io.Copy(tw, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+//RemoveArtifacts removes stored artifacts of the activity, failure is logged but not blocking
+func RemoveArtifacts(activityId string) {
+	if artifactStore == nil {
+		return
+	}
+	if err := artifactStore.Delete(activityId); err != nil {
+		logrus.Errorf("fail to remove artifacts of activity '%s': %v", activityId, err)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/artifact"
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+)
+
+const cacheSuffix = ".tar"
+
+var cacheStore artifact.BlobStore
+var regCacheKey = regexp.MustCompile(`[^\w.-]+`)
+
+//InitCacheStore sets the store of caches saved by steps
+func InitCacheStore(s artifact.BlobStore) {
+	cacheStore = s
+}
+
+func parseCacheKey(keyTemplate string, checksum func(string) string) (*template.Template, error) {
+	return template.New("cache").Funcs(template.FuncMap{"checksum": checksum}).Parse(keyTemplate)
+}
+
+//CheckCacheKey checks the syntax of the cache key template
+func CheckCacheKey(keyTemplate string) error {
+	_, err := parseCacheKey(keyTemplate, func(string) string { return "" })
+	return err
+}
+
+//CacheKeyFiles gets workspace files whose checksums are used by the cache key of the step
+func CacheKeyFiles(activity *model.Activity, step *model.Step) ([]string, error) {
+	files := []string{}
+	t, err := parseCacheKey(SubstituteVar(activity, step.Cache.Key), func(file string) string {
+		files = append(files, file)
+		return ""
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(&bytes.Buffer{}, nil); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+//RenderCacheKey gets the cache key of the step, checksum gets the sha256 of the workspace file
+func RenderCacheKey(activity *model.Activity, step *model.Step, checksum func(file string) string) (string, error) {
+	if step.Cache == nil {
+		return "", fmt.Errorf("no cache in step '%s'", step.Name)
+	}
+	t, err := parseCacheKey(SubstituteVar(activity, step.Cache.Key), checksum)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, nil); err != nil {
+		return "", err
+	}
+	key := strings.Trim(regCacheKey.ReplaceAllString(buf.String(), "-"), "-.")
+	if key == "" {
+		return "", fmt.Errorf("cache key of step '%s' is empty", step.Name)
+	}
+	return key, nil
+}
+
+func cacheBlobKey(pipelineId string, key string) string {
+	return pipelineId + "/" + key + cacheSuffix
+}
+
+//GetCache gets the tar archive of the cache of the pipeline
+func GetCache(pipelineId string, key string) (io.ReadCloser, error) {
+	if cacheStore == nil {
+		return nil, artifact.ErrNotFound
+	}
+	return cacheStore.Get(cacheBlobKey(pipelineId, key))
+}
+
+//SaveCache saves the tar archive of the cache of the pipeline, then evicts stale caches
+func SaveCache(pipelineId string, key string, r io.Reader) error {
+	if cacheStore == nil {
+		return fmt.Errorf("cache store is not configured")
+	}
+	if _, _, err := cacheStore.Put(cacheBlobKey(pipelineId, key), r); err != nil {
+		return fmt.Errorf("fail to save cache '%s': %v", key, err)
+	}
+	EvictCaches(pipelineId)
+	return nil
+}
+
+//ListCaches lists caches of the pipeline, most recently saved first
+func ListCaches(pipelineId string) ([]*model.Cache, error) {
+	if cacheStore == nil {
+		return []*model.Cache{}, nil
+	}
+	blobs, err := cacheStore.List(pipelineId)
+	if err != nil {
+		return nil, err
+	}
+	caches := []*model.Cache{}
+	for _, blob := range blobs {
+		if !strings.HasSuffix(blob.Key, cacheSuffix) {
+			continue
+		}
+		caches = append(caches, &model.Cache{
+			Key:        strings.TrimSuffix(strings.TrimPrefix(blob.Key, pipelineId+"/"), cacheSuffix),
+			PipelineId: pipelineId,
+			Size:       blob.Size,
+			UpdateTS:   blob.ModTime.UnixNano() / int64(time.Millisecond),
+		})
+	}
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].UpdateTS > caches[j].UpdateTS
+	})
+	return caches, nil
+}
+
+//RemoveCache removes the cache of the pipeline
+func RemoveCache(pipelineId string, key string) error {
+	if cacheStore == nil {
+		return artifact.ErrNotFound
+	}
+	r, err := cacheStore.Get(cacheBlobKey(pipelineId, key))
+	if err != nil {
+		return err
+	}
+	r.Close()
+	return cacheStore.Delete(cacheBlobKey(pipelineId, key))
+}
+
+//RemoveCaches removes all caches of the pipeline
+func RemoveCaches(pipelineId string) error {
+	if cacheStore == nil {
+		return nil
+	}
+	return cacheStore.Delete(pipelineId)
+}
+
+//EvictCaches removes caches of the pipeline older than the max age,
+//then least recently saved ones until the total size is under the max size
+func EvictCaches(pipelineId string) {
+	caches, err := ListCaches(pipelineId)
+	if err != nil {
+		logrus.Errorf("fail to list caches of pipeline '%s': %v", pipelineId, err)
+		return
+	}
+	maxAge := int64(config.Config.CacheMaxAge) * int64(24*time.Hour/time.Millisecond)
+	maxSize := int64(config.Config.CacheMaxSize) << 20
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	var total int64
+	for _, cache := range caches {
+		total += cache.Size
+		expired := maxAge > 0 && now-cache.UpdateTS > maxAge
+		oversize := maxSize > 0 && total > maxSize
+		if !expired && !oversize {
+			continue
+		}
+		logrus.Infof("evicting cache '%s' of pipeline '%s'", cache.Key, pipelineId)
+		if err := cacheStore.Delete(cacheBlobKey(pipelineId, cache.Key)); err != nil {
+			logrus.Errorf("fail to evict cache '%s': %v", cache.Key, err)
+		}
+		total -= cache.Size
+	}
+}
@@ -2,65 +2,20 @@ package service
 
 import (
 	"fmt"
-	"net/url"
 	"strings"
 
-	"github.com/Sirupsen/logrus"
-	"github.com/rancher/go-rancher/v2"
 	"github.com/rancher/pipeline/model"
 	"github.com/rancher/pipeline/scm"
-	"github.com/rancher/pipeline/util"
+	"github.com/rancher/pipeline/store"
 )
 
-func PaginateGenericObjects(kind string) ([]client.GenericObject, error) {
-	result := []client.GenericObject{}
-	limit := "1000"
-	marker := ""
-	var pageData []client.GenericObject
-	var err error
-	for {
-		pageData, marker, err = getGenericObjects(kind, limit, marker)
-		if err != nil {
-			logrus.Debugf("get genericobject err:%v", err)
-			return nil, err
-		}
-		result = append(result, pageData...)
-		if marker == "" {
-			break
-		}
-	}
-	return result, nil
-}
-
-func getGenericObjects(kind string, limit string, marker string) ([]client.GenericObject, string, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		logrus.Errorf("fail to get client:%v", err)
-		return nil, "", err
-	}
-	filters := make(map[string]interface{})
-	filters["kind"] = kind
-	filters["limit"] = limit
-	filters["marker"] = marker
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("fail querying generic objects, error:%v", err)
-		return nil, "", err
-	}
-	//get next marker
-	nextMarker := ""
-	if goCollection.Pagination != nil && goCollection.Pagination.Next != "" {
-		r, err := url.Parse(goCollection.Pagination.Next)
-		if err != nil {
-			logrus.Errorf("fail parsing next url, error:%v", err)
-			return nil, "", err
-		}
-		nextMarker = r.Query().Get("marker")
-	}
-	return goCollection.Data, nextMarker, err
+var dataStore store.Store
 
+//InitStore sets the storage backend used by the services, secret fields are encrypted by the
+//configured encryption key
+func InitStore(s store.Store) {
+	rawStore = s
+	dataStore = encryptedStore{s, masterKey()}
 }
 
 func GetSCManager(scmType string) (model.SCManager, error) {
@@ -68,15 +23,7 @@ func GetSCManager(scmType string) (model.SCManager, error) {
 	if err != nil {
 		return nil, err
 	}
-	var manager model.SCManager
-	switch s.ScmType {
-	case "github":
-		manager = &scm.GithubManager{}
-	case "gitlab":
-		manager = &scm.GitlabManager{}
-	}
-	manager = manager.Config(s)
-	return manager, nil
+	return scm.GetManager(s)
 }
 
 func GetSCManagerFromSetting(s *model.SCMSetting) (model.SCManager, error) {
@@ -84,15 +31,7 @@ func GetSCManagerFromSetting(s *model.SCMSetting) (model.SCManager, error) {
 		return nil, fmt.Errorf("null setting")
 	}
 
-	var manager model.SCManager
-	switch s.ScmType {
-	case "github":
-		manager = &scm.GithubManager{}
-	case "gitlab":
-		manager = &scm.GitlabManager{}
-	}
-	manager = manager.Config(s)
-	return manager, nil
+	return scm.GetManager(s)
 }
 
 func GetSCManagerFromUserID(userId string) (model.SCManager, error) {
@@ -105,44 +44,5 @@ func GetSCManagerFromUserID(userId string) (model.SCManager, error) {
 }
 
 func Reset() error {
-	if err := cleanGO("activity"); err != nil {
-		return err
-	}
-	if err := cleanGO("pipeline"); err != nil {
-		return err
-	}
-	if err := cleanGO("pipelineSetting"); err != nil {
-		return err
-	}
-	if err := cleanGO("scmSetting"); err != nil {
-		return err
-	}
-	if err := cleanGO("gitaccount"); err != nil {
-		return err
-	}
-	if err := cleanGO("repocache"); err != nil {
-		return err
-	}
-	if err := cleanGO("pipelineCred"); err != nil {
-		return err
-	}
-	return nil
-}
-
-func cleanGO(kind string) error {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-	data, err := PaginateGenericObjects(kind)
-	if err != nil {
-		return err
-	}
-	for _, gobj := range data {
-		if err := apiClient.GenericObject.Delete(&gobj); err != nil {
-			return err
-		}
-	}
-	return nil
-
+	return dataStore.Reset()
 }
@@ -0,0 +1,158 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/rancher/pipeline/model"
+	"github.com/sluu99/uuid"
+)
+
+//ErrRunSkipped is returned when a run is skipped as the pipeline runs at its max concurrency
+var ErrRunSkipped = errors.New("run is skipped as the pipeline runs at its max concurrency")
+
+//IsRunning checks whether the activity is started and not complete
+func IsRunning(activity *model.Activity) bool {
+	return activity.Status == model.ActivityWaiting ||
+		activity.Status == model.ActivityBuilding ||
+		activity.Status == model.ActivityPending
+}
+
+//PipelineRuns gets running activities of the pipeline and queued ones, both oldest first
+func PipelineRuns(pipelineId string) ([]*model.Activity, []*model.Activity, error) {
+	activities, err := ListActivitiesOfPipeline(pipelineId)
+	if err != nil {
+		return nil, nil, err
+	}
+	running := []*model.Activity{}
+	queued := []*model.Activity{}
+	for _, activity := range activities {
+		if IsRunning(activity) {
+			running = append(running, activity)
+		} else if activity.Status == model.ActivityQueued {
+			queued = append(queued, activity)
+		}
+	}
+	sort.SliceStable(running, func(i, j int) bool {
+		return running[i].StartTS < running[j].StartTS
+	})
+	sort.SliceStable(queued, func(i, j int) bool {
+		return queued[i].StartTS < queued[j].StartTS
+	})
+	return running, queued, nil
+}
+
+//IsAtMaxConcurrency checks whether a new run of the pipeline has to wait, cancel previous runs or be skipped
+func IsAtMaxConcurrency(p *model.Pipeline, running []*model.Activity, queued []*model.Activity) bool {
+	if p.Concurrency == nil || p.Concurrency.Max <= 0 {
+		return false
+	}
+	return len(running) >= p.Concurrency.Max || len(queued) > 0
+}
+
+//ConcurrencyPolicy gets the policy of the pipeline at its max concurrency
+func ConcurrencyPolicy(p *model.Pipeline) string {
+	if p.Concurrency == nil || p.Concurrency.Policy == "" {
+		return model.ConcurrencyQueue
+	}
+	return p.Concurrency.Policy
+}
+
+//QueueActivity creates a queued activity of the pipeline, which runs when the pipeline is under its max concurrency
+func QueueActivity(id string, trigger *model.TriggerInfo) (*model.Activity, error) {
+	pp, err := GetPipelineById(id)
+	if err != nil {
+		return nil, fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	resolved, err := runnablePipeline(pp, trigger)
+	if err != nil {
+		return nil, err
+	}
+	_, queued, err := PipelineRuns(id)
+	if err != nil {
+		return nil, err
+	}
+	activity := &model.Activity{
+		Id:            uuid.Rand().Hex(),
+		Pipeline:      *resolved,
+		Status:        model.ActivityQueued,
+		StartTS:       time.Now().UnixNano() / int64(time.Millisecond),
+		TriggerType:   trigger.TriggerType,
+		ChangedFiles:  trigger.ChangedFiles,
+		CommitInfo:    trigger.Commit,
+		PullRequest:   trigger.PullRequest,
+		QueuePosition: len(queued) + 1,
+	}
+	//matrix steps are expanded in stages of the activity, the pipeline is not changed
+	activity.Pipeline.Stages = []*model.Stage{}
+	for _, stage := range resolved.Stages {
+		stage = ExpandMatrix(stage)
+		activity.Pipeline.Stages = append(activity.Pipeline.Stages, stage)
+		activity.ActivityStages = append(activity.ActivityStages, ToActivityStage(stage))
+	}
+	InitActivityEnvvars(activity)
+	if err := CreateActivity(activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+//RunQueuedActivity runs the queued activity and updates the last run of the pipeline
+func RunQueuedActivity(provider model.PipelineProvider, activity *model.Activity) error {
+	if activity.Status != model.ActivityQueued {
+		return errors.New("activity is not queued")
+	}
+	pp, err := GetPipelineById(activity.Pipeline.Id)
+	if err != nil {
+		return fmt.Errorf("fail to get pipeline: %v", err)
+	}
+	ResetActivityStatus(activity)
+	activity.Pipeline.RunCount = pp.RunCount
+	if err := provider.RerunActivity(activity); err != nil {
+		return err
+	}
+	pp.RunCount = activity.RunSequence
+	pp.LastRunId = activity.Id
+	pp.LastRunStatus = activity.Status
+	pp.LastRunTime = activity.StartTS
+	pp.NextRunTime = GetNextRunTime(pp)
+	return UpdatePipeline(pp)
+}
+
+//activityBranch gets the branch that the activity runs, pull requests are told apart by the number
+func activityBranch(activity *model.Activity) string {
+	if pr := activity.PullRequest; pr != nil {
+		return fmt.Sprintf("pull/%d", pr.Number)
+	}
+	return activity.Pipeline.Stages[0].Steps[0].Branch
+}
+
+//SupersededActivities gets older running or queued activities of the same branch as the newer activity,
+//activities waiting for approval are included only if configured by auto cancel of the pipeline
+func SupersededActivities(p *model.Pipeline, newer *model.Activity) ([]*model.Activity, error) {
+	if p.AutoCancel == nil || !p.AutoCancel.Enabled {
+		return nil, nil
+	}
+	activities, err := ListActivitiesOfPipeline(p.Id)
+	if err != nil {
+		return nil, err
+	}
+	superseded := []*model.Activity{}
+	for _, activity := range activities {
+		if activity.Id == newer.Id || activity.StartTS > newer.StartTS {
+			continue
+		}
+		if !IsRunning(activity) && activity.Status != model.ActivityQueued {
+			continue
+		}
+		if activity.Status == model.ActivityPending && !p.AutoCancel.Pending {
+			continue
+		}
+		if activityBranch(activity) == activityBranch(newer) {
+			superseded = append(superseded, activity)
+		}
+	}
+	return superseded, nil
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/store"
+)
+
+//initTestStore stores data of the services in a SQLite store of a temp dir, which is removed by the returned func
+func initTestStore(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "pipeline-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := store.NewSQLiteStore(filepath.Join(dir, "pipeline.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("fail to create store: %v", err)
+	}
+	InitStore(s)
+	return func() { os.RemoveAll(dir) }
+}
+
+func testPipeline(id string) *model.Pipeline {
+	return &model.Pipeline{
+		Id:   id,
+		Name: "pipeline " + id,
+		Stages: []*model.Stage{
+			{Steps: []*model.Step{{Type: model.StepTypeSCM, Repository: "https://github.com/user/repo.git", Branch: "master"}}},
+			{Steps: []*model.Step{{Name: "build", Type: model.StepTypeTask}}},
+		},
+	}
+}
+
+//createTestActivity stores an activity of the pipeline with the status and the start time
+func createTestActivity(t *testing.T, p *model.Pipeline, id string, status string, startTS int64) *model.Activity {
+	activity := &model.Activity{
+		Id:       id,
+		Pipeline: *p,
+		Status:   status,
+		StartTS:  startTS,
+	}
+	if err := CreateActivity(activity); err != nil {
+		t.Fatalf("fail to create activity %s: %v", id, err)
+	}
+	return activity
+}
+
+func activityIds(activities []*model.Activity) []string {
+	ids := []string{}
+	for _, activity := range activities {
+		ids = append(ids, activity.Id)
+	}
+	return ids
+}
+
+func TestPipelineRuns(t *testing.T) {
+	defer initTestStore(t)()
+	p := testPipeline("p1")
+	createTestActivity(t, p, "q2", model.ActivityQueued, 50)
+	createTestActivity(t, p, "r2", model.ActivityBuilding, 30)
+	createTestActivity(t, p, "done", model.ActivitySuccess, 5)
+	createTestActivity(t, p, "r1", model.ActivityPending, 10)
+	createTestActivity(t, p, "q1", model.ActivityQueued, 40)
+	createTestActivity(t, p, "r3", model.ActivityWaiting, 35)
+	createTestActivity(t, p, "aborted", model.ActivityAbort, 45)
+	createTestActivity(t, testPipeline("p2"), "other", model.ActivityBuilding, 1)
+
+	running, queued, err := PipelineRuns("p1")
+	if err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if got := activityIds(running); !reflect.DeepEqual(got, []string{"r1", "r2", "r3"}) {
+		t.Errorf("got running %v, want oldest first [r1 r2 r3]", got)
+	}
+	if got := activityIds(queued); !reflect.DeepEqual(got, []string{"q1", "q2"}) {
+		t.Errorf("got queued %v, want oldest first [q1 q2]", got)
+	}
+}
+
+func TestIsAtMaxConcurrency(t *testing.T) {
+	one := []*model.Activity{{}}
+	two := []*model.Activity{{}, {}}
+	tests := []struct {
+		name        string
+		concurrency *model.PipelineConcurrency
+		running     []*model.Activity
+		queued      []*model.Activity
+		want        bool
+	}{
+		{"no limit", nil, two, nil, false},
+		{"zero max", &model.PipelineConcurrency{Max: 0}, two, one, false},
+		{"under max", &model.PipelineConcurrency{Max: 2}, one, nil, false},
+		{"at max", &model.PipelineConcurrency{Max: 2}, two, nil, true},
+		{"under max with queued", &model.PipelineConcurrency{Max: 2}, one, one, true},
+	}
+	for _, test := range tests {
+		p := &model.Pipeline{Concurrency: test.concurrency}
+		if got := IsAtMaxConcurrency(p, test.running, test.queued); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConcurrencyPolicy(t *testing.T) {
+	tests := []struct {
+		concurrency *model.PipelineConcurrency
+		want        string
+	}{
+		{nil, model.ConcurrencyQueue},
+		{&model.PipelineConcurrency{Max: 1}, model.ConcurrencyQueue},
+		{&model.PipelineConcurrency{Max: 1, Policy: model.ConcurrencySkip}, model.ConcurrencySkip},
+		{&model.PipelineConcurrency{Max: 1, Policy: model.ConcurrencyCancelPrevious}, model.ConcurrencyCancelPrevious},
+	}
+	for _, test := range tests {
+		if got := ConcurrencyPolicy(&model.Pipeline{Concurrency: test.concurrency}); got != test.want {
+			t.Errorf("concurrency %+v: got policy %s, want %s", test.concurrency, got, test.want)
+		}
+	}
+}
+
+func TestQueueActivity(t *testing.T) {
+	defer initTestStore(t)()
+	p := testPipeline("p1")
+	if err := CreatePipeline(p); err != nil {
+		t.Fatal(err)
+	}
+	createTestActivity(t, p, "r1", model.ActivityBuilding, 1)
+	for i := 1; i <= 2; i++ {
+		activity, err := QueueActivity("p1", &model.TriggerInfo{TriggerType: model.TriggerTypeManual})
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		if activity.Status != model.ActivityQueued || activity.QueuePosition != i {
+			t.Errorf("got status %s at position %d, want %s at %d", activity.Status, activity.QueuePosition, model.ActivityQueued, i)
+		}
+		if len(activity.ActivityStages) != len(p.Stages) {
+			t.Errorf("got %d activity stages, want %d", len(activity.ActivityStages), len(p.Stages))
+		}
+	}
+	if _, queued, _ := PipelineRuns("p1"); len(queued) != 2 {
+		t.Errorf("got %d queued activities stored, want 2", len(queued))
+	}
+}
+
+//rerunProvider reruns queued activities, or fails to if err is set
+type rerunProvider struct {
+	model.PipelineProvider
+	err error
+}
+
+func (r *rerunProvider) RerunActivity(activity *model.Activity) error {
+	if r.err != nil {
+		return r.err
+	}
+	activity.Status = model.ActivityBuilding
+	activity.RunSequence = activity.Pipeline.RunCount + 1
+	return nil
+}
+
+func TestRunQueuedActivity(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      string
+		rerunErr    error
+		wantErr     bool
+		wantLastRun string
+	}{
+		{"queued", model.ActivityQueued, nil, false, "q1"},
+		{"provider error", model.ActivityQueued, errors.New("jenkins is down"), true, ""},
+		{"not queued", model.ActivityAbort, nil, true, ""},
+	}
+	for _, test := range tests {
+		cleanup := initTestStore(t)
+		p := testPipeline("p1")
+		p.RunCount = 3
+		if err := CreatePipeline(p); err != nil {
+			t.Fatal(err)
+		}
+		activity := createTestActivity(t, p, "q1", test.status, 1)
+		err := RunQueuedActivity(&rerunProvider{err: test.rerunErr}, activity)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+		pp, _ := GetPipelineById("p1")
+		if pp.LastRunId != test.wantLastRun {
+			t.Errorf("%s: got last run '%s', want '%s'", test.name, pp.LastRunId, test.wantLastRun)
+		}
+		if test.wantLastRun != "" && pp.RunCount != 4 {
+			t.Errorf("%s: got run count %d, want 4", test.name, pp.RunCount)
+		}
+		cleanup()
+	}
+}
+
+func TestSupersededActivities(t *testing.T) {
+	defer initTestStore(t)()
+	p := testPipeline("p1")
+	dev := testPipeline("p1")
+	dev.Stages[0].Steps[0].Branch = "dev"
+	createTestActivity(t, p, "running", model.ActivityBuilding, 10)
+	createTestActivity(t, p, "waiting", model.ActivityWaiting, 11)
+	createTestActivity(t, p, "queued", model.ActivityQueued, 12)
+	createTestActivity(t, p, "pending", model.ActivityPending, 13)
+	createTestActivity(t, p, "done", model.ActivitySuccess, 14)
+	createTestActivity(t, dev, "dev", model.ActivityBuilding, 15)
+	pr1 := createTestActivity(t, p, "pr1", model.ActivityBuilding, 16)
+	pr1.PullRequest = &model.PullRequest{Number: 1}
+	UpdateActivity(pr1)
+	pr2 := createTestActivity(t, p, "pr2", model.ActivityBuilding, 17)
+	pr2.PullRequest = &model.PullRequest{Number: 2}
+	UpdateActivity(pr2)
+	createTestActivity(t, p, "later", model.ActivityBuilding, 30)
+
+	tests := []struct {
+		name       string
+		autoCancel *model.AutoCancel
+		newer      *model.Activity
+		want       []string
+	}{
+		{"disabled", nil, &model.Activity{Id: "new", Pipeline: *p, StartTS: 20}, nil},
+		{"same branch", &model.AutoCancel{Enabled: true}, &model.Activity{Id: "new", Pipeline: *p, StartTS: 20},
+			[]string{"running", "waiting", "queued"}},
+		{"same branch with pending", &model.AutoCancel{Enabled: true, Pending: true}, &model.Activity{Id: "new", Pipeline: *p, StartTS: 20},
+			[]string{"running", "waiting", "queued", "pending"}},
+		{"other branch", &model.AutoCancel{Enabled: true}, &model.Activity{Id: "new", Pipeline: *dev, StartTS: 20},
+			[]string{"dev"}},
+		{"same pull request", &model.AutoCancel{Enabled: true},
+			&model.Activity{Id: "new", Pipeline: *p, StartTS: 20, PullRequest: &model.PullRequest{Number: 1}},
+			[]string{"pr1"}},
+	}
+	for _, test := range tests {
+		pp := testPipeline("p1")
+		pp.AutoCancel = test.autoCancel
+		superseded, err := SupersededActivities(pp, test.newer)
+		if err != nil {
+			t.Errorf("%s: got error: %v", test.name, err)
+			continue
+		}
+		got := activityIds(superseded)
+		if !sameStrings(got, test.want) {
+			t.Errorf("%s: got superseded %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+//sameStrings compares strings regardless of the order
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := map[string]int{}
+	for _, s := range a {
+		count[s]++
+	}
+	for _, s := range b {
+		count[s]--
+		if count[s] < 0 {
+			return false
+		}
+	}
+	return true
+}
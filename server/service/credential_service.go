@@ -0,0 +1,198 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/pipeline/git"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/store"
+)
+
+//ListGitCredentials lists ssh key and basic auth credentials for git repositories that the user can use
+func ListGitCredentials(uid string) ([]*model.Credential, error) {
+	creds, err := dataStore.Credentials().List()
+	if err != nil {
+		return nil, fmt.Errorf("Error %v listing credentials", err)
+	}
+	result := []*model.Credential{}
+	for _, cred := range creds {
+		if isGitCredential(cred) && ValidCredentialAccessById(uid, cred) {
+			result = append(result, cred)
+		}
+	}
+	return result, nil
+}
+
+func GetGitCredential(id string) (*model.Credential, error) {
+	cred, err := dataStore.Credentials().Get(id)
+	if err == store.ErrNotFound || err == nil && !isGitCredential(cred) {
+		return nil, fmt.Errorf("git credential '%s' is not found", id)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error %v getting credential", err)
+	}
+	return cred, nil
+}
+
+func CreateGitCredential(cred *model.Credential) error {
+	if err := validateGitCredential(cred); err != nil {
+		return err
+	}
+	return CreateCredential(cred)
+}
+
+//UpdateGitCredential updates the credential, the secret and known hosts are kept if they are not provided
+func UpdateGitCredential(cred *model.Credential) error {
+	prev, err := GetGitCredential(cred.Id)
+	if err != nil {
+		return err
+	}
+	if cred.SecretValue == "" {
+		cred.SecretValue = prev.SecretValue
+	}
+	if cred.KnownHosts == "" {
+		cred.KnownHosts = prev.KnownHosts
+	}
+	cred.Owner = prev.Owner
+	if err := validateGitCredential(cred); err != nil {
+		return err
+	}
+	return UpdateCredential(cred)
+}
+
+//RemoveGitCredential removes the credential if no pipeline uses it
+func RemoveGitCredential(id string) (*model.Credential, error) {
+	if _, err := GetGitCredential(id); err != nil {
+		return nil, err
+	}
+	for _, p := range ListPipelines() {
+		if len(p.Stages) > 0 && len(p.Stages[0].Steps) > 0 && p.Stages[0].Steps[0].GitCredential == id {
+			return nil, fmt.Errorf("git credential '%s' is used by pipeline '%s'", id, p.Name)
+		}
+	}
+	return dataStore.Credentials().Delete(id)
+}
+
+//AssignCredentialOwner sets the owner of git credentials which have no owner, which are used by all users.
+//It migrates credentials created before owners, the number of changed credentials is returned.
+func AssignCredentialOwner(owner string) (int, error) {
+	creds, err := dataStore.Credentials().List()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, cred := range creds {
+		if !isGitCredential(cred) || cred.Owner != "" {
+			continue
+		}
+		if owner == "" {
+			logrus.Warningf("git credential '%s' has no owner and is used by all users", cred.Name)
+			continue
+		}
+		cred.Owner = owner
+		if err := UpdateCredential(cred); err != nil {
+			return count, fmt.Errorf("fail to set owner of git credential '%s': %v", cred.Name, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+func isGitCredential(cred *model.Credential) bool {
+	return cred.CredType == model.CredTypeSSHKey || cred.CredType == model.CredTypeBasicAuth
+}
+
+//ValidCredentialAccess checks the current user can use the git credential
+func ValidCredentialAccess(req *http.Request, cred *model.Credential) bool {
+	return ValidCredentialAccessById(CurrentUser(req), cred)
+}
+
+//ValidCredentialAccessById checks the user owns the git credential or it has no owner
+func ValidCredentialAccessById(uid string, cred *model.Credential) bool {
+	return cred.Owner == "" || cred.Owner == uid
+}
+
+//validateKnownHosts checks the known_hosts entries of the ssh host, which are required to verify the host key
+func validateKnownHosts(knownHosts string) error {
+	count := 0
+	for _, line := range strings.Split(knownHosts, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		//host names, key type and base64 key, an optional marker comes first
+		fields := strings.Fields(line)
+		if strings.HasPrefix(fields[0], "@") {
+			fields = fields[1:]
+		}
+		if len(fields) < 3 {
+			return fmt.Errorf("invalid known hosts entry '%s'", line)
+		}
+		count++
+	}
+	if count == 0 {
+		return errors.New("known hosts of the ssh host are required for ssh key credential")
+	}
+	return nil
+}
+
+func validateGitCredential(cred *model.Credential) error {
+	switch cred.CredType {
+	case model.CredTypeSSHKey:
+		if !strings.Contains(cred.SecretValue, "PRIVATE KEY") {
+			return errors.New("a private key in PEM format is required for ssh key credential")
+		}
+		if err := validateKnownHosts(cred.KnownHosts); err != nil {
+			return err
+		}
+	case model.CredTypeBasicAuth:
+		if cred.PublicValue == "" || cred.SecretValue == "" {
+			return errors.New("user name and password are required for basic auth credential")
+		}
+	default:
+		return fmt.Errorf("invalid git credential type '%s'", cred.CredType)
+	}
+	return nil
+}
+
+//GetActivityCloneCredential gets the clone credential of the scm step of the activity, pull requests of forks
+//are cloned without credentials
+func GetActivityCloneCredential(activity *model.Activity, step *model.Step) (string, string, string, error) {
+	if IsForkPullRequest(activity) {
+		return step.Repository, "", "", nil
+	}
+	return GetCloneCredential(step)
+}
+
+//GetCloneCredential gets the repository url to clone in the scm step, the ssh private key and known hosts
+//if the step uses a ssh key credential. The url carries the git account token or the basic auth credential.
+func GetCloneCredential(step *model.Step) (string, string, string, error) {
+	if step.GitCredential != "" {
+		cred, err := GetGitCredential(step.GitCredential)
+		if err != nil {
+			return "", "", "", err
+		}
+		if cred.CredType == model.CredTypeSSHKey {
+			return step.Repository, cred.SecretValue, cred.KnownHosts, nil
+		}
+		repoUrl, err := git.GetAuthRepoUrl(step.Repository, cred.PublicValue, cred.SecretValue)
+		return repoUrl, "", "", err
+	}
+	if step.GitUser != "" {
+		repoUrl, err := GetAuthRepoUrl(step.Repository, step.GitUser)
+		return repoUrl, "", "", err
+	}
+	return step.Repository, "", "", nil
+}
+
+//BranchHeadCommit gets the head commit of the branch in the scm step
+func BranchHeadCommit(step *model.Step) (string, error) {
+	repoUrl, sshKey, knownHosts, err := GetCloneCredential(step)
+	if err != nil {
+		return "", fmt.Errorf("get repo credential got error: %v", err)
+	}
+	return git.BranchHeadCommit(repoUrl, step.Branch, sshKey, knownHosts)
+}
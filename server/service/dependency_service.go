@@ -0,0 +1,208 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+)
+
+//StepRef refers to a step by its stage ordinal and step ordinal
+type StepRef struct {
+	Stage int
+	Step  int
+}
+
+//IsDependencyMode checks whether steps of the pipeline run by declared dependencies instead of stage by stage
+func IsDependencyMode(p *model.Pipeline) bool {
+	for _, stage := range p.Stages {
+		for _, step := range stage.Steps {
+			if len(step.DependsOn) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//StepDependencies gets steps that each step depends on. Steps declaring dependsOn depend on them and the SCM step.
+//Other steps keep stage semantics, they depend on the previous step in a sequential stage, or on all steps of
+//the previous stage for steps in a parallel stage and the first step in a sequential stage.
+//Names of matrix steps refer to all their combinations.
+func StepDependencies(p *model.Pipeline) (map[StepRef][]StepRef, error) {
+	byName := map[string][]StepRef{}
+	for i, stage := range p.Stages {
+		for j, step := range stage.Steps {
+			ref := StepRef{i, j}
+			byName[step.Name] = append(byName[step.Name], ref)
+			if step.MatrixOf != "" && step.MatrixOf != step.Name {
+				byName[step.MatrixOf] = append(byName[step.MatrixOf], ref)
+			}
+		}
+	}
+	deps := map[StepRef][]StepRef{}
+	for i, stage := range p.Stages {
+		for j, step := range stage.Steps {
+			ref := StepRef{i, j}
+			switch {
+			case i == 0 && j == 0:
+				deps[ref] = nil
+			case len(step.DependsOn) > 0:
+				deps[ref] = []StepRef{{0, 0}}
+				for _, name := range step.DependsOn {
+					refs, ok := byName[name]
+					if !ok {
+						return nil, fmt.Errorf("step '%s' depends on unknown step '%s'", step.Name, name)
+					}
+					deps[ref] = append(deps[ref], refs...)
+				}
+			case !stage.Parallel && j > 0:
+				deps[ref] = []StepRef{{i, j - 1}}
+			default:
+				for k := range p.Stages[i-1].Steps {
+					deps[ref] = append(deps[ref], StepRef{i - 1, k})
+				}
+			}
+		}
+	}
+	if ref, ok := findCycle(deps); ok {
+		return nil, fmt.Errorf("dependencies of step '%s' form a cycle", p.Stages[ref.Stage].Steps[ref.Step].Name)
+	}
+	return deps, nil
+}
+
+//findCycle gets a step in a dependency cycle if there is any
+func findCycle(deps map[StepRef][]StepRef) (StepRef, bool) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[StepRef]int{}
+	var visit func(ref StepRef) bool
+	visit = func(ref StepRef) bool {
+		switch state[ref] {
+		case visiting:
+			return true
+		case visited:
+			return false
+		}
+		state[ref] = visiting
+		for _, dep := range deps[ref] {
+			if visit(dep) {
+				return true
+			}
+		}
+		state[ref] = visited
+		return false
+	}
+	for ref := range deps {
+		if visit(ref) {
+			for r, st := range state {
+				if st == visiting {
+					return r, true
+				}
+			}
+		}
+	}
+	return StepRef{}, false
+}
+
+//TriggerReadySteps runs waiting steps whose dependencies are all successful or skipped. Stage and step
+//conditions are evaluated when the steps are ready, and steps of unmet conditions are skipped.
+func TriggerReadySteps(activity *model.Activity, provider model.PipelineProvider) {
+	deps, err := StepDependencies(&activity.Pipeline)
+	if err != nil {
+		logrus.Errorf("fail to get step dependencies of activity '%s': %v", activity.Id, err)
+		activity.FailMessage = err.Error()
+		return
+	}
+	curTime := time.Now().UnixNano() / int64(time.Millisecond)
+	for progressed := true; progressed; {
+		progressed = false
+		for i, actiStage := range activity.ActivityStages {
+			stage := activity.Pipeline.Stages[i]
+			for j, actiStep := range actiStage.ActivitySteps {
+				if actiStep.Status != model.ActivityStepWaiting || actiStep.Triggered || !isStepsDone(activity, deps[StepRef{i, j}]) {
+					continue
+				}
+				if actiStage.Status == model.ActivityStageWaiting {
+					if !evaluateOrSkip(activity, stage.Name, HasStageCondition(stage), func() (bool, error) {
+						return EvaluateStageCondition(activity, stage)
+					}) {
+						skipStage(actiStage)
+						progressed = true
+						break
+					}
+					actiStage.Status = model.ActivityStageBuilding
+					actiStage.StartTS = curTime
+				}
+				step := stage.Steps[j]
+				if !evaluateOrSkip(activity, step.Name, HasStepCondition(step), func() (bool, error) {
+					return EvaluateStepCondition(activity, step)
+				}) {
+					actiStep.Status = model.ActivityStepSkip
+					if IsStageSuccess(actiStage) {
+						actiStage.Status = model.ActivityStageSuccess
+						actiStage.Duration = curTime - actiStage.StartTS
+					}
+					progressed = true
+					continue
+				}
+				actiStep.Triggered = true
+				if err := provider.RunStep(activity, i, j); err != nil {
+					logrus.Errorf("trigger step '%s' got error:%v", step.Name, err)
+					activity.FailMessage = fmt.Sprintf("trigger step '%s' got error:%v", step.Name, err)
+				}
+			}
+		}
+	}
+	if activity.Status != model.ActivityFail && isStagesDone(activity) {
+		activity.Status = model.ActivitySuccess
+		activity.StopTS = curTime
+	}
+}
+
+//evaluateOrSkip evaluates the condition if there is one, an evaluation error is logged and taken as unmet
+func evaluateOrSkip(activity *model.Activity, name string, hasCondition bool, evaluate func() (bool, error)) bool {
+	if !hasCondition {
+		return true
+	}
+	ok, err := evaluate()
+	if err != nil {
+		logrus.Errorf("Evaluate condition of '%s' got error:%v", name, err)
+		return false
+	}
+	return ok
+}
+
+func skipStage(stage *model.ActivityStage) {
+	stage.Status = model.ActivityStageSkip
+	for _, step := range stage.ActivitySteps {
+		if step.Status == model.ActivityStepWaiting {
+			step.Status = model.ActivityStepSkip
+		}
+	}
+}
+
+//isStepsDone checks whether the steps are all successful or skipped. A skipped step satisfies its dependents as
+//a skipped stage lets the next stage run, while dependents of a failed step never run as the activity fails.
+func isStepsDone(activity *model.Activity, refs []StepRef) bool {
+	for _, ref := range refs {
+		status := activity.ActivityStages[ref.Stage].ActivitySteps[ref.Step].Status
+		if status != model.ActivityStepSuccess && status != model.ActivityStepSkip {
+			return false
+		}
+	}
+	return true
+}
+
+//isStagesDone checks whether all stages of the activity are successful or skipped
+func isStagesDone(activity *model.Activity) bool {
+	for _, stage := range activity.ActivityStages {
+		if stage.Status != model.ActivityStageSuccess && stage.Status != model.ActivityStageSkip {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+//testDependencyPipeline gets a pipeline of the scm step and the stages
+func testDependencyPipeline(stages ...*model.Stage) *model.Pipeline {
+	scm := &model.Stage{Name: "scm", Steps: []*model.Step{{Name: "checkout", Type: model.StepTypeSCM}}}
+	return &model.Pipeline{Stages: append([]*model.Stage{scm}, stages...)}
+}
+
+func TestStepDependencies(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  []*model.Stage
+		want    map[StepRef][]StepRef
+		wantErr bool
+	}{
+		{
+			name: "fan-out and fan-in",
+			stages: []*model.Stage{
+				{Parallel: true, Steps: []*model.Step{
+					{Name: "lint", DependsOn: []string{"checkout"}},
+					{Name: "unit", DependsOn: []string{"checkout"}},
+				}},
+				{Steps: []*model.Step{{Name: "package", DependsOn: []string{"lint", "unit"}}}},
+			},
+			want: map[StepRef][]StepRef{
+				{0, 0}: nil,
+				{1, 0}: {{0, 0}, {0, 0}},
+				{1, 1}: {{0, 0}, {0, 0}},
+				{2, 0}: {{0, 0}, {1, 0}, {1, 1}},
+			},
+		},
+		{
+			name: "stage order without dependsOn",
+			stages: []*model.Stage{
+				{Parallel: true, Steps: []*model.Step{{Name: "a", DependsOn: []string{"checkout"}}, {Name: "b"}}},
+				{Steps: []*model.Step{{Name: "c"}, {Name: "d"}}},
+			},
+			want: map[StepRef][]StepRef{
+				{0, 0}: nil,
+				{1, 0}: {{0, 0}, {0, 0}},
+				{1, 1}: {{0, 0}},
+				{2, 0}: {{1, 0}, {1, 1}},
+				{2, 1}: {{2, 0}},
+			},
+		},
+		{
+			name: "matrix combinations",
+			stages: []*model.Stage{
+				{Parallel: true, Steps: []*model.Step{{Name: "test-1", MatrixOf: "test"}, {Name: "test-2", MatrixOf: "test"}}},
+				{Steps: []*model.Step{{Name: "report", DependsOn: []string{"test"}}}},
+			},
+			want: map[StepRef][]StepRef{
+				{0, 0}: nil,
+				{1, 0}: {{0, 0}},
+				{1, 1}: {{0, 0}},
+				{2, 0}: {{0, 0}, {1, 0}, {1, 1}},
+			},
+		},
+		{
+			name:    "unknown dependency",
+			stages:  []*model.Stage{{Steps: []*model.Step{{Name: "a", DependsOn: []string{"missing"}}}}},
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			stages: []*model.Stage{
+				{Parallel: true, Steps: []*model.Step{
+					{Name: "a", DependsOn: []string{"b"}},
+					{Name: "b", DependsOn: []string{"a"}},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "self dependency",
+			stages:  []*model.Stage{{Steps: []*model.Step{{Name: "a", DependsOn: []string{"a"}}}}},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got, err := StepDependencies(testDependencyPipeline(test.stages...))
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+//runRecorder records steps run by TriggerReadySteps
+type runRecorder struct {
+	model.PipelineProvider
+	runs []StepRef
+}
+
+func (r *runRecorder) RunStep(activity *model.Activity, stageOrdinal int, stepOrdinal int) error {
+	r.runs = append(r.runs, StepRef{stageOrdinal, stepOrdinal})
+	return nil
+}
+
+func TestTriggerReadySteps(t *testing.T) {
+	//lint and unit fan out from checkout, package fans in from both
+	stages := []*model.Stage{
+		{Parallel: true, Steps: []*model.Step{
+			{Name: "lint", DependsOn: []string{"checkout"}},
+			{Name: "unit", DependsOn: []string{"checkout"}},
+		}},
+		{Steps: []*model.Step{{Name: "package", DependsOn: []string{"lint", "unit"}}}},
+	}
+	tests := []struct {
+		name         string
+		lint         string
+		unit         string
+		want         []StepRef
+		wantActivity string
+	}{
+		{"fan-out", model.ActivityStepWaiting, model.ActivityStepWaiting, []StepRef{{1, 0}, {1, 1}}, model.ActivityBuilding},
+		{"fan-in waits for all parents", model.ActivityStepSuccess, model.ActivityStepBuilding, nil, model.ActivityBuilding},
+		{"fan-in", model.ActivityStepSuccess, model.ActivityStepSuccess, []StepRef{{2, 0}}, model.ActivityBuilding},
+		{"skipped parent", model.ActivityStepSkip, model.ActivityStepSuccess, []StepRef{{2, 0}}, model.ActivityBuilding},
+		{"failed parent", model.ActivityStepFail, model.ActivityStepSuccess, nil, model.ActivityFail},
+	}
+	for _, test := range tests {
+		activity := &model.Activity{Status: model.ActivityBuilding, Pipeline: *testDependencyPipeline(stages...)}
+		for _, stage := range activity.Pipeline.Stages {
+			activity.ActivityStages = append(activity.ActivityStages, ToActivityStage(stage))
+		}
+		activity.ActivityStages[0].Status = model.ActivityStageSuccess
+		activity.ActivityStages[0].ActivitySteps[0].Status = model.ActivityStepSuccess
+		activity.ActivityStages[1].Status = model.ActivityStageBuilding
+		activity.ActivityStages[1].ActivitySteps[0].Status = test.lint
+		activity.ActivityStages[1].ActivitySteps[1].Status = test.unit
+		if test.lint == model.ActivityStepFail {
+			//a failed step fails the stage and the activity
+			activity.ActivityStages[1].Status = model.ActivityStageFail
+			activity.Status = model.ActivityFail
+		}
+		if test.lint == model.ActivityStepWaiting {
+			activity.ActivityStages[1].Status = model.ActivityStageWaiting
+		}
+
+		provider := &runRecorder{}
+		TriggerReadySteps(activity, provider)
+		if !reflect.DeepEqual(provider.runs, test.want) {
+			t.Errorf("%s: got steps %v run, want %v", test.name, provider.runs, test.want)
+		}
+		if activity.Status != test.wantActivity {
+			t.Errorf("%s: got activity status %s, want %s", test.name, activity.Status, test.wantActivity)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/store"
+	"github.com/rancher/pipeline/util"
+)
+
+//rawStore is the store without encryption, used to migrate stored data
+var rawStore store.Store
+
+//masterKey gets the key that encrypts data keys of secret fields, nil if no encryption key is configured
+func masterKey() *util.MasterKey {
+	if config.Config.EncryptionKey == "" {
+		return nil
+	}
+	return util.NewMasterKey(config.Config.EncryptionKey)
+}
+
+//encryptedStore encrypts secret fields on write and decrypts them on read, which are access tokens of
+//git accounts, client secrets of scm settings and secret values of credentials. Each value is encrypted by
+//its own data key wrapped by the master key. Values are written as plaintext if no master key is set,
+//plaintext values in the store are read as they are.
+type encryptedStore struct {
+	store.Store
+	key *util.MasterKey
+}
+
+func (s encryptedStore) Accounts() store.AccountRepository {
+	return encryptedAccounts{s.Store.Accounts(), s.key}
+}
+
+func (s encryptedStore) Settings() store.SettingRepository {
+	return encryptedSettings{s.Store.Settings(), s.key}
+}
+
+func (s encryptedStore) Credentials() store.CredentialRepository {
+	return encryptedCredentials{s.Store.Credentials(), s.key}
+}
+
+func seal(key *util.MasterKey, value string) (string, error) {
+	if key == nil || value == "" || util.IsEnvelope(value) {
+		return value, nil
+	}
+	return util.SealEnvelope(key, value)
+}
+
+type encryptedAccounts struct {
+	store.AccountRepository
+	key *util.MasterKey
+}
+
+func (r encryptedAccounts) Get(id string) (*model.GitAccount, error) {
+	account, err := r.AccountRepository.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return account, r.open(account)
+}
+
+func (r encryptedAccounts) List() ([]*model.GitAccount, error) {
+	accounts, err := r.AccountRepository.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, account := range accounts {
+		if err := r.open(account); err != nil {
+			return nil, err
+		}
+	}
+	return accounts, nil
+}
+
+func (r encryptedAccounts) Create(account *model.GitAccount) error {
+	sealed, err := r.seal(account)
+	if err != nil {
+		return err
+	}
+	return r.AccountRepository.Create(sealed)
+}
+
+func (r encryptedAccounts) Update(account *model.GitAccount) error {
+	sealed, err := r.seal(account)
+	if err != nil {
+		return err
+	}
+	return r.AccountRepository.Update(sealed)
+}
+
+func (r encryptedAccounts) Delete(id string) (*model.GitAccount, error) {
+	account, err := r.AccountRepository.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+	return account, r.open(account)
+}
+
+func (r encryptedAccounts) open(account *model.GitAccount) error {
+	token, err := util.OpenEnvelope(r.key, account.AccessToken)
+	if err != nil {
+		return fmt.Errorf("fail to decrypt access token of account '%s': %v", account.Id, err)
+	}
+	account.AccessToken = token
+	return nil
+}
+
+//seal gets a copy of the account with encrypted token, the account itself is not changed
+func (r encryptedAccounts) seal(account *model.GitAccount) (*model.GitAccount, error) {
+	sealed := *account
+	var err error
+	sealed.AccessToken, err = seal(r.key, account.AccessToken)
+	return &sealed, err
+}
+
+type encryptedSettings struct {
+	store.SettingRepository
+	key *util.MasterKey
+}
+
+func (r encryptedSettings) GetSCMSetting(scmType string) (*model.SCMSetting, error) {
+	setting, err := r.SettingRepository.GetSCMSetting(scmType)
+	if err != nil {
+		return nil, err
+	}
+	return setting, r.open(setting)
+}
+
+func (r encryptedSettings) ListSCMSettings() ([]*model.SCMSetting, error) {
+	settings, err := r.SettingRepository.ListSCMSettings()
+	if err != nil {
+		return nil, err
+	}
+	for _, setting := range settings {
+		if err := r.open(setting); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+func (r encryptedSettings) SaveSCMSetting(setting *model.SCMSetting) error {
+	sealed := *setting
+	var err error
+	if sealed.ClientSecret, err = seal(r.key, setting.ClientSecret); err != nil {
+		return err
+	}
+	return r.SettingRepository.SaveSCMSetting(&sealed)
+}
+
+func (r encryptedSettings) DeleteSCMSetting(scmType string) (*model.SCMSetting, error) {
+	setting, err := r.SettingRepository.DeleteSCMSetting(scmType)
+	if err != nil {
+		return nil, err
+	}
+	return setting, r.open(setting)
+}
+
+func (r encryptedSettings) open(setting *model.SCMSetting) error {
+	secret, err := util.OpenEnvelope(r.key, setting.ClientSecret)
+	if err != nil {
+		return fmt.Errorf("fail to decrypt client secret of '%s' setting: %v", setting.ScmType, err)
+	}
+	setting.ClientSecret = secret
+	return nil
+}
+
+type encryptedCredentials struct {
+	store.CredentialRepository
+	key *util.MasterKey
+}
+
+func (r encryptedCredentials) Get(id string) (*model.Credential, error) {
+	cred, err := r.CredentialRepository.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return cred, r.open(cred)
+}
+
+func (r encryptedCredentials) List() ([]*model.Credential, error) {
+	creds, err := r.CredentialRepository.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, cred := range creds {
+		if err := r.open(cred); err != nil {
+			return nil, err
+		}
+	}
+	return creds, nil
+}
+
+func (r encryptedCredentials) Create(cred *model.Credential) error {
+	sealed, err := r.seal(cred)
+	if err != nil {
+		return err
+	}
+	return r.CredentialRepository.Create(sealed)
+}
+
+func (r encryptedCredentials) Update(cred *model.Credential) error {
+	sealed, err := r.seal(cred)
+	if err != nil {
+		return err
+	}
+	return r.CredentialRepository.Update(sealed)
+}
+
+func (r encryptedCredentials) Delete(id string) (*model.Credential, error) {
+	cred, err := r.CredentialRepository.Delete(id)
+	if err != nil {
+		return nil, err
+	}
+	return cred, r.open(cred)
+}
+
+func (r encryptedCredentials) open(cred *model.Credential) error {
+	value, err := util.OpenEnvelope(r.key, cred.SecretValue)
+	if err != nil {
+		return fmt.Errorf("fail to decrypt credential '%s': %v", cred.Id, err)
+	}
+	cred.SecretValue = value
+	return nil
+}
+
+//seal gets a copy of the credential with encrypted secret, the credential itself is not changed
+func (r encryptedCredentials) seal(cred *model.Credential) (*model.Credential, error) {
+	sealed := *cred
+	var err error
+	sealed.SecretValue, err = seal(r.key, cred.SecretValue)
+	return &sealed, err
+}
+
+//EncryptPlaintextData encrypts secret fields that are stored as plaintext by the master key,
+//which migrates data stored before the master key is set. Data keys wrapped by the legacy key
+//derivation are wrapped again by the salted one.
+func EncryptPlaintextData() (int, error) {
+	key := masterKey()
+	if key == nil {
+		return 0, nil
+	}
+	return reencrypt(func(value string) (string, error) {
+		if util.IsLegacyEnvelope(value) {
+			return util.RewrapEnvelope(key, key, value)
+		}
+		return seal(key, value)
+	})
+}
+
+//RotateEncryptionKey encrypts data keys of secret fields by the new master key, plaintext fields are
+//encrypted too. The configured encryption key is the current master key, which is empty if data are
+//plaintext. The number of changed fields is returned.
+func RotateEncryptionKey(newKey string) (int, error) {
+	if newKey == "" {
+		return 0, fmt.Errorf("new encryption key should not be empty")
+	}
+	oldKey := masterKey()
+	key := util.NewMasterKey(newKey)
+	return reencrypt(func(value string) (string, error) {
+		if value == "" {
+			return value, nil
+		}
+		return util.RewrapEnvelope(oldKey, key, value)
+	})
+}
+
+//reencrypt updates secret fields of all objects in the raw store by fn
+func reencrypt(fn func(string) (string, error)) (int, error) {
+	count := 0
+	accounts, err := rawStore.Accounts().List()
+	if err != nil {
+		return count, err
+	}
+	for _, account := range accounts {
+		token, err := fn(account.AccessToken)
+		if err != nil {
+			return count, fmt.Errorf("account '%s': %v", account.Id, err)
+		}
+		if token == account.AccessToken {
+			continue
+		}
+		account.AccessToken = token
+		if err := rawStore.Accounts().Update(account); err != nil {
+			return count, err
+		}
+		count++
+	}
+	settings, err := rawStore.Settings().ListSCMSettings()
+	if err != nil {
+		return count, err
+	}
+	for _, setting := range settings {
+		secret, err := fn(setting.ClientSecret)
+		if err != nil {
+			return count, fmt.Errorf("'%s' setting: %v", setting.ScmType, err)
+		}
+		if secret == setting.ClientSecret {
+			continue
+		}
+		setting.ClientSecret = secret
+		if err := rawStore.Settings().SaveSCMSetting(setting); err != nil {
+			return count, err
+		}
+		count++
+	}
+	creds, err := rawStore.Credentials().List()
+	if err != nil {
+		return count, err
+	}
+	for _, cred := range creds {
+		value, err := fn(cred.SecretValue)
+		if err != nil {
+			return count, fmt.Errorf("credential '%s': %v", cred.Id, err)
+		}
+		if value == cred.SecretValue {
+			continue
+		}
+		cred.SecretValue = value
+		if err := rawStore.Credentials().Update(cred); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
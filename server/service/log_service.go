@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/artifact"
+	"github.com/rancher/pipeline/model"
+)
+
+var logStore artifact.BlobStore
+
+//InitLogStore sets the store of step logs
+func InitLogStore(s artifact.BlobStore) {
+	logStore = s
+}
+
+func stepLogKey(activityId string, stageOrdinal int, stepOrdinal int) string {
+	return artifact.Key(activityId, fmt.Sprintf("%d-%d.log.gz", stageOrdinal, stepOrdinal))
+}
+
+//attemptLogKey gets the key of the log of the attempt of the step, attempts start from 1
+func attemptLogKey(activityId string, stageOrdinal int, stepOrdinal int, attempt int) string {
+	return artifact.Key(activityId, fmt.Sprintf("%d-%d.%d.log.gz", stageOrdinal, stepOrdinal, attempt))
+}
+
+//IsStepFinished checks whether the step is finished so that its log does not change
+func IsStepFinished(activity *model.Activity, stageOrdinal int, stepOrdinal int) bool {
+	if stageOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) ||
+		stepOrdinal < 0 || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
+		return false
+	}
+	status := activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Status
+	return status == model.ActivityStepSuccess ||
+		status == model.ActivityStepFail ||
+		status == model.ActivityStepAbort
+}
+
+//SaveStepLog stores the log of the finished step compressed, with secrets masked and the finish line of the status
+func SaveStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, stepLog string, status string) error {
+	return saveLog(activity, stepLogKey(activity.Id, stageOrdinal, stepOrdinal), stepLog, status)
+}
+
+//SaveAttemptLog stores the log of the finished attempt of the step like SaveStepLog
+func SaveAttemptLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, attempt int, stepLog string, status string) error {
+	return saveLog(activity, attemptLogKey(activity.Id, stageOrdinal, stepOrdinal, attempt), stepLog, status)
+}
+
+func saveLog(activity *model.Activity, key string, stepLog string, status string) error {
+	if logStore == nil {
+		return nil
+	}
+	stepLog, err := MaskSecrets(activity, stepLog)
+	if err != nil {
+		//never keep the log if secrets cannot be masked
+		return fmt.Errorf("fail to mask secrets: %v", err)
+	}
+	if status != "" && !strings.HasSuffix(stepLog, "  Finished: "+status+"\n") {
+		if stepLog != "" && !strings.HasSuffix(stepLog, "\n") {
+			stepLog += "\n"
+		}
+		stepLog += "  Finished: " + status + "\n"
+	}
+	b := &bytes.Buffer{}
+	w := gzip.NewWriter(b)
+	if _, err := w.Write([]byte(stepLog)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if _, _, err := logStore.Put(key, b); err != nil {
+		return fmt.Errorf("fail to store step log: %v", err)
+	}
+	return nil
+}
+
+//GetSavedStepLog gets the stored log of the step, artifact.ErrNotFound if it is not stored
+func GetSavedStepLog(activityId string, stageOrdinal int, stepOrdinal int) (string, error) {
+	return getLog(stepLogKey(activityId, stageOrdinal, stepOrdinal))
+}
+
+//GetSavedAttemptLog gets the stored log of the attempt of the step, artifact.ErrNotFound if it is not stored
+func GetSavedAttemptLog(activityId string, stageOrdinal int, stepOrdinal int, attempt int) (string, error) {
+	return getLog(attemptLogKey(activityId, stageOrdinal, stepOrdinal, attempt))
+}
+
+func getLog(key string) (string, error) {
+	if logStore == nil {
+		return "", artifact.ErrNotFound
+	}
+	r, err := logStore.Get(key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return "", fmt.Errorf("fail to read step log: %v", err)
+	}
+	defer gr.Close()
+	b, err := ioutil.ReadAll(gr)
+	if err != nil {
+		return "", fmt.Errorf("fail to read step log: %v", err)
+	}
+	return string(b), nil
+}
+
+//RemoveStepLogs removes stored logs of the activity, failure is logged but not blocking
+func RemoveStepLogs(activityId string) {
+	if logStore == nil {
+		return
+	}
+	if err := logStore.Delete(activityId); err != nil {
+		logrus.Errorf("fail to remove logs of activity '%s': %v", activityId, err)
+	}
+}
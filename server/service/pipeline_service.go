@@ -1,107 +1,52 @@
 package service
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/rancher/go-rancher/v2"
 	"github.com/rancher/pipeline/model"
-	"github.com/rancher/pipeline/util"
+	"github.com/rancher/pipeline/store"
 	"github.com/robfig/cron"
+	yaml "gopkg.in/yaml.v2"
 )
 
+//PipelineFileName is the pipeline file in the repository for pipelines from repository
+const PipelineFileName = ".rancher-pipeline.yml"
+
 func GetPipelineById(id string) (*model.Pipeline, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-	filters := make(map[string]interface{})
-	filters["key"] = id
-	filters["kind"] = "pipeline"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
-		return nil, err
-	}
-	if len(goCollection.Data) == 0 {
+	ppl, err := dataStore.Pipelines().Get(id)
+	if err == store.ErrNotFound {
 		return nil, fmt.Errorf("pipeline '%s' is not found", id)
+	} else if err != nil {
+		logrus.Errorf("Error %v getting pipeline", err)
+		return nil, err
 	}
-	data := goCollection.Data[0]
-	ppl := &model.Pipeline{}
-	json.Unmarshal([]byte(data.ResourceData["data"].(string)), ppl)
 	return ppl, nil
 }
 
 func CreatePipeline(pipeline *model.Pipeline) error {
-	b, err := json.Marshal(*pipeline)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
-	}
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
+	if err := dataStore.Pipelines().Create(pipeline); err != nil {
 		return err
 	}
-	_, err = apiClient.GenericObject.Create(&client.GenericObject{
-		Name:         pipeline.Name,
-		Key:          pipeline.Id,
-		ResourceData: resourceData,
-		Kind:         "pipeline",
-	})
 	logrus.Debugf("created pipeline:%v", pipeline)
-
-	return err
+	return nil
 }
 
 func UpdatePipeline(pipeline *model.Pipeline) error {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return err
-	}
-
-	filters := make(map[string]interface{})
-	filters["key"] = pipeline.Id
-	filters["kind"] = "pipeline"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
+	prevPipeline, err := dataStore.Pipelines().Get(pipeline.Id)
 	if err != nil {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
-		return err
-	}
-	if len(goCollection.Data) == 0 {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
-		return err
-	}
-	existing := goCollection.Data[0]
-	prevPipeline := &model.Pipeline{}
-	if err := json.Unmarshal([]byte(existing.ResourceData["data"].(string)), prevPipeline); err != nil {
+		logrus.Errorf("Error %v getting pipeline '%s'", err, pipeline.Id)
 		return err
 	}
 	pipeline.WebHookToken = prevPipeline.WebHookToken
-
-	b, err := json.Marshal(*pipeline)
-	if err != nil {
-		return err
-	}
-	resourceData := map[string]interface{}{
-		"data": string(b),
+	//keep the secret as it is filtered in responses
+	if pipeline.GenericWebhook != nil && pipeline.GenericWebhook.Secret == "" && prevPipeline.GenericWebhook != nil {
+		pipeline.GenericWebhook.Secret = prevPipeline.GenericWebhook.Secret
 	}
-
-	_, err = apiClient.GenericObject.Update(&existing, &client.GenericObject{
-		Name:         pipeline.Name,
-		Key:          pipeline.Id,
-		ResourceData: resourceData,
-		Kind:         "pipeline",
-	})
-	if err != nil {
+	if err := dataStore.Pipelines().Update(pipeline); err != nil {
 		return err
 	}
 	logrus.Debugf("updated pipeline")
@@ -109,59 +54,37 @@ func UpdatePipeline(pipeline *model.Pipeline) error {
 }
 
 func DeletePipeline(id string) (*model.Pipeline, error) {
-	apiClient, err := util.GetRancherClient()
-	if err != nil {
-		return nil, err
-	}
-	filters := make(map[string]interface{})
-	filters["key"] = id
-	filters["kind"] = "pipeline"
-	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
-		Filters: filters,
-	})
-	if err != nil {
-		logrus.Errorf("Error %v filtering genericObjects by key", err)
-		return nil, err
-	}
-	if len(goCollection.Data) == 0 {
+	ppl, err := dataStore.Pipelines().Delete(id)
+	if err == store.ErrNotFound {
 		return nil, errors.New("cannot find pipeline to delete")
-	}
-	existing := goCollection.Data[0]
-	ppl := &model.Pipeline{}
-	if err = json.Unmarshal([]byte(existing.ResourceData["data"].(string)), ppl); err != nil {
-		return ppl, err
-	}
-	if err = apiClient.GenericObject.Delete(&existing); err != nil {
+	} else if err != nil {
+		logrus.Errorf("Error %v deleting pipeline", err)
 		return nil, err
 	}
-
 	return ppl, nil
 }
 
-//get all pipelines from GenericObject
+//get all pipelines from the store
 func ListPipelines() []*model.Pipeline {
-	geObjList, err := PaginateGenericObjects("pipeline")
+	pipelines, err := dataStore.Pipelines().List()
 	if err != nil {
 		logrus.Errorf("fail to list pipeline,err:%v", err)
 		return nil
 	}
-	var pipelines []*model.Pipeline
-	for _, gobj := range geObjList {
-		b := []byte(gobj.ResourceData["data"].(string))
-		a := &model.Pipeline{}
-		json.Unmarshal(b, a)
-		pipelines = append(pipelines, a)
-	}
 	return pipelines
 }
 
-func RunPipeline(provider model.PipelineProvider, id string, triggerType string) (*model.Activity, error) {
+func RunPipeline(provider model.PipelineProvider, id string, trigger *model.TriggerInfo) (*model.Activity, error) {
 	pp, err := GetPipelineById(id)
 	if err != nil {
 		return nil, fmt.Errorf("fail to get pipeline: %v", err)
 	}
 
-	activity, err := provider.RunPipeline(pp, triggerType)
+	resolved, err := runnablePipeline(pp, trigger)
+	if err != nil {
+		return nil, err
+	}
+	activity, err := provider.RunPipeline(resolved, trigger)
 	if err != nil {
 		return nil, err
 	}
@@ -175,6 +98,140 @@ func RunPipeline(provider model.PipelineProvider, id string, triggerType string)
 	return activity, nil
 }
 
+//runnablePipeline gets the pipeline to run by the trigger, the stored pipeline is not changed
+func runnablePipeline(pp *model.Pipeline, trigger *model.TriggerInfo) (*model.Pipeline, error) {
+	resolved, err := ResolvePipeline(pp, trigger)
+	if err != nil {
+		return nil, err
+	}
+	if trigger.PullRequest != nil && trigger.PullRequest.Fork {
+		if err := validForkPipeline(resolved); err != nil {
+			return nil, err
+		}
+	}
+	if len(trigger.EnvVars) > 0 {
+		//env vars of the trigger override user defined ones
+		withEnv := *resolved
+		withEnv.Parameters = append([]string{}, resolved.Parameters...)
+		keys := []string{}
+		for k := range trigger.EnvVars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			withEnv.Parameters = append(withEnv.Parameters, k+"="+trigger.EnvVars[k])
+		}
+		resolved = &withEnv
+	}
+	return resolved, nil
+}
+
+//validForkPipeline checks the pipeline can run for pull requests of forks, upgrade steps are rejected
+//as they deploy by env keys and git accounts which are withheld from forks
+func validForkPipeline(p *model.Pipeline) error {
+	for _, stage := range p.Stages {
+		for _, step := range stage.Steps {
+			switch step.Type {
+			case model.StepTypeUpgradeService, model.StepTypeUpgradeStack, model.StepTypeUpgradeCatalog:
+				return fmt.Errorf("step '%s' of type '%s' is not allowed to run for pull requests of forks", step.Name, step.Type)
+			}
+		}
+	}
+	return nil
+}
+
+//ResolvePipeline reads stages of the pipeline from the pipeline file at the commit to run if the pipeline is
+//from repository, the commit is set to the trigger. The stored pipeline is not changed.
+func ResolvePipeline(p *model.Pipeline, trigger *model.TriggerInfo) (*model.Pipeline, error) {
+	if !p.FromRepository {
+		return p, nil
+	}
+	scmStep := p.Stages[0].Steps[0]
+	token, err := GetUserToken(scmStep.GitUser)
+	if err != nil {
+		return nil, err
+	}
+	ref := trigger.Commit
+	if trigger.PullRequest != nil {
+		//the pipeline file of the pull request is read from the ref to build, never from the target branch
+		ref = trigger.PullRequest.Ref
+		if ref == "" {
+			return nil, fmt.Errorf("ref of pull request #%d is unknown", trigger.PullRequest.Number)
+		}
+	} else if ref == "" {
+		commit, err := BranchHeadCommit(scmStep)
+		if err != nil {
+			return nil, err
+		}
+		if commit == "" {
+			return nil, fmt.Errorf("branch '%s' is not found", scmStep.Branch)
+		}
+		trigger.Commit = commit
+		ref = commit
+	}
+	manager, err := GetSCManagerFromUserID(scmStep.GitUser)
+	if err != nil {
+		return nil, err
+	}
+	content, err := manager.GetFileContent(scmStep.Repository, ref, PipelineFileName, token)
+	if err == model.ErrFileNotFound {
+		return nil, fmt.Errorf("pipeline file '%s' is not found at '%s'", PipelineFileName, ref)
+	} else if err != nil {
+		return nil, err
+	}
+	file := &model.PipelineContent{}
+	if err := yaml.Unmarshal(content, file); err != nil {
+		return nil, fmt.Errorf("fail to parse pipeline file: %v", err)
+	}
+	resolved := *p
+	stages := file.Stages
+	if len(stages) > 0 && len(stages[0].Steps) > 0 && stages[0].Steps[0].Type == model.StepTypeSCM {
+		//the scm stage is always the one of the stored pipeline
+		stages = stages[1:]
+	}
+	if err := checkBoundCredentials(p, stages); err != nil {
+		return nil, fmt.Errorf("invalid pipeline file at '%s': %v", ref, err)
+	}
+	resolved.Stages = append([]*model.Stage{p.Stages[0]}, stages...)
+	if len(file.Parameters) > 0 {
+		resolved.Parameters = file.Parameters
+	}
+	if err := Validate(&resolved); err != nil {
+		return nil, fmt.Errorf("invalid pipeline file at '%s': %v", ref, err)
+	}
+	return &resolved, nil
+}
+
+//checkBoundCredentials checks stages read from the pipeline file only use env keys and git credentials which are
+//used by stored stages of the pipeline or bound to it by editors
+func checkBoundCredentials(p *model.Pipeline, stages []*model.Stage) error {
+	envKeys := map[string]bool{}
+	gitCredentials := map[string]bool{}
+	for _, key := range p.BoundEnvKeys {
+		envKeys[key] = true
+	}
+	for _, id := range p.BoundGitCredentials {
+		gitCredentials[id] = true
+	}
+	for _, stage := range p.Stages {
+		for _, step := range stage.Steps {
+			envKeys[step.Accesskey] = true
+			gitCredentials[step.GitCredential] = true
+		}
+	}
+	for _, stage := range stages {
+		for _, step := range stage.Steps {
+			if step.Accesskey != "" && !envKeys[step.Accesskey] {
+				return fmt.Errorf("env key '%s' of step '%s' is not bound to the pipeline", step.Accesskey, step.Name)
+			}
+			if step.GitCredential != "" && !gitCredentials[step.GitCredential] {
+				return fmt.Errorf("git credential '%s' of step '%s' is not bound to the pipeline", step.GitCredential, step.Name)
+			}
+		}
+	}
+	return nil
+}
+
 func UpdatePipelineEnvKey(p *model.Pipeline) error {
 	for _, stage := range p.Stages {
 		for _, step := range stage.Steps {
@@ -198,11 +255,11 @@ func UpdatePipelineEnvKey(p *model.Pipeline) error {
 }
 
 func HasStepCondition(s *model.Step) bool {
-	return s.Conditions != nil && (len(s.Conditions.All) > 0 || len(s.Conditions.Any) > 0)
+	return s.Condition != "" || s.Conditions != nil && (len(s.Conditions.All) > 0 || len(s.Conditions.Any) > 0)
 }
 
 func HasStageCondition(s *model.Stage) bool {
-	return s.Conditions != nil && (len(s.Conditions.All) > 0 || len(s.Conditions.Any) > 0)
+	return s.Condition != "" || s.Conditions != nil && (len(s.Conditions.All) > 0 || len(s.Conditions.Any) > 0)
 }
 
 func GetNextRunTime(pipeline *model.Pipeline) int64 {
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func TestRunnablePipelineOfFork(t *testing.T) {
+	tests := []struct {
+		stepType string
+		fork     bool
+		wantErr  bool
+	}{
+		{model.StepTypeTask, true, false},
+		{model.StepTypeUpgradeService, false, false},
+		{model.StepTypeUpgradeService, true, true},
+		{model.StepTypeUpgradeStack, true, true},
+		{model.StepTypeUpgradeCatalog, true, true},
+	}
+	for _, test := range tests {
+		ppl := &model.Pipeline{
+			Stages: []*model.Stage{
+				{Steps: []*model.Step{{Type: model.StepTypeSCM}}},
+				{Steps: []*model.Step{{Name: "s", Type: test.stepType}}},
+			},
+		}
+		trigger := &model.TriggerInfo{PullRequest: &model.PullRequest{Number: 1, Fork: test.fork}}
+		_, err := runnablePipeline(ppl, trigger)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s step, fork %v: got error %v, want error %v", test.stepType, test.fork, err, test.wantErr)
+		}
+	}
+}
+
+func TestCheckBoundCredentials(t *testing.T) {
+	p := &model.Pipeline{
+		Stages: []*model.Stage{
+			{Steps: []*model.Step{{Type: model.StepTypeSCM, GitCredential: "c1"}}},
+			{Steps: []*model.Step{{Type: model.StepTypeUpgradeService, Accesskey: "k1"}}},
+		},
+	}
+	p.BoundEnvKeys = []string{"k2"}
+	p.BoundGitCredentials = []string{"c2"}
+	tests := []struct {
+		name    string
+		step    *model.Step
+		wantErr bool
+	}{
+		{"no credentials", &model.Step{Type: model.StepTypeTask}, false},
+		{"env key of stored stage", &model.Step{Type: model.StepTypeUpgradeService, Accesskey: "k1"}, false},
+		{"bound env key", &model.Step{Type: model.StepTypeUpgradeStack, Accesskey: "k2"}, false},
+		{"unbound env key", &model.Step{Type: model.StepTypeUpgradeService, Accesskey: "k3"}, true},
+		{"git credential of stored stage", &model.Step{Type: model.StepTypeSCM, GitCredential: "c1"}, false},
+		{"bound git credential", &model.Step{Type: model.StepTypeSCM, GitCredential: "c2"}, false},
+		{"unbound git credential", &model.Step{Type: model.StepTypeSCM, GitCredential: "c3"}, true},
+	}
+	for _, test := range tests {
+		stages := []*model.Stage{{Steps: []*model.Step{test.step}}}
+		if err := checkBoundCredentials(p, stages); (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+		}
+	}
+}
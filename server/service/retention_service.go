@@ -0,0 +1,61 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+)
+
+//RetentionEnabled checks whether finished activities are removed by the retention policy
+func RetentionEnabled() bool {
+	return config.Config.ActivityKeepLast > 0 || config.Config.ActivityMaxAge > 0
+}
+
+//ExpiredActivities gets finished activities out of the retention policy, oldest first.
+//Activities beyond the latest keep last ones of each pipeline or older than the max age are expired,
+//except the last successful one of each pipeline if it is to keep.
+func ExpiredActivities() ([]*model.Activity, error) {
+	expired := []*model.Activity{}
+	if !RetentionEnabled() {
+		return expired, nil
+	}
+	activities, err := ListActivities()
+	if err != nil {
+		return nil, err
+	}
+	pipelineActivities := map[string][]*model.Activity{}
+	for _, activity := range activities {
+		pipelineActivities[activity.Pipeline.Id] = append(pipelineActivities[activity.Pipeline.Id], activity)
+	}
+	keepLast := config.Config.ActivityKeepLast
+	maxAge := int64(config.Config.ActivityMaxAge) * int64(24*time.Hour/time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, list := range pipelineActivities {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].StartTS > list[j].StartTS
+		})
+		successKept := false
+		for i, activity := range list {
+			if IsRunning(activity) || activity.Status == model.ActivityQueued {
+				continue
+			}
+			if activity.Status == model.ActivitySuccess && !successKept {
+				successKept = true
+				if config.Config.ActivityKeepLastSuccess {
+					continue
+				}
+			}
+			outOfCount := keepLast > 0 && i >= keepLast
+			outOfAge := maxAge > 0 && now-activity.StartTS > maxAge
+			if outOfCount || outOfAge {
+				expired = append(expired, activity)
+			}
+		}
+	}
+	sort.SliceStable(expired, func(i, j int) bool {
+		return expired[i].StartTS < expired[j].StartTS
+	})
+	return expired, nil
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+)
+
+func TestExpiredActivities(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	//activities of p1 newest first, with the days since they started
+	p1 := []struct {
+		id     string
+		status string
+		age    int64
+	}{
+		{"running", model.ActivityBuilding, 0},
+		{"queued", model.ActivityQueued, 0},
+		{"failed1", model.ActivityFail, 1},
+		{"success1", model.ActivitySuccess, 2},
+		{"failed2", model.ActivityFail, 3},
+		{"success2", model.ActivitySuccess, 4},
+		{"aborted", model.ActivityAbort, 10},
+		{"pending", model.ActivityPending, 20},
+		{"old running", model.ActivityWaiting, 30},
+	}
+	tests := []struct {
+		name            string
+		keepLast        int
+		maxAge          int
+		keepLastSuccess bool
+		want            []string
+	}{
+		{"disabled", 0, 0, true, []string{}},
+		{"keep last", 3, 0, false, []string{"aborted", "success2", "failed2", "success1"}},
+		{"keep last and last success", 3, 0, true, []string{"aborted", "success2", "failed2"}},
+		{"keep only last success", 1, 0, true, []string{"old other", "other", "aborted", "success2", "failed2", "failed1"}},
+		{"max age", 0, 3, false, []string{"old other", "other", "newer other", "aborted", "success2", "failed2"}},
+		{"max age and last success", 0, 3, true, []string{"old other", "other", "aborted", "success2", "failed2"}},
+		{"keep last or max age", 6, 5, false, []string{"old other", "other", "newer other", "aborted"}},
+	}
+	keepLast, maxAge, keepLastSuccess := config.Config.ActivityKeepLast, config.Config.ActivityMaxAge, config.Config.ActivityKeepLastSuccess
+	defer func() {
+		config.Config.ActivityKeepLast, config.Config.ActivityMaxAge, config.Config.ActivityKeepLastSuccess = keepLast, maxAge, keepLastSuccess
+	}()
+	defer initTestStore(t)()
+	p := testPipeline("p1")
+	for i, a := range p1 {
+		//ages in the same day are ordered by the position
+		createTestActivity(t, p, a.id, a.status, now-a.age*day-int64(i)*1000-1000)
+	}
+	p2 := testPipeline("p2")
+	createTestActivity(t, p2, "other", model.ActivityFail, now-40*day)
+	createTestActivity(t, p2, "old other", model.ActivityFail, now-50*day)
+	createTestActivity(t, p2, "newer other", model.ActivitySuccess, now-30*day)
+
+	for _, test := range tests {
+		config.Config.ActivityKeepLast = test.keepLast
+		config.Config.ActivityMaxAge = test.maxAge
+		config.Config.ActivityKeepLastSuccess = test.keepLastSuccess
+		expired, err := ExpiredActivities()
+		if err != nil {
+			t.Errorf("%s: got error: %v", test.name, err)
+			continue
+		}
+		if got := activityIds(expired); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got expired %v, want oldest first %v", test.name, got, test.want)
+		}
+		for _, activity := range expired {
+			if IsRunning(activity) || activity.Status == model.ActivityQueued {
+				t.Errorf("%s: got running activity %s expired", test.name, activity.Id)
+			}
+		}
+	}
+}
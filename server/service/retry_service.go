@@ -0,0 +1,106 @@
+package service
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/model"
+)
+
+//maxRetryBackoff caps the doubled backoff
+const maxRetryBackoff = time.Hour
+
+//RecordStepAttempt records the finished run of the step with retry,
+//its log is kept in the log store with secrets masked rather than in the activity
+func RecordStepAttempt(activity *model.Activity, stageOrdinal int, stepOrdinal int, status string, exitCode int, timedOut bool, log string) {
+	step := activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal]
+	curTime := time.Now().UnixNano() / int64(time.Millisecond)
+	attempt := &model.StepAttempt{
+		Status:   model.ActivityStepSuccess,
+		StartTS:  step.StartTS,
+		Duration: curTime - step.StartTS,
+		ExitCode: exitCode,
+		TimedOut: timedOut,
+	}
+	if status != "SUCCESS" {
+		attempt.Status = model.ActivityStepFail
+	}
+	step.Attempts = append(step.Attempts, attempt)
+	if err := SaveAttemptLog(activity, stageOrdinal, stepOrdinal, len(step.Attempts), log, status); err != nil {
+		logrus.Errorf("fail to save log of attempt %d of step %d in stage %d: %v", len(step.Attempts), stepOrdinal+1, stageOrdinal+1, err)
+		return
+	}
+	attempt.LogSaved = logStore != nil
+}
+
+//IsStepRetryable checks whether the failed step has attempts left and its failure is to retry
+func IsStepRetryable(activity *model.Activity, stageOrdinal int, stepOrdinal int, exitCode int, timedOut bool) bool {
+	retry := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal].Retry
+	if retry == nil || activity.Status != model.ActivityBuilding {
+		return false
+	}
+	if len(activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].Attempts) >= retry.Attempts {
+		return false
+	}
+	if len(retry.ExitCodes) == 0 && !retry.OnTimeout {
+		return true
+	}
+	if timedOut {
+		return retry.OnTimeout
+	}
+	for _, code := range retry.ExitCodes {
+		if code == exitCode {
+			return true
+		}
+	}
+	return false
+}
+
+//WaitStepRetry sets the failed step waiting for the next attempt, returns the backoff before it runs
+func WaitStepRetry(activity *model.Activity, stageOrdinal int, stepOrdinal int) time.Duration {
+	retry := activity.Pipeline.Stages[stageOrdinal].Steps[stepOrdinal].Retry
+	step := activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal]
+	backoff := time.Duration(retry.Backoff) * time.Second
+	for i := 1; i < len(step.Attempts) && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	step.Status = model.ActivityStepWaiting
+	step.StartTS = 0
+	step.Duration = 0
+	step.RetryTS = time.Now().Add(backoff).UnixNano() / int64(time.Millisecond)
+	return backoff
+}
+
+//IsWaitingRetry checks whether the step of the running activity waits for the next attempt
+func IsWaitingRetry(activity *model.Activity, stageOrdinal int, stepOrdinal int) bool {
+	if activity.Status != model.ActivityBuilding ||
+		stageOrdinal >= len(activity.ActivityStages) ||
+		stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
+		return false
+	}
+	step := activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal]
+	return step.Status == model.ActivityStepWaiting && step.RetryTS > 0
+}
+
+//RetryStep runs the next attempt of the step waiting for retry
+func RetryStep(activity *model.Activity, stageOrdinal int, stepOrdinal int, provider model.PipelineProvider) error {
+	activity.ActivityStages[stageOrdinal].ActivitySteps[stepOrdinal].RetryTS = 0
+	return provider.RunStep(activity, stageOrdinal, stepOrdinal)
+}
+
+//DueRetries gets steps of the activity whose next attempt is due
+func DueRetries(activity *model.Activity) []StepRef {
+	refs := []StepRef{}
+	curTime := time.Now().UnixNano() / int64(time.Millisecond)
+	for i, stage := range activity.ActivityStages {
+		for j, step := range stage.ActivitySteps {
+			if IsWaitingRetry(activity, i, j) && step.RetryTS <= curTime {
+				refs = append(refs, StepRef{i, j})
+			}
+		}
+	}
+	return refs
+}
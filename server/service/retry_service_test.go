@@ -0,0 +1,118 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rancher/pipeline/model"
+)
+
+//retryActivity gets a running activity of a step with the retry which has finished attempts
+func retryActivity(retry *model.StepRetry, attempts int) *model.Activity {
+	step := &model.ActivityStep{Status: model.ActivityStepFail}
+	for i := 0; i < attempts; i++ {
+		step.Attempts = append(step.Attempts, &model.StepAttempt{Status: model.ActivityStepFail})
+	}
+	return &model.Activity{
+		Status:         model.ActivityBuilding,
+		Pipeline:       model.Pipeline{Stages: []*model.Stage{{Steps: []*model.Step{{Retry: retry}}}}},
+		ActivityStages: []*model.ActivityStage{{ActivitySteps: []*model.ActivityStep{step}}},
+	}
+}
+
+func TestIsStepRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		retry    *model.StepRetry
+		attempts int
+		exitCode int
+		timedOut bool
+		want     bool
+	}{
+		{"no retry", nil, 1, 1, false, false},
+		{"attempts left", &model.StepRetry{Attempts: 3}, 2, 1, false, true},
+		{"no attempts left", &model.StepRetry{Attempts: 3}, 3, 1, false, false},
+		{"single attempt", &model.StepRetry{Attempts: 1}, 1, 1, false, false},
+		{"any failure on timeout", &model.StepRetry{Attempts: 2}, 1, 0, true, true},
+		{"exit code to retry", &model.StepRetry{Attempts: 2, ExitCodes: []int{137, 143}}, 1, 143, false, true},
+		{"exit code not to retry", &model.StepRetry{Attempts: 2, ExitCodes: []int{137}}, 1, 1, false, false},
+		{"timeout not to retry", &model.StepRetry{Attempts: 2, ExitCodes: []int{137}}, 1, 137, true, false},
+		{"timeout to retry", &model.StepRetry{Attempts: 2, OnTimeout: true}, 1, 1, true, true},
+		{"exit code with timeout only", &model.StepRetry{Attempts: 2, OnTimeout: true}, 1, 1, false, false},
+	}
+	for _, test := range tests {
+		activity := retryActivity(test.retry, test.attempts)
+		if got := IsStepRetryable(activity, 0, 0, test.exitCode, test.timedOut); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestIsStepRetryableOfStoppedActivity(t *testing.T) {
+	activity := retryActivity(&model.StepRetry{Attempts: 3}, 1)
+	activity.Status = model.ActivityAbort
+	if IsStepRetryable(activity, 0, 0, 1, false) {
+		t.Error("got a step of the stopped activity retryable")
+	}
+}
+
+func TestWaitStepRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  int
+		attempts int
+		want     time.Duration
+	}{
+		{"no backoff", 0, 1, 0},
+		{"first retry", 10, 1, 10 * time.Second},
+		{"second retry", 10, 2, 20 * time.Second},
+		{"third retry", 10, 3, 40 * time.Second},
+		{"capped", 1800, 3, maxRetryBackoff},
+		{"capped of many attempts", 10, 100, maxRetryBackoff},
+		{"capped first retry", 7200, 1, maxRetryBackoff},
+	}
+	for _, test := range tests {
+		activity := retryActivity(&model.StepRetry{Attempts: 200, Backoff: test.backoff}, test.attempts)
+		step := activity.ActivityStages[0].ActivitySteps[0]
+		step.StartTS = 1
+		before := time.Now()
+		got := WaitStepRetry(activity, 0, 0)
+		if got != test.want {
+			t.Errorf("%s: got backoff %v, want %v", test.name, got, test.want)
+		}
+		if step.Status != model.ActivityStepWaiting || step.StartTS != 0 {
+			t.Errorf("%s: got step %s started at %d, want it waiting", test.name, step.Status, step.StartTS)
+		}
+		wantTS := before.Add(test.want).UnixNano() / int64(time.Millisecond)
+		if step.RetryTS < wantTS || step.RetryTS > wantTS+1000 {
+			t.Errorf("%s: got retry at %d, want about %d", test.name, step.RetryTS, wantTS)
+		}
+	}
+}
+
+func TestDueRetries(t *testing.T) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	activity := &model.Activity{
+		Status: model.ActivityBuilding,
+		ActivityStages: []*model.ActivityStage{
+			{ActivitySteps: []*model.ActivityStep{
+				{Status: model.ActivityStepWaiting, RetryTS: now - 1000},
+				{Status: model.ActivityStepWaiting, RetryTS: now + 60000},
+			}},
+			{ActivitySteps: []*model.ActivityStep{
+				{Status: model.ActivityStepWaiting},
+				{Status: model.ActivityStepBuilding, RetryTS: now - 1000},
+				{Status: model.ActivityStepWaiting, RetryTS: now - 1},
+			}},
+		},
+	}
+	want := []StepRef{{0, 0}, {1, 2}}
+	if got := DueRetries(activity); !reflect.DeepEqual(got, want) {
+		t.Errorf("got due retries %v, want %v", got, want)
+	}
+	activity.Status = model.ActivityAbort
+	if got := DueRetries(activity); len(got) != 0 {
+		t.Errorf("got due retries %v of the stopped activity, want none", got)
+	}
+}
@@ -0,0 +1,254 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/store"
+)
+
+//SecretMask replaces values of secrets in step logs
+const SecretMask = "********"
+
+var ErrNoEncryptionKey = errors.New("encryption key is not configured, set PIPELINE_ENCRYPTION_KEY to use secrets")
+
+//secret names are used as env var names
+var regSecretName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+//SecretId gets the credential id of the pipeline secret, which is also the id of jenkins credentials
+func SecretId(pipelineId string, name string) string {
+	return "secret-" + pipelineId + "-" + name
+}
+
+//ListSecrets lists secrets of the pipeline without values
+func ListSecrets(pipelineId string) ([]*model.Credential, error) {
+	creds, err := dataStore.Credentials().List()
+	if err != nil {
+		return nil, fmt.Errorf("Error %v listing credentials", err)
+	}
+	result := []*model.Credential{}
+	for _, cred := range creds {
+		if cred.CredType == model.CredTypeSecret && cred.PublicValue == pipelineId {
+			cred.SecretValue = ""
+			result = append(result, cred)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+//SetSecret encrypts the value and saves it as the secret of the pipeline, the stored credential is returned
+func SetSecret(pipelineId string, name string, value string) (*model.Credential, error) {
+	if !regSecretName.MatchString(name) {
+		return nil, fmt.Errorf("Invalid secret name '%s', must be a valid env var name", name)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("value of secret '%s' should not be empty", name)
+	}
+	//secrets are never stored as plaintext
+	if masterKey() == nil {
+		return nil, ErrNoEncryptionKey
+	}
+	cred := &model.Credential{
+		Name:        name,
+		CredType:    model.CredTypeSecret,
+		PublicValue: pipelineId,
+		SecretValue: value,
+	}
+	cred.Id = SecretId(pipelineId, name)
+	defer dropMasks(pipelineId)
+	_, err := dataStore.Credentials().Get(cred.Id)
+	if err == store.ErrNotFound {
+		return cred, CreateCredential(cred)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error %v getting credential", err)
+	}
+	return cred, UpdateCredential(cred)
+}
+
+func RemoveSecret(pipelineId string, name string) (*model.Credential, error) {
+	defer dropMasks(pipelineId)
+	cred, err := dataStore.Credentials().Delete(SecretId(pipelineId, name))
+	if err == store.ErrNotFound {
+		return nil, fmt.Errorf("secret '%s' is not found", name)
+	}
+	return cred, err
+}
+
+//RemoveSecrets removes all secrets of the pipeline
+func RemoveSecrets(pipelineId string) ([]*model.Credential, error) {
+	defer dropMasks(pipelineId)
+	secrets, err := ListSecrets(pipelineId)
+	if err != nil {
+		return nil, err
+	}
+	for _, secret := range secrets {
+		if _, err := dataStore.Credentials().Delete(secret.Id); err != nil && err != store.ErrNotFound {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+//GetSecretValues gets decrypted values of the named secrets of the pipeline
+func GetSecretValues(pipelineId string, names []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, name := range names {
+		cred, err := dataStore.Credentials().Get(SecretId(pipelineId, name))
+		if err == store.ErrNotFound {
+			return nil, fmt.Errorf("secret '%s' is not found", name)
+		} else if err != nil {
+			return nil, fmt.Errorf("Error %v getting credential", err)
+		}
+		values[name] = cred.SecretValue
+	}
+	return values, nil
+}
+
+//IsForkPullRequest checks if the activity builds a pull request of a fork, which runs code of others.
+//Secrets, env keys and git credentials are withheld from it and its caches are not saved.
+func IsForkPullRequest(activity *model.Activity) bool {
+	return activity.PullRequest != nil && activity.PullRequest.Fork
+}
+
+//StepSecrets gets names of secrets given to the step of the activity, secrets are withheld
+//from pull requests of forks
+func StepSecrets(activity *model.Activity, step *model.Step) []string {
+	if IsForkPullRequest(activity) {
+		return nil
+	}
+	return step.Secrets
+}
+
+//GetStepSecretEnv gets secrets given to the step of the activity in KEY=value format
+func GetStepSecretEnv(activity *model.Activity, step *model.Step) ([]string, error) {
+	names := StepSecrets(activity, step)
+	values, err := GetSecretValues(activity.Pipeline.Id, names)
+	if err != nil {
+		return nil, err
+	}
+	env := []string{}
+	for _, name := range names {
+		env = append(env, name+"="+values[name])
+	}
+	return env, nil
+}
+
+//maxMaskCache is the max number of activities whose values to mask are cached
+const maxMaskCache = 256
+
+//masks caches values of secrets to mask in logs of activities, so that secrets are decrypted once
+//per activity rather than on each read of its logs. Entries of a pipeline are dropped when its secrets change.
+var masks = struct {
+	sync.Mutex
+	values    map[string][]string
+	pipelines map[string]string
+	order     []string
+}{values: map[string][]string{}, pipelines: map[string]string{}}
+
+//activityMasks gets values of all secrets of the pipeline of the activity, cached for the activity
+func activityMasks(activity *model.Activity) ([]string, error) {
+	masks.Lock()
+	values, ok := masks.values[activity.Id]
+	masks.Unlock()
+	if ok {
+		return values, nil
+	}
+	pipelineId := activity.Pipeline.Id
+	secrets, err := ListSecrets(pipelineId)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+	}
+	secretValues, err := GetSecretValues(pipelineId, names)
+	if err != nil {
+		return nil, err
+	}
+	values = []string{}
+	for _, v := range secretValues {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+
+	masks.Lock()
+	defer masks.Unlock()
+	if _, ok := masks.values[activity.Id]; !ok {
+		masks.order = append(masks.order, activity.Id)
+	}
+	masks.values[activity.Id] = values
+	masks.pipelines[activity.Id] = pipelineId
+	for len(masks.order) > maxMaskCache {
+		delete(masks.values, masks.order[0])
+		delete(masks.pipelines, masks.order[0])
+		masks.order = masks.order[1:]
+	}
+	return values, nil
+}
+
+//dropMasks drops cached values to mask of activities of the pipeline
+func dropMasks(pipelineId string) {
+	masks.Lock()
+	defer masks.Unlock()
+	order := []string{}
+	for _, id := range masks.order {
+		if masks.pipelines[id] == pipelineId {
+			delete(masks.values, id)
+			delete(masks.pipelines, id)
+			continue
+		}
+		order = append(order, id)
+	}
+	masks.order = order
+}
+
+//MaskSecrets replaces values of all secrets of the pipeline in the log of the activity.
+//Overlapping values are masked as a whole.
+func MaskSecrets(activity *model.Activity, log string) (string, error) {
+	values, err := activityMasks(activity)
+	if err != nil || len(values) == 0 {
+		return log, err
+	}
+	masked := make([]bool, len(log))
+	found := false
+	for _, v := range values {
+		for i := 0; i < len(log); {
+			j := strings.Index(log[i:], v)
+			if j < 0 {
+				break
+			}
+			for k := i + j; k < i+j+len(v); k++ {
+				masked[k] = true
+			}
+			found = true
+			i += j + 1
+		}
+	}
+	if !found {
+		return log, nil
+	}
+	b := &bytes.Buffer{}
+	for i := 0; i < len(log); {
+		if !masked[i] {
+			b.WriteByte(log[i])
+			i++
+			continue
+		}
+		for i < len(log) && masked[i] {
+			i++
+		}
+		b.WriteString(SecretMask)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+)
+
+//initSecretStore inits a test store with encryption to set secrets
+func initSecretStore(t *testing.T) func() {
+	key := config.Config.EncryptionKey
+	config.Config.EncryptionKey = "test-encryption-key"
+	cleanup := initTestStore(t)
+	return func() {
+		cleanup()
+		config.Config.EncryptionKey = key
+	}
+}
+
+func TestMaskSecrets(t *testing.T) {
+	defer initSecretStore(t)()
+	secrets := map[string]string{
+		"PASS":     "pass",
+		"PASSWORD": "password",
+		"HEAD":     "abcd",
+		"TAIL":     "cdef",
+		"TOKEN":    "t0k3n",
+	}
+	for name, value := range secrets {
+		if _, err := SetSecret("p1", name, value); err != nil {
+			t.Fatalf("fail to set secret: %v", err)
+		}
+	}
+	if _, err := SetSecret("p2", "OTHER", "other"); err != nil {
+		t.Fatalf("fail to set secret: %v", err)
+	}
+	tests := []struct {
+		name string
+		log  string
+		want string
+	}{
+		{"no secret", "echo hello\n", "echo hello\n"},
+		{"secret", "token=t0k3n\n", "token=" + SecretMask + "\n"},
+		{"repeated", "t0k3n t0k3n\n", SecretMask + " " + SecretMask + "\n"},
+		{"contained", "password pass\n", SecretMask + " " + SecretMask + "\n"},
+		{"overlapping", "xabcdefx\n", "x" + SecretMask + "x\n"},
+		{"adjacent", "passt0k3n\n", SecretMask + "\n"},
+		{"secret of other pipeline", "other\n", "other\n"},
+	}
+	activity := &model.Activity{Id: "mask", Pipeline: model.Pipeline{Id: "p1"}}
+	for _, test := range tests {
+		got, err := MaskSecrets(activity, test.log)
+		if err != nil {
+			t.Errorf("%s: got error: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMaskSecretsCache(t *testing.T) {
+	defer initSecretStore(t)()
+	if _, err := SetSecret("p1", "TOKEN", "t0k3n"); err != nil {
+		t.Fatalf("fail to set secret: %v", err)
+	}
+	activity := &model.Activity{Id: "cache", Pipeline: model.Pipeline{Id: "p1"}}
+	if got, _ := MaskSecrets(activity, "t0k3n"); got != SecretMask {
+		t.Fatalf("got %q, want the secret masked", got)
+	}
+
+	//values are not read again for the activity
+	cred, err := rawStore.Credentials().Get(SecretId("p1", "TOKEN"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred.SecretValue = "changed"
+	if err := rawStore.Credentials().Update(cred); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := MaskSecrets(activity, "t0k3n"); got != SecretMask {
+		t.Errorf("got %q, want the cached secret masked", got)
+	}
+
+	//changing secrets drops cached values of the pipeline
+	if _, err := SetSecret("p1", "TOKEN", "n3wt0k3n"); err != nil {
+		t.Fatalf("fail to set secret: %v", err)
+	}
+	if got, _ := MaskSecrets(activity, "n3wt0k3n t0k3n"); got != SecretMask+" t0k3n" {
+		t.Errorf("got %q, want the new secret masked", got)
+	}
+	if _, err := RemoveSecret("p1", "TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := MaskSecrets(activity, "n3wt0k3n"); got != "n3wt0k3n" {
+		t.Errorf("got %q of a removed secret, want it not masked", got)
+	}
+}
+
+func TestMaskSecretsCacheLimit(t *testing.T) {
+	defer initSecretStore(t)()
+	for i := 0; i < maxMaskCache+10; i++ {
+		activity := &model.Activity{Id: fmt.Sprintf("limit%d", i), Pipeline: model.Pipeline{Id: "p1"}}
+		if _, err := MaskSecrets(activity, "log"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	masks.Lock()
+	defer masks.Unlock()
+	if len(masks.values) > maxMaskCache || len(masks.order) != len(masks.values) {
+		t.Errorf("got %d cached activities in order of %d, want at most %d", len(masks.values), len(masks.order), maxMaskCache)
+	}
+}
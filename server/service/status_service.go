@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+)
+
+//commitStatusReport is a commit status to set in the SCM
+type commitStatusReport struct {
+	gitUser string
+	repoURL string
+	commit  string
+	status  *model.CommitStatus
+}
+
+var statusQueue = make(chan *commitStatusReport, 1000)
+var statusWorkerOnce sync.Once
+
+//ReportCommitStatus sets the status of the activity to its commit in the SCM if the status changes from prev,
+//which is nil for new activities. Statuses are reported in order in the background.
+func ReportCommitStatus(prev *model.Activity, activity *model.Activity) {
+	if activity.CommitInfo == "" || activity.CommitInfo == "null" || len(activity.Pipeline.Stages) == 0 {
+		return
+	}
+	status := commitStatusOf(activity)
+	if status == nil {
+		return
+	}
+	if prev != nil && prev.CommitInfo == activity.CommitInfo {
+		if prevStatus := commitStatusOf(prev); prevStatus != nil && *prevStatus == *status {
+			return
+		}
+	}
+	scmStep := activity.Pipeline.Stages[0].Steps[0]
+	if scmStep.GitUser == "" {
+		//no scm to report without git account
+		return
+	}
+	report := &commitStatusReport{
+		gitUser: scmStep.GitUser,
+		repoURL: scmStep.Repository,
+		commit:  activity.CommitInfo,
+		status:  status,
+	}
+	statusWorkerOnce.Do(func() {
+		go statusWorker()
+	})
+	select {
+	case statusQueue <- report:
+	default:
+		logrus.Warningf("commit status queue is full, skip reporting status of activity '%s'", activity.Id)
+	}
+}
+
+func statusWorker() {
+	for report := range statusQueue {
+		if err := setCommitStatus(report); err != nil {
+			logrus.Errorf("fail to set status of commit '%s' in '%s': %v", report.commit, report.repoURL, err)
+		}
+	}
+}
+
+func setCommitStatus(report *commitStatusReport) error {
+	token, err := GetUserToken(report.gitUser)
+	if err != nil {
+		return err
+	}
+	manager, err := GetSCManagerFromUserID(report.gitUser)
+	if err != nil {
+		return err
+	}
+	return manager.SetCommitStatus(report.repoURL, report.commit, report.status, token)
+}
+
+//commitStatusOf gets the commit status of the activity, with the current stage in the description.
+//The status links to the activity if the public address of the server is set.
+func commitStatusOf(activity *model.Activity) *model.CommitStatus {
+	status := &model.CommitStatus{
+		Context: "continuous-integration/rancher-pipeline/" + activity.Pipeline.Name,
+	}
+	if config.Config.ServerUrl != "" {
+		status.TargetURL = strings.TrimSuffix(config.Config.ServerUrl, "/") + "/v1/activities/" + activity.Id
+	}
+	stage := currentStage(activity)
+	switch activity.Status {
+	case model.ActivityWaiting, model.ActivityBuilding:
+		status.State = model.CommitStateRunning
+		status.Description = "Pipeline is running"
+		if stage != "" {
+			status.Description = fmt.Sprintf("Stage '%s' is running", stage)
+		}
+	case model.ActivityQueued:
+		status.State = model.CommitStatePending
+		status.Description = fmt.Sprintf("Pipeline is queued at position %d", activity.QueuePosition)
+	case model.ActivityPending:
+		status.State = model.CommitStatePending
+		status.Description = fmt.Sprintf("Stage '%s' is waiting for approval", stage)
+	case model.ActivitySuccess:
+		status.State = model.CommitStateSuccess
+		status.Description = "Pipeline succeeded"
+	case model.ActivityFail:
+		status.State = model.CommitStateFailure
+		status.Description = fmt.Sprintf("Stage '%s' failed", stage)
+	case model.ActivityDenied:
+		status.State = model.CommitStateFailure
+		status.Description = fmt.Sprintf("Stage '%s' is denied", stage)
+	case model.ActivityAbort:
+		status.State = model.CommitStateCanceled
+		status.Description = "Pipeline is aborted"
+	default:
+		return nil
+	}
+	return status
+}
+
+//currentStage gets name of the stage that is running, pending or failed
+func currentStage(activity *model.Activity) string {
+	for _, stage := range activity.ActivityStages {
+		switch stage.Status {
+		case model.ActivityStageBuilding, model.ActivityStagePending, model.ActivityStageFail, model.ActivityStageDenied:
+			return stage.Name
+		}
+	}
+	return ""
+}
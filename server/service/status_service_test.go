@@ -0,0 +1,41 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+)
+
+func TestCommitStatusOf(t *testing.T) {
+	activity := &model.Activity{
+		Id:       "a1",
+		Pipeline: model.Pipeline{Name: "p"},
+		Status:   model.ActivityFail,
+		ActivityStages: []*model.ActivityStage{
+			{Name: "build", Status: model.ActivityStageSuccess},
+			{Name: "test", Status: model.ActivityStageFail},
+		},
+	}
+	defer func(url string) { config.Config.ServerUrl = url }(config.Config.ServerUrl)
+	tests := []struct {
+		serverUrl string
+		targetURL string
+	}{
+		{"", ""},
+		{"https://pipeline.example.com", "https://pipeline.example.com/v1/activities/a1"},
+		{"https://pipeline.example.com/", "https://pipeline.example.com/v1/activities/a1"},
+	}
+	for _, test := range tests {
+		config.Config.ServerUrl = test.serverUrl
+		want := model.CommitStatus{
+			State:       model.CommitStateFailure,
+			Context:     "continuous-integration/rancher-pipeline/p",
+			Description: "Stage 'test' failed",
+			TargetURL:   test.targetURL,
+		}
+		if got := commitStatusOf(activity); got == nil || *got != want {
+			t.Errorf("server url %q: got status %+v, want %+v", test.serverUrl, got, want)
+		}
+	}
+}
@@ -2,14 +2,25 @@ package service
 
 import (
 	"fmt"
+	"path"
 	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/rancher/pipeline/artifact"
+	"github.com/rancher/pipeline/condition"
+	"github.com/rancher/pipeline/config"
 	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/webhook"
 	"github.com/robfig/cron"
 )
 
+//maxMatrixCombinations caps steps expanded from a matrix step
+const maxMatrixCombinations = 32
+
+//maxRetryAttempts caps runs of a step with retry
+const maxRetryAttempts = 10
+
 var ErrInvalidPipeline = errors.New("Invalid Pipeline definition")
 var regName = regexp.MustCompile(`^[\w]+[\w-_]*`)
 
@@ -28,6 +39,7 @@ func CleanPipeline(p *model.Pipeline) {
 	p.Templates = nil
 	p.WebHookId = 0
 	p.WebHookToken = ""
+	p.Owner = ""
 
 	//set condition to nil if empty, for cleaner serialization
 	for _, stage := range p.Stages {
@@ -64,14 +76,44 @@ func Validate(p *model.Pipeline) error {
 		return err
 	}
 
+	if p.FromRepository && p.Stages[0].Steps[0].GitUser == "" {
+		return errors.Wrap(ErrInvalidPipeline, "pipeline from repository requires a git account for SCM step")
+	}
+
+	if err := webhook.CheckGenericWebhook(p.GenericWebhook); err != nil {
+		return err
+	}
+
+	if err := checkMembers(p); err != nil {
+		return err
+	}
+
+	if err := checkGitCredentialAccess(p); err != nil {
+		return err
+	}
+
+	if err := checkDependencies(p); err != nil {
+		return err
+	}
+
+	if err := checkConcurrency(p.Concurrency); err != nil {
+		return err
+	}
+
 	for _, stage := range p.Stages {
-		if err := checkCondition(stage.Conditions); err != nil {
+		if err := checkApprovalPolicy(stage); err != nil {
 			return err
 		}
+		if err := checkCondition(p, stage.Condition, stage.Conditions); err != nil {
+			return errors.Wrapf(err, "stage '%s'", stage.Name)
+		}
 		for _, step := range stage.Steps {
 			if err := validateStep(step); err != nil {
 				return err
 			}
+			if err := checkCondition(p, step.Condition, step.Conditions); err != nil {
+				return errors.Wrapf(err, "step '%s' in stage '%s'", step.Name, stage.Name)
+			}
 		}
 	}
 
@@ -79,6 +121,29 @@ func Validate(p *model.Pipeline) error {
 }
 
 func validateStep(step *model.Step) error {
+	if len(step.Secrets) > 0 && step.Type != model.StepTypeTask {
+		return errors.Wrapf(ErrInvalidPipeline, "secrets are only available to task steps, got '%s' step", step.Type)
+	}
+	if step.RestoreArtifacts && step.Type != model.StepTypeTask {
+		return errors.Wrapf(ErrInvalidPipeline, "restoreArtifacts is only available to task steps, got '%s' step", step.Type)
+	}
+	for _, pattern := range step.Artifacts {
+		if _, err := path.Match(pattern, ""); err != nil || artifact.CleanName(pattern) == "" {
+			return errors.Wrapf(ErrInvalidPipeline, "Invalid artifact path '%s' for step '%s'", pattern, step.Name)
+		}
+	}
+	if err := checkStepCache(step); err != nil {
+		return err
+	}
+	if err := checkProviderSupport(step); err != nil {
+		return err
+	}
+	if err := checkMatrix(step); err != nil {
+		return err
+	}
+	if err := checkRetry(step); err != nil {
+		return err
+	}
 	switch step.Type {
 	case model.StepTypeSCM:
 		if step.Repository == "" {
@@ -90,10 +155,35 @@ func validateStep(step *model.Step) error {
 		if !strings.HasSuffix(step.Repository, ".git") {
 			return errors.Wrap(ErrInvalidPipeline, "Invalid repo url for SCM step")
 		}
+		if step.GitUser == "" && step.Webhook {
+			return errors.Wrap(ErrInvalidPipeline, "webhook requires a git account for SCM step")
+		}
+		if step.GitCredential != "" {
+			cred, err := GetGitCredential(step.GitCredential)
+			if err != nil {
+				return errors.Wrap(ErrInvalidPipeline, err.Error())
+			}
+			isHTTP := strings.HasPrefix(step.Repository, "http://") || strings.HasPrefix(step.Repository, "https://")
+			if cred.CredType == model.CredTypeSSHKey && isHTTP {
+				return errors.Wrap(ErrInvalidPipeline, "ssh key credential requires a ssh repo url for SCM step")
+			} else if cred.CredType == model.CredTypeBasicAuth && !isHTTP {
+				return errors.Wrap(ErrInvalidPipeline, "basic auth credential requires a http repo url for SCM step")
+			}
+		}
+		for _, event := range step.WebhookEvents {
+			if event != model.WebhookEventPush && event != model.WebhookEventPullRequest {
+				return errors.Wrapf(ErrInvalidPipeline, "Invalid webhook event '%s' for SCM step", event)
+			}
+		}
 	case model.StepTypeTask:
 		if step.Image == "" {
 			return errors.Wrap(ErrInvalidPipeline, "Image field should not be null for task step")
 		}
+		for _, name := range step.Secrets {
+			if !regSecretName.MatchString(name) {
+				return errors.Wrapf(ErrInvalidPipeline, "Invalid secret name '%s' for task step", name)
+			}
+		}
 	case model.StepTypeBuild:
 		if step.TargetImage == "" {
 			return errors.Wrap(ErrInvalidPipeline, "Target Image field should not be null for build step")
@@ -114,8 +204,146 @@ func validateStep(step *model.Step) error {
 			return errors.Wrap(ErrInvalidPipeline, "ExternalId should not be null for upgradeCatalog step")
 		}
 	}
-	if err := checkCondition(step.Conditions); err != nil {
-		return err
+	return nil
+}
+
+func checkMatrix(step *model.Step) error {
+	if len(step.Matrix) == 0 {
+		return nil
+	}
+	if step.Type != model.StepTypeTask || step.IsService {
+		return errors.Wrapf(ErrInvalidPipeline, "matrix is only available to task steps not running as a service, got step '%s'", step.Name)
+	}
+	count := 1
+	for key, values := range step.Matrix {
+		if !regSecretName.MatchString(key) {
+			return errors.Wrapf(ErrInvalidPipeline, "Invalid matrix variable '%s' for step '%s'", key, step.Name)
+		}
+		if len(values) == 0 {
+			return errors.Wrapf(ErrInvalidPipeline, "matrix variable '%s' of step '%s' has no value", key, step.Name)
+		}
+		count *= len(values)
+		if count > maxMatrixCombinations {
+			return errors.Wrapf(ErrInvalidPipeline, "matrix of step '%s' has more than %d combinations", step.Name, maxMatrixCombinations)
+		}
+	}
+	return nil
+}
+
+func checkRetry(step *model.Step) error {
+	if step.Retry == nil {
+		return nil
+	}
+	if step.IsService {
+		return errors.Wrapf(ErrInvalidPipeline, "retry is not available to step '%s' running as a service", step.Name)
+	}
+	if step.Retry.Attempts < 1 || step.Retry.Attempts > maxRetryAttempts {
+		return errors.Wrapf(ErrInvalidPipeline, "retry attempts of step '%s' should be between 1 and %d", step.Name, maxRetryAttempts)
+	}
+	if step.Retry.Backoff < 0 {
+		return errors.Wrapf(ErrInvalidPipeline, "retry backoff of step '%s' should not be negative", step.Name)
+	}
+	for _, code := range step.Retry.ExitCodes {
+		if code < 1 || code > 255 {
+			return errors.Wrapf(ErrInvalidPipeline, "Invalid retry exit code %d for step '%s'", code, step.Name)
+		}
+	}
+	return nil
+}
+
+//checkProviderSupport rejects features of the step the configured provider does not run
+func checkProviderSupport(step *model.Step) error {
+	if config.Config.Provider != "kubernetes" {
+		return nil
+	}
+	if len(step.Artifacts) > 0 || step.RestoreArtifacts {
+		return errors.Wrapf(ErrInvalidPipeline, "artifacts are not supported by kubernetes provider, got step '%s'", step.Name)
+	}
+	if step.Cache != nil {
+		return errors.Wrapf(ErrInvalidPipeline, "cache is not supported by kubernetes provider, got step '%s'", step.Name)
+	}
+	return nil
+}
+
+func checkStepCache(step *model.Step) error {
+	if step.Cache == nil {
+		return nil
+	}
+	if step.Type != model.StepTypeTask || step.IsService {
+		return errors.Wrapf(ErrInvalidPipeline, "cache is only available to task steps not running as a service, got step '%s'", step.Name)
+	}
+	if step.Cache.Key == "" || len(step.Cache.Paths) == 0 {
+		return errors.Wrapf(ErrInvalidPipeline, "cache of step '%s' requires key and paths", step.Name)
+	}
+	if err := CheckCacheKey(step.Cache.Key); err != nil {
+		return errors.Wrapf(ErrInvalidPipeline, "Invalid cache key of step '%s': %v", step.Name, err)
+	}
+	for _, p := range step.Cache.Paths {
+		cleaned := path.Clean(p)
+		if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return errors.Wrapf(ErrInvalidPipeline, "cache path '%s' of step '%s' should be relative to the workspace", p, step.Name)
+		}
+	}
+	return nil
+}
+
+//checkDependencies checks steps run by declared dependencies refer to unique step names without cycles
+func checkDependencies(p *model.Pipeline) error {
+	if !IsDependencyMode(p) {
+		return nil
+	}
+	if len(p.Stages[0].Steps) > 1 {
+		return errors.Wrap(ErrInvalidPipeline, "the first stage should only contain the SCM step when steps declare dependsOn")
+	}
+	names := map[string]bool{}
+	for _, stage := range p.Stages {
+		if stage.NeedApprove {
+			return errors.Wrapf(ErrInvalidPipeline, "approval of stage '%s' is not available when steps declare dependsOn", stage.Name)
+		}
+		for _, step := range stage.Steps {
+			if step.Name == "" {
+				return errors.Wrapf(ErrInvalidPipeline, "Step name in stage '%s' should not be null when steps declare dependsOn", stage.Name)
+			}
+			if names[step.Name] {
+				return errors.Wrapf(ErrInvalidPipeline, "Step name '%s' duplicates", step.Name)
+			}
+			names[step.Name] = true
+		}
+	}
+	if len(p.Stages[0].Steps[0].DependsOn) > 0 {
+		return errors.Wrap(ErrInvalidPipeline, "SCM step should not declare dependsOn")
+	}
+	if _, err := StepDependencies(p); err != nil {
+		return errors.Wrap(ErrInvalidPipeline, err.Error())
+	}
+	return nil
+}
+
+func checkConcurrency(c *model.PipelineConcurrency) error {
+	if c == nil {
+		return nil
+	}
+	if c.Max < 0 {
+		return errors.Wrap(ErrInvalidPipeline, "max concurrency should not be negative")
+	}
+	switch c.Policy {
+	case "", model.ConcurrencyQueue, model.ConcurrencyCancelPrevious, model.ConcurrencySkip:
+		return nil
+	}
+	return errors.Wrapf(ErrInvalidPipeline, "Invalid concurrency policy '%s', should be queue, cancelPrevious or skip", c.Policy)
+}
+
+func checkApprovalPolicy(stage *model.Stage) error {
+	if stage.MinApprovals < 0 || stage.ApprovalTimeout < 0 {
+		return errors.Wrapf(ErrInvalidPipeline, "minApprovals and approvalTimeout should not be negative in stage '%s'", stage.Name)
+	}
+	if len(stage.ApproverGroups) == 0 && len(stage.Approvers) > 0 && stage.MinApprovals > len(stage.Approvers) {
+		return errors.Wrapf(ErrInvalidPipeline, "minApprovals is more than the number of approvers in stage '%s'", stage.Name)
+	}
+	if stage.ApprovalTimeoutAction != "" &&
+		stage.ApprovalTimeoutAction != model.ApprovalTimeoutDeny &&
+		stage.ApprovalTimeoutAction != model.ApprovalTimeoutApprove {
+		return errors.Wrapf(ErrInvalidPipeline, "Invalid approvalTimeoutAction '%s' in stage '%s', should be deny or approve", stage.ApprovalTimeoutAction, stage.Name)
 	}
 	return nil
 }
@@ -158,18 +386,32 @@ func checkCronSpec(spec string) error {
 	return nil
 }
 
-func checkCondition(conditions *model.PipelineConditions) error {
-	if conditions == nil {
-		return nil
+//checkCondition parses the condition expression and the all/any conditions,
+//and checks that steps referred by them exist in the pipeline
+func checkCondition(p *model.Pipeline, expr string, conditions *model.PipelineConditions) error {
+	exprs := []string{}
+	if expr != "" {
+		exprs = append(exprs, expr)
 	}
-	for _, condition := range conditions.All {
-		if !strings.Contains(condition, "=") {
-			return errors.Wrapf(ErrInvalidPipeline, "condition '%s' is not valid, expected format 'xx=xx' or 'xx!=xx'", condition)
+	if conditions != nil {
+		exprs = append(exprs, conditions.All...)
+		exprs = append(exprs, conditions.Any...)
+	}
+	stepNames := map[string]bool{}
+	for _, stage := range p.Stages {
+		for _, step := range stage.Steps {
+			stepNames[step.Name] = true
 		}
 	}
-	for _, condition := range conditions.Any {
-		if !strings.Contains(condition, "=") {
-			return errors.Wrapf(ErrInvalidPipeline, "condition '%s' is not valid, expected format 'xx=xx' or 'xx!=xx'", condition)
+	for _, e := range exprs {
+		parsed, err := condition.Parse(e)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidPipeline, "condition '%s' is not valid: %v", e, err)
+		}
+		for name, pos := range condition.StepRefs(parsed) {
+			if !stepNames[name] {
+				return errors.Wrapf(ErrInvalidPipeline, "condition '%s' is not valid: step '%s' is not found at position %d", e, name, pos)
+			}
 		}
 	}
 	return nil
@@ -201,3 +443,45 @@ func IsValidName(name string) error {
 	}
 	return nil
 }
+
+//checkGitCredentialAccess checks the owner of the pipeline can use git credentials of its scm steps
+//and the bound ones
+func checkGitCredentialAccess(p *model.Pipeline) error {
+	for _, id := range p.BoundGitCredentials {
+		cred, err := GetGitCredential(id)
+		if err != nil {
+			return errors.Wrap(ErrInvalidPipeline, err.Error())
+		}
+		if !ValidCredentialAccessById(p.Owner, cred) {
+			return errors.Wrapf(ErrInvalidPipeline, "no access to git credential '%s'", cred.Name)
+		}
+	}
+	for _, stage := range p.Stages {
+		for _, step := range stage.Steps {
+			if step.Type != model.StepTypeSCM || step.GitCredential == "" {
+				continue
+			}
+			cred, err := GetGitCredential(step.GitCredential)
+			if err != nil {
+				return errors.Wrap(ErrInvalidPipeline, err.Error())
+			}
+			if !ValidCredentialAccessById(p.Owner, cred) {
+				return errors.Wrapf(ErrInvalidPipeline, "no access to git credential '%s'", cred.Name)
+			}
+		}
+	}
+	return nil
+}
+
+//checkMembers checks roles of pipeline members
+func checkMembers(ppl *model.Pipeline) error {
+	for _, member := range ppl.Members {
+		if member.UserId == "" {
+			return errors.Wrap(ErrInvalidPipeline, "user id of pipeline member should not be empty")
+		}
+		if member.Role != model.RoleReader && member.Role != model.RoleRunner && member.Role != model.RoleEditor {
+			return errors.Wrapf(ErrInvalidPipeline, "invalid role '%s' for user '%s', should be one of reader, runner and editor", member.Role, member.UserId)
+		}
+	}
+	return nil
+}
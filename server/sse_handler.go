@@ -0,0 +1,293 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/api"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+	"github.com/rancher/pipeline/util"
+	"github.com/sluu99/uuid"
+)
+
+const (
+	//buffered messages of an event stream, the stream is closed when the client falls behind
+	sseBufferSize = 256
+
+	//max time to wait for new log lines in a log request without follow
+	longPollTimeout = 30 * time.Second
+)
+
+//ServeStatusSSE streams resource changes as server-sent events, the same messages as the status websocket.
+//Recent messages after the `Last-Event-ID` are sent first to resume the stream.
+func (s *Server) ServeStatusSSE(w http.ResponseWriter, r *http.Request) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported")
+	}
+	apiContext := api.GetApiContext(r)
+	uid, err := util.GetCurrentUser(r.Cookies())
+	if err != nil || uid == "" {
+		logrus.Errorf("get currentUser fail,%v,%v", uid, err)
+	}
+	groups := service.CurrentUserGroups(r)
+	connHolder := &ConnHolder{agent: GlobalAgent, send: make(chan WSMsg, sseBufferSize)}
+	connHolder.agent.register <- connHolder
+	defer func() {
+		connHolder.agent.unregister <- connHolder
+	}()
+
+	setSSEHeaders(w)
+	//messages broadcast after registering may be in the history as well
+	replayed := map[string]bool{}
+	if lastId := r.Header.Get("Last-Event-ID"); lastId != "" {
+		for _, message := range connHolder.agent.historyAfter(lastId) {
+			replayed[message.Id] = true
+			if message, ok := filterMessage(apiContext, uid, groups, message); ok {
+				if err := writeSSEMessage(w, message.Id, message); err != nil {
+					return nil
+				}
+			}
+		}
+	}
+	flusher.Flush()
+
+	pingTicker := time.NewTicker(pingPeriod)
+	defer pingTicker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case message, ok := <-connHolder.send:
+			if !ok {
+				return nil
+			}
+			if replayed[message.Id] {
+				continue
+			}
+			message, ok = filterMessage(apiContext, uid, groups, message)
+			if !ok {
+				continue
+			}
+			if err := writeSSEMessage(w, message.Id, message); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-pingTicker.C:
+			if err := writeSSE(w, "", PingMsg()); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+//ServeStepLogSSE streams new lines of the step log as server-sent events until the step finishes,
+//the event id is the number of lines sent and the stream resumes from the `Last-Event-ID` or `offset`.
+//With `follow=false`, lines from the offset are written as plain text instead, waiting for new lines
+//if there is none. A negative offset gets the tail of the log.
+func (s *Server) ServeStepLogSSE(w http.ResponseWriter, r *http.Request) error {
+	v := r.URL.Query()
+	activity, err := service.GetActivity(v.Get("activityId"))
+	if err != nil {
+		return err
+	}
+	if !service.ValidActivityAccess(r, activity, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", activity.Pipeline.Name)
+	}
+	stageOrdinal, err := strconv.Atoi(v.Get("stageOrdinal"))
+	if err != nil {
+		return err
+	}
+	stepOrdinal, err := strconv.Atoi(v.Get("stepOrdinal"))
+	if err != nil {
+		return err
+	}
+	if stageOrdinal < 0 || stepOrdinal < 0 || stageOrdinal >= len(activity.ActivityStages) || stepOrdinal >= len(activity.ActivityStages[stageOrdinal].ActivitySteps) {
+		return errors.New("step index invalid")
+	}
+	offset := 0
+	if o := r.Header.Get("Last-Event-ID"); o != "" {
+		offset, err = strconv.Atoi(o)
+	} else if o := v.Get("offset"); o != "" {
+		offset, err = strconv.Atoi(o)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid offset: %v", err)
+	}
+	if v.Get("follow") == "false" {
+		return s.serveStepLogLines(w, r, activity, stageOrdinal, stepOrdinal, offset)
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported")
+	}
+	prevLog := ""
+	logData, finished, err := s.readStepLog(activity, stageOrdinal, stepOrdinal, &prevLog)
+	if err != nil {
+		return fmt.Errorf("fail to get step log: %v", err)
+	}
+	if finished && offset >= len(splitLines(logData)) {
+		//no content stops the client reconnecting to a finished log
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	setSSEHeaders(w)
+	flusher.Flush()
+	pingTicker := time.NewTicker(pingPeriod)
+	pollTimer := time.NewTimer(0)
+	defer func() {
+		pingTicker.Stop()
+		pollTimer.Stop()
+	}()
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-pollTimer.C:
+			logData, finished, err := s.readStepLog(activity, stageOrdinal, stepOrdinal, &prevLog)
+			if err != nil {
+				logrus.Errorf("error get steplog,%v", err)
+				return nil
+			}
+			lines := splitLines(logData)
+			if offset < 0 {
+				offset = tailOffset(len(lines), offset)
+			}
+			if len(lines) > offset {
+				message := WSMsg{
+					Id:           uuid.Rand().Hex(),
+					Name:         "resource.change",
+					ResourceType: "log",
+					Time:         time.Now(),
+					Data:         strings.Join(lines[offset:], "\n") + "\n",
+				}
+				offset = len(lines)
+				if err := writeSSEMessage(w, strconv.Itoa(offset), message); err != nil {
+					return nil
+				}
+				flusher.Flush()
+			}
+			if finished {
+				return nil
+			}
+			pollTimer.Reset(pollPeriod)
+		case <-pingTicker.C:
+			if err := writeSSE(w, "", PingMsg()); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+//serveStepLogLines writes lines of the step log from the offset as plain text, it waits up to the
+//long poll timeout for new lines of the running step. The next offset is given by `X-Log-Offset`.
+func (s *Server) serveStepLogLines(w http.ResponseWriter, r *http.Request, activity *model.Activity, stageOrdinal int, stepOrdinal int, offset int) error {
+	deadline := time.Now().Add(longPollTimeout)
+	prevLog := ""
+	for {
+		logData, finished, err := s.readStepLog(activity, stageOrdinal, stepOrdinal, &prevLog)
+		if err != nil {
+			return fmt.Errorf("fail to get step log: %v", err)
+		}
+		lines := splitLines(logData)
+		if offset < 0 {
+			offset = tailOffset(len(lines), offset)
+		}
+		if len(lines) > offset || finished || time.Now().After(deadline) {
+			if offset > len(lines) {
+				offset = len(lines)
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("X-Log-Offset", strconv.Itoa(len(lines)))
+			w.Header().Set("X-Log-Finished", strconv.FormatBool(finished))
+			for _, line := range lines[offset:] {
+				if _, err := io.WriteString(w, line+"\n"); err != nil {
+					return nil
+				}
+			}
+			return nil
+		}
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-time.After(pollPeriod):
+		}
+	}
+}
+
+//readStepLog reads the step log with timestamps of lines and secrets masked, and whether the log is finished.
+//The stored log is read for the finished step, otherwise the provider is polled with the fetched raw log in prevLog
+//and only complete lines are read.
+func (s *Server) readStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, prevLog *string) (string, bool, error) {
+	if service.IsStepFinished(activity, stageOrdinal, stepOrdinal) {
+		if stepLog, err := service.GetSavedStepLog(activity.Id, stageOrdinal, stepOrdinal); err == nil {
+			logData, err := formatStepLog(activity, stepLog)
+			return logData, true, err
+		}
+	}
+	stepLog, err := s.Provider.GetStepLog(activity, stageOrdinal, stepOrdinal, map[string]interface{}{"prevLog": prevLog})
+	if err != nil {
+		return "", false, err
+	}
+	logData, err := formatStepLog(activity, completeLines(stepLog))
+	return logData, isLogFinished(stepLog), err
+}
+
+func formatStepLog(activity *model.Activity, stepLog string) (string, error) {
+	logData, _ := computeLogTimestamp(activity.StartTS, stepLog)
+	return service.MaskSecrets(activity, logData)
+}
+
+func splitLines(logData string) []string {
+	logData = strings.TrimSuffix(logData, "\n")
+	if logData == "" {
+		return []string{}
+	}
+	return strings.Split(logData, "\n")
+}
+
+//tailOffset gets the offset of the last lines of the negative offset
+func tailOffset(total int, offset int) int {
+	if total+offset < 0 {
+		return 0
+	}
+	return total + offset
+}
+
+func setSSEHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	//disable buffering of nginx proxies
+	w.Header().Set("X-Accel-Buffering", "no")
+}
+
+func writeSSEMessage(w io.Writer, id string, message WSMsg) error {
+	b, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return writeSSE(w, id, b)
+}
+
+//writeSSE writes an event of the data in one line, the last event id of the client is kept if id is empty
+func writeSSE(w io.Writer, id string, data []byte) error {
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+	return err
+}
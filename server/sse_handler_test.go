@@ -0,0 +1,249 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/pipeline/config"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/server/service"
+)
+
+//logProvider gets the step log written so far, the next chunk is appended on each read
+type logProvider struct {
+	model.PipelineProvider
+	chunks []string
+	log    string
+}
+
+func (p *logProvider) GetStepLog(activity *model.Activity, stageOrdinal int, stepOrdinal int, paras map[string]interface{}) (string, error) {
+	if len(p.chunks) > 0 {
+		p.log += p.chunks[0]
+		p.chunks = p.chunks[1:]
+	}
+	return p.log, nil
+}
+
+//initSecretServer inits a test server with encryption to set secrets
+func initSecretServer(t *testing.T, provider model.PipelineProvider) (*Server, func()) {
+	key := config.Config.EncryptionKey
+	config.Config.EncryptionKey = "test-encryption-key"
+	s, cleanup := initTestServer(t, provider)
+	return s, func() {
+		cleanup()
+		config.Config.EncryptionKey = key
+	}
+}
+
+//TestReadStepLogSplitSecret reads a running step log whose secret is written across chunks
+func TestReadStepLogSplitSecret(t *testing.T) {
+	provider := &logProvider{chunks: []string{
+		"1s  login\n2s  token=t0",
+		"k3n",
+		"\n3s  done",
+		"\n  Finished: SUCCESS\n",
+	}}
+	s, cleanup := initSecretServer(t, provider)
+	defer cleanup()
+	if _, err := service.SetSecret("p1", "TOKEN", "t0k3n"); err != nil {
+		t.Fatalf("fail to set secret: %v", err)
+	}
+	activity := &model.Activity{
+		Id:             "a1",
+		Pipeline:       model.Pipeline{Id: "p1"},
+		ActivityStages: []*model.ActivityStage{{ActivitySteps: []*model.ActivityStep{{Status: model.ActivityStepBuilding}}}},
+	}
+
+	//lines are sent from the offset as the stream does
+	sent := []string{}
+	prevLog := ""
+	finished := false
+	for i := 0; i < 4 && !finished; i++ {
+		var logData string
+		var err error
+		logData, finished, err = s.readStepLog(activity, 0, 0, &prevLog)
+		if err != nil {
+			t.Fatalf("got error: %v", err)
+		}
+		lines := splitLines(logData)
+		if len(lines) > len(sent) {
+			sent = append(sent, lines[len(sent):]...)
+		}
+	}
+	if !finished {
+		t.Error("got the log not finished")
+	}
+	got := []string{}
+	for _, line := range sent {
+		//drop timestamps
+		got = append(got, line[strings.Index(line, "  ")+2:])
+	}
+	want := []string{"login", "token=" + service.SecretMask, "done", "Finished: SUCCESS"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got lines %q, want %q", got, want)
+	}
+}
+
+//initStreamServer inits a test server whose agent sends broadcast messages to the registered streams,
+//the user of a request is the value of its "user" cookie
+func initStreamServer(t *testing.T) (*Server, func()) {
+	s, cleanup := initTestServer(t, &fakeProvider{})
+	GlobalAgent = &Agent{
+		Server:      s,
+		connHolders: make(map[*ConnHolder]bool),
+		register:    make(chan *ConnHolder),
+		unregister:  make(chan *ConnHolder),
+		broadcast:   make(chan WSMsg),
+	}
+	go GlobalAgent.handleWS()
+	cattle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("user"); err == nil && r.URL.Path == "/accounts" {
+			w.Header().Set("X-Api-User-Id", cookie.Value)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	cattleUrl := config.Config.CattleUrl
+	config.Config.CattleUrl = cattle.URL
+	return s, func() {
+		config.Config.CattleUrl = cattleUrl
+		cattle.Close()
+		cleanup()
+	}
+}
+
+//openStream opens the event stream of the url as the user, lines of events with an id are sent to the returned channel
+func openStream(t *testing.T, ctx context.Context, url string, user string) (*http.Response, chan []string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "user", Value: user})
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("fail to open stream: %v", err)
+	}
+	events := make(chan []string, 10)
+	go func() {
+		defer close(events)
+		reader := bufio.NewReader(resp.Body)
+		event := []string{}
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			if line != "" {
+				event = append(event, line)
+				continue
+			}
+			//pings have no id
+			if len(event) > 0 && strings.HasPrefix(event[0], "id: ") {
+				events <- event
+			}
+			event = []string{}
+		}
+	}()
+	return resp, events
+}
+
+func nextEvent(t *testing.T, events chan []string) []string {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("got the stream closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no event")
+	}
+	return nil
+}
+
+func TestServeStatusSSE(t *testing.T) {
+	s, cleanup := initStreamServer(t)
+	defer cleanup()
+	p := &model.Pipeline{Id: "p1", Name: "p", Owner: "owner"}
+	if err := service.CreatePipeline(p); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(HandleError(model.NewSchema(), s.ServeStatusSSE))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ownerResp, ownerEvents := openStream(t, ctx, ts.URL, "owner")
+	defer ownerResp.Body.Close()
+	otherResp, otherEvents := openStream(t, ctx, ts.URL, "other")
+	defer otherResp.Body.Close()
+	if got := ownerResp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("got content type %s, want text/event-stream", got)
+	}
+
+	broadcastResourceChange(model.Activity{Id: "a1", Pipeline: *p})
+	broadcastResourceChange(model.PipelineSetting{})
+
+	tests := []struct {
+		name         string
+		events       chan []string
+		resourceType string
+	}{
+		{"owner", ownerEvents, "activity"},
+		//the activity is not sent to a user without access
+		{"other", otherEvents, "setting"},
+	}
+	for _, test := range tests {
+		event := nextEvent(t, test.events)
+		if len(event) != 2 || !strings.HasPrefix(event[1], "data: ") {
+			t.Errorf("%s: got event %q, want an id line and a data line", test.name, event)
+			continue
+		}
+		message := struct {
+			Id           string `json:"id"`
+			ResourceType string `json:"resourceType"`
+		}{}
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(event[1], "data: ")), &message); err != nil {
+			t.Errorf("%s: got invalid data: %v", test.name, err)
+			continue
+		}
+		if event[0] != "id: "+message.Id {
+			t.Errorf("%s: got %q, want the id of message %s", test.name, event[0], message.Id)
+		}
+		if message.ResourceType != test.resourceType {
+			t.Errorf("%s: got %s event, want %s", test.name, message.ResourceType, test.resourceType)
+		}
+	}
+}
+
+func TestServeStatusSSEDisconnect(t *testing.T) {
+	s, cleanup := initStreamServer(t)
+	defer cleanup()
+	done := make(chan struct{})
+	handler := HandleError(model.NewSchema(), s.ServeStatusSSE)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+		close(done)
+	}))
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, _ := openStream(t, ctx, ts.URL, "owner")
+	defer resp.Body.Close()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("got the stream running after the client disconnected")
+	}
+	//the agent has handled the unregister once it takes the next broadcast
+	broadcastResourceChange(model.PipelineSetting{})
+	if len(GlobalAgent.connHolders) != 0 {
+		t.Errorf("got %d registered streams, want none", len(GlobalAgent.connHolders))
+	}
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/rancher/pipeline/model"
+)
+
+const GenericSignatureHeader = "X-Pipeline-Signature"
+const GenericTokenHeader = "X-Pipeline-Token"
+
+var regEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+//CheckGenericWebhook validates the generic webhook config of a pipeline
+func CheckGenericWebhook(w *model.GenericWebhook) error {
+	if w == nil || !w.Enabled {
+		return nil
+	}
+	if w.Secret == "" {
+		return fmt.Errorf("secret is required for generic webhook")
+	}
+	for _, param := range w.Params {
+		if !regEnvName.MatchString(param.Name) {
+			return fmt.Errorf("invalid webhook param name '%s'", param.Name)
+		}
+		if strings.HasPrefix(param.Name, "CICD_") {
+			return fmt.Errorf("webhook param name '%s' is preserved", param.Name)
+		}
+		if _, err := parseJSONPath(param.Path); err != nil {
+			return err
+		}
+		if _, err := regexp.Compile(param.Filter); err != nil {
+			return fmt.Errorf("invalid filter of webhook param '%s': %v", param.Name, err)
+		}
+	}
+	return nil
+}
+
+//VerifyGenericPayload checks the hmac-sha256 signature of the body in the signature header,
+//which is hex encoded with an optional 'sha256=' prefix, or the token in the token header
+func VerifyGenericPayload(w *model.GenericWebhook, req *http.Request, body []byte) bool {
+	if w.Secret == "" {
+		return false
+	}
+	if signature := req.Header.Get(GenericSignatureHeader); signature != "" {
+		actual, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+		if err != nil {
+			return false
+		}
+		computed := hmac.New(sha256.New, []byte(w.Secret))
+		computed.Write(body)
+		return hmac.Equal(computed.Sum(nil), actual)
+	}
+	if token := req.Header.Get(GenericTokenHeader); token != "" {
+		return hmac.Equal([]byte(token), []byte(w.Secret))
+	}
+	return false
+}
+
+//ExtractParams gets the params from the JSON payload, returns false if any value does not match the filter
+func ExtractParams(w *model.GenericWebhook, body []byte) (map[string]string, bool, error) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, false, fmt.Errorf("invalid JSON payload: %v", err)
+	}
+	params := map[string]string{}
+	for _, param := range w.Params {
+		value, err := LookupJSONPath(param.Path, data)
+		if err != nil {
+			return nil, false, err
+		}
+		if param.Filter != "" {
+			re, err := regexp.Compile(param.Filter)
+			if err != nil {
+				return nil, false, err
+			}
+			if !re.MatchString(value) {
+				return nil, false, nil
+			}
+		}
+		params[param.Name] = value
+	}
+	return params, true, nil
+}
@@ -0,0 +1,127 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/rancher/pipeline/model"
+)
+
+func sign(h func() hash.Hash, secret string, body []byte) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyGenericPayload(t *testing.T) {
+	body := []byte(`{"ref":"master"}`)
+	w := &model.GenericWebhook{Enabled: true, Secret: "s3cret"}
+	tests := []struct {
+		name    string
+		secret  string
+		headers map[string]string
+		want    bool
+	}{
+		{"sha256 signature", "s3cret", map[string]string{GenericSignatureHeader: sign(sha256.New, "s3cret", body)}, true},
+		{"sha256 prefixed signature", "s3cret", map[string]string{GenericSignatureHeader: "sha256=" + sign(sha256.New, "s3cret", body)}, true},
+		{"sha1 prefixed signature", "s3cret", map[string]string{GenericSignatureHeader: "sha1=" + sign(sha1.New, "s3cret", body)}, false},
+		{"sha1 signature", "s3cret", map[string]string{GenericSignatureHeader: sign(sha1.New, "s3cret", body)}, false},
+		{"wrong signature", "s3cret", map[string]string{GenericSignatureHeader: sign(sha256.New, "other", body)}, false},
+		{"malformed signature", "s3cret", map[string]string{GenericSignatureHeader: "sha256=zz"}, false},
+		{"wrong signature with right token", "s3cret", map[string]string{GenericSignatureHeader: sign(sha256.New, "other", body), GenericTokenHeader: "s3cret"}, false},
+		{"token", "s3cret", map[string]string{GenericTokenHeader: "s3cret"}, true},
+		{"wrong token", "s3cret", map[string]string{GenericTokenHeader: "s3cre"}, false},
+		{"missing header", "s3cret", map[string]string{}, false},
+		{"no secret", "", map[string]string{GenericTokenHeader: ""}, false},
+	}
+	for _, test := range tests {
+		w.Secret = test.secret
+		req, err := http.NewRequest("POST", "/v1/webhook", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		if got := VerifyGenericPayload(w, req, body); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExtractParams(t *testing.T) {
+	body := []byte(`{"push_data":{"tag":"v1.2"},"repository":{"name":"app"}}`)
+	tests := []struct {
+		name      string
+		params    []*model.WebhookParam
+		body      []byte
+		want      map[string]string
+		wantMatch bool
+		wantErr   bool
+	}{
+		{
+			name: "params",
+			params: []*model.WebhookParam{
+				{Name: "TAG", Path: "$.push_data.tag"},
+				{Name: "REPO", Path: "$.repository.name"},
+			},
+			body:      body,
+			want:      map[string]string{"TAG": "v1.2", "REPO": "app"},
+			wantMatch: true,
+		},
+		{
+			name:      "missing value",
+			params:    []*model.WebhookParam{{Name: "TAG", Path: "$.push_data.digest"}},
+			body:      body,
+			want:      map[string]string{"TAG": ""},
+			wantMatch: true,
+		},
+		{
+			name:      "matched filter",
+			params:    []*model.WebhookParam{{Name: "TAG", Path: "$.push_data.tag", Filter: `^v\d+\.\d+$`}},
+			body:      body,
+			want:      map[string]string{"TAG": "v1.2"},
+			wantMatch: true,
+		},
+		{
+			name:   "unmatched filter",
+			params: []*model.WebhookParam{{Name: "TAG", Path: "$.push_data.tag", Filter: "^latest$"}},
+			body:   body,
+		},
+		{
+			name:    "invalid path",
+			params:  []*model.WebhookParam{{Name: "TAG", Path: "push_data.tag"}},
+			body:    body,
+			wantErr: true,
+		},
+		{
+			name:    "invalid filter",
+			params:  []*model.WebhookParam{{Name: "TAG", Path: "$.push_data.tag", Filter: "("}},
+			body:    body,
+			wantErr: true,
+		},
+		{
+			name:    "invalid payload",
+			params:  []*model.WebhookParam{{Name: "TAG", Path: "$.push_data.tag"}},
+			body:    []byte("tag=v1"),
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		w := &model.GenericWebhook{Enabled: true, Secret: "s", Params: test.params}
+		got, match, err := ExtractParams(w, test.body)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if match != test.wantMatch || (match && !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("%s: got %v, %v, want %v, %v", test.name, got, match, test.want, test.wantMatch)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//pathElem is a field name or an array index in a JSONPath
+type pathElem struct {
+	field string
+	index int
+	isIdx bool
+}
+
+//parseJSONPath parses the JSONPath subset of fields and array indexes,
+//e.g. $.push_data.tag, $.events[0].target['media-type']
+func parseJSONPath(path string) ([]pathElem, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath '%s' should start with '$'", path)
+	}
+	elems := []pathElem{}
+	s := path[1:]
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			i := 1
+			for i < len(s) && s[i] != '.' && s[i] != '[' {
+				i++
+			}
+			if i == 1 {
+				return nil, fmt.Errorf("empty field in JSONPath '%s'", path)
+			}
+			elems = append(elems, pathElem{field: s[1:i]})
+			s = s[i:]
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed '[' in JSONPath '%s'", path)
+			}
+			inner := s[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				elems = append(elems, pathElem{field: inner[1 : len(inner)-1]})
+			} else {
+				idx, err := strconv.Atoi(inner)
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("invalid index '%s' in JSONPath '%s'", inner, path)
+				}
+				elems = append(elems, pathElem{index: idx, isIdx: true})
+			}
+			s = s[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected '%c' in JSONPath '%s'", s[0], path)
+		}
+	}
+	return elems, nil
+}
+
+//LookupJSONPath gets the value at the path of the decoded JSON data.
+//Strings are returned as is, other values are JSON encoded, missing values are empty
+func LookupJSONPath(path string, data interface{}) (string, error) {
+	elems, err := parseJSONPath(path)
+	if err != nil {
+		return "", err
+	}
+	v := data
+	for _, elem := range elems {
+		if elem.isIdx {
+			arr, ok := v.([]interface{})
+			if !ok || elem.index >= len(arr) {
+				return "", nil
+			}
+			v = arr[elem.index]
+		} else {
+			obj, ok := v.(map[string]interface{})
+			if !ok {
+				return "", nil
+			}
+			if v, ok = obj[elem.field]; !ok {
+				return "", nil
+			}
+		}
+	}
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
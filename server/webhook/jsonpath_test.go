@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []pathElem
+		wantErr bool
+	}{
+		{"$", []pathElem{}, false},
+		{"$.push_data.tag", []pathElem{{field: "push_data"}, {field: "tag"}}, false},
+		{"$.events[0].target", []pathElem{{field: "events"}, {index: 0, isIdx: true}, {field: "target"}}, false},
+		{"$[12]", []pathElem{{index: 12, isIdx: true}}, false},
+		{"$.target['media-type']", []pathElem{{field: "target"}, {field: "media-type"}}, false},
+		{`$["a.b"]["c]"]`, nil, true},
+		{`$["a.b"]`, []pathElem{{field: "a.b"}}, false},
+		{"$['']", []pathElem{{field: ""}}, false},
+		{"push_data.tag", nil, true},
+		{"$push_data", nil, true},
+		{"$..tag", nil, true},
+		{"$.", nil, true},
+		{"$[0", nil, true},
+		{"$.a[", nil, true},
+		{"$[]", nil, true},
+		{"$[-1]", nil, true},
+		{"$[x]", nil, true},
+		{"$['a]", nil, true},
+		{`$['a"]`, nil, true},
+		{"$[0]x", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parseJSONPath(test.path)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.path, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.path, got, test.want)
+		}
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	var data interface{}
+	payload := `{"push_data":{"tag":"v1","size":3,"latest":true,"none":null},"events":[{"target":{"media-type":"json"}}],"name":"x"}`
+	if err := json.Unmarshal([]byte(payload), &data); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"$.push_data.tag", "v1", false},
+		{"$.push_data.size", "3", false},
+		{"$.push_data.latest", "true", false},
+		{"$.push_data.none", "", false},
+		{"$.events[0].target['media-type']", "json", false},
+		{"$.events[0].target", `{"media-type":"json"}`, false},
+		{"$.push_data.missing", "", false},
+		{"$.missing.tag", "", false},
+		{"$.events[1].target", "", false},
+		{"$.name.first", "", false},
+		{"$.name[0]", "", false},
+		{"$.push_data[0]", "", false},
+		{"$.events.target", "", false},
+		{"$.events[", "", true},
+	}
+	for _, test := range tests {
+		got, err := LookupJSONPath(test.path, data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: got error %v, want error %v", test.path, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.want)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/websocket"
+	"github.com/rancher/pipeline/model"
 	"github.com/rancher/pipeline/server/service"
 	"github.com/sluu99/uuid"
 )
@@ -57,12 +59,6 @@ func PingMsg() []byte {
 	return b
 }
 
-func getActivityLog(activityId string, stepOrdinal int) ([]byte, error) {
-
-	log := "testing log," + time.Now().String()
-	return []byte(log), nil
-}
-
 func stepLogReader(ws *websocket.Conn) {
 	defer ws.Close()
 	ws.SetReadLimit(512)
@@ -88,13 +84,17 @@ func (s *Server) stepLogWriter(ws *websocket.Conn, activityId string, stageOrdin
 	if err != nil {
 		return
 	}
+	if service.IsStepFinished(activity, stageOrdinal, stepOrdinal) {
+		//serve the stored log once, jobs of the step may be gone
+		if stepLog, err := service.GetSavedStepLog(activityId, stageOrdinal, stepOrdinal); err == nil {
+			writeStepLog(ws, activity, stepLog)
+			return
+		}
+	}
 	prevLog := ""
 	for {
 		select {
 		case <-pollTicker.C:
-			var b []byte
-			var err error
-
 			paras := map[string]interface{}{}
 			paras["prevLog"] = &prevLog
 			stepLog, err := s.Provider.GetStepLog(activity, stageOrdinal, stepOrdinal, paras)
@@ -102,23 +102,11 @@ func (s *Server) stepLogWriter(ws *websocket.Conn, activityId string, stageOrdin
 				logrus.Errorf("error get steplog,%v", err)
 				return
 			}
-			if stepLog != "" {
-				ws.SetWriteDeadline(time.Now().Add(writeWait))
-				logData, _ := computeLogTimestamp(activity.StartTS, stepLog)
-				response := WSMsg{
-					Id:           uuid.Rand().Hex(),
-					Name:         "resource.change",
-					ResourceType: "log",
-					Time:         time.Now(),
-					Data:         logData,
-				}
-				b, err = json.Marshal(response)
-				if err := ws.WriteMessage(websocket.TextMessage, b); err != nil {
+			if stepLog = completeLines(stepLog); stepLog != "" {
+				if err := writeStepLog(ws, activity, stepLog); err != nil {
 					return
 				}
-				if strings.HasSuffix(stepLog, "\n  Finished: SUCCESS\n") ||
-					strings.HasSuffix(stepLog, "\n  Finished: FAILURE\n") ||
-					strings.HasSuffix(stepLog, "\n  Finished: ABORTED\n") {
+				if isLogFinished(stepLog) {
 					//finish
 					return
 				}
@@ -137,6 +125,45 @@ func (s *Server) stepLogWriter(ws *websocket.Conn, activityId string, stageOrdin
 	}
 }
 
+func isLogFinished(stepLog string) bool {
+	return strings.HasSuffix(stepLog, "\n  Finished: SUCCESS\n") ||
+		strings.HasSuffix(stepLog, "\n  Finished: FAILURE\n") ||
+		strings.HasSuffix(stepLog, "\n  Finished: ABORTED\n")
+}
+
+//completeLines cuts the last line of the log of the running step if it is not complete,
+//it may end in part of a secret which cannot be masked until the rest is written
+func completeLines(stepLog string) string {
+	if isLogFinished(stepLog) {
+		return stepLog
+	}
+	return stepLog[:strings.LastIndex(stepLog, "\n")+1]
+}
+
+//writeStepLog writes the step log with timestamps of lines and secrets masked
+func writeStepLog(ws *websocket.Conn, activity *model.Activity, stepLog string) error {
+	ws.SetWriteDeadline(time.Now().Add(writeWait))
+	logData, _ := computeLogTimestamp(activity.StartTS, stepLog)
+	logData, err := service.MaskSecrets(activity, logData)
+	if err != nil {
+		//never serve the log if secrets cannot be masked
+		logrus.Errorf("fail to mask secrets in step log: %v", err)
+		return err
+	}
+	response := WSMsg{
+		Id:           uuid.Rand().Hex(),
+		Name:         "resource.change",
+		ResourceType: "log",
+		Time:         time.Now(),
+		Data:         logData,
+	}
+	b, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(websocket.TextMessage, b)
+}
+
 func computeLogTimestamp(startTS int64, stepLog string) (string, error) {
 	lines := strings.Split(stepLog, "\n")
 	b := bytes.NewBufferString("")
@@ -167,6 +194,13 @@ func computeLogTimestamp(startTS int64, stepLog string) (string, error) {
 }
 
 func (s *Server) ServeStepLog(w http.ResponseWriter, r *http.Request) error {
+	activity, err := service.GetActivity(r.URL.Query().Get("activityId"))
+	if err != nil {
+		return err
+	}
+	if !service.ValidActivityAccess(r, activity, model.RoleReader) {
+		return fmt.Errorf("no access to pipeline '%s'", activity.Pipeline.Name)
+	}
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		if _, ok := err.(websocket.HandshakeError); !ok {
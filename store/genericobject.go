@@ -0,0 +1,500 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/pipeline/model"
+	"github.com/rancher/pipeline/util"
+)
+
+const (
+	pipelineKind        = "pipeline"
+	activityKind        = "activity"
+	gitAccountKind      = "gitaccount"
+	repoCacheKind       = "repocache"
+	pipelineSettingKind = "pipelineSetting"
+	scmSettingKind      = "scmSetting"
+	credentialKind      = "pipelineCred"
+)
+
+//GenericObjectStore keeps pipeline data as JSON blobs in Rancher GenericObjects
+type GenericObjectStore struct {
+}
+
+func NewGenericObjectStore() *GenericObjectStore {
+	return &GenericObjectStore{}
+}
+
+func (s *GenericObjectStore) Pipelines() PipelineRepository {
+	return goPipelineRepository{}
+}
+
+func (s *GenericObjectStore) Activities() ActivityRepository {
+	return goActivityRepository{}
+}
+
+func (s *GenericObjectStore) Accounts() AccountRepository {
+	return goAccountRepository{}
+}
+
+func (s *GenericObjectStore) Settings() SettingRepository {
+	return goSettingRepository{}
+}
+
+func (s *GenericObjectStore) Credentials() CredentialRepository {
+	return goCredentialRepository{}
+}
+
+func (s *GenericObjectStore) Reset() error {
+	kinds := []string{activityKind, pipelineKind, pipelineSettingKind, scmSettingKind, gitAccountKind, repoCacheKind, credentialKind}
+	for _, kind := range kinds {
+		if err := cleanGO(kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func PaginateGenericObjects(kind string) ([]client.GenericObject, error) {
+	result := []client.GenericObject{}
+	limit := "1000"
+	marker := ""
+	var pageData []client.GenericObject
+	var err error
+	for {
+		pageData, marker, err = getGenericObjects(kind, limit, marker)
+		if err != nil {
+			logrus.Debugf("get genericobject err:%v", err)
+			return nil, err
+		}
+		result = append(result, pageData...)
+		if marker == "" {
+			break
+		}
+	}
+	return result, nil
+}
+
+func getGenericObjects(kind string, limit string, marker string) ([]client.GenericObject, string, error) {
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		logrus.Errorf("fail to get client:%v", err)
+		return nil, "", err
+	}
+	filters := make(map[string]interface{})
+	filters["kind"] = kind
+	filters["limit"] = limit
+	filters["marker"] = marker
+	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
+		Filters: filters,
+	})
+	if err != nil {
+		logrus.Errorf("fail querying generic objects, error:%v", err)
+		return nil, "", err
+	}
+	//get next marker
+	nextMarker := ""
+	if goCollection.Pagination != nil && goCollection.Pagination.Next != "" {
+		r, err := url.Parse(goCollection.Pagination.Next)
+		if err != nil {
+			logrus.Errorf("fail parsing next url, error:%v", err)
+			return nil, "", err
+		}
+		nextMarker = r.Query().Get("marker")
+	}
+	return goCollection.Data, nextMarker, err
+
+}
+
+//getGenericObject gets the first generic object of the kind by key
+func getGenericObject(kind string, key string) (*client.GenericObject, error) {
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return nil, err
+	}
+	filters := make(map[string]interface{})
+	filters["kind"] = kind
+	if key != "" {
+		filters["key"] = key
+	}
+	goCollection, err := apiClient.GenericObject.List(&client.ListOpts{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error %v filtering genericObjects by key", err)
+	}
+	if len(goCollection.Data) == 0 {
+		return nil, ErrNotFound
+	}
+	return &goCollection.Data[0], nil
+}
+
+func decodeGenericObject(gobj *client.GenericObject, obj interface{}) error {
+	data, ok := gobj.ResourceData["data"].(string)
+	if !ok {
+		return fmt.Errorf("invalid data in generic object '%s'", gobj.Key)
+	}
+	return json.Unmarshal([]byte(data), obj)
+}
+
+func toGenericObject(kind string, name string, key string, obj interface{}) (*client.GenericObject, error) {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &client.GenericObject{
+		Name: name,
+		Key:  key,
+		ResourceData: map[string]interface{}{
+			"data": string(b),
+		},
+		Kind: kind,
+	}, nil
+}
+
+func createGenericObject(kind string, name string, key string, obj interface{}) error {
+	gobj, err := toGenericObject(kind, name, key, obj)
+	if err != nil {
+		return err
+	}
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return err
+	}
+	_, err = apiClient.GenericObject.Create(gobj)
+	return err
+}
+
+//saveGenericObject updates the generic object of the key, or creates one if not exist
+func saveGenericObject(kind string, name string, key string, obj interface{}, create bool) error {
+	existing, err := getGenericObject(kind, key)
+	if err == ErrNotFound && create {
+		return createGenericObject(kind, name, key, obj)
+	} else if err != nil {
+		return err
+	}
+	gobj, err := toGenericObject(kind, name, key, obj)
+	if err != nil {
+		return err
+	}
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return err
+	}
+	_, err = apiClient.GenericObject.Update(existing, gobj)
+	return err
+}
+
+//deleteGenericObject deletes the generic object of the key and decodes the deleted data into obj
+func deleteGenericObject(kind string, key string, obj interface{}) error {
+	existing, err := getGenericObject(kind, key)
+	if err != nil {
+		return err
+	}
+	if err := decodeGenericObject(existing, obj); err != nil {
+		return err
+	}
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return err
+	}
+	return apiClient.GenericObject.Delete(existing)
+}
+
+func cleanGO(kind string) error {
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return err
+	}
+	data, err := PaginateGenericObjects(kind)
+	if err != nil {
+		return err
+	}
+	for _, gobj := range data {
+		if err := apiClient.GenericObject.Delete(&gobj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type goPipelineRepository struct{}
+
+func (r goPipelineRepository) Get(id string) (*model.Pipeline, error) {
+	gobj, err := getGenericObject(pipelineKind, id)
+	if err != nil {
+		return nil, err
+	}
+	ppl := &model.Pipeline{}
+	if err := decodeGenericObject(gobj, ppl); err != nil {
+		return nil, err
+	}
+	return ppl, nil
+}
+
+func (r goPipelineRepository) List() ([]*model.Pipeline, error) {
+	geObjList, err := PaginateGenericObjects(pipelineKind)
+	if err != nil {
+		return nil, err
+	}
+	var pipelines []*model.Pipeline
+	for _, gobj := range geObjList {
+		p := &model.Pipeline{}
+		if err := decodeGenericObject(&gobj, p); err != nil {
+			logrus.Errorf("unmarshal pipeline got err:%v", err)
+			continue
+		}
+		pipelines = append(pipelines, p)
+	}
+	return pipelines, nil
+}
+
+func (r goPipelineRepository) Create(pipeline *model.Pipeline) error {
+	return createGenericObject(pipelineKind, pipeline.Name, pipeline.Id, pipeline)
+}
+
+func (r goPipelineRepository) Update(pipeline *model.Pipeline) error {
+	return saveGenericObject(pipelineKind, pipeline.Name, pipeline.Id, pipeline, false)
+}
+
+func (r goPipelineRepository) Delete(id string) (*model.Pipeline, error) {
+	ppl := &model.Pipeline{}
+	if err := deleteGenericObject(pipelineKind, id, ppl); err != nil {
+		return nil, err
+	}
+	return ppl, nil
+}
+
+type goActivityRepository struct{}
+
+func (r goActivityRepository) Get(id string) (*model.Activity, error) {
+	gobj, err := getGenericObject(activityKind, id)
+	if err != nil {
+		return nil, err
+	}
+	activity := &model.Activity{}
+	if err := decodeGenericObject(gobj, activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+//List pages through all activity objects as GenericObjects cannot be filtered by content
+func (r goActivityRepository) List(filter *ActivityFilter) ([]*model.Activity, error) {
+	geObjList, err := PaginateGenericObjects(activityKind)
+	if err != nil {
+		return nil, err
+	}
+	var activities []*model.Activity
+	for _, gobj := range geObjList {
+		a := &model.Activity{}
+		if err := decodeGenericObject(&gobj, a); err != nil {
+			logrus.Errorf("unmarshal activity got err:%v", err)
+			continue
+		}
+		if filter.Match(a) {
+			activities = append(activities, a)
+		}
+	}
+	return activities, nil
+}
+
+func (r goActivityRepository) Create(activity *model.Activity) error {
+	return createGenericObject(activityKind, activity.Id, activity.Id, activity)
+}
+
+func (r goActivityRepository) Update(activity *model.Activity) error {
+	return saveGenericObject(activityKind, activity.Id, activity.Id, activity, false)
+}
+
+func (r goActivityRepository) Delete(id string) error {
+	return deleteGenericObject(activityKind, id, &model.Activity{})
+}
+
+type goAccountRepository struct{}
+
+func (r goAccountRepository) Get(id string) (*model.GitAccount, error) {
+	gobj, err := getGenericObject(gitAccountKind, id)
+	if err != nil {
+		return nil, err
+	}
+	account := &model.GitAccount{}
+	if err := decodeGenericObject(gobj, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (r goAccountRepository) List() ([]*model.GitAccount, error) {
+	geObjList, err := PaginateGenericObjects(gitAccountKind)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*model.GitAccount
+	for _, gobj := range geObjList {
+		a := &model.GitAccount{}
+		if err := decodeGenericObject(&gobj, a); err != nil {
+			logrus.Errorf("parse data got error:%v", err)
+			continue
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, nil
+}
+
+func (r goAccountRepository) Create(account *model.GitAccount) error {
+	return createGenericObject(gitAccountKind, account.Id, account.Id, account)
+}
+
+func (r goAccountRepository) Update(account *model.GitAccount) error {
+	return saveGenericObject(gitAccountKind, account.Id, account.Id, account, false)
+}
+
+func (r goAccountRepository) Delete(id string) (*model.GitAccount, error) {
+	account := &model.GitAccount{}
+	if err := deleteGenericObject(gitAccountKind, id, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (r goAccountRepository) GetRepoCache(accountId string) ([]*model.GitRepository, error) {
+	gobj, err := getGenericObject(repoCacheKind, accountId)
+	if err != nil {
+		return nil, err
+	}
+	repos := []*model.GitRepository{}
+	if err := decodeGenericObject(gobj, &repos); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func (r goAccountRepository) SaveRepoCache(accountId string, repos []*model.GitRepository) error {
+	return saveGenericObject(repoCacheKind, "", accountId, repos, true)
+}
+
+type goSettingRepository struct{}
+
+func (r goSettingRepository) GetPipelineSetting() (*model.PipelineSetting, error) {
+	gobj, err := getGenericObject(pipelineSettingKind, "")
+	if err != nil {
+		return nil, err
+	}
+	setting := &model.PipelineSetting{}
+	if err := decodeGenericObject(gobj, setting); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+func (r goSettingRepository) SavePipelineSetting(setting *model.PipelineSetting) error {
+	existing, err := getGenericObject(pipelineSettingKind, "")
+	if err == ErrNotFound {
+		return createGenericObject(pipelineSettingKind, pipelineSettingKind, pipelineSettingKind, setting)
+	} else if err != nil {
+		return err
+	}
+	gobj, err := toGenericObject(pipelineSettingKind, pipelineSettingKind, pipelineSettingKind, setting)
+	if err != nil {
+		return err
+	}
+	apiClient, err := util.GetRancherClient()
+	if err != nil {
+		return err
+	}
+	_, err = apiClient.GenericObject.Update(existing, gobj)
+	return err
+}
+
+func (r goSettingRepository) GetSCMSetting(scmType string) (*model.SCMSetting, error) {
+	gobj, err := getGenericObject(scmSettingKind, scmType)
+	if err != nil {
+		return nil, err
+	}
+	setting := &model.SCMSetting{}
+	if err := decodeGenericObject(gobj, setting); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+func (r goSettingRepository) ListSCMSettings() ([]*model.SCMSetting, error) {
+	geObjList, err := PaginateGenericObjects(scmSettingKind)
+	if err != nil {
+		return nil, err
+	}
+	var settings []*model.SCMSetting
+	for _, gobj := range geObjList {
+		a := &model.SCMSetting{}
+		if err := decodeGenericObject(&gobj, a); err != nil {
+			logrus.Errorf("unmarshal setting got err:%v", err)
+			continue
+		}
+		settings = append(settings, a)
+	}
+	return settings, nil
+}
+
+func (r goSettingRepository) SaveSCMSetting(setting *model.SCMSetting) error {
+	return saveGenericObject(scmSettingKind, setting.ScmType+"-setting", setting.ScmType, setting, true)
+}
+
+func (r goSettingRepository) DeleteSCMSetting(scmType string) (*model.SCMSetting, error) {
+	setting := &model.SCMSetting{}
+	if err := deleteGenericObject(scmSettingKind, scmType, setting); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+type goCredentialRepository struct{}
+
+func (r goCredentialRepository) Get(id string) (*model.Credential, error) {
+	gobj, err := getGenericObject(credentialKind, id)
+	if err != nil {
+		return nil, err
+	}
+	cred := &model.Credential{}
+	if err := decodeGenericObject(gobj, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (r goCredentialRepository) List() ([]*model.Credential, error) {
+	geObjList, err := PaginateGenericObjects(credentialKind)
+	if err != nil {
+		return nil, err
+	}
+	var creds []*model.Credential
+	for _, gobj := range geObjList {
+		c := &model.Credential{}
+		if err := decodeGenericObject(&gobj, c); err != nil {
+			logrus.Errorf("unmarshal credential got err:%v", err)
+			continue
+		}
+		creds = append(creds, c)
+	}
+	return creds, nil
+}
+
+func (r goCredentialRepository) Create(cred *model.Credential) error {
+	return createGenericObject(credentialKind, cred.Id, cred.Id, cred)
+}
+
+func (r goCredentialRepository) Update(cred *model.Credential) error {
+	return saveGenericObject(credentialKind, cred.Id, cred.Id, cred, false)
+}
+
+func (r goCredentialRepository) Delete(id string) (*model.Credential, error) {
+	cred := &model.Credential{}
+	if err := deleteGenericObject(credentialKind, id, cred); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
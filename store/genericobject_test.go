@@ -0,0 +1,118 @@
+package store
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/pipeline/config"
+)
+
+//fakeCattle serves generic objects of the Cattle API in memory, lists are paged by pageSize to follow markers
+type fakeCattle struct {
+	mu       sync.Mutex
+	url      string
+	objects  []*client.GenericObject
+	nextId   int
+	pageSize int
+}
+
+func (f *fakeCattle) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	collection := f.url + "/v2-beta/genericobjects"
+	switch {
+	case req.URL.Path == "/v2-beta":
+		w.Header().Set("X-API-Schemas", f.url+"/v2-beta/schemas")
+		w.Write([]byte("{}"))
+	case req.URL.Path == "/v2-beta/schemas":
+		json.NewEncoder(w).Encode(client.Schemas{Data: []client.Schema{{
+			Resource:          client.Resource{Id: client.GENERIC_OBJECT_TYPE, Type: "schema", Links: map[string]string{"collection": collection}},
+			PluralName:        "genericObjects",
+			CollectionMethods: []string{"GET", "POST"},
+			ResourceMethods:   []string{"GET", "PUT", "DELETE"},
+		}}})
+	case req.URL.Path == "/v2-beta/genericobjects" && req.Method == "GET":
+		f.list(w, req)
+	case req.URL.Path == "/v2-beta/genericobjects" && req.Method == "POST":
+		obj := &client.GenericObject{}
+		if err := json.NewDecoder(req.Body).Decode(obj); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.nextId++
+		obj.Id = strconv.Itoa(f.nextId)
+		obj.Type = client.GENERIC_OBJECT_TYPE
+		obj.Links = map[string]string{"self": collection + "/" + obj.Id}
+		f.objects = append(f.objects, obj)
+		json.NewEncoder(w).Encode(obj)
+	default:
+		id := strings.TrimPrefix(req.URL.Path, "/v2-beta/genericobjects/")
+		for i, obj := range f.objects {
+			if obj.Id != id {
+				continue
+			}
+			switch req.Method {
+			case "PUT":
+				update := &client.GenericObject{}
+				if err := json.NewDecoder(req.Body).Decode(update); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				obj.Name = update.Name
+				obj.ResourceData = update.ResourceData
+				json.NewEncoder(w).Encode(obj)
+			case "DELETE":
+				f.objects = append(f.objects[:i], f.objects[i+1:]...)
+				json.NewEncoder(w).Encode(obj)
+			}
+			return
+		}
+		http.NotFound(w, req)
+	}
+}
+
+func (f *fakeCattle) list(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	matched := []client.GenericObject{}
+	for _, obj := range f.objects {
+		if obj.Kind != query.Get("kind") || (query.Get("key") != "" && obj.Key != query.Get("key")) {
+			continue
+		}
+		matched = append(matched, *obj)
+	}
+	start, _ := strconv.Atoi(query.Get("marker"))
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + f.pageSize
+	collection := client.GenericObjectCollection{Collection: client.Collection{Pagination: &client.Pagination{}}}
+	if end < len(matched) {
+		query.Set("marker", strconv.Itoa(end))
+		collection.Pagination.Next = f.url + req.URL.Path + "?" + query.Encode()
+	} else {
+		end = len(matched)
+	}
+	collection.Data = matched[start:end]
+	json.NewEncoder(w).Encode(collection)
+}
+
+func TestGenericObjectStore(t *testing.T) {
+	cattle := &fakeCattle{pageSize: 2}
+	server := httptest.NewServer(cattle)
+	defer server.Close()
+	cattle.url = server.URL
+	defer func(url string) { config.Config.CattleUrl = url }(config.Config.CattleUrl)
+	config.Config.CattleUrl = server.URL + "/v2-beta"
+
+	s, err := New(GenericObjectDriver, "")
+	if err != nil {
+		t.Fatalf("New got error: %v", err)
+	}
+	testStore(t, s)
+}
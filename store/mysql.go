@@ -0,0 +1,55 @@
+package store
+
+import (
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+)
+
+var mysqlDialect = &dialect{
+	driver: MysqlDriver,
+	schema: []string{
+		`CREATE TABLE IF NOT EXISTS pipeline (
+			id VARCHAR(64) NOT NULL PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			data LONGTEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS activity (
+			id VARCHAR(64) NOT NULL PRIMARY KEY,
+			pipeline_id VARCHAR(64) NOT NULL,
+			status VARCHAR(32) NOT NULL,
+			start_ts BIGINT NOT NULL,
+			data LONGTEXT NOT NULL,
+			INDEX idx_activity_pipeline (pipeline_id, status)
+		)`,
+		`CREATE TABLE IF NOT EXISTS gitaccount (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			account_type VARCHAR(32) NOT NULL,
+			data LONGTEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS repocache (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			data LONGTEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS setting (
+			id VARCHAR(255) NOT NULL,
+			kind VARCHAR(32) NOT NULL,
+			data LONGTEXT NOT NULL,
+			PRIMARY KEY (kind, id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS credential (
+			id VARCHAR(255) NOT NULL PRIMARY KEY,
+			cred_type VARCHAR(32) NOT NULL,
+			data LONGTEXT NOT NULL
+		)`,
+	},
+	saveRepoCache: "INSERT INTO repocache (id, data) VALUES (?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data)",
+	saveSetting:   "INSERT INTO setting (id, kind, data) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE data = VALUES(data)",
+}
+
+//NewMySQLStore connects to an external MySQL database by the data source name
+func NewMySQLStore(dsn string) (*SQLStore, error) {
+	if dsn == "" {
+		return nil, errors.New("data source name is required for mysql storage")
+	}
+	return newSQLStore(mysqlDialect, dsn)
+}
@@ -0,0 +1,451 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rancher/pipeline/model"
+)
+
+//dialect holds the schema and statements that differ between databases
+type dialect struct {
+	driver string
+	schema []string
+	//upserts of the repocache and setting tables
+	saveRepoCache string
+	saveSetting   string
+}
+
+//SQLStore keeps pipeline data in a SQL database, so that the server runs without the Cattle API.
+//Indexed columns are used for filtering and the whole object is kept as JSON in the data column.
+type SQLStore struct {
+	db      *sql.DB
+	dialect *dialect
+}
+
+//newSQLStore connects to the database by the data source name and creates tables if not exist
+func newSQLStore(d *dialect, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		return nil, errors.Wrap(err, "fail to connect database")
+	}
+	for _, stmt := range d.schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, errors.Wrap(err, "fail to init database schema")
+		}
+	}
+	logrus.Infof("Using %s as pipeline storage", d.driver)
+	return &SQLStore{db: db, dialect: d}, nil
+}
+
+func (s *SQLStore) Pipelines() PipelineRepository {
+	return sqlPipelineRepository{s}
+}
+
+func (s *SQLStore) Activities() ActivityRepository {
+	return sqlActivityRepository{s}
+}
+
+func (s *SQLStore) Accounts() AccountRepository {
+	return sqlAccountRepository{s}
+}
+
+func (s *SQLStore) Settings() SettingRepository {
+	return sqlSettingRepository{s}
+}
+
+func (s *SQLStore) Credentials() CredentialRepository {
+	return sqlCredentialRepository{s}
+}
+
+func (s *SQLStore) Reset() error {
+	for _, table := range []string{"activity", "pipeline", "setting", "gitaccount", "repocache", "credential"} {
+		if _, err := s.db.Exec("DELETE FROM " + table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLStore) get(obj interface{}, query string, args ...interface{}) error {
+	var data string
+	err := s.db.QueryRow(query, args...).Scan(&data)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), obj)
+}
+
+//list queries data column of the rows and decodes each of them by the decode func
+func (s *SQLStore) list(decode func(data []byte) error, query string, args ...interface{}) error {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := decode([]byte(data)); err != nil {
+			logrus.Errorf("unmarshal stored data got err:%v", err)
+		}
+	}
+	return rows.Err()
+}
+
+//exec runs the statement and returns ErrNotFound if no row is affected
+func (s *SQLStore) exec(query string, args ...interface{}) error {
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+//exists is used before updates, mysql reports no affected rows when data is unchanged
+func (s *SQLStore) exists(query string, args ...interface{}) error {
+	var count int
+	if err := s.db.QueryRow(query, args...).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type sqlPipelineRepository struct {
+	s *SQLStore
+}
+
+func (r sqlPipelineRepository) Get(id string) (*model.Pipeline, error) {
+	ppl := &model.Pipeline{}
+	if err := r.s.get(ppl, "SELECT data FROM pipeline WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return ppl, nil
+}
+
+func (r sqlPipelineRepository) List() ([]*model.Pipeline, error) {
+	var pipelines []*model.Pipeline
+	err := r.s.list(func(data []byte) error {
+		p := &model.Pipeline{}
+		if err := json.Unmarshal(data, p); err != nil {
+			return err
+		}
+		pipelines = append(pipelines, p)
+		return nil
+	}, "SELECT data FROM pipeline ORDER BY name")
+	return pipelines, err
+}
+
+func (r sqlPipelineRepository) Create(pipeline *model.Pipeline) error {
+	b, err := json.Marshal(pipeline)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("INSERT INTO pipeline (id, name, data) VALUES (?, ?, ?)", pipeline.Id, pipeline.Name, string(b))
+	return err
+}
+
+func (r sqlPipelineRepository) Update(pipeline *model.Pipeline) error {
+	if err := r.s.exists("SELECT COUNT(*) FROM pipeline WHERE id = ?", pipeline.Id); err != nil {
+		return err
+	}
+	b, err := json.Marshal(pipeline)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("UPDATE pipeline SET name = ?, data = ? WHERE id = ?", pipeline.Name, string(b), pipeline.Id)
+	return err
+}
+
+func (r sqlPipelineRepository) Delete(id string) (*model.Pipeline, error) {
+	ppl, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.s.exec("DELETE FROM pipeline WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return ppl, nil
+}
+
+type sqlActivityRepository struct {
+	s *SQLStore
+}
+
+func (r sqlActivityRepository) Get(id string) (*model.Activity, error) {
+	activity := &model.Activity{}
+	if err := r.s.get(activity, "SELECT data FROM activity WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+func (r sqlActivityRepository) List(filter *ActivityFilter) ([]*model.Activity, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	if filter != nil && filter.PipelineId != "" {
+		conditions = append(conditions, "pipeline_id = ?")
+		args = append(args, filter.PipelineId)
+	}
+	if filter != nil && len(filter.Status) > 0 {
+		conditions = append(conditions, "status IN (?"+strings.Repeat(", ?", len(filter.Status)-1)+")")
+		for _, status := range filter.Status {
+			args = append(args, status)
+		}
+	}
+	query := "SELECT data FROM activity"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY start_ts DESC"
+
+	var activities []*model.Activity
+	err := r.s.list(func(data []byte) error {
+		a := &model.Activity{}
+		if err := json.Unmarshal(data, a); err != nil {
+			return err
+		}
+		activities = append(activities, a)
+		return nil
+	}, query, args...)
+	return activities, err
+}
+
+func (r sqlActivityRepository) Create(activity *model.Activity) error {
+	b, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("INSERT INTO activity (id, pipeline_id, status, start_ts, data) VALUES (?, ?, ?, ?, ?)",
+		activity.Id, activity.Pipeline.Id, activity.Status, activity.StartTS, string(b))
+	return err
+}
+
+func (r sqlActivityRepository) Update(activity *model.Activity) error {
+	if err := r.s.exists("SELECT COUNT(*) FROM activity WHERE id = ?", activity.Id); err != nil {
+		return err
+	}
+	b, err := json.Marshal(activity)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("UPDATE activity SET pipeline_id = ?, status = ?, start_ts = ?, data = ? WHERE id = ?",
+		activity.Pipeline.Id, activity.Status, activity.StartTS, string(b), activity.Id)
+	return err
+}
+
+func (r sqlActivityRepository) Delete(id string) error {
+	return r.s.exec("DELETE FROM activity WHERE id = ?", id)
+}
+
+type sqlAccountRepository struct {
+	s *SQLStore
+}
+
+func (r sqlAccountRepository) Get(id string) (*model.GitAccount, error) {
+	account := &model.GitAccount{}
+	if err := r.s.get(account, "SELECT data FROM gitaccount WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (r sqlAccountRepository) List() ([]*model.GitAccount, error) {
+	var accounts []*model.GitAccount
+	err := r.s.list(func(data []byte) error {
+		a := &model.GitAccount{}
+		if err := json.Unmarshal(data, a); err != nil {
+			return err
+		}
+		accounts = append(accounts, a)
+		return nil
+	}, "SELECT data FROM gitaccount")
+	return accounts, err
+}
+
+func (r sqlAccountRepository) Create(account *model.GitAccount) error {
+	b, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("INSERT INTO gitaccount (id, account_type, data) VALUES (?, ?, ?)", account.Id, account.AccountType, string(b))
+	return err
+}
+
+func (r sqlAccountRepository) Update(account *model.GitAccount) error {
+	if err := r.s.exists("SELECT COUNT(*) FROM gitaccount WHERE id = ?", account.Id); err != nil {
+		return err
+	}
+	b, err := json.Marshal(account)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("UPDATE gitaccount SET account_type = ?, data = ? WHERE id = ?", account.AccountType, string(b), account.Id)
+	return err
+}
+
+func (r sqlAccountRepository) Delete(id string) (*model.GitAccount, error) {
+	account, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.s.exec("DELETE FROM gitaccount WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (r sqlAccountRepository) GetRepoCache(accountId string) ([]*model.GitRepository, error) {
+	repos := []*model.GitRepository{}
+	if err := r.s.get(&repos, "SELECT data FROM repocache WHERE id = ?", accountId); err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+func (r sqlAccountRepository) SaveRepoCache(accountId string, repos []*model.GitRepository) error {
+	b, err := json.Marshal(repos)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec(r.s.dialect.saveRepoCache, accountId, string(b))
+	return err
+}
+
+type sqlSettingRepository struct {
+	s *SQLStore
+}
+
+func (r sqlSettingRepository) GetPipelineSetting() (*model.PipelineSetting, error) {
+	setting := &model.PipelineSetting{}
+	if err := r.s.get(setting, "SELECT data FROM setting WHERE kind = ? AND id = ?", pipelineSettingKind, pipelineSettingKind); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+func (r sqlSettingRepository) SavePipelineSetting(setting *model.PipelineSetting) error {
+	return r.save(pipelineSettingKind, pipelineSettingKind, setting)
+}
+
+func (r sqlSettingRepository) GetSCMSetting(scmType string) (*model.SCMSetting, error) {
+	setting := &model.SCMSetting{}
+	if err := r.s.get(setting, "SELECT data FROM setting WHERE kind = ? AND id = ?", scmSettingKind, scmType); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+func (r sqlSettingRepository) ListSCMSettings() ([]*model.SCMSetting, error) {
+	var settings []*model.SCMSetting
+	err := r.s.list(func(data []byte) error {
+		a := &model.SCMSetting{}
+		if err := json.Unmarshal(data, a); err != nil {
+			return err
+		}
+		settings = append(settings, a)
+		return nil
+	}, "SELECT data FROM setting WHERE kind = ?", scmSettingKind)
+	return settings, err
+}
+
+func (r sqlSettingRepository) SaveSCMSetting(setting *model.SCMSetting) error {
+	return r.save(scmSettingKind, setting.ScmType, setting)
+}
+
+func (r sqlSettingRepository) DeleteSCMSetting(scmType string) (*model.SCMSetting, error) {
+	setting, err := r.GetSCMSetting(scmType)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.s.exec("DELETE FROM setting WHERE kind = ? AND id = ?", scmSettingKind, scmType); err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+func (r sqlSettingRepository) save(kind string, id string, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec(r.s.dialect.saveSetting, id, kind, string(b))
+	return err
+}
+
+type sqlCredentialRepository struct {
+	s *SQLStore
+}
+
+func (r sqlCredentialRepository) Get(id string) (*model.Credential, error) {
+	cred := &model.Credential{}
+	if err := r.s.get(cred, "SELECT data FROM credential WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
+
+func (r sqlCredentialRepository) List() ([]*model.Credential, error) {
+	var creds []*model.Credential
+	err := r.s.list(func(data []byte) error {
+		c := &model.Credential{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return err
+		}
+		creds = append(creds, c)
+		return nil
+	}, "SELECT data FROM credential")
+	return creds, err
+}
+
+func (r sqlCredentialRepository) Create(cred *model.Credential) error {
+	b, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("INSERT INTO credential (id, cred_type, data) VALUES (?, ?, ?)", cred.Id, cred.CredType, string(b))
+	return err
+}
+
+func (r sqlCredentialRepository) Update(cred *model.Credential) error {
+	if err := r.s.exists("SELECT COUNT(*) FROM credential WHERE id = ?", cred.Id); err != nil {
+		return err
+	}
+	b, err := json.Marshal(cred)
+	if err != nil {
+		return err
+	}
+	_, err = r.s.db.Exec("UPDATE credential SET cred_type = ?, data = ? WHERE id = ?", cred.CredType, string(b), cred.Id)
+	return err
+}
+
+func (r sqlCredentialRepository) Delete(id string) (*model.Credential, error) {
+	cred, err := r.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.s.exec("DELETE FROM credential WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return cred, nil
+}
@@ -0,0 +1,70 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+//DefaultSQLitePath is the database file of the embedded storage if no data source name is given
+const DefaultSQLitePath = "/var/lib/pipeline/pipeline.db"
+
+var sqliteDialect = &dialect{
+	driver: SqliteDriver,
+	schema: []string{
+		`CREATE TABLE IF NOT EXISTS pipeline (
+			id TEXT NOT NULL PRIMARY KEY,
+			name TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS activity (
+			id TEXT NOT NULL PRIMARY KEY,
+			pipeline_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			start_ts INTEGER NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_activity_pipeline ON activity (pipeline_id, status)`,
+		`CREATE TABLE IF NOT EXISTS gitaccount (
+			id TEXT NOT NULL PRIMARY KEY,
+			account_type TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS repocache (
+			id TEXT NOT NULL PRIMARY KEY,
+			data TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS setting (
+			id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			data TEXT NOT NULL,
+			PRIMARY KEY (kind, id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS credential (
+			id TEXT NOT NULL PRIMARY KEY,
+			cred_type TEXT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+	},
+	saveRepoCache: "INSERT OR REPLACE INTO repocache (id, data) VALUES (?, ?)",
+	saveSetting:   "INSERT OR REPLACE INTO setting (id, kind, data) VALUES (?, ?, ?)",
+}
+
+//NewSQLiteStore opens the SQLite database file embedded in the pipeline server, which is created if not exists
+func NewSQLiteStore(path string) (*SQLStore, error) {
+	if path == "" {
+		path = DefaultSQLitePath
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "fail to create database directory")
+	}
+	s, err := newSQLStore(sqliteDialect, path)
+	if err != nil {
+		return nil, err
+	}
+	//sqlite allows one writer at a time, queries share the connection instead of failing on a locked database
+	s.db.SetMaxOpenConns(1)
+	return s, nil
+}
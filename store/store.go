@@ -0,0 +1,117 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/rancher/pipeline/model"
+)
+
+const (
+	GenericObjectDriver = "genericobject"
+	MysqlDriver         = "mysql"
+	SqliteDriver        = "sqlite3"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+//Store is the persistence layer of pipeline data
+type Store interface {
+	Pipelines() PipelineRepository
+	Activities() ActivityRepository
+	Accounts() AccountRepository
+	Settings() SettingRepository
+	Credentials() CredentialRepository
+	//Reset removes all stored pipeline data
+	Reset() error
+}
+
+type PipelineRepository interface {
+	Get(id string) (*model.Pipeline, error)
+	List() ([]*model.Pipeline, error)
+	Create(pipeline *model.Pipeline) error
+	Update(pipeline *model.Pipeline) error
+	Delete(id string) (*model.Pipeline, error)
+}
+
+//ActivityFilter narrows down activities to list, empty fields match all
+type ActivityFilter struct {
+	PipelineId string
+	Status     []string
+}
+
+type ActivityRepository interface {
+	Get(id string) (*model.Activity, error)
+	List(filter *ActivityFilter) ([]*model.Activity, error)
+	Create(activity *model.Activity) error
+	Update(activity *model.Activity) error
+	Delete(id string) error
+}
+
+type AccountRepository interface {
+	Get(id string) (*model.GitAccount, error)
+	List() ([]*model.GitAccount, error)
+	Create(account *model.GitAccount) error
+	Update(account *model.GitAccount) error
+	Delete(id string) (*model.GitAccount, error)
+	GetRepoCache(accountId string) ([]*model.GitRepository, error)
+	SaveRepoCache(accountId string, repos []*model.GitRepository) error
+}
+
+type SettingRepository interface {
+	GetPipelineSetting() (*model.PipelineSetting, error)
+	SavePipelineSetting(setting *model.PipelineSetting) error
+	GetSCMSetting(scmType string) (*model.SCMSetting, error)
+	ListSCMSettings() ([]*model.SCMSetting, error)
+	SaveSCMSetting(setting *model.SCMSetting) error
+	DeleteSCMSetting(scmType string) (*model.SCMSetting, error)
+}
+
+type CredentialRepository interface {
+	Get(id string) (*model.Credential, error)
+	List() ([]*model.Credential, error)
+	Create(cred *model.Credential) error
+	Update(cred *model.Credential) error
+	Delete(id string) (*model.Credential, error)
+}
+
+//New creates a store of the given driver
+func New(driver string, dsn string) (Store, error) {
+	switch driver {
+	case "", GenericObjectDriver:
+		return NewGenericObjectStore(), nil
+	case MysqlDriver:
+		s, err := NewMySQLStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case SqliteDriver:
+		s, err := NewSQLiteStore(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported storage driver '%s'", driver)
+	}
+}
+
+//Match checks if the activity is matched by the filter
+func (f *ActivityFilter) Match(activity *model.Activity) bool {
+	if f == nil {
+		return true
+	}
+	if f.PipelineId != "" && activity.Pipeline.Id != f.PipelineId {
+		return false
+	}
+	if len(f.Status) == 0 {
+		return true
+	}
+	for _, status := range f.Status {
+		if activity.Status == status {
+			return true
+		}
+	}
+	return false
+}
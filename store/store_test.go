@@ -0,0 +1,288 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rancher/go-rancher/client"
+	"github.com/rancher/pipeline/model"
+)
+
+//testStore runs the CRUD operations of all repositories of the store, which should be empty
+func testStore(t *testing.T, s Store) {
+	testPipelines(t, s.Pipelines())
+	testActivities(t, s.Activities())
+	testAccounts(t, s.Accounts())
+	testSettings(t, s.Settings())
+	testCredentials(t, s.Credentials())
+
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset got error: %v", err)
+	}
+	if pipelines, err := s.Pipelines().List(); err != nil || len(pipelines) != 0 {
+		t.Errorf("got pipelines %v, %v after reset, want none", pipelines, err)
+	}
+	if activities, err := s.Activities().List(nil); err != nil || len(activities) != 0 {
+		t.Errorf("got activities %v, %v after reset, want none", activities, err)
+	}
+}
+
+func testPipelines(t *testing.T, r PipelineRepository) {
+	if _, err := r.Get("p1"); err != ErrNotFound {
+		t.Errorf("Get of missing pipeline got error %v, want ErrNotFound", err)
+	}
+	for _, p := range []*model.Pipeline{
+		{Resource: client.Resource{Id: "p1"}, Name: "b"},
+		{Resource: client.Resource{Id: "p2"}, Name: "a"},
+	} {
+		if err := r.Create(p); err != nil {
+			t.Fatalf("Create got error: %v", err)
+		}
+	}
+	if p, err := r.Get("p1"); err != nil || p.Name != "b" {
+		t.Errorf("Get got %+v, %v, want pipeline 'b'", p, err)
+	}
+	if pipelines, err := r.List(); err != nil || len(pipelines) != 2 {
+		t.Errorf("List got %v, %v, want 2 pipelines", pipelines, err)
+	}
+	if err := r.Update(&model.Pipeline{Resource: client.Resource{Id: "p1"}, Name: "c"}); err != nil {
+		t.Fatalf("Update got error: %v", err)
+	}
+	if p, err := r.Get("p1"); err != nil || p.Name != "c" {
+		t.Errorf("Get got %+v, %v after update, want pipeline 'c'", p, err)
+	}
+	if err := r.Update(&model.Pipeline{Resource: client.Resource{Id: "p3"}, Name: "d"}); err != ErrNotFound {
+		t.Errorf("Update of missing pipeline got error %v, want ErrNotFound", err)
+	}
+	if p, err := r.Delete("p1"); err != nil || p.Name != "c" {
+		t.Errorf("Delete got %+v, %v, want the deleted pipeline", p, err)
+	}
+	if _, err := r.Get("p1"); err != ErrNotFound {
+		t.Errorf("Get of deleted pipeline got error %v, want ErrNotFound", err)
+	}
+	if _, err := r.Delete("p1"); err != ErrNotFound {
+		t.Errorf("Delete of missing pipeline got error %v, want ErrNotFound", err)
+	}
+}
+
+func testActivities(t *testing.T, r ActivityRepository) {
+	for _, a := range []*model.Activity{
+		{Id: "a1", Pipeline: model.Pipeline{Resource: client.Resource{Id: "p1"}}, Status: model.ActivitySuccess, StartTS: 1},
+		{Id: "a2", Pipeline: model.Pipeline{Resource: client.Resource{Id: "p1"}}, Status: model.ActivityBuilding, StartTS: 2},
+		{Id: "a3", Pipeline: model.Pipeline{Resource: client.Resource{Id: "p2"}}, Status: model.ActivityFail, StartTS: 3},
+	} {
+		if err := r.Create(a); err != nil {
+			t.Fatalf("Create got error: %v", err)
+		}
+	}
+	if a, err := r.Get("a2"); err != nil || a.Status != model.ActivityBuilding || a.Pipeline.Id != "p1" {
+		t.Errorf("Get got %+v, %v, want activity 'a2'", a, err)
+	}
+	if _, err := r.Get("a4"); err != ErrNotFound {
+		t.Errorf("Get of missing activity got error %v, want ErrNotFound", err)
+	}
+	tests := []struct {
+		filter *ActivityFilter
+		want   int
+	}{
+		{nil, 3},
+		{&ActivityFilter{}, 3},
+		{&ActivityFilter{PipelineId: "p1"}, 2},
+		{&ActivityFilter{PipelineId: "p1", Status: []string{model.ActivitySuccess}}, 1},
+		{&ActivityFilter{Status: []string{model.ActivitySuccess, model.ActivityFail}}, 2},
+		{&ActivityFilter{PipelineId: "p3"}, 0},
+	}
+	for _, test := range tests {
+		if activities, err := r.List(test.filter); err != nil || len(activities) != test.want {
+			t.Errorf("List of %+v got %d activities, %v, want %d", test.filter, len(activities), err, test.want)
+		}
+	}
+	a2 := &model.Activity{Id: "a2", Pipeline: model.Pipeline{Resource: client.Resource{Id: "p1"}}, Status: model.ActivitySuccess, StartTS: 2}
+	if err := r.Update(a2); err != nil {
+		t.Fatalf("Update got error: %v", err)
+	}
+	if activities, err := r.List(&ActivityFilter{Status: []string{model.ActivitySuccess}}); err != nil || len(activities) != 2 {
+		t.Errorf("List got %d successful activities, %v after update, want 2", len(activities), err)
+	}
+	if err := r.Update(&model.Activity{Id: "a4"}); err != ErrNotFound {
+		t.Errorf("Update of missing activity got error %v, want ErrNotFound", err)
+	}
+	if err := r.Delete("a3"); err != nil {
+		t.Fatalf("Delete got error: %v", err)
+	}
+	if err := r.Delete("a3"); err != ErrNotFound {
+		t.Errorf("Delete of missing activity got error %v, want ErrNotFound", err)
+	}
+}
+
+func testAccounts(t *testing.T, r AccountRepository) {
+	account := &model.GitAccount{Resource: client.Resource{Id: "github:1"}, AccountType: "github", Login: "u1"}
+	if err := r.Create(account); err != nil {
+		t.Fatalf("Create got error: %v", err)
+	}
+	account.Login = "u2"
+	if err := r.Update(account); err != nil {
+		t.Fatalf("Update got error: %v", err)
+	}
+	if a, err := r.Get("github:1"); err != nil || a.Login != "u2" {
+		t.Errorf("Get got %+v, %v, want the updated account", a, err)
+	}
+	if accounts, err := r.List(); err != nil || len(accounts) != 1 {
+		t.Errorf("List got %v, %v, want 1 account", accounts, err)
+	}
+
+	if _, err := r.GetRepoCache("github:1"); err != ErrNotFound {
+		t.Errorf("GetRepoCache of missing cache got error %v, want ErrNotFound", err)
+	}
+	for _, url := range []string{"https://github.com/a/b.git", "https://github.com/a/c.git"} {
+		if err := r.SaveRepoCache("github:1", []*model.GitRepository{{CloneURL: url}}); err != nil {
+			t.Fatalf("SaveRepoCache got error: %v", err)
+		}
+		if repos, err := r.GetRepoCache("github:1"); err != nil || len(repos) != 1 || repos[0].CloneURL != url {
+			t.Errorf("GetRepoCache got %v, %v, want the saved repository %s", repos, err, url)
+		}
+	}
+
+	if a, err := r.Delete("github:1"); err != nil || a.Login != "u2" {
+		t.Errorf("Delete got %+v, %v, want the deleted account", a, err)
+	}
+	if _, err := r.Get("github:1"); err != ErrNotFound {
+		t.Errorf("Get of deleted account got error %v, want ErrNotFound", err)
+	}
+}
+
+func testSettings(t *testing.T, r SettingRepository) {
+	if _, err := r.GetPipelineSetting(); err != ErrNotFound {
+		t.Errorf("GetPipelineSetting of missing setting got error %v, want ErrNotFound", err)
+	}
+	for _, status := range []string{"a", "b"} {
+		if err := r.SavePipelineSetting(&model.PipelineSetting{Status: status}); err != nil {
+			t.Fatalf("SavePipelineSetting got error: %v", err)
+		}
+		if s, err := r.GetPipelineSetting(); err != nil || s.Status != status {
+			t.Errorf("GetPipelineSetting got %+v, %v, want status %s", s, err, status)
+		}
+	}
+
+	for _, setting := range []*model.SCMSetting{
+		{ScmType: "github", HostName: "github.com"},
+		{ScmType: "gitlab", HostName: "gitlab.com"},
+		{ScmType: "github", HostName: "github.example.com"},
+	} {
+		if err := r.SaveSCMSetting(setting); err != nil {
+			t.Fatalf("SaveSCMSetting got error: %v", err)
+		}
+	}
+	if s, err := r.GetSCMSetting("github"); err != nil || s.HostName != "github.example.com" {
+		t.Errorf("GetSCMSetting got %+v, %v, want the last saved setting", s, err)
+	}
+	if settings, err := r.ListSCMSettings(); err != nil || len(settings) != 2 {
+		t.Errorf("ListSCMSettings got %v, %v, want 2 settings", settings, err)
+	}
+	if s, err := r.DeleteSCMSetting("github"); err != nil || s.ScmType != "github" {
+		t.Errorf("DeleteSCMSetting got %+v, %v, want the deleted setting", s, err)
+	}
+	if _, err := r.GetSCMSetting("github"); err != ErrNotFound {
+		t.Errorf("GetSCMSetting of deleted setting got error %v, want ErrNotFound", err)
+	}
+	if _, err := r.GetPipelineSetting(); err != nil {
+		t.Errorf("GetPipelineSetting got error %v, the pipeline setting should be kept", err)
+	}
+}
+
+func testCredentials(t *testing.T, r CredentialRepository) {
+	cred := &model.Credential{Resource: client.Resource{Id: "c1"}, Name: "deploy", CredType: "usernamePassword", PublicValue: "u"}
+	if err := r.Create(cred); err != nil {
+		t.Fatalf("Create got error: %v", err)
+	}
+	cred.PublicValue = "v"
+	if err := r.Update(cred); err != nil {
+		t.Fatalf("Update got error: %v", err)
+	}
+	if c, err := r.Get("c1"); err != nil || c.PublicValue != "v" {
+		t.Errorf("Get got %+v, %v, want the updated credential", c, err)
+	}
+	if creds, err := r.List(); err != nil || len(creds) != 1 {
+		t.Errorf("List got %v, %v, want 1 credential", creds, err)
+	}
+	if c, err := r.Delete("c1"); err != nil || c.Name != "deploy" {
+		t.Errorf("Delete got %+v, %v, want the deleted credential", c, err)
+	}
+	if _, err := r.Get("c1"); err != ErrNotFound {
+		t.Errorf("Get of deleted credential got error %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "data", "pipeline.db")
+	s, err := New(SqliteDriver, path)
+	if err != nil {
+		t.Fatalf("New got error: %v", err)
+	}
+	testStore(t, s)
+
+	//data are kept in the file
+	if err := s.Pipelines().Create(&model.Pipeline{Resource: client.Resource{Id: "p1"}, Name: "a"}); err != nil {
+		t.Fatalf("Create got error: %v", err)
+	}
+	reopened, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore got error: %v", err)
+	}
+	if p, err := reopened.Pipelines().Get("p1"); err != nil || p.Name != "a" {
+		t.Errorf("Get got %+v, %v from the reopened store, want pipeline 'a'", p, err)
+	}
+}
+
+func TestSQLiteActivityOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s, err := NewSQLiteStore(filepath.Join(dir, "pipeline.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore got error: %v", err)
+	}
+	for _, a := range []*model.Activity{{Id: "a1", StartTS: 2}, {Id: "a2", StartTS: 3}, {Id: "a3", StartTS: 1}} {
+		if err := s.Activities().Create(a); err != nil {
+			t.Fatalf("Create got error: %v", err)
+		}
+	}
+	activities, err := s.Activities().List(nil)
+	if err != nil || len(activities) != 3 || activities[0].Id != "a2" || activities[2].Id != "a3" {
+		t.Errorf("List got %v, %v, want the latest activity first", activities, err)
+	}
+}
+
+//TestMySQLStore runs against the database of PIPELINE_TEST_MYSQL_DSN, all data in it are removed
+func TestMySQLStore(t *testing.T) {
+	dsn := os.Getenv("PIPELINE_TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("PIPELINE_TEST_MYSQL_DSN is not set")
+	}
+	s, err := New(MysqlDriver, dsn)
+	if err != nil {
+		t.Fatalf("New got error: %v", err)
+	}
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset got error: %v", err)
+	}
+	testStore(t, s)
+}
+
+func TestNewUnsupportedDriver(t *testing.T) {
+	if _, err := New("bolt", ""); err == nil {
+		t.Error("New got no error for the unsupported driver")
+	}
+	if _, err := New(MysqlDriver, ""); err == nil {
+		t.Error("New got no error for mysql without data source name")
+	}
+}
@@ -0,0 +1,135 @@
+package util
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+//parameters of keys derived from passphrases, iterations are limited when reading envelopes
+const (
+	kdfName          = "pbkdf2-sha256"
+	kdfIterations    = 100000
+	kdfMaxIterations = 10000000
+	kdfSaltSize      = 16
+	keySize          = 32
+)
+
+//ErrDecrypt is returned if the ciphertext is broken or encrypted by another key
+var ErrDecrypt = errors.New("fail to decrypt data")
+
+//MasterKey derives 256-bit AES keys from a passphrase by PBKDF2-SHA256 with the KDF parameters stored in each
+//envelope, so the iterations can be raised without breaking sealed values. Keys to seal new values use a random
+//salt generated once per MasterKey, derived keys are cached by their parameters.
+type MasterKey struct {
+	passphrase string
+	mu         sync.Mutex
+	//params of keys to seal new values, in "<kdf>:<iterations>:<salt>" format
+	params string
+	keys   map[string][]byte
+}
+
+func NewMasterKey(passphrase string) *MasterKey {
+	return &MasterKey{passphrase: passphrase, keys: map[string][]byte{}}
+}
+
+//sealKey gets the KDF parameters and the key to seal new values
+func (k *MasterKey) sealKey() (string, []byte, error) {
+	k.mu.Lock()
+	if k.params == "" {
+		salt := make([]byte, kdfSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			k.mu.Unlock()
+			return "", nil, err
+		}
+		k.params = fmt.Sprintf("%s:%d:%s", kdfName, kdfIterations, base64.StdEncoding.EncodeToString(salt))
+	}
+	params := k.params
+	k.mu.Unlock()
+	key, err := k.key(params)
+	return params, key, err
+}
+
+//key derives the key by the KDF parameters, empty parameters get the sha256 of the passphrase used by
+//legacy envelopes
+func (k *MasterKey) key(params string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[params]; ok {
+		return key, nil
+	}
+	var key []byte
+	if params == "" {
+		key = DeriveKey(k.passphrase)
+	} else {
+		parts := strings.Split(params, ":")
+		if len(parts) != 3 || parts[0] != kdfName {
+			return nil, fmt.Errorf("unsupported key derivation '%s'", params)
+		}
+		iterations, err := strconv.Atoi(parts[1])
+		if err != nil || iterations < 1 || iterations > kdfMaxIterations {
+			return nil, fmt.Errorf("invalid iterations of key derivation '%s'", params)
+		}
+		salt, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil || len(salt) == 0 {
+			return nil, fmt.Errorf("invalid salt of key derivation '%s'", params)
+		}
+		key = pbkdf2.Key([]byte(k.passphrase), salt, iterations, keySize, sha256.New)
+	}
+	k.keys[params] = key
+	return key, nil
+}
+
+//DeriveKey derives the 256-bit AES key from a passphrase without salt, which is used by legacy envelopes
+func DeriveKey(passphrase string) []byte {
+	sum := sha256.Sum256([]byte(passphrase))
+	return sum[:]
+}
+
+//Encrypt encrypts the plaintext by AES-GCM, the random nonce is prepended to the result
+func Encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+//Decrypt decrypts the ciphertext got by Encrypt
+func Decrypt(key []byte, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	nonce, data := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, data, nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,29 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMasterKey(t *testing.T) {
+	k := NewMasterKey("secret")
+	params, key, err := k.sealKey()
+	if err != nil {
+		t.Fatalf("sealKey got error: %v", err)
+	}
+	if again, _, _ := k.sealKey(); again != params {
+		t.Errorf("got params %s then %s, want the same salt", params, again)
+	}
+	derived, err := NewMasterKey("secret").key(params)
+	if err != nil || !bytes.Equal(derived, key) {
+		t.Errorf("got key %x, %v by params %s, want %x", derived, err, params, key)
+	}
+	if other, _, _ := NewMasterKey("secret").sealKey(); other == params {
+		t.Errorf("got the same params %s of another master key, want a random salt", params)
+	}
+	for _, params := range []string{"sha1:1:c2FsdA==", "pbkdf2-sha256:0:c2FsdA==", "pbkdf2-sha256:100000000:c2FsdA==", "pbkdf2-sha256:1:", "pbkdf2-sha256:1"} {
+		if _, err := k.key(params); err == nil {
+			t.Errorf("got no error for params %s", params)
+		}
+	}
+}
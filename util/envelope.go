@@ -0,0 +1,125 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+)
+
+//envelopePrefix marks values sealed by SealEnvelope, in "enc:v2:<kdf>:<iterations>:<salt>:<wrapped key>:<ciphertext>"
+//format, values without it are plaintext. Data keys of legacy envelopes are wrapped by the sha256 of the passphrase,
+//in "enc:v1:<wrapped key>:<ciphertext>" format.
+const (
+	envelopePrefix       = "enc:v2:"
+	legacyEnvelopePrefix = "enc:v1:"
+)
+
+var ErrNoMasterKey = errors.New("master key is required to decrypt data")
+
+//IsEnvelope checks if the value is sealed by SealEnvelope
+func IsEnvelope(value string) bool {
+	return strings.HasPrefix(value, envelopePrefix) || IsLegacyEnvelope(value)
+}
+
+//IsLegacyEnvelope checks if the data key of the sealed value is wrapped by a key derived without salt,
+//which is upgraded by RewrapEnvelope
+func IsLegacyEnvelope(value string) bool {
+	return strings.HasPrefix(value, legacyEnvelopePrefix)
+}
+
+//SealEnvelope encrypts the value by a random data key, which is encrypted by the master key and
+//stored along with the result
+func SealEnvelope(masterKey *MasterKey, value string) (string, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := Encrypt(dataKey, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return wrapEnvelope(masterKey, dataKey, ciphertext)
+}
+
+//OpenEnvelope decrypts the value sealed by SealEnvelope, plaintext values are returned as they are
+func OpenEnvelope(masterKey *MasterKey, value string) (string, error) {
+	if !IsEnvelope(value) {
+		return value, nil
+	}
+	dataKey, ciphertext, err := unwrapEnvelope(masterKey, value)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := Decrypt(dataKey, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+//RewrapEnvelope encrypts the data key of the sealed value by the new master key, the encrypted value is
+//not changed. Plaintext values are sealed by the new master key.
+func RewrapEnvelope(oldKey *MasterKey, newKey *MasterKey, value string) (string, error) {
+	if !IsEnvelope(value) {
+		return SealEnvelope(newKey, value)
+	}
+	dataKey, ciphertext, err := unwrapEnvelope(oldKey, value)
+	if err != nil {
+		return "", err
+	}
+	return wrapEnvelope(newKey, dataKey, ciphertext)
+}
+
+//wrapEnvelope encrypts the data key by the master key and formats the envelope
+func wrapEnvelope(masterKey *MasterKey, dataKey []byte, ciphertext []byte) (string, error) {
+	params, key, err := masterKey.sealKey()
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := Encrypt(key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return envelopePrefix + params + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+//unwrapEnvelope gets the data key decrypted by the master key and the ciphertext of the sealed value
+func unwrapEnvelope(masterKey *MasterKey, value string) ([]byte, []byte, error) {
+	if masterKey == nil {
+		return nil, nil, ErrNoMasterKey
+	}
+	var parts []string
+	params := ""
+	if IsLegacyEnvelope(value) {
+		parts = strings.Split(strings.TrimPrefix(value, legacyEnvelopePrefix), ":")
+	} else {
+		parts = strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+		if len(parts) != 5 {
+			return nil, nil, ErrDecrypt
+		}
+		params = strings.Join(parts[:3], ":")
+		parts = parts[3:]
+	}
+	if len(parts) != 2 {
+		return nil, nil, ErrDecrypt
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	key, err := masterKey.key(params)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := Decrypt(key, wrapped)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, ciphertext, nil
+}
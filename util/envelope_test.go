@@ -0,0 +1,93 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	key := NewMasterKey("secret")
+	sealed, err := SealEnvelope(key, "token")
+	if err != nil {
+		t.Fatalf("SealEnvelope got error: %v", err)
+	}
+	if !strings.HasPrefix(sealed, "enc:v2:pbkdf2-sha256:100000:") || !IsEnvelope(sealed) || IsLegacyEnvelope(sealed) {
+		t.Errorf("got envelope %s, want the KDF parameters in it", sealed)
+	}
+	//keys are derived from the parameters in the envelope
+	if value, err := OpenEnvelope(NewMasterKey("secret"), sealed); err != nil || value != "token" {
+		t.Errorf("OpenEnvelope got %q, %v, want token", value, err)
+	}
+	if _, err := OpenEnvelope(NewMasterKey("other"), sealed); err != ErrDecrypt {
+		t.Errorf("OpenEnvelope by another key got error %v, want %v", err, ErrDecrypt)
+	}
+	if _, err := OpenEnvelope(nil, sealed); err != ErrNoMasterKey {
+		t.Errorf("OpenEnvelope without key got error %v, want %v", err, ErrNoMasterKey)
+	}
+	if value, err := OpenEnvelope(nil, "plain"); err != nil || value != "plain" {
+		t.Errorf("OpenEnvelope got %q, %v, want the plaintext", value, err)
+	}
+	if _, err := OpenEnvelope(key, "enc:v2:broken"); err != ErrDecrypt {
+		t.Errorf("OpenEnvelope of broken envelope got error %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestRewrapEnvelope(t *testing.T) {
+	oldKey, newKey := NewMasterKey("old"), NewMasterKey("new")
+	sealed, err := SealEnvelope(oldKey, "token")
+	if err != nil {
+		t.Fatalf("SealEnvelope got error: %v", err)
+	}
+	rewrapped, err := RewrapEnvelope(oldKey, newKey, sealed)
+	if err != nil {
+		t.Fatalf("RewrapEnvelope got error: %v", err)
+	}
+	//the encrypted value is not changed
+	if sealed[strings.LastIndex(sealed, ":"):] != rewrapped[strings.LastIndex(rewrapped, ":"):] {
+		t.Errorf("got ciphertext of %s changed to %s", sealed, rewrapped)
+	}
+	if value, err := OpenEnvelope(newKey, rewrapped); err != nil || value != "token" {
+		t.Errorf("OpenEnvelope by the new key got %q, %v, want token", value, err)
+	}
+	if _, err := OpenEnvelope(oldKey, rewrapped); err != ErrDecrypt {
+		t.Errorf("OpenEnvelope by the old key got error %v, want %v", err, ErrDecrypt)
+	}
+	if plain, err := RewrapEnvelope(nil, newKey, "plain"); err != nil || !IsEnvelope(plain) {
+		t.Errorf("RewrapEnvelope of plaintext got %q, %v, want an envelope", plain, err)
+	}
+}
+
+func TestLegacyEnvelope(t *testing.T) {
+	//data key wrapped by the sha256 of the passphrase
+	legacyKey := sha256.Sum256([]byte("secret"))
+	dataKey := make([]byte, keySize)
+	wrapped, err := Encrypt(legacyKey[:], dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := Encrypt(dataKey, []byte("token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := "enc:v1:" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+	if !IsEnvelope(legacy) || !IsLegacyEnvelope(legacy) {
+		t.Errorf("got %s not a legacy envelope", legacy)
+	}
+
+	key := NewMasterKey("secret")
+	if value, err := OpenEnvelope(key, legacy); err != nil || value != "token" {
+		t.Errorf("OpenEnvelope got %q, %v, want token", value, err)
+	}
+	upgraded, err := RewrapEnvelope(key, key, legacy)
+	if err != nil {
+		t.Fatalf("RewrapEnvelope got error: %v", err)
+	}
+	if IsLegacyEnvelope(upgraded) {
+		t.Errorf("got %s, want the salted key derivation", upgraded)
+	}
+	if value, err := OpenEnvelope(key, upgraded); err != nil || value != "token" {
+		t.Errorf("OpenEnvelope got %q, %v, want token", value, err)
+	}
+}
@@ -1,7 +1,9 @@
 package util
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/rand"
 	"net/http"
 	"regexp"
@@ -125,3 +127,33 @@ func GetCurrentUser(cookies []*http.Cookie) (string, error) {
 	}
 	return userid, nil
 }
+
+//GetCurrentIdentities gets identity ids of the current user and the groups the user is in,
+//in externalIdType:externalId format
+func GetCurrentIdentities(cookies []*http.Cookie) ([]string, error) {
+	httpClient := &http.Client{}
+	req, err := http.NewRequest("GET", config.Config.CattleUrl+"/identities", nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fail to get identities, status code %d", resp.StatusCode)
+	}
+	identities := &client.IdentityCollection{}
+	if err := json.NewDecoder(resp.Body).Decode(identities); err != nil {
+		return nil, err
+	}
+	result := []string{}
+	for _, identity := range identities.Data {
+		result = append(result, identity.ExternalIdType+":"+identity.ExternalId)
+	}
+	return result, nil
+}